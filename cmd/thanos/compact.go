@@ -170,6 +170,11 @@ func runCompact(
 	conf compactConfig,
 	flagsMap map[string]string,
 ) (rerr error) {
+	if conf.dryRun && conf.wait {
+		level.Warn(logger).Log("msg", "--wait is ignored in dry run mode, the compactor exits once the plan is reported")
+		conf.wait = false
+	}
+
 	deleteDelay := time.Duration(conf.deleteDelay)
 	compactMetrics := newCompactMetrics(reg, deleteDelay)
 	downsampleMetrics := newDownsampleMetrics(reg)
@@ -353,12 +358,16 @@ func runCompact(
 		conf.compactBlocksFetchConcurrency,
 	)
 	tsdbPlanner := compact.NewPlanner(logger, levels, noCompactMarkerFilter)
-	planner := compact.WithLargeTotalIndexSizeFilter(
+	var planner compact.Planner = compact.WithLargeTotalIndexSizeFilter(
 		tsdbPlanner,
 		bkt,
 		int64(conf.maxBlockIndexSize),
 		compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.IndexSizeExceedingNoCompactReason),
 	)
+	if conf.dryRun {
+		// The index size filter marks the blocks exceeding it for no compaction while planning.
+		planner = tsdbPlanner
+	}
 	blocksCleaner := compact.NewBlocksCleaner(logger, bkt, ignoreDeletionMarkFilter, deleteDelay, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
 	compactor, err := compact.NewBucketCompactor(
 		logger,
@@ -468,6 +477,9 @@ func runCompact(
 	g.Add(func() error {
 		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
 
+		if conf.dryRun {
+			return compactDryRun(ctx, logger, compactor, sy, blocksCleaner, retentionByResolution, conf.disableDownsampling)
+		}
 		if !conf.wait {
 			return compactMainFn()
 		}
@@ -641,6 +653,91 @@ func runCompact(
 	return nil
 }
 
+// compactDryRun logs the work the next compaction run would start with: the blocks garbage collected,
+// compacted, downsampled and deleted, without downloading, uploading, marking nor deleting any block.
+func compactDryRun(
+	ctx context.Context,
+	logger log.Logger,
+	compactor *compact.BucketCompactor,
+	sy *compact.Syncer,
+	blocksCleaner *compact.BlocksCleaner,
+	retentionByResolution map[compact.ResolutionLevel]time.Duration,
+	disableDownsampling bool,
+) error {
+	logger = log.With(logger, "dryRun", true)
+	level.Info(logger).Log("msg", "start of compaction planning")
+
+	// Plan syncs the metas, checking the blocks metadata.
+	plans, err := compactor.Plan(ctx)
+	if err != nil {
+		return errors.Wrap(err, "plan compactions")
+	}
+
+	metas := sy.Metas()
+	garbage := sy.GarbageBlocks()
+	for _, id := range garbage {
+		level.Info(logger).Log("msg", "would mark outdated block for deletion", "block", id)
+		delete(metas, id)
+	}
+
+	for _, p := range plans {
+		ids := make([]string, 0, len(p.Blocks))
+		for _, m := range p.Blocks {
+			ids = append(ids, m.ULID.String())
+		}
+		level.Info(logger).Log(
+			"msg", "would compact blocks",
+			"group", p.Group.Key(),
+			"labels", p.Group.Labels().String(),
+			"resolution", p.Group.Resolution(),
+			"blocks", strings.Join(ids, ","),
+			"mint", p.Blocks[0].MinTime,
+			"maxt", p.Blocks[len(p.Blocks)-1].MaxTime,
+		)
+	}
+
+	var toDownsample []*metadata.Meta
+	if !disableDownsampling {
+		// Only the blocks present now are considered, not the ones the compactions above would create.
+		if toDownsample, err = blocksToDownsample(metas); err != nil {
+			return errors.Wrap(err, "plan downsampling")
+		}
+		for _, m := range toDownsample {
+			to := downsample.ResLevel1
+			if m.Thanos.Downsample.Resolution == downsample.ResLevel1 {
+				to = downsample.ResLevel2
+			}
+			level.Info(logger).Log("msg", "would downsample block", "block", m.ULID, "resolution", m.Thanos.Downsample.Resolution, "targetResolution", to)
+		}
+	}
+
+	exceedingRetention := compact.BlocksExceedingRetention(metas, retentionByResolution)
+	for id, retention := range exceedingRetention {
+		level.Info(logger).Log("msg", "would mark block exceeding retention for deletion", "block", id, "retention", retention)
+	}
+
+	toDelete := blocksCleaner.BlocksToDelete()
+	for _, id := range toDelete {
+		level.Info(logger).Log("msg", "would delete block marked for deletion", "block", id)
+	}
+
+	abortedUploads := compact.AbortedPartialUploads(sy.Partial())
+	for _, id := range abortedUploads {
+		level.Info(logger).Log("msg", "would delete aborted partial upload", "block", id)
+	}
+
+	level.Info(logger).Log(
+		"msg", "compaction planning done",
+		"garbageCollected", len(garbage),
+		"compactions", len(plans),
+		"downsamplings", len(toDownsample),
+		"retentionDeletions", len(exceedingRetention),
+		"markedBlockDeletions", len(toDelete),
+		"partialUploadDeletions", len(abortedUploads),
+	)
+	return nil
+}
+
 type compactConfig struct {
 	haltOnError                                    bool
 	acceptMalformedIndex                           bool
@@ -674,6 +771,7 @@ type compactConfig struct {
 	skipBlockWithOutOfOrderChunks                  bool
 	progressCalculateInterval                      time.Duration
 	filterConf                                     *store.FilterConfig
+	dryRun                                         bool
 }
 
 func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
@@ -726,6 +824,11 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("compact.progress-interval", "Frequency of calculating the compaction progress in the background when --wait has been enabled. Setting it to \"0s\" disables it. Now compaction, downsampling and retention progress are supported.").
 		Default("5m").DurationVar(&cc.progressCalculateInterval)
 
+	cmd.Flag("compact.dry-run", "Plan the next compaction run once and log the blocks it would garbage collect, compact, downsample and delete, "+
+		"then exit without downloading, uploading, marking nor deleting any block. The blocks metadata is still checked the same way as before compacting. "+
+		"The plan does not account for blocks the index size limit would mark for no compaction, nor for blocks created by the planned compactions. --wait is ignored.").
+		Default("false").BoolVar(&cc.dryRun)
+
 	cmd.Flag("compact.concurrency", "Number of goroutines to use when compacting groups.").
 		Default("1").IntVar(&cc.compactionConcurrency)
 	cmd.Flag("compact.blocks-fetch-concurrency", "Number of goroutines to use when download block during compaction.").
@@ -39,6 +39,8 @@ type DownsampleMetrics struct {
 	downsamples        *prometheus.CounterVec
 	downsampleFailures *prometheus.CounterVec
 	downsampleDuration *prometheus.HistogramVec
+	workers            prometheus.Gauge
+	workersBusy        prometheus.Gauge
 }
 
 func newDownsampleMetrics(reg *prometheus.Registry) *DownsampleMetrics {
@@ -57,6 +59,14 @@ func newDownsampleMetrics(reg *prometheus.Registry) *DownsampleMetrics {
 		Help:    "Duration of downsample runs",
 		Buckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400}, // 1m, 5m, 15m, 30m, 60m, 120m, 240m
 	}, []string{"group"})
+	m.workers = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_downsample_workers",
+		Help: "Number of workers downsampling blocks concurrently in the current downsample run.",
+	})
+	m.workersBusy = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_downsample_workers_busy",
+		Help: "Number of workers currently downsampling a block.",
+	})
 
 	return m
 }
@@ -194,26 +204,9 @@ func downsampleBucket(
 		}
 	}()
 
-	// mapping from a hash over all source IDs to blocks. We don't need to downsample a block
-	// if a downsampled version with the same hash already exists.
-	sources5m := map[ulid.ULID]struct{}{}
-	sources1h := map[ulid.ULID]struct{}{}
-
-	for _, m := range metas {
-		switch m.Thanos.Downsample.Resolution {
-		case downsample.ResLevel0:
-			continue
-		case downsample.ResLevel1:
-			for _, id := range m.Compaction.Sources {
-				sources5m[id] = struct{}{}
-			}
-		case downsample.ResLevel2:
-			for _, id := range m.Compaction.Sources {
-				sources1h[id] = struct{}{}
-			}
-		default:
-			return errors.Errorf("unexpected downsampling resolution %d", m.Thanos.Downsample.Resolution)
-		}
+	toDownsample, err := blocksToDownsample(metas)
+	if err != nil {
+		return err
 	}
 
 	ignoreDirs := []string{}
@@ -225,14 +218,6 @@ func downsampleBucket(
 		level.Warn(logger).Log("msg", "failed deleting potentially outdated directories/files, some disk space usage might have leaked. Continuing", "err", err, "dir", dir)
 	}
 
-	metasULIDS := make([]ulid.ULID, 0, len(metas))
-	for k := range metas {
-		metasULIDS = append(metasULIDS, k)
-	}
-	sort.Slice(metasULIDS, func(i, j int) bool {
-		return metasULIDS[i].Compare(metasULIDS[j]) < 0
-	})
-
 	var (
 		wg                      sync.WaitGroup
 		metaCh                  = make(chan *metadata.Meta)
@@ -244,11 +229,19 @@ func downsampleBucket(
 	defer workerCancel()
 
 	level.Debug(logger).Log("msg", "downsampling bucket", "concurrency", downsampleConcurrency)
+	metrics.workers.Set(float64(downsampleConcurrency))
+	defer metrics.workers.Set(0)
+
+	// Blocks are downsampled independently of each other, each worker downloading the block it
+	// is given to its own directory and uploading the result with the meta file last, so a
+	// failed worker leaves at most a partial upload behind, cleaned up by the compactor.
 	for i := 0; i < downsampleConcurrency; i++ {
 		wg.Add(1)
-		go func() {
+		go func(worker int) {
 			defer wg.Done()
 			for m := range metaCh {
+				metrics.workersBusy.Inc()
+				level.Debug(logger).Log("msg", "downsampling block", "block", m.ULID, "group", m.Thanos.GroupKey(), "worker", worker)
 				resolution := downsample.ResLevel1
 				errMsg := "downsampling to 5 min"
 				if m.Thanos.Downsample.Resolution == downsample.ResLevel1 {
@@ -261,12 +254,72 @@ func downsampleBucket(
 
 				}
 				metrics.downsamples.WithLabelValues(m.Thanos.GroupKey()).Inc()
+				metrics.workersBusy.Dec()
 			}
-		}()
+		}(i)
 	}
 
 	// Workers scheduled, distribute blocks.
 metaSendLoop:
+	for _, m := range toDownsample {
+		select {
+		case <-workerCtx.Done():
+			downsampleErrs.Add(workerCtx.Err())
+			break metaSendLoop
+		case metaCh <- m:
+		case downsampleErr := <-errCh:
+			downsampleErrs.Add(downsampleErr)
+			break metaSendLoop
+		}
+	}
+
+	close(metaCh)
+	wg.Wait()
+	workerCancel()
+	close(errCh)
+
+	// Collect any other error reported by the workers.
+	for downsampleErr := range errCh {
+		downsampleErrs.Add(downsampleErr)
+	}
+
+	return downsampleErrs.Err()
+}
+
+// blocksToDownsample returns the blocks, ordered by ULID, which have no downsampled version yet in the
+// next resolution, and are large enough to be downsampled to it.
+func blocksToDownsample(metas map[ulid.ULID]*metadata.Meta) ([]*metadata.Meta, error) {
+	// mapping from a hash over all source IDs to blocks. We don't need to downsample a block
+	// if a downsampled version with the same hash already exists.
+	sources5m := map[ulid.ULID]struct{}{}
+	sources1h := map[ulid.ULID]struct{}{}
+
+	for _, m := range metas {
+		switch m.Thanos.Downsample.Resolution {
+		case downsample.ResLevel0:
+			continue
+		case downsample.ResLevel1:
+			for _, id := range m.Compaction.Sources {
+				sources5m[id] = struct{}{}
+			}
+		case downsample.ResLevel2:
+			for _, id := range m.Compaction.Sources {
+				sources1h[id] = struct{}{}
+			}
+		default:
+			return nil, errors.Errorf("unexpected downsampling resolution %d", m.Thanos.Downsample.Resolution)
+		}
+	}
+
+	metasULIDS := make([]ulid.ULID, 0, len(metas))
+	for k := range metas {
+		metasULIDS = append(metasULIDS, k)
+	}
+	sort.Slice(metasULIDS, func(i, j int) bool {
+		return metasULIDS[i].Compare(metasULIDS[j]) < 0
+	})
+
+	var res []*metadata.Meta
 	for _, mk := range metasULIDS {
 		m := metas[mk]
 
@@ -311,28 +364,9 @@ metaSendLoop:
 			}
 		}
 
-		select {
-		case <-workerCtx.Done():
-			downsampleErrs.Add(workerCtx.Err())
-			break metaSendLoop
-		case metaCh <- m:
-		case downsampleErr := <-errCh:
-			downsampleErrs.Add(downsampleErr)
-			break metaSendLoop
-		}
-	}
-
-	close(metaCh)
-	wg.Wait()
-	workerCancel()
-	close(errCh)
-
-	// Collect any other error reported by the workers.
-	for downsampleErr := range errCh {
-		downsampleErrs.Add(downsampleErr)
+		res = append(res, m)
 	}
-
-	return downsampleErrs.Err()
+	return res, nil
 }
 
 func processDownsampling(
@@ -202,3 +202,64 @@ func TestCleanupDownsampleCacheFolder(t *testing.T) {
 	_, err = os.Stat(dir)
 	testutil.Assert(t, os.IsNotExist(err), "index cache dir should not exist at the end of execution")
 }
+
+func TestDownsampleBucket_ConcurrentGroups(t *testing.T) {
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	// Two blocks in each of three groups, all in need of downsampling.
+	var groupKeys []string
+	for g := 0; g < 3; g++ {
+		for b := 0; b < 2; b++ {
+			id, err := e2eutil.CreateBlock(
+				ctx,
+				dir,
+				[]labels.Labels{{{Name: "a", Value: fmt.Sprintf("%d", b)}}},
+				1, int64(b)*(downsample.ResLevel1DownsampleRange+1), int64(b+1)*(downsample.ResLevel1DownsampleRange+1), // Pass the minimum ResLevel1DownsampleRange check.
+				labels.Labels{{Name: "e1", Value: fmt.Sprintf("%d", g)}},
+				downsample.ResLevel0, metadata.NoneFunc)
+			testutil.Ok(t, err)
+			testutil.Ok(t, block.Upload(ctx, logger, bkt, path.Join(dir, id.String()), metadata.NoneFunc))
+
+			if b == 0 {
+				meta, err := block.DownloadMeta(ctx, logger, bkt, id)
+				testutil.Ok(t, err)
+				groupKeys = append(groupKeys, meta.Thanos.GroupKey())
+			}
+		}
+	}
+
+	metrics := newDownsampleMetrics(prometheus.NewRegistry())
+	metaFetcher, err := block.NewMetaFetcher(nil, block.FetcherConcurrency, bkt, "", nil, nil)
+	testutil.Ok(t, err)
+
+	metas, _, err := metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 6, len(metas))
+	testutil.Ok(t, downsampleBucket(ctx, logger, metrics, bkt, metas, path.Join(dir, "downsample"), 4, metadata.NoneFunc, false))
+
+	for _, key := range groupKeys {
+		testutil.Equals(t, 2.0, promtest.ToFloat64(metrics.downsamples.WithLabelValues(key)))
+		testutil.Equals(t, 0.0, promtest.ToFloat64(metrics.downsampleFailures.WithLabelValues(key)))
+	}
+	testutil.Equals(t, 0.0, promtest.ToFloat64(metrics.workersBusy))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(metrics.workers))
+
+	// Each block got its own downsampled version, within its group.
+	metas, _, err = metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 12, len(metas))
+
+	downsampled := map[string]int{}
+	for _, m := range metas {
+		if m.Thanos.Downsample.Resolution == downsample.ResLevel1 {
+			downsampled[m.Thanos.Labels["e1"]]++
+		}
+	}
+	testutil.Equals(t, map[string]int{"0": 2, "1": 2, "2": 2}, downsampled)
+}
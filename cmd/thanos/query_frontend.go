@@ -104,6 +104,12 @@ func registerQueryFrontend(app *extkingpin.App) {
 
 	cfg.QueryRangeConfig.CachePathOrContent = *extflag.RegisterPathOrContent(cmd, "query-range.response-cache-config", "YAML file that contains response cache configuration.", extflag.WithEnvSubstitution())
 
+	cmd.Flag("query-range.cache-aware-split", "Further split the query range requests at the boundaries of their results cached in the response cache, so that only the uncached sub-ranges are recomputed, even when the cached ones are too small to be reused otherwise. It requires query-range.split-interval and the response cache, and has no effect on the queries sharded vertically.").
+		Default("false").BoolVar(&cfg.QueryRangeConfig.CacheAwareSplit)
+
+	cmd.Flag("query-range.response-cache-stale-while-revalidate", "How long expired query range results are still served from the response cache while being recomputed in the background. 0 disables it, recomputing expired results before responding.").
+		Default("0s").DurationVar(&cfg.QueryRangeConfig.CacheStaleWhileRevalidate)
+
 	// Labels tripperware flags.
 	cmd.Flag("labels.split-interval", "Split labels requests by an interval and execute in parallel, it should be greater than 0 when labels.response-cache-config is configured.").
 		Default("24h").DurationVar(&cfg.LabelsConfig.SplitQueriesByInterval)
@@ -142,7 +148,8 @@ func registerQueryFrontend(app *extkingpin.App) {
 	cmd.Flag("query-frontend.org-id-header", "Request header names used to identify the source of slow queries (repeated flag). "+
 		"The values of the header will be added to the org id field in the slow query log. "+
 		"If multiple headers match the request, the first matching arg specified will take precedence. "+
-		"If no headers match 'anonymous' will be used.").PlaceHolder("<http-header-name>").StringsVar(&cfg.orgIdHeaders)
+		"If no headers match 'anonymous' will be used. "+
+		"The org id also namespaces the response cache entries, so that they're only served to requests with the same org id.").PlaceHolder("<http-header-name>").StringsVar(&cfg.orgIdHeaders)
 
 	cmd.Flag("query-frontend.forward-header", "List of headers forwarded by the query-frontend to downstream queriers, default is empty").PlaceHolder("<http-header-name>").StringsVar(&cfg.ForwardHeaders)
 
@@ -227,8 +234,9 @@ func runQueryFrontend(
 			return errors.Wrap(err, "initializing the query range cache config")
 		}
 		cfg.QueryRangeConfig.ResultsCacheConfig = &queryrange.ResultsCacheConfig{
-			Compression: cfg.CacheCompression,
-			CacheConfig: *cacheConfig,
+			Compression:          cfg.CacheCompression,
+			CacheConfig:          *cacheConfig,
+			StaleWhileRevalidate: cfg.QueryRangeConfig.CacheStaleWhileRevalidate,
 		}
 	}
 
@@ -207,7 +207,10 @@ func runReceive(
 		conf.allowOutOfOrderUpload,
 		hashFunc,
 	)
-	writer := receive.NewWriter(log.With(logger, "component", "receive-writer"), dbs, conf.writerInterning)
+	writer := receive.NewWriter(log.With(logger, "component", "receive-writer"), reg, dbs, &receive.WriterOptions{
+		Intern:             conf.writerInterning,
+		SeriesRefCacheSize: conf.writerSeriesRefCacheSize,
+	})
 
 	var limitsConfig *receive.RootLimitsConfig
 	if conf.writeLimitsConfig != nil {
@@ -786,6 +789,8 @@ type receiveConfig struct {
 	noLockFile      bool
 	writerInterning bool
 
+	writerSeriesRefCacheSize int
+
 	hashFunc string
 
 	ignoreBlockSize       bool
@@ -904,6 +909,10 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"[EXPERIMENTAL] Enables string interning in receive writer, for more optimized memory usage.").
 		Default("false").Hidden().BoolVar(&rc.writerInterning)
 
+	cmd.Flag("writer.series-ref-cache-size",
+		"[EXPERIMENTAL] Maximum number of series references cached per tenant by the receive writer, to skip looking up hot series in the TSDB head on each remote write request. The cache is purged whenever the TSDB head is truncated. 0 disables the cache.").
+		Default("0").IntVar(&rc.writerSeriesRefCacheSize)
+
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&rc.hashFunc, "SHA256", "")
 
@@ -85,6 +85,8 @@ type ruleConfig struct {
 
 	rwConfig *extflag.PathOrContent
 
+	queryResultCacheConfig *extflag.PathOrContent
+
 	resendDelay       time.Duration
 	evalInterval      time.Duration
 	outageTolerance   time.Duration
@@ -140,6 +142,8 @@ func registerRule(app *extkingpin.App) {
 
 	conf.rwConfig = extflag.RegisterPathOrContent(cmd, "remote-write.config", "YAML config for the remote-write configurations, that specify servers where samples should be sent to (see https://prometheus.io/docs/prometheus/latest/configuration/configuration/#remote_write). This automatically enables stateless mode for ruler and no series will be stored in the ruler's TSDB. If an empty config (or file) is provided, the flag is ignored and ruler is run with its own TSDB.", extflag.WithEnvSubstitution())
 
+	conf.queryResultCacheConfig = extflag.RegisterPathOrContent(cmd, "query.result-cache.config", "YAML file that contains the configuration of an optional cache of the rule query results, shared by the ruler replicas evaluating identical rule groups so that they reuse the results evaluated by their peers at the same timestamp. See format details: https://thanos.io/tip/components/rule.md/#query-result-cache", extflag.WithEnvSubstitution())
+
 	reqLogDecision := cmd.Flag("log.request.decision", "Deprecation Warning - This flag would be soon deprecated, and replaced with `request.logging-config`. Request Logging for logging the start and end of requests. By default this flag is disabled. LogFinishCall: Logs the finish call of the requests. LogStartAndFinishCall: Logs the start and finish call of the requests. NoLogCall: Disable request logging.").Default("").Enum("NoLogCall", "LogFinishCall", "LogStartAndFinishCall", "")
 
 	conf.objStoreConfig = extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
@@ -506,6 +510,23 @@ func runRule(
 			alertQ.Push(res)
 		}
 
+		newQueryFunc := queryFuncCreator(logger, queryClients, promClients, metrics.duplicatedQuery, metrics.ruleEvalWarnings, conf.query.httpMethod)
+
+		queryResultCacheConfigYAML, err := conf.queryResultCacheConfig.Content()
+		if err != nil {
+			return err
+		}
+		if len(queryResultCacheConfigYAML) > 0 {
+			queryResultCache, err := thanosrules.NewQueryResultCacheFromYaml(logger, queryResultCacheConfigYAML, conf.evalInterval, reg)
+			if err != nil {
+				return errors.Wrap(err, "create query result cache")
+			}
+			queryFuncWithoutCache := newQueryFunc
+			newQueryFunc = func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
+				return queryResultCache.Wrap(partialResponseStrategy, queryFuncWithoutCache(partialResponseStrategy))
+			}
+		}
+
 		ctx, cancel := context.WithCancel(context.Background())
 		logger = log.With(logger, "component", "rules")
 		ruleMgr = thanosrules.NewManager(
@@ -522,7 +543,7 @@ func runRule(
 				OutageTolerance: conf.outageTolerance,
 				ForGracePeriod:  conf.forGracePeriod,
 			},
-			queryFuncCreator(logger, queryClients, promClients, metrics.duplicatedQuery, metrics.ruleEvalWarnings, conf.query.httpMethod),
+			newQueryFunc,
 			conf.lset,
 			// In our case the querying URL is the external URL because in Prometheus
 			// --web.external-url points to it i.e. it points at something where the user
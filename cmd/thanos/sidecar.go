@@ -101,6 +101,15 @@ func runSidecar(
 
 	reloader.SetHttpClient(*httpClient)
 
+	// Refresh the external labels as soon as Prometheus is reloaded, given they may have changed.
+	reloaded := make(chan struct{}, 1)
+	reloader.SetOnReload(func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+
 	var m = &promMetadata{
 		promURL: conf.prometheus.url,
 
@@ -214,23 +223,33 @@ func runSidecar(
 				return errors.New("no external labels configured on Prometheus server, uniquely identifying external labels must be configured; see https://thanos.io/tip/thanos/storage.md#external-labels for details.")
 			}
 
-			// Periodically query the Prometheus config. We use this as a heartbeat as well as for updating
-			// the external labels we apply.
-			return runutil.Repeat(conf.prometheus.getConfigInterval, ctx.Done(), func() error {
-				iterCtx, iterCancel := context.WithTimeout(context.Background(), conf.prometheus.getConfigTimeout)
-				defer iterCancel()
+			// Periodically query the Prometheus config, and as soon as Prometheus is reloaded. We use this
+			// as a heartbeat as well as for updating the external labels we apply.
+			tick := time.NewTicker(conf.prometheus.getConfigInterval)
+			defer tick.Stop()
+
+			for {
+				func() {
+					iterCtx, iterCancel := context.WithTimeout(context.Background(), conf.prometheus.getConfigTimeout)
+					defer iterCancel()
+
+					if err := m.UpdateLabels(iterCtx); err != nil {
+						level.Warn(logger).Log("msg", "heartbeat failed", "err", err)
+						promUp.Set(0)
+						statusProber.NotReady(err)
+					} else {
+						promUp.Set(1)
+						statusProber.Ready()
+					}
+				}()
 
-				if err := m.UpdateLabels(iterCtx); err != nil {
-					level.Warn(logger).Log("msg", "heartbeat failed", "err", err)
-					promUp.Set(0)
-					statusProber.NotReady(err)
-				} else {
-					promUp.Set(1)
-					statusProber.Ready()
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-tick.C:
+				case <-reloaded:
 				}
-
-				return nil
-			})
+			}
 		}, func(error) {
 			cancel()
 		})
@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"strconv"
 	"time"
 
@@ -20,6 +21,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/route"
 	"github.com/thanos-io/objstore/client"
+	"google.golang.org/grpc"
 
 	commonmodel "github.com/prometheus/common/model"
 
@@ -34,6 +36,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/gate"
+	"github.com/thanos-io/thanos/pkg/hedging"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
@@ -62,15 +65,35 @@ type storeConfig struct {
 	grpcConfig                  grpcConfig
 	httpConfig                  httpConfig
 	indexCacheSizeBytes         units.Base2Bytes
+	indexCacheWarmLabelNames    []string
+	indexCacheWarmPostingsRate  float64
+	indexCacheVerifyKeys        bool
+	indexCacheCompactKeys       bool
+	indexCacheHitsByBlockAge    bool
+	indexCacheSynchronousStore  bool
+	indexCacheStoreIfAbsent     bool
+	indexCacheHealthCheck       bool
+	indexCacheAllowBypass       bool
+	indexCacheChecksums         bool
+	indexCacheMaxGetMulti       int
+	indexCacheGetMultiWait      time.Duration
+	indexCachePostingsAllow     []string
+	indexCachePostingsDeny      []string
+	indexCacheOperationsLog     string
+	indexCacheMissReasonKeys    int
+	indexCacheLabelValues       bool
+	indexCacheLabelNames        bool
 	chunkPoolSize               units.Base2Bytes
 	seriesBatchSize             int
 	storeRateLimits             store.SeriesSelectLimits
 	maxDownloadedBytes          units.Base2Bytes
+	maxIndexCacheBytes          units.Base2Bytes
 	maxConcurrency              int
 	component                   component.StoreAPI
 	debugLogging                bool
 	syncInterval                time.Duration
 	blockSyncConcurrency        int
+	blockLoadConcurrency        int
 	blockMetaFetchConcurrency   int
 	filterConf                  *store.FilterConfig
 	selectorRelabelConf         extflag.PathOrContent
@@ -82,6 +105,7 @@ type storeConfig struct {
 	label                       string
 	postingOffsetsInMemSampling int
 	cachingBucketConfig         extflag.PathOrContent
+	hedgingConfig               hedging.Config
 	reqLogConfig                *extflag.PathOrContent
 	lazyIndexReaderEnabled      bool
 	lazyIndexReaderIdleTimeout  time.Duration
@@ -106,11 +130,74 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 		extflag.WithEnvSubstitution(),
 	)
 
+	cmd.Flag("index-cache.warm-label-names", "Names of the labels whose postings are fetched and stored to the index cache for all the blocks once the initial sync is completed, so that the first queries don't hit a cold cache. Repeat the flag to warm multiple labels.").
+		PlaceHolder("<name>").StringsVar(&sc.indexCacheWarmLabelNames)
+
+	cmd.Flag("index-cache.warm-postings-rate", "Maximum number of postings per second fetched from the object storage while warming the index cache. 0 means no limit.").
+		Default("100").Float64Var(&sc.indexCacheWarmPostingsRate)
+
+	cmd.Flag("index-cache.verify-keys", "Store a fingerprint of each item along with its entry in the remote index cache, and verify it on fetch, counting mismatches in thanos_store_index_cache_collisions_total. Meant to catch cache keys encoding regressions while testing.").
+		Hidden().Default("false").BoolVar(&sc.indexCacheVerifyKeys)
+
+	cmd.Flag("index-cache.compact-keys", "Use a compact binary encoding of the remote index cache keys, shorter than the default human readable one. Switching the encoding starts from a cold cache, given the keys of the two encodings never collide.").
+		Default("false").BoolVar(&sc.indexCacheCompactKeys)
+
+	cmd.Flag("index-cache.track-hits-by-block-age", "Track the remote index cache requests and hits by age of the block, bucketed in less than an hour, less than a day and more than a day, in thanos_store_index_cache_requests_by_block_age_total and thanos_store_index_cache_hits_by_block_age_total.").
+		Default("false").BoolVar(&sc.indexCacheHitsByBlockAge)
+
+	cmd.Flag("index-cache.synchronous-store", "Store the remote index cache entries synchronously, blocking until they're stored, instead of enqueuing them to be stored asynchronously. It makes the cache content deterministic at the expense of the request latency, e.g. for integration tests and small deployments.").
+		Default("false").BoolVar(&sc.indexCacheSynchronousStore)
+
+	cmd.Flag("index-cache.store-if-absent", "Store the remote index cache entries only if they're not stored yet, using memcached add or redis SET NX, so that the concurrent backfills of the same missed entries are written once. The skipped stores are counted by thanos_store_index_cache_store_skipped_existing_total. It can't be combined with --index-cache.synchronous-store.").
+		Default("false").BoolVar(&sc.indexCacheStoreIfAbsent)
+
+	cmd.Flag("index-cache.health-check-on-startup", "Don't get ready until the index cache backend is reachable, checking it once the initial sync is completed and retrying until it succeeds. Only the remote index caches are checked.").
+		Default("false").BoolVar(&sc.indexCacheHealthCheck)
+
+	cmd.Flag("index-cache.allow-bypass", "Bypass the remote index cache for the requests asking for it, as forwarded by the queriers for the queries with the X-Thanos-Bypass-Index-Cache HTTP header, so that a specific query can be debugged without disabling the cache globally. The fetches then miss and the stores are skipped for those requests only.").
+		Default("false").BoolVar(&sc.indexCacheAllowBypass)
+
+	cmd.Flag("index-cache.checksums", "Store a CRC32 checksum of each entry along with it in the remote index cache, and verify it on fetch, so that the entries corrupted or truncated by the backend are treated as misses, counted in thanos_store_index_cache_corrupted_entries_total, and read again from the object storage. Enabling it starts from a cold cache, given the checksummed entries are stored under other keys.").
+		Default("false").BoolVar(&sc.indexCacheChecksums)
+
+	cmd.Flag("index-cache.max-in-flight-get-multi", "Maximum number of GetMulti operations to the remote index cache in flight across all the requests, protecting the backend from query storms. The operations beyond are queued, as tracked by thanos_store_index_cache_getmulti_queue_length. 0 means no limit.").
+		Default("0").IntVar(&sc.indexCacheMaxGetMulti)
+
+	cmd.Flag("index-cache.max-get-multi-queue-wait", "Maximum duration a GetMulti operation to the remote index cache is queued for when --index-cache.max-in-flight-get-multi is reached, after which its keys are counted as misses. 0 means the operations are queued until the request is canceled.").
+		Default("0s").DurationVar(&sc.indexCacheGetMultiWait)
+
+	cmd.Flag("index-cache.postings-allow-label-names", "Names of the only labels whose postings are cached in the remote index cache, so that the cache is kept focused on the labels whose postings are reused. The postings of the other labels are always fetched from the object storage. Repeat the flag to allow multiple labels. All the labels are allowed if not set.").
+		PlaceHolder("<name>").StringsVar(&sc.indexCachePostingsAllow)
+
+	cmd.Flag("index-cache.postings-deny-label-names", "Names of the labels whose postings are never cached in the remote index cache, e.g. high cardinality labels like pod or instance whose postings would pollute the cache with one-hit entries. The skipped stores are counted by thanos_store_index_cache_skipped_postings_total. Repeat the flag to deny multiple labels.").
+		PlaceHolder("<name>").StringsVar(&sc.indexCachePostingsDeny)
+
+	cmd.Flag("index-cache.operations-log-file", "Path of the file each fetch and store of the remote index cache is appended to, as a JSON object per line with its type, item type, block, number of keys, hits and bytes, so that the sequence of operations can be replayed offline with thanos tools cache replay. The writes are synchronous, so it's meant for debugging only. Disabled if empty.").
+		PlaceHolder("<file-path>").StringVar(&sc.indexCacheOperationsLog)
+
+	cmd.Flag("index-cache.miss-reasons-tracked-keys", "Maximum number of the index cache keys most recently stored or hit which are tracked to categorize the misses in thanos_store_index_cache_miss_reason_total, telling the misses of the keys never cached, e.g. of cold blocks, apart from the ones of the keys evicted or expired since. The tracking takes about 100 bytes per key. 0 disables the tracking.").
+		Default("0").IntVar(&sc.indexCacheMissReasonKeys)
+
+	cmd.Flag("index-cache.label-values", "Cache the label values of the series matching the matchers of LabelValues calls in the index cache, for each block the call covers entirely, so that the calls for the same label and matchers don't fetch the matching series again. Calls without matchers are answered from the index headers, and are never cached.").
+		Default("false").BoolVar(&sc.indexCacheLabelValues)
+
+	cmd.Flag("index-cache.label-names", "Cache the label names of the series matching the matchers of LabelNames calls in the index cache, for each block the call covers entirely, so that the calls for the same matchers don't fetch the matching series again. Calls without matchers are answered from the index headers, and are never cached.").
+		Default("false").BoolVar(&sc.indexCacheLabelNames)
+
 	sc.cachingBucketConfig = *extflag.RegisterPathOrContent(hidden.HiddenCmdClause(cmd), "store.caching-bucket.config",
 		"YAML that contains configuration for caching bucket. Experimental feature, with high risk of changes. See format details: https://thanos.io/tip/components/store.md/#caching-bucket",
 		extflag.WithEnvSubstitution(),
 	)
 
+	cmd.Flag("store.hedged-get.percentile", "Percentile of the recent object storage GET latencies after which a GET which hasn't returned yet is hedged: a second request is issued and the first one to return is used, the other being cancelled. 0 disables hedging.").
+		Default("0").Float64Var(&sc.hedgingConfig.Percentile)
+
+	cmd.Flag("store.hedged-get.min-delay", "Minimum delay before hedging an object storage GET, also used until enough GETs have been observed to derive the delay from --store.hedged-get.percentile. It must be positive.").
+		Default("50ms").DurationVar(&sc.hedgingConfig.MinDelay)
+
+	cmd.Flag("store.hedged-get.max-in-flight", "Maximum number of hedged object storage GETs in flight at once. GETs are not hedged beyond it.").
+		Default("10").IntVar(&sc.hedgingConfig.MaxInFlight)
+
 	cmd.Flag("chunk-pool-size", "Maximum size of concurrently allocatable bytes reserved strictly to reuse for chunks in memory.").
 		Default("2GB").BytesVar(&sc.chunkPoolSize)
 
@@ -121,6 +208,10 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"Maximum amount of downloaded (either fetched or touched) bytes in a single Series/LabelNames/LabelValues call. The Series call fails if this limit is exceeded. 0 means no limit.").
 		Default("0").BytesVar(&sc.maxDownloadedBytes)
 
+	cmd.Flag("store.grpc.index-cache-bytes-limit",
+		"Maximum amount of bytes fetched from the index cache in a single Series call. Unlike --store.grpc.downloaded-bytes-limit, only the bytes served by the index cache are counted. The Series call fails with a ResourceExhausted error if this limit is exceeded. 0 means no limit.").
+		Default("0").BytesVar(&sc.maxIndexCacheBytes)
+
 	cmd.Flag("store.grpc.series-max-concurrency", "Maximum number of concurrent Series calls.").Default("20").IntVar(&sc.maxConcurrency)
 
 	sc.component = component.Store
@@ -133,6 +224,9 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("block-sync-concurrency", "Number of goroutines to use when constructing index-cache.json blocks from object storage. Must be equal or greater than 1.").
 		Default("20").IntVar(&sc.blockSyncConcurrency)
 
+	cmd.Flag("store.block-load-concurrency", "Maximum number of blocks loaded concurrently, so that loading all blocks on startup doesn't overwhelm the index cache. If 0, the number of blocks loaded concurrently is only bounded by --block-sync-concurrency.").
+		Default("10").IntVar(&sc.blockLoadConcurrency)
+
 	cmd.Flag("block-meta-fetch-concurrency", "Number of goroutines to use when fetching block metadata from object storage.").
 		Default("32").IntVar(&sc.blockMetaFetchConcurrency)
 
@@ -280,6 +374,13 @@ func runStore(
 		return errors.Wrap(err, "create bucket client")
 	}
 
+	if conf.hedgingConfig.Percentile > 0 {
+		bkt, err = hedging.NewBucket(bkt, conf.hedgingConfig, reg)
+		if err != nil {
+			return errors.Wrap(err, "create hedging bucket")
+		}
+	}
+
 	cachingBucketConfigYaml, err := conf.cachingBucketConfig.Content()
 	if err != nil {
 		return errors.Wrap(err, "get caching bucket configuration")
@@ -311,9 +412,30 @@ func runStore(
 
 	// Create the index cache loading its config from config file, while keeping
 	// backward compatibility with the pre-config file era.
-	var indexCache storecache.IndexCache
+	var (
+		indexCache    storecache.IndexCache
+		operationsLog *os.File
+	)
 	if len(indexCacheContentYaml) > 0 {
-		indexCache, err = storecache.NewIndexCache(logger, indexCacheContentYaml, reg)
+		remoteIndexCacheConfig := storecache.DefaultRemoteIndexCacheConfig
+		remoteIndexCacheConfig.VerifyKeys = conf.indexCacheVerifyKeys
+		remoteIndexCacheConfig.CompactKeys = conf.indexCacheCompactKeys
+		remoteIndexCacheConfig.TrackHitsByBlockAge = conf.indexCacheHitsByBlockAge
+		remoteIndexCacheConfig.SynchronousStore = conf.indexCacheSynchronousStore
+		remoteIndexCacheConfig.StoreIfAbsent = conf.indexCacheStoreIfAbsent
+		remoteIndexCacheConfig.Checksums = conf.indexCacheChecksums
+		remoteIndexCacheConfig.MaxInFlightGetMulti = conf.indexCacheMaxGetMulti
+		remoteIndexCacheConfig.MaxGetMultiQueueWait = conf.indexCacheGetMultiWait
+		remoteIndexCacheConfig.PostingsLabelNamesAllowlist = conf.indexCachePostingsAllow
+		remoteIndexCacheConfig.PostingsLabelNamesDenylist = conf.indexCachePostingsDeny
+		if conf.indexCacheOperationsLog != "" {
+			operationsLog, err = os.OpenFile(conf.indexCacheOperationsLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				return errors.Wrap(err, "open index cache operations log")
+			}
+			remoteIndexCacheConfig.OperationsLog = operationsLog
+		}
+		indexCache, err = storecache.NewIndexCacheWithRouter(logger, indexCacheContentYaml, reg, remoteIndexCacheConfig, r)
 	} else {
 		indexCache, err = storecache.NewInMemoryIndexCacheWithConfig(logger, reg, storecache.InMemoryIndexCacheConfig{
 			MaxSize:     model.Bytes(conf.indexCacheSizeBytes),
@@ -323,6 +445,15 @@ func runStore(
 	if err != nil {
 		return errors.Wrap(err, "create index cache")
 	}
+	remoteIndexCache, _ := indexCache.(*storecache.RemoteIndexCache)
+	groupcacheIndexCache, _ := indexCache.(*storecache.GroupcacheIndexCache)
+	if conf.indexCacheMissReasonKeys > 0 {
+		indexCache, err = storecache.NewMissReasonIndexCache(indexCache, conf.indexCacheMissReasonKeys, reg)
+		if err != nil {
+			return errors.Wrap(err, "create miss reasons index cache")
+		}
+	}
+	indexCache = storecache.NewTracingIndexCache(indexCache)
 
 	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, time.Duration(conf.ignoreDeletionMarksDelay), conf.blockMetaFetchConcurrency)
 	metaFetcher, err := block.NewMetaFetcher(logger, conf.blockMetaFetchConcurrency, bkt, dataDir, extprom.WrapRegistererWithPrefix("thanos_", reg),
@@ -358,6 +489,11 @@ func runStore(
 		store.WithFilterConfig(conf.filterConf),
 		store.WithChunkHashCalculation(true),
 		store.WithSeriesBatchSize(conf.seriesBatchSize),
+		store.WithIndexCacheWarming(conf.indexCacheWarmLabelNames, conf.indexCacheWarmPostingsRate),
+		store.WithBlockLoadConcurrency(conf.blockLoadConcurrency),
+		store.WithCacheBytesLimiterFactory(store.NewBytesLimiterFactory(conf.maxIndexCacheBytes)),
+		store.WithLabelValuesCache(conf.indexCacheLabelValues),
+		store.WithLabelNamesCache(conf.indexCacheLabelNames),
 	}
 
 	if conf.debugLogging {
@@ -383,6 +519,10 @@ func runStore(
 	if err != nil {
 		return errors.Wrap(err, "create object storage store")
 	}
+	if groupcacheIndexCache != nil {
+		// The items owned by this store are loaded from its blocks.
+		groupcacheIndexCache.SetLoader(bs)
+	}
 
 	// bucketStoreReady signals when bucket store is ready.
 	bucketStoreReady := make(chan struct{})
@@ -408,9 +548,34 @@ func runStore(
 				return errors.Wrap(err, "bucket store initial sync")
 			}
 
+			if conf.indexCacheHealthCheck {
+				err := runutil.Retry(retryIntervalDuration*time.Second, ctx.Done(), func() error {
+					if err := storecache.HealthCheck(ctx, indexCache); err != nil {
+						level.Warn(logger).Log("msg", "index cache health check failed, retrying", "err", err)
+						return err
+					}
+					return nil
+				})
+				if err != nil {
+					close(bucketStoreReady)
+					return errors.Wrap(err, "index cache health check")
+				}
+			}
+
 			level.Info(logger).Log("msg", "bucket store ready", "init_duration", time.Since(begin).String())
 			close(bucketStoreReady)
 
+			if len(conf.indexCacheWarmLabelNames) > 0 {
+				go func() {
+					begin := time.Now()
+					if err := bs.WarmIndexCache(ctx); err != nil && ctx.Err() == nil {
+						level.Warn(logger).Log("msg", "warming index cache failed", "err", err)
+						return
+					}
+					level.Info(logger).Log("msg", "index cache warmed", "duration", time.Since(begin).String())
+				}()
+			}
+
 			err = runutil.Repeat(conf.syncInterval, ctx.Done(), func() error {
 				if err := bs.SyncBlocks(ctx); err != nil {
 					level.Warn(logger).Log("msg", "syncing blocks failed", "err", err)
@@ -452,14 +617,21 @@ func runStore(
 		}
 
 		storeServer := store.NewInstrumentedStoreServer(reg, bs)
-		s := grpcserver.New(logger, reg, tracer, grpcLogOpts, tagOpts, conf.component, grpcProbe,
+		opts := []grpcserver.Option{
 			grpcserver.WithServer(store.RegisterStoreServer(storeServer, logger)),
 			grpcserver.WithServer(info.RegisterInfoServer(infoSrv)),
 			grpcserver.WithListen(conf.grpcConfig.bindAddress),
 			grpcserver.WithGracePeriod(conf.grpcConfig.gracePeriod),
 			grpcserver.WithMaxConnAge(conf.grpcConfig.maxConnectionAge),
 			grpcserver.WithTLSConfig(tlsCfg),
-		)
+		}
+		if conf.indexCacheAllowBypass {
+			opts = append(opts,
+				grpcserver.WithGRPCServerOption(grpc.ChainUnaryInterceptor(storecache.BypassUnaryServerInterceptor())),
+				grpcserver.WithGRPCServerOption(grpc.ChainStreamInterceptor(storecache.BypassStreamServerInterceptor())),
+			)
+		}
+		s := grpcserver.New(logger, reg, tracer, grpcLogOpts, tagOpts, conf.component, grpcProbe, opts...)
 
 		g.Add(func() error {
 			<-bucketStoreReady
@@ -468,12 +640,24 @@ func runStore(
 		}, func(err error) {
 			statusProber.NotReady(err)
 			s.Shutdown(err)
+			// The in-flight requests are done, so stop using the index cache, whose
+			// client may be stopped before the remaining background operations complete.
+			if remoteIndexCache != nil {
+				remoteIndexCache.Close()
+			}
+			if operationsLog != nil {
+				runutil.CloseWithLogOnErr(logger, operationsLog, "index cache operations log")
+			}
 		})
 	}
 	// Add bucket UI for loaded blocks.
 	{
 		ins := extpromhttp.NewInstrumentationMiddleware(reg, nil)
 
+		if remoteIndexCache != nil {
+			r.Get("/debug/index-cache/keys", ins.NewHandler("index_cache_keys", storecache.NewProbeKeysHandler(remoteIndexCache)))
+		}
+
 		if !conf.disableWeb {
 			compactorView := ui.NewBucketUI(logger, conf.webConfig.externalPrefix, conf.webConfig.prefixHeaderName, conf.component)
 			compactorView.Register(r, ins)
@@ -0,0 +1,393 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/units"
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/oklog/run"
+	"github.com/oklog/ulid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	storecache "github.com/thanos-io/thanos/pkg/store/cache"
+)
+
+const (
+	cacheBenchmarkOpGetMulti = "GetMulti"
+	cacheBenchmarkOpSetAsync = "SetAsync"
+)
+
+type cacheBenchmarkConfig struct {
+	duration    time.Duration
+	concurrency int
+	numKeys     int
+	valueSize   units.Base2Bytes
+	getRatio    float64
+	batchSize   int
+	ttl         time.Duration
+}
+
+func (cbc *cacheBenchmarkConfig) registerFlag(cmd extkingpin.FlagClause) *cacheBenchmarkConfig {
+	cmd.Flag("duration", "Duration of the benchmark.").Default("30s").DurationVar(&cbc.duration)
+	cmd.Flag("concurrency", "Number of concurrent workers running operations against the cache.").Default("10").IntVar(&cbc.concurrency)
+	cmd.Flag("keys", "Number of distinct synthetic keys the operations are run for.").Default("10000").IntVar(&cbc.numKeys)
+	cmd.Flag("value-size", "Size of the values stored to the cache.").Default("1KiB").BytesVar(&cbc.valueSize)
+	cmd.Flag("get-ratio", "Ratio, between 0 and 1, of GetMulti operations, the others being SetAsync operations.").Default("0.9").Float64Var(&cbc.getRatio)
+	cmd.Flag("batch-size", "Number of keys fetched by each GetMulti operation.").Default("100").IntVar(&cbc.batchSize)
+	cmd.Flag("ttl", "TTL of the values stored to the cache.").Default("5m").DurationVar(&cbc.ttl)
+	return cbc
+}
+
+func (cbc *cacheBenchmarkConfig) validate() error {
+	if cbc.duration <= 0 || cbc.concurrency <= 0 || cbc.numKeys <= 0 || cbc.batchSize <= 0 {
+		return errors.New("duration, concurrency, keys and batch size must be positive")
+	}
+	if cbc.getRatio < 0 || cbc.getRatio > 1 {
+		return errors.New("get ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+type cacheReplayConfig struct {
+	operationsLog string
+	speed         float64
+	ttl           time.Duration
+}
+
+func (crc *cacheReplayConfig) registerFlag(cmd extkingpin.FlagClause) *cacheReplayConfig {
+	cmd.Flag("operations-log-file", "Path of the index cache operations log to replay, as recorded by the Store Gateway with --index-cache.operations-log-file.").Required().PlaceHolder("<file-path>").StringVar(&crc.operationsLog)
+	cmd.Flag("speed", "Pace of the replay relative to the recorded one, e.g. 2 to replay twice as fast. 0 means the operations are replayed as fast as possible.").Default("0").Float64Var(&crc.speed)
+	cmd.Flag("ttl", "TTL of the values stored to the cache.").Default("5m").DurationVar(&crc.ttl)
+	return crc
+}
+
+func registerCache(app extkingpin.AppClause) {
+	cmd := app.Command("cache", "Cache utility commands")
+
+	registerCacheBenchmark(cmd)
+	registerCacheReplay(cmd)
+}
+
+func registerCacheBenchmark(app extkingpin.AppClause) {
+	cmd := app.Command("benchmark", "Run a mix of SetAsync and GetMulti operations against synthetic keys of a remote cache, and report their throughput, latency and errors, to validate the cache configuration end-to-end.")
+	cacheConfig := extflag.RegisterPathOrContent(cmd, "cache.config", "YAML file that contains the remote cache configuration, in the same format as the Store Gateway index cache one. Only MEMCACHED and REDIS types are supported. See format details: https://thanos.io/tip/components/store.md/#index-cache", extflag.WithRequired())
+
+	cbc := &cacheBenchmarkConfig{}
+	cbc.registerFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		if err := cbc.validate(); err != nil {
+			return err
+		}
+		confContentYaml, err := cacheConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		client, err := storecache.NewRemoteCacheClient(logger, confContentYaml, reg)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runCacheBenchmark(ctx, client, *cbc, os.Stdout)
+		}, func(error) {
+			cancel()
+			client.Stop()
+		})
+		return nil
+	})
+}
+
+func registerCacheReplay(app extkingpin.AppClause) {
+	cmd := app.Command("replay", "Replay the sequence of fetches and stores recorded to an index cache operations log against synthetic keys of a remote cache, and report the replayed hit ratio and latencies along with the recorded hit ratio, to reproduce a behavior of the production cache offline.")
+	cacheConfig := extflag.RegisterPathOrContent(cmd, "cache.config", "YAML file that contains the remote cache configuration, in the same format as the Store Gateway index cache one. Only MEMCACHED and REDIS types are supported. See format details: https://thanos.io/tip/components/store.md/#index-cache", extflag.WithRequired())
+
+	crc := &cacheReplayConfig{}
+	crc.registerFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		if crc.speed < 0 {
+			return errors.New("speed must not be negative")
+		}
+		confContentYaml, err := cacheConfig.Content()
+		if err != nil {
+			return err
+		}
+		client, err := storecache.NewRemoteCacheClient(logger, confContentYaml, reg)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			f, err := os.Open(crc.operationsLog)
+			if err != nil {
+				return errors.Wrap(err, "open operations log")
+			}
+			defer runutil.CloseWithLogOnErr(logger, f, "operations log")
+
+			return runCacheReplay(ctx, client, f, *crc, os.Stdout)
+		}, func(error) {
+			cancel()
+			client.Stop()
+		})
+		return nil
+	})
+}
+
+// cacheReplayStats holds the results of the replayed operations of a type.
+type cacheReplayStats struct {
+	cacheBenchmarkStats
+
+	recordedHits int
+}
+
+// runCacheReplay replays the operations read from the operations log against the cache client, in
+// order and at the configured pace, and writes a report of their results to out. The recorded keys
+// aren't known, so each store is replayed with as many synthetic keys of the same block and item
+// type, and each fetch is replayed with as many of the keys stored last as it recorded hits, and
+// never stored keys for the rest. The keys are namespaced by a random run ID, so that they never
+// collide with actual entries.
+func runCacheReplay(ctx context.Context, client cacheutil.RemoteCacheClient, operationsLog io.Reader, conf cacheReplayConfig, out io.Writer) error {
+	prefix := "thanos-cache-replay:" + ulid.MustNew(ulid.Now(), rand.New(rand.NewSource(time.Now().UnixNano()))).String() + ":"
+
+	var (
+		stats = map[string]*cacheReplayStats{storecache.OperationFetch: {}, storecache.OperationStore: {}}
+		// Number of synthetic keys stored and missed so far, by block and item type.
+		stored = map[string]int{}
+		missed = map[string]int{}
+		value  []byte
+
+		first, start time.Time
+	)
+	err := storecache.ReadOperationsLog(operationsLog, func(e storecache.OperationsLogEntry) error {
+		if first.IsZero() {
+			first, start = e.Time, time.Now()
+		}
+		if conf.speed > 0 {
+			wait := time.Until(start.Add(time.Duration(float64(e.Time.Sub(first)) / conf.speed)))
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+
+		group := prefix + e.ItemType + ":" + e.Block + ":"
+		s := stats[e.Op]
+		switch e.Op {
+		case storecache.OperationStore:
+			size := 0
+			if e.Keys > 0 {
+				size = e.Bytes / e.Keys
+			}
+			if size > len(value) {
+				value = make([]byte, size)
+			}
+
+			opStart := time.Now()
+			for i := 0; i < e.Keys; i++ {
+				if err := client.SetAsync(ctx, group+strconv.Itoa(stored[group]), value[:size], conf.ttl); err != nil {
+					s.errors++
+				}
+				stored[group]++
+			}
+			s.durations = append(s.durations, time.Since(opStart))
+		case storecache.OperationFetch:
+			keys := make([]string, 0, e.Keys)
+			for i := 0; i < e.Hits && i < stored[group]; i++ {
+				keys = append(keys, group+strconv.Itoa(stored[group]-1-i))
+			}
+			for len(keys) < e.Keys {
+				keys = append(keys, group+"miss:"+strconv.Itoa(missed[group]))
+				missed[group]++
+			}
+
+			opStart := time.Now()
+			hits, err := getMulti(ctx, client, keys)
+			s.durations = append(s.durations, time.Since(opStart))
+			if err != nil {
+				s.errors++
+			}
+			s.fetchedKeys += len(keys)
+			s.hits += len(hits)
+			s.recordedHits += e.Hits
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tCOUNT\tERRORS\tRECORDED HIT RATIO\tREPLAYED HIT RATIO\tP50\tP90\tP99\tMAX")
+	for _, op := range []string{storecache.OperationFetch, storecache.OperationStore} {
+		s := stats[op]
+		sort.Slice(s.durations, func(i, j int) bool { return s.durations[i] < s.durations[j] })
+
+		recordedHitRatio, replayedHitRatio := "-", "-"
+		if op == storecache.OperationFetch {
+			recordedHitRatio, replayedHitRatio = formatRatio(s.recordedHits, s.fetchedKeys), formatRatio(s.hits, s.fetchedKeys)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%v\t%v\t%v\t%v\n", op, len(s.durations), s.errors, recordedHitRatio, replayedHitRatio,
+			durationQuantile(s.durations, 0.5), durationQuantile(s.durations, 0.9), durationQuantile(s.durations, 0.99), durationQuantile(s.durations, 1))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, "\nThe stores are replayed with SetAsync, so the fetches replayed right after them may miss entries still being stored.")
+	return err
+}
+
+// cacheBenchmarkStats holds the results of the operations of a type run by a worker.
+type cacheBenchmarkStats struct {
+	durations   []time.Duration
+	errors      int
+	fetchedKeys int
+	hits        int
+}
+
+func (s *cacheBenchmarkStats) merge(o cacheBenchmarkStats) {
+	s.durations = append(s.durations, o.durations...)
+	s.errors += o.errors
+	s.fetchedKeys += o.fetchedKeys
+	s.hits += o.hits
+}
+
+// runCacheBenchmark runs the operations against the cache client for the configured duration and
+// writes a report of their results to out. The keys are namespaced by a random run ID, so that they
+// never collide with actual entries.
+func runCacheBenchmark(ctx context.Context, client cacheutil.RemoteCacheClient, conf cacheBenchmarkConfig, out io.Writer) error {
+	runID := ulid.MustNew(ulid.Now(), rand.New(rand.NewSource(time.Now().UnixNano()))).String()
+	keys := make([]string, conf.numKeys)
+	for i := range keys {
+		keys[i] = "thanos-cache-benchmark:" + runID + ":" + strconv.Itoa(i)
+	}
+	value := make([]byte, conf.valueSize)
+
+	ctx, cancel := context.WithTimeout(ctx, conf.duration)
+	defer cancel()
+
+	var (
+		mtx   sync.Mutex
+		wg    sync.WaitGroup
+		stats = map[string]*cacheBenchmarkStats{cacheBenchmarkOpGetMulti: {}, cacheBenchmarkOpSetAsync: {}}
+	)
+	start := time.Now()
+	for w := 0; w < conf.concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			rnd := rand.New(rand.NewSource(seed))
+			var gets, sets cacheBenchmarkStats
+			batch := make([]string, conf.batchSize)
+			for ctx.Err() == nil {
+				if rnd.Float64() < conf.getRatio {
+					for i := range batch {
+						batch[i] = keys[rnd.Intn(len(keys))]
+					}
+					opStart := time.Now()
+					hits, err := getMulti(ctx, client, batch)
+					if ctx.Err() != nil {
+						// Don't account for the operations interrupted by the end of the benchmark.
+						break
+					}
+					gets.durations = append(gets.durations, time.Since(opStart))
+					if err != nil {
+						gets.errors++
+					}
+					gets.fetchedKeys += len(batch)
+					gets.hits += len(hits)
+					continue
+				}
+
+				opStart := time.Now()
+				err := client.SetAsync(ctx, keys[rnd.Intn(len(keys))], value, conf.ttl)
+				sets.durations = append(sets.durations, time.Since(opStart))
+				if err != nil {
+					sets.errors++
+				}
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			stats[cacheBenchmarkOpGetMulti].merge(gets)
+			stats[cacheBenchmarkOpSetAsync].merge(sets)
+		}(start.UnixNano() + int64(w))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tCOUNT\tOPS/S\tERRORS\tERROR RATE\tHIT RATIO\tP50\tP90\tP99\tMAX")
+	for _, op := range []string{cacheBenchmarkOpGetMulti, cacheBenchmarkOpSetAsync} {
+		s := stats[op]
+		sort.Slice(s.durations, func(i, j int) bool { return s.durations[i] < s.durations[j] })
+
+		count := len(s.durations)
+		hitRatio := "-"
+		if op == cacheBenchmarkOpGetMulti {
+			hitRatio = formatRatio(s.hits, s.fetchedKeys)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%d\t%s\t%s\t%v\t%v\t%v\t%v\n", op, count, float64(count)/elapsed.Seconds(), s.errors, formatRatio(s.errors, count), hitRatio,
+			durationQuantile(s.durations, 0.5), durationQuantile(s.durations, 0.9), durationQuantile(s.durations, 0.99), durationQuantile(s.durations, 1))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(out, "\nSetAsync latencies only account for enqueuing the operations. Their asynchronous failures are tracked by the client metrics.")
+	return err
+}
+
+// getMulti fetches the keys, returning the error if the client supports reporting it.
+func getMulti(ctx context.Context, client cacheutil.RemoteCacheClient, keys []string) (map[string][]byte, error) {
+	if client, ok := client.(cacheutil.RemoteCacheClientWithError); ok {
+		return client.GetMultiWithError(ctx, keys)
+	}
+	return client.GetMulti(ctx, keys), nil
+}
+
+func formatRatio(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", 100*float64(n)/float64(total))
+}
+
+// durationQuantile returns the quantile q of the sorted durations.
+func durationQuantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(q*float64(len(sorted))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
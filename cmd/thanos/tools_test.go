@@ -4,7 +4,12 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 
@@ -45,3 +50,86 @@ func Test_CheckRules_Glob(t *testing.T) {
 	files = &[]string{"./testdata/rules-files/*.yamlaaa"}
 	testutil.NotOk(t, checkRulesFiles(logger, files), "expected err for file %s", files)
 }
+
+type benchmarkCacheClient struct {
+	mtx   sync.Mutex
+	items map[string][]byte
+}
+
+func (c *benchmarkCacheClient) GetMulti(_ context.Context, keys []string) map[string][]byte {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	hits := map[string][]byte{}
+	for _, key := range keys {
+		if v, ok := c.items[key]; ok {
+			hits[key] = v
+		}
+	}
+	return hits
+}
+
+func (c *benchmarkCacheClient) SetAsync(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.items[key] = value
+	return nil
+}
+
+func (c *benchmarkCacheClient) Stop() {}
+
+func Test_CacheBenchmark(t *testing.T) {
+	client := &benchmarkCacheClient{items: map[string][]byte{}}
+	out := &bytes.Buffer{}
+	testutil.Ok(t, runCacheBenchmark(context.Background(), client, cacheBenchmarkConfig{
+		duration:    100 * time.Millisecond,
+		concurrency: 2,
+		numKeys:     10,
+		valueSize:   16,
+		getRatio:    0.5,
+		batchSize:   5,
+		ttl:         time.Minute,
+	}, out))
+
+	lines := strings.Split(out.String(), "\n")
+	testutil.Assert(t, strings.HasPrefix(lines[0], "OPERATION"), "unexpected report %s", out.String())
+	testutil.Assert(t, strings.HasPrefix(lines[1], cacheBenchmarkOpGetMulti), "unexpected report %s", out.String())
+	testutil.Assert(t, strings.HasPrefix(lines[2], cacheBenchmarkOpSetAsync), "unexpected report %s", out.String())
+	testutil.Assert(t, len(client.items) > 0, "no values stored")
+
+	// The synthetic keys are namespaced away from the actual entries.
+	for key := range client.items {
+		testutil.Assert(t, strings.HasPrefix(key, "thanos-cache-benchmark:"), "unexpected key %s", key)
+	}
+}
+
+func Test_CacheReplay(t *testing.T) {
+	client := &benchmarkCacheClient{items: map[string][]byte{}}
+	operationsLog := strings.NewReader(`{"time":"2023-01-01T00:00:00Z","op":"store","item_type":"Postings","block":"01GNKX0000000000000000000","keys":2,"bytes":8}
+{"time":"2023-01-01T00:00:00.001Z","op":"fetch","item_type":"Postings","block":"01GNKX0000000000000000000","keys":3,"hits":2,"bytes":8}
+{"time":"2023-01-01T00:00:00.002Z","op":"fetch","item_type":"Series","block":"01GNKX0000000000000000000","keys":1,"hits":1,"bytes":4}
+`)
+	out := &bytes.Buffer{}
+	testutil.Ok(t, runCacheReplay(context.Background(), client, operationsLog, cacheReplayConfig{speed: 1, ttl: time.Minute}, out))
+
+	// The hits of the fetches of the entries never stored during the replay can't be replayed.
+	lines := strings.Split(out.String(), "\n")
+	testutil.Assert(t, strings.HasPrefix(lines[0], "OPERATION"), "unexpected report %s", out.String())
+	testutil.Equals(t, []string{"fetch", "2", "0", "75.00%", "50.00%"}, strings.Fields(lines[1])[:5])
+	testutil.Equals(t, []string{"store", "1", "0", "-", "-"}, strings.Fields(lines[2])[:5])
+	testutil.Equals(t, 2, len(client.items))
+	for key, value := range client.items {
+		testutil.Assert(t, strings.HasPrefix(key, "thanos-cache-replay:"), "unexpected key %s", key)
+		testutil.Equals(t, 4, len(value))
+	}
+}
+
+func Test_DurationQuantile(t *testing.T) {
+	durations := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	testutil.Equals(t, time.Duration(0), durationQuantile(nil, 0.5))
+	testutil.Equals(t, time.Duration(5), durationQuantile(durations, 0.5))
+	testutil.Equals(t, time.Duration(9), durationQuantile(durations, 0.9))
+	testutil.Equals(t, time.Duration(10), durationQuantile(durations, 1))
+	testutil.Equals(t, time.Duration(1), durationQuantile(durations, 0))
+}
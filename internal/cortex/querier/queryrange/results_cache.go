@@ -4,12 +4,15 @@
 package queryrange
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
@@ -20,12 +23,14 @@ import (
 	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/uber/jaeger-client-go"
 	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
 
 	"github.com/thanos-io/thanos/internal/cortex/chunk/cache"
 	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
@@ -43,6 +48,11 @@ var (
 
 	// ResultsCacheGenNumberHeaderName holds name of the header we want to set in http response
 	ResultsCacheGenNumberHeaderName = "Results-Cache-Gen-Number"
+
+	// Header of the cached values prefixed by the time they were stored at, written when
+	// stale-while-revalidate is enabled. It can't be mistaken for the beginning of a
+	// marshalled CachedResponse, given 0 is not a valid protobuf field tag.
+	storedAtHeader = []byte("\x00SWR")
 )
 
 type CacheGenNumberLoader interface {
@@ -54,6 +64,9 @@ type ResultsCacheConfig struct {
 	CacheConfig                cache.Config `yaml:"cache"`
 	Compression                string       `yaml:"compression"`
 	CacheQueryableSamplesStats bool         `yaml:"cache_queryable_samples_stats"`
+	// StaleWhileRevalidate is how long expired entries are kept and served while being
+	// recomputed in the background. 0 disables it.
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
 }
 
 // RegisterFlags registers flags.
@@ -78,6 +91,10 @@ func (cfg *ResultsCacheConfig) Validate(qCfg querier.Config) error {
 		return errors.New("frontend.cache-queryable-samples-stats may only be enabled in conjunction with querier.per-step-stats-enabled. Please set the latter")
 	}
 
+	if cfg.StaleWhileRevalidate < 0 {
+		return errors.New("stale-while-revalidate window must not be negative")
+	}
+
 	return cfg.CacheConfig.Validate()
 }
 
@@ -166,6 +183,37 @@ type resultsCache struct {
 	cacheGenNumberLoader       CacheGenNumberLoader
 	shouldCache                ShouldCacheFn
 	cacheQueryableSamplesStats bool
+
+	// maxFreshAge is the age after which cached entries are stale and recomputed in the
+	// background while still being served. 0 means that entries are never stale.
+	maxFreshAge   time.Duration
+	revalidations *revalidations
+	cacheHits     *prometheus.CounterVec
+}
+
+// revalidations tracks the keys of the stale entries being recomputed, so that each stale
+// entry is recomputed once at a time.
+type revalidations struct {
+	mtx      sync.Mutex
+	inflight map[string]struct{}
+}
+
+func (r *revalidations) start(key string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.inflight[key]; ok {
+		return false
+	}
+	r.inflight[key] = struct{}{}
+	return true
+}
+
+func (r *revalidations) done(key string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.inflight, key)
 }
 
 // NewResultsCacheMiddleware creates results cache middleware from config.
@@ -185,6 +233,11 @@ func NewResultsCacheMiddleware(
 	shouldCache ShouldCacheFn,
 	reg prometheus.Registerer,
 ) (Middleware, cache.Cache, error) {
+	var maxFreshAge time.Duration
+	if cfg.StaleWhileRevalidate > 0 {
+		maxFreshAge = extendExpirations(&cfg.CacheConfig, cfg.StaleWhileRevalidate)
+	}
+
 	c, err := cache.New(cfg.CacheConfig, reg, logger)
 	if err != nil {
 		return nil, nil, err
@@ -197,6 +250,15 @@ func NewResultsCacheMiddleware(
 		c = cache.NewCacheGenNumMiddleware(c)
 	}
 
+	cacheHits := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "frontend_results_cache_hits_total",
+		Help:      "Total number of requests served from the results cache, by freshness of the cached entry.",
+	}, []string{"freshness"})
+	cacheHits.WithLabelValues("fresh")
+	cacheHits.WithLabelValues("stale")
+	revalidations := &revalidations{inflight: map[string]struct{}{}}
+
 	return MiddlewareFunc(func(next Handler) Handler {
 		return &resultsCache{
 			logger:                     logger,
@@ -211,10 +273,101 @@ func NewResultsCacheMiddleware(
 			cacheGenNumberLoader:       cacheGenNumberLoader,
 			shouldCache:                shouldCache,
 			cacheQueryableSamplesStats: cfg.CacheQueryableSamplesStats,
+			maxFreshAge:                maxFreshAge,
+			revalidations:              revalidations,
+			cacheHits:                  cacheHits,
 		}
 	}), c, nil
 }
 
+// CachedExtentsReporter reports the extents of the results cached for the requests.
+type CachedExtentsReporter interface {
+	// CachedExtents returns the extents cached under the cache key of the request, ordered by start time.
+	CachedExtents(ctx context.Context, r Request) ([]Extent, error)
+}
+
+// NewCachedExtentsReporter returns a CachedExtentsReporter of the results stored to c, the cache
+// returned by NewResultsCacheMiddleware, under the keys generated by splitter.
+func NewCachedExtentsReporter(logger log.Logger, c cache.Cache, splitter CacheSplitter) CachedExtentsReporter {
+	return resultsCache{logger: logger, cache: c, splitter: splitter}
+}
+
+// CachedExtents implements CachedExtentsReporter. If the context has been returned by
+// ContextWithReportedExtents, the entry fetched is kept in it for the results cache to reuse.
+func (s resultsCache) CachedExtents(ctx context.Context, r Request) ([]Extent, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	key := s.splitter.GenerateCacheKey(tenant.JoinTenantIDs(tenantIDs), r)
+	extents, storedAt, ok := s.get(ctx, key)
+	if reported, found := ctx.Value(reportedExtentsKey{}).(*reportedExtents); found {
+		reported.add(key, reportedEntry{extents: extents, storedAt: storedAt, ok: ok})
+	}
+	return extents, nil
+}
+
+type reportedExtentsKey struct{}
+
+// reportedEntry is an entry fetched from the results cache by a CachedExtentsReporter.
+type reportedEntry struct {
+	extents  []Extent
+	storedAt time.Time
+	ok       bool
+}
+
+// reportedExtents holds the entries fetched by a CachedExtentsReporter, by cache key.
+type reportedExtents struct {
+	mtx     sync.Mutex
+	entries map[string]reportedEntry
+}
+
+func (r *reportedExtents) add(key string, e reportedEntry) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.entries[key] = e
+}
+
+// get returns the entry of the key, with a copy of its extents given the results cache appends
+// to and sorts them in place.
+func (r *reportedExtents) get(key string) (reportedEntry, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	e, ok := r.entries[key]
+	if ok {
+		e.extents = append([]Extent(nil), e.extents...)
+	}
+	return e, ok
+}
+
+// ContextWithReportedExtents returns a context in which the entries fetched by the CachedExtents
+// of a CachedExtentsReporter are kept, so that the results cache serving the requests made with
+// it reuses them rather than fetching them again.
+func ContextWithReportedExtents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reportedExtentsKey{}, &reportedExtents{entries: map[string]reportedEntry{}})
+}
+
+// extendExpirations extends the expiration of the configured cache backends by the window,
+// so that expired entries are kept for that long, and returns the shortest expiration they
+// had, after which entries are stale. If no expiration is configured, entries never expire
+// and 0 is returned.
+func extendExpirations(cfg *cache.Config, window time.Duration) time.Duration {
+	var maxFreshAge time.Duration
+	for _, expiration := range []*time.Duration{&cfg.Fifocache.Validity, &cfg.Memcache.Expiration, &cfg.Redis.Expiration} {
+		if *expiration == 0 {
+			*expiration = cfg.DefaultValidity
+		}
+		if *expiration <= 0 {
+			continue
+		}
+		if maxFreshAge == 0 || *expiration < maxFreshAge {
+			maxFreshAge = *expiration
+		}
+		*expiration += window
+	}
+	return maxFreshAge
+}
+
 func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
 	tenantIDs, err := tenant.TenantIDs(ctx)
 	respWithStats := r.GetStats() != "" && s.cacheQueryableSamplesStats
@@ -249,8 +402,24 @@ func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
 		return s.next.Do(ctx, r)
 	}
 
-	cached, ok := s.get(ctx, key)
+	cached, storedAt, ok := s.getReported(ctx, key)
+	if ok && s.isStale(storedAt) {
+		// Serve the stale entry, without writing it back, while it's recomputed in the background.
+		response, _, err = s.handleHit(ctx, r, cached, maxCacheTime)
+		if err != nil {
+			return nil, err
+		}
+		s.cacheHits.WithLabelValues("stale").Inc()
+		s.revalidate(ctx, key, r, maxCacheTime, maxCacheFreshness)
+
+		if !respWithStats {
+			response = s.extractor.ResponseWithoutStats(response)
+		}
+		return response, nil
+	}
+
 	if ok {
+		s.cacheHits.WithLabelValues("fresh").Inc()
 		response, extents, err = s.handleHit(ctx, r, cached, maxCacheTime)
 	} else {
 		response, extents, err = s.handleMiss(ctx, r, maxCacheTime)
@@ -270,6 +439,99 @@ func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
 	return response, err
 }
 
+// isStale returns whether an entry stored at the given time has to be recomputed. Entries
+// stored before stale-while-revalidate was enabled have no known age and are never stale.
+func (s resultsCache) isStale(storedAt time.Time) bool {
+	return s.maxFreshAge > 0 && !storedAt.IsZero() && time.Since(storedAt) > s.maxFreshAge
+}
+
+// revalidate recomputes the request in the background, unless the entry is already being recomputed,
+// merging the result into the cache entry, whose stale extents covered by the result are replaced
+// while the others are kept. The recomputation is bounded by the staleness window, past which the
+// entry it would update has expired anyway.
+func (s resultsCache) revalidate(ctx context.Context, key string, r Request, maxCacheTime int64, maxCacheFreshness time.Duration) {
+	if !s.revalidations.start(key) {
+		return
+	}
+
+	// The request context is canceled once the stale response is served, so detach from it.
+	orgID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		s.revalidations.done(key)
+		return
+	}
+	revalidateCtx := user.InjectOrgID(context.Background(), orgID)
+	if genNumber := cache.ExtractCacheGenNumber(ctx); genNumber != "" {
+		revalidateCtx = cache.InjectCacheGenNumber(revalidateCtx, genNumber)
+	}
+	revalidateCtx, cancel := context.WithTimeout(revalidateCtx, s.cfg.StaleWhileRevalidate)
+
+	go func() {
+		defer s.revalidations.done(key)
+		defer cancel()
+
+		_, extents, err := s.handleMiss(revalidateCtx, r, maxCacheTime)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to revalidate stale results cache entry", "key", key, "err", err)
+			return
+		}
+		if len(extents) == 0 {
+			return
+		}
+
+		extents, err = s.filterRecentExtents(r, maxCacheFreshness, extents)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to revalidate stale results cache entry", "key", key, "err", err)
+			return
+		}
+		if len(extents) == 0 {
+			return
+		}
+
+		// Read the entry again, given it may have been updated while recomputing.
+		if cached, _, ok := s.get(revalidateCtx, key); ok {
+			kept, err := trimExtents(cached, extents[0].Start, extents[len(extents)-1].End, s.extractor)
+			if err != nil {
+				level.Warn(s.logger).Log("msg", "failed to revalidate stale results cache entry", "key", key, "err", err)
+				return
+			}
+			if extents, err = s.mergeExtents(revalidateCtx, r, append(kept, extents...)); err != nil {
+				level.Warn(s.logger).Log("msg", "failed to revalidate stale results cache entry", "key", key, "err", err)
+				return
+			}
+		}
+		s.put(revalidateCtx, key, extents)
+	}()
+}
+
+// trimExtents returns the parts of the extents outside of [start, end], so that the extents
+// recomputed for that range replace the stale ones instead of being merged with them.
+func trimExtents(extents []Extent, start, end int64, extractor Extractor) ([]Extent, error) {
+	kept := make([]Extent, 0, len(extents))
+	for _, extent := range extents {
+		if extent.End < start || extent.Start > end {
+			kept = append(kept, extent)
+			continue
+		}
+
+		res, err := extent.toResponse()
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range [][2]int64{{extent.Start, start - 1}, {end + 1, extent.End}} {
+			if part[0] > part[1] {
+				continue
+			}
+			any, err := types.MarshalAny(extractor.Extract(part[0], part[1], res))
+			if err != nil {
+				return nil, err
+			}
+			kept = append(kept, Extent{Start: part[0], End: part[1], Response: any, TraceId: extent.TraceId})
+		}
+	}
+	return kept, nil
+}
+
 // shouldCacheResponse says whether the response should be cached or not.
 func (s resultsCache) shouldCacheResponse(ctx context.Context, req Request, r Response, maxCacheTime int64) bool {
 	headerValues := getHeaderValuesWithName(r, cacheControlHeader)
@@ -425,6 +687,17 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		}
 		extents = append(extents, extent)
 	}
+	mergedExtents, err := s.mergeExtents(ctx, r, extents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := s.merger.MergeResponse(r, responses...)
+	return response, mergedExtents, err
+}
+
+// mergeExtents sorts the extents and merges the overlapping or adjacent ones.
+func (s resultsCache) mergeExtents(ctx context.Context, r Request, extents []Extent) ([]Extent, error) {
 	sort.Slice(extents, func(i, j int) bool {
 		if extents[i].Start == extents[j].Start {
 			// as an optimization, for two extents starts at the same time, we
@@ -439,7 +712,7 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 	// Merge any extents - potentially overlapping
 	accumulator, err := newAccumulator(extents[0])
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	mergedExtents := make([]Extent, 0, len(extents))
 
@@ -447,11 +720,11 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		if accumulator.End+r.GetStep() < extents[i].Start {
 			mergedExtents, err = merge(mergedExtents, accumulator)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			accumulator, err = newAccumulator(extents[i])
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			continue
 		}
@@ -464,22 +737,16 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		accumulator.End = extents[i].End
 		currentRes, err := extents[i].toResponse()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		merged, err := s.merger.MergeResponse(r, accumulator.Response, currentRes)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		accumulator.Response = merged
 	}
 
-	mergedExtents, err = merge(mergedExtents, accumulator)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	response, err := s.merger.MergeResponse(r, responses...)
-	return response, mergedExtents, err
+	return merge(mergedExtents, accumulator)
 }
 
 type accumulator struct {
@@ -597,10 +864,24 @@ func (s resultsCache) filterRecentExtents(req Request, maxCacheFreshness time.Du
 	return extents, nil
 }
 
-func (s resultsCache) get(ctx context.Context, key string) ([]Extent, bool) {
+// get returns the cached extents and the time they were stored at, which is zero
+// if unknown.
+// getReported is like get, but reuses the entry of the key already fetched by CachedExtents
+// with the context, if any. The reported entries are fetched without a cache generation number,
+// so they aren't reused when using one.
+func (s resultsCache) getReported(ctx context.Context, key string) ([]Extent, time.Time, bool) {
+	if reported, found := ctx.Value(reportedExtentsKey{}).(*reportedExtents); found && s.cacheGenNumberLoader == nil {
+		if e, ok := reported.get(key); ok {
+			return e.extents, e.storedAt, e.ok
+		}
+	}
+	return s.get(ctx, key)
+}
+
+func (s resultsCache) get(ctx context.Context, key string) ([]Extent, time.Time, bool) {
 	found, bufs, _ := s.cache.Fetch(ctx, []string{cache.HashKey(key)})
 	if len(found) != 1 {
-		return nil, false
+		return nil, time.Time{}, false
 	}
 
 	var resp CachedResponse
@@ -609,24 +890,30 @@ func (s resultsCache) get(ctx context.Context, key string) ([]Extent, bool) {
 
 	log.LogFields(otlog.Int("bytes", len(bufs[0])))
 
-	if err := proto.Unmarshal(bufs[0], &resp); err != nil {
+	buf, storedAt := bufs[0], time.Time{}
+	if bytes.HasPrefix(buf, storedAtHeader) && len(buf) >= len(storedAtHeader)+8 {
+		storedAt = time.UnixMilli(int64(binary.BigEndian.Uint64(buf[len(storedAtHeader):])))
+		buf = buf[len(storedAtHeader)+8:]
+	}
+
+	if err := proto.Unmarshal(buf, &resp); err != nil {
 		level.Error(log).Log("msg", "error unmarshalling cached value", "err", err)
 		log.Error(err)
-		return nil, false
+		return nil, time.Time{}, false
 	}
 
 	if resp.Key != key {
-		return nil, false
+		return nil, time.Time{}, false
 	}
 
 	// Refreshes the cache if it contains an old proto schema.
 	for _, e := range resp.Extents {
 		if e.Response == nil {
-			return nil, false
+			return nil, time.Time{}, false
 		}
 	}
 
-	return resp.Extents, true
+	return resp.Extents, storedAt, true
 }
 
 func (s resultsCache) put(ctx context.Context, key string, extents []Extent) {
@@ -639,6 +926,14 @@ func (s resultsCache) put(ctx context.Context, key string, extents []Extent) {
 		return
 	}
 
+	// Prefix the value with the time it's stored at, so that it's known when it becomes stale.
+	if s.maxFreshAge > 0 {
+		prefixed := make([]byte, len(storedAtHeader)+8, len(storedAtHeader)+8+len(buf))
+		copy(prefixed, storedAtHeader)
+		binary.BigEndian.PutUint64(prefixed[len(storedAtHeader):], uint64(time.Now().UnixMilli()))
+		buf = append(prefixed, buf...)
+	}
+
 	s.cache.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
 }
 
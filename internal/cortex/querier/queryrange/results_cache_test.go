@@ -7,11 +7,13 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/gogo/protobuf/types"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -1026,6 +1028,256 @@ func TestResultsCache(t *testing.T) {
 	require.Equal(t, 2, calls)
 }
 
+func TestCachedExtentsReporter(t *testing.T) {
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			Cache: cache.NewMockCache(),
+		},
+	}
+	rcm, c, err := NewResultsCacheMiddleware(
+		log.NewNopLogger(),
+		cfg,
+		constSplitter(day),
+		mockLimits{},
+		PrometheusCodec,
+		PrometheusResponseExtractor{},
+		nil,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+	reporter := NewCachedExtentsReporter(log.NewNopLogger(), c, constSplitter(day))
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	extents, err := reporter.CachedExtents(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Empty(t, extents)
+
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		return parsedResponse, nil
+	}))
+	_, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+
+	extents, err = reporter.CachedExtents(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Len(t, extents, 1)
+	require.Equal(t, parsedRequest.GetStart(), extents[0].Start)
+	require.Equal(t, parsedRequest.GetEnd(), extents[0].End)
+
+	// The requests of other tenants have other cache keys.
+	extents, err = reporter.CachedExtents(user.InjectOrgID(context.Background(), "2"), parsedRequest)
+	require.NoError(t, err)
+	require.Empty(t, extents)
+
+	_, err = reporter.CachedExtents(context.Background(), parsedRequest)
+	require.Error(t, err)
+
+	// The results cache reuses the entry reported with the context rather than fetching it
+	// again, even though it has been stored since.
+	calls := 0
+	rc = rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		calls++
+		return parsedResponse, nil
+	}))
+	ctx = user.InjectOrgID(context.Background(), "3")
+	reportedCtx := ContextWithReportedExtents(ctx)
+	_, err = reporter.CachedExtents(reportedCtx, parsedRequest)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = rc.Do(reportedCtx, parsedRequest)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, calls)
+
+	_, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+}
+
+func (r *revalidations) isInflight() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return len(r.inflight) > 0
+}
+
+func TestResultsCacheStaleWhileRevalidate(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		calls int
+	)
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			EnableFifoCache: true,
+			Fifocache: cache.FifoCacheConfig{
+				MaxSizeItems: 10,
+				Validity:     100 * time.Millisecond,
+			},
+		},
+		StaleWhileRevalidate: time.Hour,
+	}
+	rcm, _, err := NewResultsCacheMiddleware(
+		log.NewNopLogger(),
+		cfg,
+		constSplitter(day),
+		mockLimits{},
+		PrometheusCodec,
+		PrometheusResponseExtractor{},
+		nil,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		calls++
+		return parsedResponse, nil
+	}))
+	getCalls := func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return calls
+	}
+	hits := rc.(*resultsCache).cacheHits
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	resp, err := rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 1, getCalls())
+	require.Equal(t, parsedResponse, resp)
+
+	// The entry is fresh.
+	resp, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 1, getCalls())
+	require.Equal(t, parsedResponse, resp)
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(hits.WithLabelValues("fresh")))
+
+	// Once expired, the entry is still served, while recomputed in the background.
+	time.Sleep(200 * time.Millisecond)
+	resp, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, parsedResponse, resp)
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(hits.WithLabelValues("stale")))
+	require.Eventually(t, func() bool {
+		return getCalls() == 2 && !rc.(*resultsCache).revalidations.isInflight()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The recomputed entry is fresh again.
+	resp, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 2, getCalls())
+	require.Equal(t, parsedResponse, resp)
+	require.Equal(t, 2.0, prom_testutil.ToFloat64(hits.WithLabelValues("fresh")))
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(hits.WithLabelValues("stale")))
+}
+
+func TestResultsCacheStaleWhileRevalidate_ShouldKeepTheOtherExtents(t *testing.T) {
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			EnableFifoCache: true,
+			Fifocache: cache.FifoCacheConfig{
+				MaxSizeItems: 10,
+				Validity:     100 * time.Millisecond,
+			},
+		},
+		StaleWhileRevalidate: time.Hour,
+	}
+	rcm, _, err := NewResultsCacheMiddleware(
+		log.NewNopLogger(),
+		cfg,
+		constSplitter(day),
+		mockLimits{},
+		PrometheusCodec,
+		PrometheusResponseExtractor{},
+		nil,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		return mkAPIResponse(req.GetStart(), req.GetEnd(), req.GetStep()), nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// Both requests are cached in the same entry, as distinct extents.
+	reqA := &PrometheusRequest{Start: 0, End: 100, Step: 10, Query: "up"}
+	reqB := &PrometheusRequest{Start: 500, End: 600, Step: 10, Query: "up"}
+	_, err = rc.Do(ctx, reqA)
+	require.NoError(t, err)
+	_, err = rc.Do(ctx, reqB)
+	require.NoError(t, err)
+
+	// Once revalidated, the entry should still hold the extent of the request not revalidated.
+	time.Sleep(200 * time.Millisecond)
+	_, err = rc.Do(ctx, reqB)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return !rc.(*resultsCache).revalidations.isInflight()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	key := constSplitter(day).GenerateCacheKey("1", reqB)
+	extents, storedAt, ok := rc.(*resultsCache).get(ctx, key)
+	require.True(t, ok)
+	require.False(t, rc.(*resultsCache).isStale(storedAt))
+	require.Equal(t, []Extent{mkExtent(0, 100), mkExtent(500, 600)}, withoutTraceIDs(extents))
+}
+
+func TestTrimExtents(t *testing.T) {
+	extents := []Extent{mkExtent(0, 100), mkExtent(200, 300), mkExtent(400, 500)}
+
+	// The extents overlapping the range are replaced by their parts outside of it.
+	trimmed, err := trimExtents(extents, 250, 420, PrometheusResponseExtractor{})
+	require.NoError(t, err)
+	require.Equal(t, []Extent{
+		mkExtent(0, 100),
+		withResponse(Extent{Start: 200, End: 249}, mkAPIResponse(200, 240, 10)),
+		withResponse(Extent{Start: 421, End: 500}, mkAPIResponse(430, 500, 10)),
+	}, trimmed)
+
+	trimmed, err = trimExtents(extents, 0, 1000, PrometheusResponseExtractor{})
+	require.NoError(t, err)
+	require.Empty(t, trimmed)
+}
+
+func withResponse(extent Extent, res Response) Extent {
+	any, err := types.MarshalAny(res)
+	if err != nil {
+		panic(err)
+	}
+	extent.Response = any
+	return extent
+}
+
+func withoutTraceIDs(extents []Extent) []Extent {
+	result := make([]Extent, 0, len(extents))
+	for _, e := range extents {
+		e.TraceId = ""
+		result = append(result, e)
+	}
+	return result
+}
+
+func TestExtendExpirations(t *testing.T) {
+	cfg := cache.Config{
+		DefaultValidity: time.Hour,
+		Memcache:        cache.MemcachedConfig{Expiration: time.Minute},
+	}
+	require.Equal(t, time.Minute, extendExpirations(&cfg, 10*time.Minute))
+	require.Equal(t, 11*time.Minute, cfg.Memcache.Expiration)
+	require.Equal(t, 70*time.Minute, cfg.Fifocache.Validity)
+	require.Equal(t, 70*time.Minute, cfg.Redis.Expiration)
+
+	// Without any expiration configured, entries never become stale.
+	cfg = cache.Config{}
+	require.Equal(t, time.Duration(0), extendExpirations(&cfg, 10*time.Minute))
+	require.Equal(t, time.Duration(0), cfg.Fifocache.Validity)
+}
+
 func TestResultsCacheRecent(t *testing.T) {
 	var cfg ResultsCacheConfig
 	flagext.DefaultValues(&cfg)
@@ -1159,15 +1411,15 @@ func Test_resultsCache_MissingData(t *testing.T) {
 		Response: nil,
 	}})
 
-	extents, hit := rc.get(ctx, "empty")
+	extents, _, hit := rc.get(ctx, "empty")
 	require.Empty(t, extents)
 	require.False(t, hit)
 
-	extents, hit = rc.get(ctx, "notempty")
+	extents, _, hit = rc.get(ctx, "notempty")
 	require.Equal(t, len(extents), 1)
 	require.True(t, hit)
 
-	extents, hit = rc.get(ctx, "mixed")
+	extents, _, hit = rc.get(ctx, "mixed")
 	require.Equal(t, len(extents), 0)
 	require.False(t, hit)
 }
@@ -56,6 +56,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/rules/rulespb"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/store"
+	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/targets"
 	"github.com/thanos-io/thanos/pkg/targets/targetspb"
@@ -184,7 +185,11 @@ func NewQueryAPI(
 func (qapi *QueryAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
 	qapi.baseAPI.Register(r, tracer, logger, ins, logMiddleware)
 
-	instr := api.GetInstr(tracer, logger, ins, logMiddleware, qapi.disableCORS)
+	apiInstr := api.GetInstr(tracer, logger, ins, logMiddleware, qapi.disableCORS)
+	// Forward the bypass of the index cache requested by a query to the StoreAPI servers.
+	instr := func(name string, f api.ApiFunc) http.HandlerFunc {
+		return storecache.BypassHTTPMiddleware(apiInstr(name, f)).ServeHTTP
+	}
 
 	r.Get("/query", instr("query", qapi.query))
 	r.Post("/query", instr("query", qapi.query))
@@ -6,6 +6,8 @@ package cache
 import (
 	"context"
 	"time"
+
+	"github.com/thanos-io/thanos/pkg/cacheutil"
 )
 
 // Generic best-effort cache.
@@ -21,3 +23,19 @@ type Cache interface {
 
 	Name() string
 }
+
+// CacheWithError is a Cache able to report the failures of its operations to the caller,
+// rather than only tracking/logging them, and to store items with different TTLs at once.
+type CacheWithError interface {
+	Cache
+
+	// StoreMulti stores the items, each with its own TTL. Returns an error in case it
+	// fails to store them, or to enqueue them when they're asynchronously stored.
+	//
+	// Note that individual byte buffers may be retained by the cache!
+	StoreMulti(ctx context.Context, items []cacheutil.RemoteCacheItem) error
+
+	// FetchMulti fetches multiple keys from cache, like Fetch. In case of error, the data
+	// fetched so far (if any) is returned along with the error.
+	FetchMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+}
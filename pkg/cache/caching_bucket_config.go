@@ -24,6 +24,8 @@ type CachingBucketConfig struct {
 	exists     map[string]*ExistsConfig
 	getRange   map[string]*GetRangeConfig
 	attributes map[string]*AttributesConfig
+
+	maxCacheableObjectSize int64
 }
 
 func NewCachingBucketConfig() *CachingBucketConfig {
@@ -36,6 +38,19 @@ func NewCachingBucketConfig() *CachingBucketConfig {
 	}
 }
 
+// SetMaxCacheableObjectSize sets the maximum size of the objects whose "Get" and "GetRange"
+// operations are served through the cache. Operations on bigger objects are passed through to
+// the bucket, without storing anything to the cache. Values <= 0 mean there is no limit.
+func (cfg *CachingBucketConfig) SetMaxCacheableObjectSize(size int64) {
+	cfg.maxCacheableObjectSize = size
+}
+
+// MaxCacheableObjectSize returns the maximum size of the objects served through the cache,
+// or a value <= 0 if there is no limit.
+func (cfg *CachingBucketConfig) MaxCacheableObjectSize() int64 {
+	return cfg.maxCacheableObjectSize
+}
+
 // SetCacheImplementation sets the value of Cache for all configurations.
 func (cfg *CachingBucketConfig) SetCacheImplementation(c Cache) {
 	if cfg.get != nil {
@@ -92,10 +107,11 @@ type GetConfig struct {
 
 type GetRangeConfig struct {
 	OperationConfig
-	SubrangeSize   int64
-	MaxSubRequests int
-	AttributesTTL  time.Duration
-	SubrangeTTL    time.Duration
+	SubrangeSize          int64
+	MaxSubRequests        int
+	MaxCacheableRangeSize int64
+	AttributesTTL         time.Duration
+	SubrangeTTL           time.Duration
 }
 
 type AttributesConfig struct {
@@ -149,14 +165,16 @@ func (cfg *CachingBucketConfig) CacheExists(configName string, cache Cache, matc
 // Since caching operation needs to know the object size to compute correct subranges, object size is cached as well.
 // Single "GetRange" requests can result in multiple smaller GetRange sub-requests issued on the underlying bucket.
 // MaxSubRequests specifies how many such subrequests may be issued. Values <= 0 mean there is no limit (requests
-// for adjacent missing subranges are still merged).
-func (cfg *CachingBucketConfig) CacheGetRange(configName string, cache Cache, matcher func(string) bool, subrangeSize int64, attributesTTL, subrangeTTL time.Duration, maxSubRequests int) {
+// for adjacent missing subranges are still merged). Requests for ranges longer than maxCacheableRangeSize are passed
+// through to the underlying bucket, to not evict the hot subranges. Values <= 0 mean there is no limit.
+func (cfg *CachingBucketConfig) CacheGetRange(configName string, cache Cache, matcher func(string) bool, subrangeSize, maxCacheableRangeSize int64, attributesTTL, subrangeTTL time.Duration, maxSubRequests int) {
 	cfg.getRange[configName] = &GetRangeConfig{
-		OperationConfig: newOperationConfig(cache, matcher),
-		SubrangeSize:    subrangeSize,
-		AttributesTTL:   attributesTTL,
-		SubrangeTTL:     subrangeTTL,
-		MaxSubRequests:  maxSubRequests,
+		OperationConfig:       newOperationConfig(cache, matcher),
+		SubrangeSize:          subrangeSize,
+		MaxCacheableRangeSize: maxCacheableRangeSize,
+		AttributesTTL:         attributesTTL,
+		SubrangeTTL:           subrangeTTL,
+		MaxSubRequests:        maxSubRequests,
 	}
 }
 
@@ -86,19 +86,26 @@ func parseGroupcacheConfig(conf []byte) (GroupcacheConfig, error) {
 		config.Peers = append(config.Peers, config.SelfURL)
 	}
 
-	for i, peer := range config.Peers {
+	if err := config.Validate(); err != nil {
+		return GroupcacheConfig{}, err
+	}
+	return config, nil
+}
+
+// Validate checks that the peers and self URL are well-formed.
+func (c *GroupcacheConfig) Validate() error {
+	for i, peer := range c.Peers {
 		// Workaround for https://github.com/thanos-community/galaxycache/blob/master/http/http.go#L205-L210.
 		// If the peer has a slash at the end then the router redirects
 		// and then the request fails.
 		if strings.HasSuffix(peer, "/") {
-			return GroupcacheConfig{}, fmt.Errorf("peer %d must not have a trailing slash (%s)", i, peer)
+			return fmt.Errorf("peer %d must not have a trailing slash (%s)", i, peer)
 		}
 	}
-	if strings.HasSuffix(config.SelfURL, "/") {
-		return GroupcacheConfig{}, fmt.Errorf("self URL %s must not have a trailing slash", config.SelfURL)
+	if strings.HasSuffix(c.SelfURL, "/") {
+		return fmt.Errorf("self URL %s must not have a trailing slash", c.SelfURL)
 	}
-
-	return config, nil
+	return nil
 }
 
 // NewGroupcache creates a new Groupcache instance.
@@ -114,44 +121,7 @@ func NewGroupcache(logger log.Logger, reg prometheus.Registerer, conf []byte, ba
 // NewGroupcacheWithConfig creates a new Groupcache instance with the given config.
 func NewGroupcacheWithConfig(logger log.Logger, reg prometheus.Registerer, conf GroupcacheConfig, basepath string, r *route.Router, bucket objstore.Bucket,
 	cfg *CachingBucketConfig) (*Groupcache, error) {
-	httpProto := galaxyhttp.NewHTTPFetchProtocol(&galaxyhttp.HTTPOptions{
-		BasePath: basepath,
-		Transport: &http2.Transport{
-			AllowHTTP: true,
-			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-				return net.Dial(network, addr)
-			},
-		},
-	})
-	universe := galaxycache.NewUniverse(httpProto, conf.SelfURL)
-
-	dnsGroupcacheProvider := dns.NewProvider(
-		logger,
-		extprom.WrapRegistererWithPrefix("thanos_store_groupcache_", reg),
-		dns.ResolverType(conf.DNSSDResolver),
-	)
-	ticker := time.NewTicker(conf.DNSInterval)
-
-	go func() {
-		for {
-			if err := dnsGroupcacheProvider.Resolve(context.Background(), conf.Peers); err != nil {
-				level.Error(logger).Log("msg", "failed to resolve addresses for groupcache", "err", err)
-			} else {
-				err := universe.Set(dnsGroupcacheProvider.Addresses()...)
-				if err != nil {
-					level.Error(logger).Log("msg", "failed to set peers for groupcache", "err", err)
-				}
-			}
-
-			<-ticker.C
-		}
-	}()
-
-	mux := http.NewServeMux()
-	galaxyhttp.RegisterHTTPHandler(universe, &galaxyhttp.HTTPOptions{
-		BasePath: basepath,
-	}, mux)
-	r.Get(filepath.Join(basepath, conf.GroupcacheGroup, "*key"), mux.ServeHTTP)
+	universe := NewGroupcacheUniverse(logger, extprom.WrapRegistererWithPrefix("thanos_store_groupcache_", reg), conf, basepath, r)
 
 	galaxy := universe.NewGalaxy(conf.GroupcacheGroup, int64(conf.MaxSize), galaxycache.GetterFunc(
 		func(ctx context.Context, id string, dest galaxycache.Codec) error {
@@ -265,6 +235,53 @@ func NewGroupcacheWithConfig(logger log.Logger, reg prometheus.Registerer, conf
 	}, nil
 }
 
+// NewGroupcacheUniverse creates the universe of the peers of the groupcache configured by conf,
+// which keeps resolving the configured peers, and registers the handler serving the galaxy of the
+// configured group to the peers under basepath to the router. The DNS provider metrics are
+// registered to dnsReg.
+func NewGroupcacheUniverse(logger log.Logger, dnsReg prometheus.Registerer, conf GroupcacheConfig, basepath string, r *route.Router) *galaxycache.Universe {
+	httpProto := galaxyhttp.NewHTTPFetchProtocol(&galaxyhttp.HTTPOptions{
+		BasePath: basepath,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	})
+	universe := galaxycache.NewUniverse(httpProto, conf.SelfURL)
+
+	dnsGroupcacheProvider := dns.NewProvider(
+		logger,
+		dnsReg,
+		dns.ResolverType(conf.DNSSDResolver),
+	)
+	ticker := time.NewTicker(conf.DNSInterval)
+
+	go func() {
+		for {
+			if err := dnsGroupcacheProvider.Resolve(context.Background(), conf.Peers); err != nil {
+				level.Error(logger).Log("msg", "failed to resolve addresses for groupcache", "err", err)
+			} else {
+				err := universe.Set(dnsGroupcacheProvider.Addresses()...)
+				if err != nil {
+					level.Error(logger).Log("msg", "failed to set peers for groupcache", "err", err)
+				}
+			}
+
+			<-ticker.C
+		}
+	}()
+
+	mux := http.NewServeMux()
+	galaxyhttp.RegisterHTTPHandler(universe, &galaxyhttp.HTTPOptions{
+		BasePath: basepath,
+	}, mux)
+	r.Get(filepath.Join(basepath, conf.GroupcacheGroup, "*key"), mux.ServeHTTP)
+
+	return universe
+}
+
 // unsafeByteCodec is a byte slice type that implements Codec.
 type unsafeByteCodec struct {
 	bytes  []byte
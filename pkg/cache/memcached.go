@@ -84,6 +84,37 @@ func (c *MemcachedCache) Fetch(ctx context.Context, keys []string) map[string][]
 	return results
 }
 
+// StoreMulti stores the items with a single asynchronous operation if the client supports it,
+// or enqueues them one by one otherwise. In case some items fail to be enqueued, the others
+// are still enqueued and the first error is returned.
+func (c *MemcachedCache) StoreMulti(ctx context.Context, items []cacheutil.RemoteCacheItem) error {
+	if multi, ok := c.memcached.(cacheutil.RemoteCacheClientWithSetMulti); ok && len(items) > 1 {
+		return multi.SetMultiAsync(ctx, items)
+	}
+
+	var firstErr error
+	for _, item := range items {
+		if err := c.memcached.SetAsync(ctx, item.Key, item.Value, item.TTL); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// FetchMulti fetches multiple keys like Fetch, returning the error if the client supports
+// reporting it, otherwise it's tracked/logged by the client itself.
+func (c *MemcachedCache) FetchMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	client, ok := c.memcached.(cacheutil.RemoteCacheClientWithError)
+	if !ok {
+		return c.Fetch(ctx, keys), nil
+	}
+
+	c.requests.Add(float64(len(keys)))
+	results, err := client.GetMultiWithError(ctx, keys)
+	c.hits.Add(float64(len(results)))
+	return results, err
+}
+
 func (c *MemcachedCache) Name() string {
 	return c.name
 }
@@ -13,6 +13,7 @@ import (
 	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/thanos/pkg/cacheutil"
 )
 
 func TestMemcachedCache(t *testing.T) {
@@ -88,6 +89,37 @@ func TestMemcachedCache(t *testing.T) {
 	}
 }
 
+func TestMemcachedCache_StoreMultiAndFetchMulti(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	items := []cacheutil.RemoteCacheItem{
+		{Key: "key1", Value: []byte{1}, TTL: time.Hour},
+		{Key: "key2", Value: []byte{2}, TTL: time.Minute},
+	}
+
+	t.Run("should store and fetch the items", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		c := NewMemcachedCache("test", log.NewNopLogger(), memcached, nil)
+
+		testutil.Ok(t, c.StoreMulti(ctx, items))
+		hits, err := c.FetchMulti(ctx, []string{"key1", "key2", "key3"})
+		testutil.Ok(t, err)
+		testutil.Equals(t, map[string][]byte{"key1": {1}, "key2": {2}}, hits)
+		testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c.requests))
+		testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.hits))
+	})
+
+	t.Run("should return the fetch errors if the client reports them", func(t *testing.T) {
+		memcached := &mockedMemcachedClientWithError{newMockedMemcachedClient(errors.New("mocked error"))}
+		c := NewMemcachedCache("test", log.NewNopLogger(), memcached, nil)
+
+		_, err := c.FetchMulti(ctx, []string{"key1"})
+		testutil.NotOk(t, err)
+		testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.requests))
+	})
+}
+
 // mockedMemcachedClient is a mocked memcached client for testing.
 type mockedMemcachedClient struct {
 	cache       map[string][]byte
@@ -126,3 +158,12 @@ func (c *mockedMemcachedClient) SetAsync(_ context.Context, key string, value []
 func (c *mockedMemcachedClient) Stop() {
 	// Nothing to do.
 }
+
+// mockedMemcachedClientWithError is a mocked memcached client reporting the GetMulti errors.
+type mockedMemcachedClientWithError struct {
+	*mockedMemcachedClient
+}
+
+func (c *mockedMemcachedClientWithError) GetMultiWithError(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return c.GetMulti(ctx, keys), c.getMultiErr
+}
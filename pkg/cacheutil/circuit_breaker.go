@@ -0,0 +1,100 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cacheutil
+
+import (
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/sony/gobreaker"
+)
+
+var (
+	defaultCircuitBreakerConfig = CircuitBreakerConfig{
+		Enabled:             false,
+		HalfOpenMaxRequests: 10,
+		OpenDuration:        5 * time.Second,
+		MinRequests:         50,
+		FailureRatio:        0.5,
+		Window:              10 * time.Second,
+	}
+
+	errCircuitBreakerOpenDurationNotPositive = errors.New("circuit breaker open duration must be positive")
+	errCircuitBreakerWindowNegative          = errors.New("circuit breaker window must not be negative")
+	errCircuitBreakerFailureRatio            = errors.New("circuit breaker failure ratio must be greater than 0 and at most 1")
+)
+
+// CircuitBreakerConfig is the config of the circuit breaker short-circuiting the operations
+// against a remote cache while it's failing.
+type CircuitBreakerConfig struct {
+	// Enabled enables the circuit breaker.
+	Enabled bool `yaml:"enabled"`
+
+	// HalfOpenMaxRequests specifies the maximum number of requests allowed to probe the
+	// backend while the circuit breaker is half-open. If all of them succeed, the circuit
+	// breaker closes again. If set to 0, a single request is allowed.
+	HalfOpenMaxRequests uint32 `yaml:"half_open_max_requests"`
+
+	// OpenDuration specifies how long the circuit breaker stays open, short-circuiting
+	// all requests, before becoming half-open.
+	OpenDuration time.Duration `yaml:"open_duration"`
+
+	// MinRequests specifies the minimum number of requests within the window required
+	// before the failure ratio is evaluated.
+	MinRequests uint32 `yaml:"min_requests"`
+
+	// FailureRatio specifies the ratio of failed requests within the window above which
+	// the circuit breaker opens.
+	FailureRatio float64 `yaml:"failure_ratio"`
+
+	// Window specifies the period after which the requests counts are reset while the
+	// circuit breaker is closed. If set to 0, the counts are never reset while closed.
+	Window time.Duration `yaml:"window"`
+}
+
+func (c *CircuitBreakerConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.OpenDuration <= 0 {
+		return errCircuitBreakerOpenDurationNotPositive
+	}
+	if c.Window < 0 {
+		return errCircuitBreakerWindowNegative
+	}
+	if c.FailureRatio <= 0 || c.FailureRatio > 1 {
+		return errCircuitBreakerFailureRatio
+	}
+	return nil
+}
+
+// newCircuitBreaker returns a circuit breaker configured according to the config, or nil
+// if the circuit breaker is disabled.
+func newCircuitBreaker(logger log.Logger, name string, config CircuitBreakerConfig) *gobreaker.CircuitBreaker {
+	if !config.Enabled {
+		return nil
+	}
+
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        name,
+		MaxRequests: config.HalfOpenMaxRequests,
+		Interval:    config.Window,
+		Timeout:     config.OpenDuration,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.Requests >= config.MinRequests &&
+				float64(counts.TotalFailures)/float64(counts.Requests) > config.FailureRatio
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			level.Info(logger).Log("msg", "circuit breaker state change", "name", name, "from", from, "to", to)
+		},
+	})
+}
+
+// isCircuitBreakerOpen returns whether the error has been returned by a circuit breaker
+// short-circuiting the request.
+func isCircuitBreakerOpen(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
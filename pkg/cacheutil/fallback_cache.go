@@ -0,0 +1,78 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cacheutil
+
+import (
+	"math"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/simplelru"
+)
+
+// fallbackCache is a small in-process LRU, bounded in bytes, holding the values recently
+// stored to or fetched from a remote cache, so that they can still be served while the
+// remote cache is unavailable.
+type fallbackCache struct {
+	mtx sync.Mutex
+	lru *lru.LRU
+
+	maxSizeBytes uint64
+	curSizeBytes uint64
+}
+
+func newFallbackCache(maxSizeBytes uint64) (*fallbackCache, error) {
+	c := &fallbackCache{maxSizeBytes: maxSizeBytes}
+
+	// The LRU is bounded in bytes by set(), not by number of items.
+	l, err := lru.NewLRU(math.MaxInt64, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = l
+	return c, nil
+}
+
+func (c *fallbackCache) onEvict(key, value interface{}) {
+	c.curSizeBytes -= fallbackItemSize(key.(string), value.([]byte))
+}
+
+// set stores the value, evicting the least recently used values until it fits.
+// Values bigger than the max size are not stored.
+func (c *fallbackCache) set(key string, value []byte) {
+	size := fallbackItemSize(key, value)
+	if size > c.maxSizeBytes {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// Remove the previous value first, so that it's not accounted twice.
+	c.lru.Remove(key)
+	for c.curSizeBytes+size > c.maxSizeBytes {
+		if _, _, ok := c.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+	c.lru.Add(key, value)
+	c.curSizeBytes += size
+}
+
+// getMulti returns the values found for the given keys.
+func (c *fallbackCache) getMulti(keys []string) map[string][]byte {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	hits := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if v, ok := c.lru.Get(key); ok {
+			hits[key] = v.([]byte)
+		}
+	}
+	return hits
+}
+
+func fallbackItemSize(key string, value []byte) uint64 {
+	return uint64(len(key) + len(value))
+}
@@ -0,0 +1,37 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cacheutil
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestFallbackCache(t *testing.T) {
+	// Room for 2 items made of a 3 bytes key and a 2 bytes value.
+	c, err := newFallbackCache(10)
+	testutil.Ok(t, err)
+
+	c.set("k-1", []byte{1, 1})
+	c.set("k-2", []byte{2, 2})
+	testutil.Equals(t, map[string][]byte{"k-1": {1, 1}, "k-2": {2, 2}}, c.getMulti([]string{"k-1", "k-2", "k-3"}))
+	testutil.Equals(t, uint64(10), c.curSizeBytes)
+
+	// The least recently used item should be evicted to make room, k-1 having just been fetched.
+	c.getMulti([]string{"k-1"})
+	c.set("k-3", []byte{3, 3})
+	testutil.Equals(t, map[string][]byte{"k-1": {1, 1}, "k-3": {3, 3}}, c.getMulti([]string{"k-1", "k-2", "k-3"}))
+	testutil.Equals(t, uint64(10), c.curSizeBytes)
+
+	// Overwriting an item should not account it twice.
+	c.set("k-3", []byte{4})
+	testutil.Equals(t, map[string][]byte{"k-1": {1, 1}, "k-3": {4}}, c.getMulti([]string{"k-1", "k-3"}))
+	testutil.Equals(t, uint64(9), c.curSizeBytes)
+
+	// Items bigger than the max size should not be stored.
+	c.set("k-4", make([]byte, 10))
+	testutil.Equals(t, map[string][]byte{}, c.getMulti([]string{"k-4"}))
+	testutil.Equals(t, uint64(9), c.curSizeBytes)
+}
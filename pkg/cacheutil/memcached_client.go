@@ -6,10 +6,13 @@ package cacheutil
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -18,6 +21,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	config_util "github.com/prometheus/common/config"
+	"github.com/sony/gobreaker"
 	"gopkg.in/yaml.v2"
 
 	"github.com/thanos-io/thanos/pkg/discovery/dns"
@@ -29,22 +34,44 @@ import (
 
 const (
 	opSet                 = "set"
+	opAdd                 = "add"
 	opSetMulti            = "setmulti"
 	opGetMulti            = "getmulti"
+	opDelete              = "delete"
 	reasonMaxItemSize     = "max-item-size"
 	reasonAsyncBufferFull = "async-buffer-full"
+	reasonCircuitOpen     = "circuit-breaker-open"
+	reasonStopped         = "client-stopped"
 	reasonMalformedKey    = "malformed-key"
 	reasonTimeout         = "timeout"
 	reasonServerError     = "server-error"
 	reasonNetworkError    = "network-error"
 	reasonOther           = "other"
+
+	// unixSocketAddressPrefix is the prefix of the addresses of the servers listening on a UNIX domain socket.
+	unixSocketAddressPrefix = "unix://"
+
+	// healthCheckKey is the key fetched by the health check, which is never stored.
+	healthCheckKey = "thanos-health-check"
 )
 
 var (
-	errMemcachedAsyncBufferFull                = errors.New("the async buffer is full")
-	errMemcachedConfigNoAddrs                  = errors.New("no memcached addresses provided")
-	errMemcachedDNSUpdateIntervalNotPositive   = errors.New("DNS provider update interval must be positive")
-	errMemcachedMaxAsyncConcurrencyNotPositive = errors.New("max async concurrency must be positive")
+	errMemcachedAsyncBufferFull                  = errors.New("the async buffer is full")
+	errMemcachedClientStopped                    = errors.New("the client is stopped")
+	errMemcachedConfigNoAddrs                    = errors.New("no memcached addresses provided")
+	errMemcachedDNSUpdateIntervalNotPositive     = errors.New("DNS provider update interval must be positive")
+	errMemcachedAutoDiscoveryIntervalNegative    = errors.New("auto-discovery update interval must not be negative")
+	errMemcachedMaxAsyncConcurrencyNotPositive   = errors.New("max async concurrency must be positive")
+	errMemcachedMaxRetriesNegative               = errors.New("max retries must not be negative")
+	errMemcachedRetryBaseBackoffNotPositive      = errors.New("retry base backoff must be positive when retries are enabled")
+	errMemcachedSetBatchFlushIntervalNotPositive = errors.New("set batch flush interval must be positive when set batching is enabled")
+	errMemcachedFallbackRequiresCircuitBreaker   = errors.New("the in-memory fallback requires the circuit breaker to be enabled")
+	errMemcachedUnknownServerSelection           = errors.New("unknown server selection algorithm")
+	errMemcachedReadReplicaNoAddrs               = errors.New("no memcached addresses provided for a read replica")
+	errMemcachedUnixSocketPathNotAbsolute        = errors.New("memcached UNIX socket addresses must be absolute paths, as unix:///path/to/socket")
+	errMemcachedMinIdleConnectionsInvalid        = errors.New("min idle connections must not be negative nor exceed the max idle connections")
+	errMemcachedIdleTimeoutNegative              = errors.New("idle timeout must not be negative")
+	errMemcachedReconnectBackoffInvalid          = errors.New("reconnect backoff base must not be negative nor exceed the max reconnect backoff")
 
 	defaultMemcachedClientConfig = MemcachedClientConfig{
 		Timeout:                   500 * time.Millisecond,
@@ -56,12 +83,34 @@ var (
 		MaxGetMultiBatchSize:      0,
 		DNSProviderUpdateInterval: 10 * time.Second,
 		AutoDiscovery:             false,
+		ServerSelection:           MemcachedJumpHashServerSelection,
+		MaxRetries:                0,
+		RetryBaseBackoff:          20 * time.Millisecond,
+		CircuitBreaker:            defaultCircuitBreakerConfig,
+		SetBatchSize:              0,
+		SetBatchFlushInterval:     100 * time.Millisecond,
 	}
 )
 
 var (
 	_ RemoteCacheClient = (*memcachedClient)(nil)
 	_ RemoteCacheClient = (*RedisClient)(nil)
+
+	_ RemoteCacheClientWithError = (*memcachedClient)(nil)
+	_ RemoteCacheClientWithError = (*RedisClient)(nil)
+
+	_ RemoteCacheClientWithDelete = (*memcachedClient)(nil)
+	_ RemoteCacheClientWithDelete = (*RedisClient)(nil)
+
+	_ RemoteCacheClientWithAsyncQueue = (*memcachedClient)(nil)
+	_ RemoteCacheClientWithAsyncQueue = (*RedisClient)(nil)
+
+	_ RemoteCacheClientWithGracefulStop = (*memcachedClient)(nil)
+
+	_ RemoteCacheClientWithHealthCheck = (*memcachedClient)(nil)
+	_ RemoteCacheClientWithHealthCheck = (*RedisClient)(nil)
+
+	_ RemoteCacheClientWithPartialResults = (*memcachedClient)(nil)
 )
 
 // RemoteCacheClient is a high level client to interact with remote cache.
@@ -79,6 +128,90 @@ type RemoteCacheClient interface {
 	Stop()
 }
 
+// RemoteCacheClientWithError is implemented by a RemoteCacheClient able to report
+// GetMulti failures to the caller, rather than only tracking/logging them.
+type RemoteCacheClientWithError interface {
+	// GetMultiWithError fetches multiple keys at once from remoteCache. In case of
+	// error, the results fetched so far (if any) are returned along with the error,
+	// which is tracked but not logged.
+	GetMultiWithError(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// RemoteCacheClientWithPartialResults is implemented by a RemoteCacheClient able to tell
+// the keys it failed to fetch because of a timeout apart from the missing ones.
+type RemoteCacheClientWithPartialResults interface {
+	// GetMultiPartial fetches multiple keys at once from remoteCache, like GetMultiWithError,
+	// additionally returning the keys which weren't fetched because their request timed out
+	// or the context deadline was exceeded, while the other keys missing from the results
+	// are actual misses or failed for another reason.
+	GetMultiPartial(ctx context.Context, keys []string) (hits map[string][]byte, timedOut []string, err error)
+}
+
+// RemoteCacheClientWithDelete is implemented by a RemoteCacheClient able to delete keys.
+type RemoteCacheClientWithDelete interface {
+	// Delete synchronously deletes a key from remoteCache. Deleting a key
+	// which doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// RemoteCacheClientWithSetMulti is implemented by a RemoteCacheClient able to store
+// multiple items with a single operation.
+type RemoteCacheClientWithSetMulti interface {
+	// SetMultiAsync enqueues a single asynchronous operation to store multiple items into
+	// remoteCache. Returns an error in case it fails to enqueue the operation. In case the
+	// underlying async operation will fail, the error will be tracked/logged.
+	SetMultiAsync(ctx context.Context, items []RemoteCacheItem) error
+}
+
+// RemoteCacheClientWithSet is implemented by a RemoteCacheClient able to store keys
+// synchronously.
+type RemoteCacheClientWithSet interface {
+	// Set synchronously stores a key into remoteCache, returning the error if it fails.
+	// Items bigger than the max item size are skipped without error.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// RemoteCacheClientWithAdd is implemented by a RemoteCacheClient able to store keys only
+// if they don't exist yet.
+type RemoteCacheClientWithAdd interface {
+	// AddAsync enqueues an asynchronous operation to store a key into remoteCache, unless
+	// it already exists, in which case exists is called, if not nil. Returns an error in
+	// case it fails to enqueue the operation. In case the underlying async operation will
+	// fail, the error will be tracked/logged.
+	AddAsync(ctx context.Context, key string, value []byte, ttl time.Duration, exists func()) error
+}
+
+// RemoteCacheItem is an item stored into remoteCache.
+type RemoteCacheItem struct {
+	Key   string
+	Value []byte
+	TTL   time.Duration
+}
+
+// RemoteCacheClientWithAsyncQueue is implemented by a RemoteCacheClient whose SetAsync
+// operations are enqueued to a bounded buffer.
+type RemoteCacheClientWithAsyncQueue interface {
+	// AsyncQueueFullRatio returns the ratio, between 0 and 1, of the async buffer in use.
+	AsyncQueueFullRatio() float64
+}
+
+// RemoteCacheClientWithGracefulStop is implemented by a RemoteCacheClient able to flush
+// its enqueued SetAsync operations on stopping.
+type RemoteCacheClientWithGracefulStop interface {
+	// StopWithContext stops accepting new SetAsync operations, waits until the enqueued
+	// ones are processed or the context is done, then stops the client. It returns the
+	// number of items dropped because the context was done first.
+	StopWithContext(ctx context.Context) int
+}
+
+// RemoteCacheClientWithHealthCheck is implemented by a RemoteCacheClient able to check whether
+// its backend is reachable.
+type RemoteCacheClientWithHealthCheck interface {
+	// HealthCheck synchronously runs a cheap operation against remoteCache, returning the
+	// error if it fails, e.g. because the backend is unreachable.
+	HealthCheck(ctx context.Context) error
+}
+
 // MemcachedClient for compatible.
 type MemcachedClient = RemoteCacheClient
 
@@ -86,8 +219,18 @@ type MemcachedClient = RemoteCacheClient
 type memcachedClientBackend interface {
 	GetMulti(keys []string) (map[string]*memcache.Item, error)
 	Set(item *memcache.Item) error
+	Add(item *memcache.Item) error
+	Delete(key string) error
 }
 
+// MemcachedServerSelection is the algorithm used to distribute keys to memcached servers.
+type MemcachedServerSelection string
+
+const (
+	MemcachedJumpHashServerSelection MemcachedServerSelection = "jump_hash"
+	MemcachedKetamaServerSelection   MemcachedServerSelection = "ketama"
+)
+
 // updatableServerSelector extends the interface used for picking a memcached server
 // for a key to allow servers to be updated at runtime. It allows the selector used
 // by the client to be mocked in tests.
@@ -106,17 +249,57 @@ type updatableServerSelector interface {
 // MemcachedClientConfig is the config accepted by RemoteCacheClient.
 type MemcachedClientConfig struct {
 	// Addresses specifies the list of memcached addresses. The addresses get
-	// resolved with the DNS provider.
+	// resolved with the DNS provider. Servers listening on a UNIX domain socket
+	// are addressed by the absolute path of the socket, as unix:///path/to/socket.
 	Addresses []string `yaml:"addresses"`
 
-	// Timeout specifies the socket read/write timeout.
+	// TextAuthUsername specifies the username used to authenticate to memcached with the
+	// text protocol authentication, whose servers must have been started with an
+	// authentication file (-Y). SASL isn't supported, given it requires the binary
+	// protocol. Authentication is disabled if empty.
+	TextAuthUsername string `yaml:"text_auth_username"`
+
+	// TextAuthPassword specifies the password used to authenticate to memcached with the
+	// text protocol authentication.
+	TextAuthPassword config_util.Secret `yaml:"text_auth_password"`
+
+	// Timeout specifies the socket read/write timeout. It applies to the operations
+	// whose own timeout, below, isn't set.
 	Timeout time.Duration `yaml:"timeout"`
 
+	// GetTimeout specifies the socket read/write timeout of GetMulti() operations.
+	// If set to 0, Timeout is used.
+	GetTimeout time.Duration `yaml:"get_timeout"`
+
+	// SetTimeout specifies the socket read/write timeout of write operations, that is
+	// SetAsync() and Delete(). If set to 0, Timeout is used.
+	SetTimeout time.Duration `yaml:"set_timeout"`
+
 	// MaxIdleConnections specifies the maximum number of idle connections that
 	// will be maintained per address. For better performances, this should be
 	// set to a number higher than your peak parallel requests.
 	MaxIdleConnections int `yaml:"max_idle_connections"`
 
+	// MinIdleConnections specifies the number of connections dialed ahead of time per
+	// address once the client is created, so that the first requests don't pay for dialing
+	// them. It can't exceed MaxIdleConnections. If set to 0, no connection is pre-warmed.
+	MinIdleConnections int `yaml:"min_idle_connections"`
+
+	// IdleTimeout specifies for how long a connection can stay idle in the pool. Connections
+	// idle for longer are replaced by new ones when taken out of the pool, so that the ones
+	// closed by the server after a lull in traffic don't fail the next requests. It should
+	// be shorter than the server idle timeout. If set to 0, idle connections are kept open.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// ReconnectBackoffBase specifies for how long the dials to a server fail fast after a failed
+	// dial, before reconnecting is attempted again. The backoff doubles with each consecutive
+	// failure, up to ReconnectBackoffMax, and is jittered, so that the clients of a restarting
+	// server don't reconnect all at once. If set to 0, reconnecting is attempted on each dial.
+	ReconnectBackoffBase time.Duration `yaml:"reconnect_backoff_base"`
+
+	// ReconnectBackoffMax specifies the maximum backoff before reconnecting to a server.
+	ReconnectBackoffMax time.Duration `yaml:"reconnect_backoff_max"`
+
 	// MaxAsyncConcurrency specifies the maximum number of SetAsync goroutines.
 	MaxAsyncConcurrency int `yaml:"max_async_concurrency"`
 
@@ -143,26 +326,160 @@ type MemcachedClientConfig struct {
 
 	// AutoDiscovery configures memached client to perform auto-discovery instead of DNS resolution
 	AutoDiscovery bool `yaml:"auto_discovery"`
+
+	// AutoDiscoveryUpdateInterval specifies the interval at which the configuration endpoints
+	// are polled for the cluster nodes when auto-discovery is enabled. If set to 0, the DNS
+	// discovery update interval is used.
+	AutoDiscoveryUpdateInterval time.Duration `yaml:"auto_discovery_update_interval"`
+
+	// ServerSelection specifies the algorithm used to distribute keys to servers: either
+	// "jump_hash", which only moves ~1/N keys when servers are added or removed at the
+	// end of their naturally sorted list, or "ketama", a consistent hashing ring only
+	// moving ~1/N keys whichever server is added or removed.
+	ServerSelection MemcachedServerSelection `yaml:"server_selection"`
+
+	// MaxRetries specifies the maximum number of times a GetMulti() failed because of a
+	// connection error is retried. Misses are never retried. If set to 0, retries are disabled.
+	MaxRetries int `yaml:"max_retries"`
+
+	// RetryBaseBackoff specifies the backoff before the first GetMulti() retry. It's doubled
+	// on each following retry, while never waiting past the request context deadline.
+	RetryBaseBackoff time.Duration `yaml:"retry_base_backoff"`
+
+	// CircuitBreaker configures the circuit breaker which, while open, short-circuits
+	// GetMulti() to immediate misses and skips SetAsync() operations.
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// FallbackMaxSize specifies the maximum size of the in-memory LRU holding the values
+	// recently stored and fetched, which are served in place of memcached while the
+	// circuit breaker is open. It requires the circuit breaker. If set to 0, the in-memory
+	// fallback is disabled.
+	FallbackMaxSize model.Bytes `yaml:"fallback_max_size"`
+
+	// SetBatchSize specifies the maximum number of SetAsync() items coalesced into a
+	// single batch, enqueued as a single async operation once full. Items set multiple
+	// times while pending are stored once, with the last value. If set to 0, batching
+	// is disabled and each item is enqueued on its own.
+	SetBatchSize int `yaml:"set_batch_size"`
+
+	// SetBatchFlushInterval specifies the interval at which the pending batch is enqueued
+	// even if not full, bounding how long an item waits before being stored.
+	SetBatchFlushInterval time.Duration `yaml:"set_batch_flush_interval"`
+
+	// ReadReplicas specifies the sets of memcached servers replicating the ones listed in
+	// Addresses, e.g. kept in sync by a proxy. While items are only stored to and deleted from
+	// the primary servers, GetMulti() are spread across the replicas in a round-robin fashion,
+	// and the keys missed by a replica are fetched from the primary servers. Each replica is
+	// configured as the primary servers are, except for its addresses.
+	ReadReplicas []MemcachedReadReplicaConfig `yaml:"read_replicas"`
+}
+
+// MemcachedReadReplicaConfig configures a read replica of the memcached servers.
+type MemcachedReadReplicaConfig struct {
+	// Addresses specifies the list of memcached addresses of the replica, resolved
+	// with the DNS provider, like the addresses of the primary servers.
+	Addresses []string `yaml:"addresses"`
 }
 
 func (c *MemcachedClientConfig) validate() error {
 	if len(c.Addresses) == 0 {
 		return errMemcachedConfigNoAddrs
 	}
+	if err := validateUnixSocketAddresses(c.Addresses); err != nil {
+		return err
+	}
 
 	// Avoid panic in time ticker.
 	if c.DNSProviderUpdateInterval <= 0 {
 		return errMemcachedDNSUpdateIntervalNotPositive
 	}
+	if c.AutoDiscoveryUpdateInterval < 0 {
+		return errMemcachedAutoDiscoveryIntervalNegative
+	}
 
 	// Set async only available when MaxAsyncConcurrency > 0.
 	if c.MaxAsyncConcurrency <= 0 {
 		return errMemcachedMaxAsyncConcurrencyNotPositive
 	}
 
+	if c.MinIdleConnections < 0 || c.MinIdleConnections > c.MaxIdleConnections {
+		return errMemcachedMinIdleConnectionsInvalid
+	}
+	if c.IdleTimeout < 0 {
+		return errMemcachedIdleTimeoutNegative
+	}
+	if c.ReconnectBackoffBase < 0 || (c.ReconnectBackoffBase > 0 && c.ReconnectBackoffBase > c.ReconnectBackoffMax) {
+		return errMemcachedReconnectBackoffInvalid
+	}
+
+	if c.MaxRetries < 0 {
+		return errMemcachedMaxRetriesNegative
+	}
+	if c.MaxRetries > 0 && c.RetryBaseBackoff <= 0 {
+		return errMemcachedRetryBaseBackoffNotPositive
+	}
+
+	if c.SetBatchSize > 0 && c.SetBatchFlushInterval <= 0 {
+		return errMemcachedSetBatchFlushIntervalNotPositive
+	}
+
+	if c.FallbackMaxSize > 0 && !c.CircuitBreaker.Enabled {
+		return errMemcachedFallbackRequiresCircuitBreaker
+	}
+
+	switch c.ServerSelection {
+	case "", MemcachedJumpHashServerSelection, MemcachedKetamaServerSelection:
+	default:
+		return errMemcachedUnknownServerSelection
+	}
+
+	for _, replica := range c.ReadReplicas {
+		if len(replica.Addresses) == 0 {
+			return errMemcachedReadReplicaNoAddrs
+		}
+		if err := validateUnixSocketAddresses(replica.Addresses); err != nil {
+			return err
+		}
+	}
+
+	return c.CircuitBreaker.validate()
+}
+
+// validateUnixSocketAddresses checks that the UNIX socket addresses are absolute paths, given
+// the server list tells them apart from the TCP ones by the slashes of the paths.
+func validateUnixSocketAddresses(addrs []string) error {
+	for _, addr := range addrs {
+		if path := strings.TrimPrefix(addr, unixSocketAddressPrefix); path != addr && !filepath.IsAbs(path) {
+			return errMemcachedUnixSocketPathNotAbsolute
+		}
+	}
 	return nil
 }
 
+// getTimeout returns the socket timeout of read operations.
+func (c *MemcachedClientConfig) getTimeout() time.Duration {
+	if c.GetTimeout > 0 {
+		return c.GetTimeout
+	}
+	return c.Timeout
+}
+
+// setTimeout returns the socket timeout of write operations.
+func (c *MemcachedClientConfig) setTimeout() time.Duration {
+	if c.SetTimeout > 0 {
+		return c.SetTimeout
+	}
+	return c.Timeout
+}
+
+// addressesUpdateInterval returns the interval at which the servers list is updated.
+func (c *MemcachedClientConfig) addressesUpdateInterval() time.Duration {
+	if c.AutoDiscovery && c.AutoDiscoveryUpdateInterval > 0 {
+		return c.AutoDiscoveryUpdateInterval
+	}
+	return c.DNSProviderUpdateInterval
+}
+
 // parseMemcachedClientConfig unmarshals a buffer into a MemcachedClientConfig with default values.
 func parseMemcachedClientConfig(conf []byte) (MemcachedClientConfig, error) {
 	config := defaultMemcachedClientConfig
@@ -174,26 +491,61 @@ func parseMemcachedClientConfig(conf []byte) (MemcachedClientConfig, error) {
 }
 
 type memcachedClient struct {
+	// Counter used to pick the next read replica, incremented atomically. It's kept
+	// first so that it's 64-bit aligned.
+	nextReplica uint64
+
 	logger   log.Logger
 	config   MemcachedClientConfig
-	client   memcachedClientBackend
 	selector updatableServerSelector
 
+	// Backend clients used for reads and writes respectively. They're the same
+	// client unless the reads and writes timeouts differ.
+	client      memcachedClientBackend
+	writeClient memcachedClientBackend
+
 	// Name provides an identifier for the instantiated Client
 	name string
 
+	// Dialer of the connections of the backend clients, holding the pre-warmed ones. It's
+	// nil if the backend clients are not memcache.Client instances.
+	dialer *instrumentedDialer
+
 	// Address provider used to keep the memcached servers list updated.
 	addressProvider AddressProvider
 
 	// Channel used to notify internal goroutines when they should quit.
-	stop chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// Whether the client stopped accepting new async operations.
+	stoppingMtx sync.RWMutex
+	stopping    bool
+
+	// Channel used to enqueue async operations, and wait group tracking the ones
+	// enqueued but not processed yet.
+	asyncQueue chan asyncOp
+	asyncOps   sync.WaitGroup
 
-	// Channel used to enqueue async operations.
-	asyncQueue chan func()
+	// Pending set items coalesced into the next batch, if batching is enabled.
+	setBatchMtx sync.Mutex
+	setBatch    map[string]pendingSet
+
+	// Circuit breaker wrapping the operations against memcached, nil if disabled.
+	breaker *gobreaker.CircuitBreaker
+
+	// In-memory fallback serving the recent values while the circuit breaker is open,
+	// nil if disabled.
+	fallback     *fallbackCache
+	fallbackHits prometheus.Counter
 
 	// Gate used to enforce the max number of concurrent GetMulti() operations.
 	getMultiGate gate.Gate
 
+	// Clients of the read replicas GetMulti() are spread across, if any.
+	replicas      []*memcachedClient
+	replicaMisses prometheus.Counter
+
 	// Wait group used to wait all workers on stopping.
 	workers sync.WaitGroup
 
@@ -202,8 +554,21 @@ type memcachedClient struct {
 	operations *prometheus.CounterVec
 	failures   *prometheus.CounterVec
 	skipped    *prometheus.CounterVec
-	duration   *prometheus.HistogramVec
-	dataSize   *prometheus.HistogramVec
+	// Size of the items of the skipped operations.
+	skippedBytes *prometheus.CounterVec
+	retries      *prometheus.CounterVec
+	// Failures of GetMulti() by memcached server.
+	serverFailures *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	dataSize       *prometheus.HistogramVec
+	batchSize      prometheus.Histogram
+	clusterMembers prometheus.Gauge
+}
+
+// pendingSet is an item waiting in the set batch to be stored.
+type pendingSet struct {
+	value []byte
+	ttl   time.Duration
 }
 
 // AddressProvider performs node address resolution given a list of clusters.
@@ -237,22 +602,114 @@ func NewMemcachedClientWithConfig(logger log.Logger, name string, config Memcach
 	}
 
 	// We use a custom servers selector in order to use a jump hash
-	// for servers selection.
-	selector := &MemcachedJumpHashSelector{}
-
-	client := memcache.NewFromSelector(selector)
-	client.Timeout = config.Timeout
-	client.MaxIdleConns = config.MaxIdleConnections
+	// (or a ketama ring) for servers selection.
+	var selector updatableServerSelector = &MemcachedJumpHashSelector{}
+	if config.ServerSelection == MemcachedKetamaServerSelection {
+		selector = &MemcachedKetamaSelector{}
+	}
 
+	// Replicas are registered with their own name, so they're kept apart from the primary servers.
+	replicasReg := reg
 	if reg != nil {
 		reg = prometheus.WrapRegistererWith(prometheus.Labels{"name": name}, reg)
 	}
-	return newMemcachedClient(logger, client, selector, config, reg, name)
+
+	// The socket timeout is set per backend client, so a dedicated client is used for
+	// writes if they have a different timeout than reads.
+	dialer := newInstrumentedDialer(reg, config.TextAuthUsername, string(config.TextAuthPassword), config.IdleTimeout, config.ReconnectBackoffBase, config.ReconnectBackoffMax)
+	newBackend := func(timeout time.Duration) memcachedClientBackend {
+		client := memcache.NewFromSelector(selector)
+		client.Timeout = timeout
+		client.MaxIdleConns = config.MaxIdleConnections
+		client.DialTimeout = dialer.DialTimeout
+		return newPooledBackend(client, selector, dialer)
+	}
+
+	client := newBackend(config.getTimeout())
+	writeClient := client
+	if config.setTimeout() != config.getTimeout() {
+		writeClient = newBackend(config.setTimeout())
+	}
+
+	c, err := newMemcachedClient(logger, client, writeClient, selector, config, reg, name)
+	if err != nil {
+		return nil, err
+	}
+	c.dialer = dialer
+
+	// Fail fast if the credentials are rejected, rather than on each operation.
+	if config.TextAuthUsername != "" {
+		if err := checkAuthentication(logger, selector, dialer, config.getTimeout()); err != nil {
+			c.Stop()
+			return nil, err
+		}
+	}
+
+	if config.MinIdleConnections > 0 {
+		warmUpConnections(logger, selector, dialer, config.MinIdleConnections, config.getTimeout())
+	}
+
+	if len(config.ReadReplicas) > 0 {
+		for i, replicaConfig := range config.ReadReplicas {
+			rc := config
+			rc.Addresses = replicaConfig.Addresses
+			rc.ReadReplicas = nil
+
+			replica, err := NewMemcachedClientWithConfig(logger, fmt.Sprintf("%s-replica-%d", name, i), rc, replicasReg)
+			if err != nil {
+				c.Stop()
+				return nil, errors.Wrapf(err, "create read replica %d", i)
+			}
+			c.replicas = append(c.replicas, replica)
+		}
+		c.replicaMisses = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_memcached_read_replica_misses_total",
+			Help: "Total number of keys missed by a read replica, then fetched from the primary memcached servers.",
+		})
+	}
+	return c, nil
+}
+
+// checkAuthentication dials the memcached servers until one accepts or rejects the
+// configured credentials. Servers which can't be reached are skipped, so that an
+// unavailable memcached doesn't prevent the client from being created.
+func checkAuthentication(logger log.Logger, selector memcache.ServerSelector, dialer *instrumentedDialer, timeout time.Duration) error {
+	errAuthenticated := errors.New("authenticated")
+
+	err := selector.Each(func(addr net.Addr) error {
+		conn, err := dialer.DialTimeout(addr.Network(), addr.String(), timeout)
+		if errors.Is(err, errMemcachedTextAuthFailed) {
+			return err
+		}
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to check memcached authentication", "server", addr.String(), "err", err)
+			return nil
+		}
+		_ = conn.Close()
+		return errAuthenticated
+	})
+	if errors.Is(err, errAuthenticated) {
+		return nil
+	}
+	return err
+}
+
+// warmUpConnections dials n connections to each of the memcached servers ahead of time.
+// Servers which can't be reached are skipped, so that an unavailable memcached doesn't
+// prevent the client from being created.
+func warmUpConnections(logger log.Logger, selector memcache.ServerSelector, dialer *instrumentedDialer, n int, timeout time.Duration) {
+	_ = selector.Each(func(addr net.Addr) error {
+		if err := dialer.warmUp(addr.Network(), addr.String(), n, timeout); err != nil {
+			level.Warn(logger).Log("msg", "failed to pre-warm memcached connections", "server", addr.String(), "err", err)
+		}
+		return nil
+	})
 }
 
 func newMemcachedClient(
 	logger log.Logger,
 	client memcachedClientBackend,
+	writeClient memcachedClientBackend,
 	selector updatableServerSelector,
 	config MemcachedClientConfig,
 	reg prometheus.Registerer,
@@ -279,15 +736,38 @@ func newMemcachedClient(
 		logger:          log.With(logger, "name", name),
 		config:          config,
 		client:          client,
+		writeClient:     writeClient,
 		selector:        selector,
 		addressProvider: addressProvider,
-		asyncQueue:      make(chan func(), config.MaxAsyncBufferSize),
+		asyncQueue:      make(chan asyncOp, config.MaxAsyncBufferSize),
 		stop:            make(chan struct{}, 1),
 		getMultiGate: gate.New(
 			extprom.WrapRegistererWithPrefix("thanos_memcached_getmulti_", reg),
 			config.MaxGetMultiConcurrency,
 			gate.Gets,
 		),
+		breaker: newCircuitBreaker(logger, name, config.CircuitBreaker),
+	}
+
+	if config.FallbackMaxSize > 0 {
+		fallback, err := newFallbackCache(uint64(config.FallbackMaxSize))
+		if err != nil {
+			return nil, err
+		}
+		c.fallback = fallback
+		c.fallbackHits = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_memcached_fallback_hits_total",
+			Help: "Total number of items served by the in-memory fallback while the circuit breaker is open.",
+		})
+	}
+
+	if c.breaker != nil {
+		promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "thanos_memcached_circuit_breaker_state",
+			Help: "State of the circuit breaker wrapping the operations against memcached: 0 closed, 1 half-open, 2 open.",
+		}, func() float64 {
+			return float64(c.breaker.State())
+		})
 	}
 
 	c.clientInfo = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
@@ -295,6 +775,8 @@ func newMemcachedClient(
 		Help: "A metric with a constant '1' value labeled by configuration options from which memcached client was configured.",
 		ConstLabels: prometheus.Labels{
 			"timeout":                      config.Timeout.String(),
+			"get_timeout":                  config.getTimeout().String(),
+			"set_timeout":                  config.setTimeout().String(),
 			"max_idle_connections":         strconv.Itoa(config.MaxIdleConnections),
 			"max_async_concurrency":        strconv.Itoa(config.MaxAsyncConcurrency),
 			"max_async_buffer_size":        strconv.Itoa(config.MaxAsyncBufferSize),
@@ -313,6 +795,8 @@ func newMemcachedClient(
 	}, []string{"operation"})
 	c.operations.WithLabelValues(opGetMulti)
 	c.operations.WithLabelValues(opSet)
+	c.operations.WithLabelValues(opAdd)
+	c.operations.WithLabelValues(opDelete)
 
 	c.failures = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_memcached_operation_failures_total",
@@ -328,14 +812,49 @@ func newMemcachedClient(
 	c.failures.WithLabelValues(opSet, reasonServerError)
 	c.failures.WithLabelValues(opSet, reasonNetworkError)
 	c.failures.WithLabelValues(opSet, reasonOther)
+	c.failures.WithLabelValues(opAdd, reasonTimeout)
+	c.failures.WithLabelValues(opAdd, reasonMalformedKey)
+	c.failures.WithLabelValues(opAdd, reasonServerError)
+	c.failures.WithLabelValues(opAdd, reasonNetworkError)
+	c.failures.WithLabelValues(opAdd, reasonOther)
+	c.failures.WithLabelValues(opDelete, reasonTimeout)
+	c.failures.WithLabelValues(opDelete, reasonMalformedKey)
+	c.failures.WithLabelValues(opDelete, reasonServerError)
+	c.failures.WithLabelValues(opDelete, reasonNetworkError)
+	c.failures.WithLabelValues(opDelete, reasonOther)
 
 	c.skipped = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_memcached_operation_skipped_total",
 		Help: "Total number of operations against memcached that have been skipped.",
 	}, []string{"operation", "reason"})
 	c.skipped.WithLabelValues(opGetMulti, reasonMaxItemSize)
+	c.skipped.WithLabelValues(opGetMulti, reasonCircuitOpen)
 	c.skipped.WithLabelValues(opSet, reasonMaxItemSize)
 	c.skipped.WithLabelValues(opSet, reasonAsyncBufferFull)
+	c.skipped.WithLabelValues(opSet, reasonCircuitOpen)
+	c.skipped.WithLabelValues(opSet, reasonStopped)
+	c.skipped.WithLabelValues(opAdd, reasonMaxItemSize)
+	c.skipped.WithLabelValues(opAdd, reasonAsyncBufferFull)
+	c.skipped.WithLabelValues(opAdd, reasonCircuitOpen)
+	c.skipped.WithLabelValues(opAdd, reasonStopped)
+
+	c.skippedBytes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_memcached_operation_skipped_bytes_total",
+		Help: "Total size of the items of the operations against memcached that have been skipped, e.g. to tell how much cacheable data is lost because of the max item size.",
+	}, []string{"operation", "reason"})
+	c.skippedBytes.WithLabelValues(opSet, reasonMaxItemSize)
+	c.skippedBytes.WithLabelValues(opAdd, reasonMaxItemSize)
+
+	c.retries = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_memcached_operation_retries_total",
+		Help: "Total number of operations against memcached that have been retried after a connection error.",
+	}, []string{"operation"})
+	c.retries.WithLabelValues(opGetMulti)
+
+	c.serverFailures = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_memcached_server_failures_total",
+		Help: "Total number of GetMulti requests to a memcached server that failed. Keys of a failed server are returned as misses, while the items of the other servers are still returned.",
+	}, []string{"server"})
 
 	c.duration = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "thanos_memcached_operation_duration_seconds",
@@ -344,6 +863,8 @@ func newMemcachedClient(
 	}, []string{"operation"})
 	c.duration.WithLabelValues(opGetMulti)
 	c.duration.WithLabelValues(opSet)
+	c.duration.WithLabelValues(opAdd)
+	c.duration.WithLabelValues(opDelete)
 
 	c.dataSize = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
 		Name: "thanos_memcached_operation_data_size_bytes",
@@ -356,6 +877,18 @@ func newMemcachedClient(
 	)
 	c.dataSize.WithLabelValues(opGetMulti)
 	c.dataSize.WithLabelValues(opSet)
+	c.dataSize.WithLabelValues(opAdd)
+
+	c.batchSize = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_memcached_set_batch_size",
+		Help:    "Number of items stored by each coalesced set batch.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+	})
+
+	c.clusterMembers = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_memcached_cluster_members",
+		Help: "Number of memcached servers keys are currently distributed to, as resolved by the DNS discovery or auto-discovery.",
+	})
 
 	// As soon as the client is created it must ensure that memcached server
 	// addresses are resolved, so we're going to trigger an initial addresses
@@ -374,85 +907,511 @@ func newMemcachedClient(
 		go c.asyncQueueProcessLoop()
 	}
 
+	if c.config.SetBatchSize > 0 {
+		c.setBatch = make(map[string]pendingSet, c.config.SetBatchSize)
+
+		c.workers.Add(1)
+		go c.setBatchFlushLoop()
+	}
+
 	return c, nil
 }
 
 func (c *memcachedClient) Stop() {
-	close(c.stop)
+	c.setStopping()
+	c.trackDropped(c.stopWorkers(), nil)
 
-	// Wait until all workers have terminated.
-	c.workers.Wait()
+	// Store the pending set batch, so that it's not lost on shutdown.
+	if items := c.takeSetBatch(); len(items) > 0 {
+		c.storeSetBatch(items)
+	}
+
+	for _, replica := range c.replicas {
+		replica.Stop()
+	}
+}
+
+// StopWithContext stops accepting new async operations and flushes the enqueued ones,
+// including the pending set batch, before stopping the client. If the context is done
+// first, the operations not processed yet are dropped, and the number of dropped items
+// is returned.
+func (c *memcachedClient) StopWithContext(ctx context.Context) int {
+	c.setStopping()
+
+	dropped := 0
+	if items := c.takeSetBatch(); len(items) > 0 {
+		// Wait for room in the async buffer rather than skipping the batch, given
+		// it's being drained.
+		c.asyncOps.Add(1)
+		select {
+		case c.asyncQueue <- asyncOp{items: len(items), op: func() { c.storeSetBatch(items) }}:
+		case <-ctx.Done():
+			c.asyncOps.Done()
+			dropped += len(items)
+		}
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		c.asyncOps.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+	}
+
+	dropped += c.stopWorkers()
+	<-flushed
+
+	// Nothing is ever stored to the replicas, so they have nothing to flush.
+	for _, replica := range c.replicas {
+		replica.Stop()
+	}
+
+	c.trackDropped(dropped, ctx.Err())
+	return dropped
+}
+
+// stopWorkers stops the workers, once, then drops the async operations still enqueued,
+// returning the number of dropped items.
+func (c *memcachedClient) stopWorkers() int {
+	dropped := 0
+	c.stopOnce.Do(func() {
+		close(c.stop)
+
+		// Wait until all workers have terminated. No more operations can be enqueued
+		// while stopping, so the queue can be drained safely.
+		c.workers.Wait()
+		for len(c.asyncQueue) > 0 {
+			op := <-c.asyncQueue
+			dropped += op.items
+			c.asyncOps.Done()
+		}
+
+		if c.dialer != nil {
+			c.dialer.closeWarm()
+		}
+	})
+	return dropped
+}
+
+func (c *memcachedClient) trackDropped(dropped int, err error) {
+	if dropped == 0 {
+		return
+	}
+	c.skipped.WithLabelValues(opSet, reasonStopped).Add(float64(dropped))
+	level.Warn(c.logger).Log("msg", "dropped items not stored to memcached yet on stopping", "items", dropped, "err", err)
+}
+
+func (c *memcachedClient) isStopping() bool {
+	c.stoppingMtx.RLock()
+	defer c.stoppingMtx.RUnlock()
+
+	return c.stopping
+}
+
+// setStopping makes the client stop accepting new async operations.
+func (c *memcachedClient) setStopping() {
+	c.stoppingMtx.Lock()
+	defer c.stoppingMtx.Unlock()
+
+	c.stopping = true
 }
 
 func (c *memcachedClient) SetAsync(_ context.Context, key string, value []byte, ttl time.Duration) error {
 	// Skip hitting memcached at all if the item is bigger than the max allowed size.
 	if c.config.MaxItemSize > 0 && uint64(len(value)) > uint64(c.config.MaxItemSize) {
-		c.skipped.WithLabelValues(opSet, reasonMaxItemSize).Inc()
+		c.skipTooBig(opSet, len(value))
+		return nil
+	}
+
+	if c.config.SetBatchSize > 0 {
+		return c.addMultiToSetBatch(map[string]pendingSet{key: {value: value, ttl: ttl}})
+	}
+
+	err := c.enqueueAsync(1, func() {
+		_ = c.set(key, value, ttl)
+	})
+
+	switch err {
+	case errMemcachedAsyncBufferFull:
+		c.skipped.WithLabelValues(opSet, reasonAsyncBufferFull).Inc()
+		level.Debug(c.logger).Log("msg", "failed to store item to memcached because the async buffer is full", "err", err, "size", len(c.asyncQueue))
+		return nil
+	case errMemcachedClientStopped:
+		c.skipped.WithLabelValues(opSet, reasonStopped).Inc()
+	}
+	return err
+}
+
+// SetMultiAsync enqueues the items to be stored as a single batch, skipping the ones bigger
+// than the max item size. If set batches are enabled, the items are added to the pending batch.
+// Once the client is stopping, the items are skipped and errMemcachedClientStopped is returned.
+func (c *memcachedClient) SetMultiAsync(_ context.Context, items []RemoteCacheItem) error {
+	batch := make(map[string]pendingSet, len(items))
+	for _, item := range items {
+		if c.config.MaxItemSize > 0 && uint64(len(item.Value)) > uint64(c.config.MaxItemSize) {
+			c.skipTooBig(opSet, len(item.Value))
+			continue
+		}
+		batch[item.Key] = pendingSet{value: item.Value, ttl: item.TTL}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if c.config.SetBatchSize > 0 {
+		return c.addMultiToSetBatch(batch)
+	}
+	return c.enqueueSetBatch(batch)
+}
+
+// skipTooBig tracks the operation storing an item of the given size skipped because it's
+// bigger than the max item size.
+func (c *memcachedClient) skipTooBig(op string, size int) {
+	c.skipped.WithLabelValues(op, reasonMaxItemSize).Inc()
+	c.skippedBytes.WithLabelValues(op, reasonMaxItemSize).Add(float64(size))
+}
+
+// AddAsync implements RemoteCacheClientWithAdd. Unlike the sets, the adds are never
+// coalesced into set batches.
+func (c *memcachedClient) AddAsync(_ context.Context, key string, value []byte, ttl time.Duration, exists func()) error {
+	// Skip hitting memcached at all if the item is bigger than the max allowed size.
+	if c.config.MaxItemSize > 0 && uint64(len(value)) > uint64(c.config.MaxItemSize) {
+		c.skipTooBig(opAdd, len(value))
 		return nil
 	}
 
-	err := c.enqueueAsync(func() {
-		start := time.Now()
+	err := c.enqueueAsync(1, func() {
+		if c.add(key, value, ttl) == memcache.ErrNotStored && exists != nil {
+			exists()
+		}
+	})
+
+	switch err {
+	case errMemcachedAsyncBufferFull:
+		c.skipped.WithLabelValues(opAdd, reasonAsyncBufferFull).Inc()
+		level.Debug(c.logger).Log("msg", "failed to add item to memcached because the async buffer is full", "err", err, "size", len(c.asyncQueue))
+		return nil
+	case errMemcachedClientStopped:
+		c.skipped.WithLabelValues(opAdd, reasonStopped).Inc()
+		return nil
+	}
+	return err
+}
+
+// Set implements RemoteCacheClientWithSet.
+func (c *memcachedClient) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	// Skip hitting memcached at all if the item is bigger than the max allowed size.
+	if c.config.MaxItemSize > 0 && uint64(len(value)) > uint64(c.config.MaxItemSize) {
+		c.skipTooBig(opSet, len(value))
+		return nil
+	}
+	return c.set(key, value, ttl)
+}
+
+// set synchronously stores an item to memcached. The error is tracked and logged
+// before being returned.
+func (c *memcachedClient) set(key string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+
+	// Keep the item in the fallback even if storing it fails, so that it can be
+	// served while memcached is unavailable.
+	if c.fallback != nil {
+		c.fallback.set(key, value)
+	}
+
+	err := c.withCircuitBreaker(func() error {
 		c.operations.WithLabelValues(opSet).Inc()
+		return c.writeClient.Set(&memcache.Item{
+			Key:        key,
+			Value:      value,
+			Expiration: int32(time.Now().Add(ttl).Unix()),
+		})
+	})
+	if isCircuitBreakerOpen(err) {
+		c.skipped.WithLabelValues(opSet, reasonCircuitOpen).Inc()
+		return err
+	}
+	if err != nil {
+		// If the PickServer will fail for any reason the server address will be nil
+		// and so missing in the logs. We're OK with that (it's a best effort).
+		serverAddr, _ := c.selector.PickServer(key)
+		level.Debug(c.logger).Log(
+			"msg", "failed to store item to memcached",
+			"key", key,
+			"sizeBytes", len(value),
+			"server", serverAddr,
+			"err", err,
+		)
+		c.trackError(opSet, err)
+		return err
+	}
 
-		err := c.client.Set(&memcache.Item{
+	c.dataSize.WithLabelValues(opSet).Observe(float64(len(value)))
+	c.duration.WithLabelValues(opSet).Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// add synchronously stores an item to memcached unless it already exists, in which case
+// memcache.ErrNotStored is returned without being tracked as a failure. Other errors are
+// tracked and logged before being returned.
+func (c *memcachedClient) add(key string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+
+	if c.fallback != nil {
+		c.fallback.set(key, value)
+	}
+
+	exists := false
+	err := c.withCircuitBreaker(func() error {
+		c.operations.WithLabelValues(opAdd).Inc()
+		err := c.writeClient.Add(&memcache.Item{
 			Key:        key,
 			Value:      value,
 			Expiration: int32(time.Now().Add(ttl).Unix()),
 		})
-		if err != nil {
-			// If the PickServer will fail for any reason the server address will be nil
-			// and so missing in the logs. We're OK with that (it's a best effort).
-			serverAddr, _ := c.selector.PickServer(key)
-			level.Debug(c.logger).Log(
-				"msg", "failed to store item to memcached",
-				"key", key,
-				"sizeBytes", len(value),
-				"server", serverAddr,
-				"err", err,
-			)
-			c.trackError(opSet, err)
-			return
+		// An existing item is not a failure of memcached.
+		if err == memcache.ErrNotStored {
+			exists = true
+			return nil
 		}
-
-		c.dataSize.WithLabelValues(opSet).Observe(float64(len(value)))
-		c.duration.WithLabelValues(opSet).Observe(time.Since(start).Seconds())
+		return err
 	})
+	if isCircuitBreakerOpen(err) {
+		c.skipped.WithLabelValues(opAdd, reasonCircuitOpen).Inc()
+		return err
+	}
+	if err != nil {
+		serverAddr, _ := c.selector.PickServer(key)
+		level.Debug(c.logger).Log(
+			"msg", "failed to add item to memcached",
+			"key", key,
+			"sizeBytes", len(value),
+			"server", serverAddr,
+			"err", err,
+		)
+		c.trackError(opAdd, err)
+		return err
+	}
 
-	if err == errMemcachedAsyncBufferFull {
-		c.skipped.WithLabelValues(opSet, reasonAsyncBufferFull).Inc()
-		level.Debug(c.logger).Log("msg", "failed to store item to memcached because the async buffer is full", "err", err, "size", len(c.asyncQueue))
+	c.duration.WithLabelValues(opAdd).Observe(time.Since(start).Seconds())
+	if exists {
+		return memcache.ErrNotStored
+	}
+	c.dataSize.WithLabelValues(opAdd).Observe(float64(len(value)))
+	return nil
+}
+
+// addMultiToSetBatch adds the items to the pending set batch, enqueuing the batches getting full,
+// unless the client is stopping, in which case they're skipped and errMemcachedClientStopped is
+// returned. The stopping check, the append and the enqueuing of the full batches happen under the
+// same lock the pending batch is taken with on stopping, so that no item added before the client
+// is stopping can be left behind in the pending batch, or fail to be enqueued.
+func (c *memcachedClient) addMultiToSetBatch(items map[string]pendingSet) error {
+	c.setBatchMtx.Lock()
+	defer c.setBatchMtx.Unlock()
+
+	if c.isStopping() {
+		c.skipped.WithLabelValues(opSet, reasonStopped).Add(float64(len(items)))
+		return errMemcachedClientStopped
+	}
+	for key, item := range items {
+		c.setBatch[key] = item
+		if len(c.setBatch) >= c.config.SetBatchSize {
+			// The enqueuing doesn't block, so it's fine to do it while holding the lock.
+			_ = c.enqueueSetBatch(c.setBatch)
+			c.setBatch = make(map[string]pendingSet, c.config.SetBatchSize)
+		}
+	}
+	return nil
+}
+
+// takeSetBatch returns the pending set batch, replacing it with an empty one.
+func (c *memcachedClient) takeSetBatch() map[string]pendingSet {
+	c.setBatchMtx.Lock()
+	defer c.setBatchMtx.Unlock()
+
+	if len(c.setBatch) == 0 {
 		return nil
 	}
-	return err
+	items := c.setBatch
+	c.setBatch = make(map[string]pendingSet, c.config.SetBatchSize)
+	return items
 }
 
-func (c *memcachedClient) GetMulti(ctx context.Context, keys []string) map[string][]byte {
-	if len(keys) == 0 {
+// enqueueSetBatch enqueues the items to be stored as a single async operation. It only returns
+// errMemcachedClientStopped if the client is stopping, the items skipped because the async buffer
+// is full being tracked only.
+func (c *memcachedClient) enqueueSetBatch(items map[string]pendingSet) error {
+	err := c.enqueueAsync(len(items), func() {
+		c.storeSetBatch(items)
+	})
+	switch err {
+	case errMemcachedAsyncBufferFull:
+		c.skipped.WithLabelValues(opSet, reasonAsyncBufferFull).Add(float64(len(items)))
+		level.Debug(c.logger).Log("msg", "failed to store items batch to memcached because the async buffer is full", "items", len(items), "size", len(c.asyncQueue))
 		return nil
+	case errMemcachedClientStopped:
+		c.skipped.WithLabelValues(opSet, reasonStopped).Add(float64(len(items)))
 	}
+	return err
+}
 
-	batches, err := c.getMultiBatched(ctx, keys)
-	if err != nil {
-		level.Warn(c.logger).Log("msg", "failed to fetch items from memcached", "numKeys", len(keys), "firstKey", keys[0], "err", err)
+// storeSetBatch stores the items of a set batch, keeping the ones sharded to the same
+// server next to each other so that they're more likely to reuse the same connection.
+func (c *memcachedClient) storeSetBatch(items map[string]pendingSet) {
+	c.batchSize.Observe(float64(len(items)))
+
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	for _, key := range c.sortKeysByServer(keys) {
+		_ = c.set(key, items[key].value, items[key].ttl)
+	}
+}
+
+func (c *memcachedClient) setBatchFlushLoop() {
+	defer c.workers.Done()
+
+	ticker := time.NewTicker(c.config.SetBatchFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if items := c.takeSetBatch(); len(items) > 0 {
+				_ = c.enqueueSetBatch(items)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *memcachedClient) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	c.operations.WithLabelValues(opDelete).Inc()
+
+	err := c.writeClient.Delete(key)
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		c.trackError(opDelete, err)
+		return err
+	}
+
+	c.duration.WithLabelValues(opDelete).Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// HealthCheck implements RemoteCacheClientWithHealthCheck, fetching a key which is never stored
+// from the server it's mapped to. It bypasses the circuit breaker and the fallback, if enabled,
+// so that the actual reachability of memcached is reported.
+func (c *memcachedClient) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := c.client.GetMulti([]string{healthCheckKey})
+	return errors.Wrap(err, "memcached health check")
+}
 
+func (c *memcachedClient) GetMulti(ctx context.Context, keys []string) map[string][]byte {
+	hits, err := c.GetMultiWithError(ctx, keys)
+	// Requests short-circuited by the circuit breaker are tracked as skipped rather than logged.
+	if err != nil && !isCircuitBreakerOpen(err) {
 		// In case we have both results and an error, it means some batch requests
 		// failed and other succeeded. In this case we prefer to log it and move on,
 		// given returning some results from the cache is better than returning
 		// nothing.
-		if len(batches) == 0 {
-			return nil
+		level.Warn(c.logger).Log("msg", "failed to fetch items from memcached", "numKeys", len(keys), "firstKey", keys[0], "err", err)
+	}
+
+	return hits
+}
+
+func (c *memcachedClient) GetMultiWithError(ctx context.Context, keys []string) (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if len(c.replicas) > 0 {
+		return c.getMultiFromReplica(ctx, keys)
+	}
+	return c.getMultiFromPrimary(ctx, keys)
+}
+
+func (c *memcachedClient) GetMultiPartial(ctx context.Context, keys []string) (map[string][]byte, []string, error) {
+	hits, err := c.GetMultiWithError(ctx, keys)
+
+	// Keys fetched before the request timed out are hits nonetheless.
+	var timedOut []string
+	for _, key := range timedOutKeys(err) {
+		if _, ok := hits[key]; !ok {
+			timedOut = append(timedOut, key)
+		}
+	}
+	return hits, timedOut, err
+}
+
+// getMultiFromReplica fetches the keys from the next read replica, then the keys it missed
+// from the primary servers. The errors of the replica are tracked by its own client, while
+// the error of the primary servers, if any, is returned along with the hits.
+func (c *memcachedClient) getMultiFromReplica(ctx context.Context, keys []string) (map[string][]byte, error) {
+	replica := c.replicas[(atomic.AddUint64(&c.nextReplica, 1)-1)%uint64(len(c.replicas))]
+
+	hits, _ := replica.GetMultiWithError(ctx, keys)
+	var missing []string
+	for _, key := range keys {
+		if _, ok := hits[key]; !ok {
+			missing = append(missing, key)
 		}
 	}
+	if len(missing) == 0 {
+		return hits, nil
+	}
+	c.replicaMisses.Add(float64(len(missing)))
+
+	primaryHits, err := c.getMultiFromPrimary(ctx, missing)
+	if hits == nil {
+		return primaryHits, err
+	}
+	for key, value := range primaryHits {
+		hits[key] = value
+	}
+	return hits, err
+}
+
+// getMultiFromPrimary fetches the keys from the primary servers.
+func (c *memcachedClient) getMultiFromPrimary(ctx context.Context, keys []string) (map[string][]byte, error) {
+	// Serve the recent values from the fallback while memcached is unavailable. As soon as
+	// the circuit breaker is half-open, requests go to memcached again to probe it.
+	if c.fallback != nil && c.breaker.State() == gobreaker.StateOpen {
+		hits := c.fallback.getMulti(keys)
+		c.fallbackHits.Add(float64(len(hits)))
+		return hits, nil
+	}
+
+	batches, err := c.getMultiBatched(ctx, keys)
+	if err != nil && len(batches) == 0 {
+		return nil, err
+	}
 
 	hits := map[string][]byte{}
 	for _, items := range batches {
 		for key, item := range items {
 			hits[key] = item.Value
+			if c.fallback != nil {
+				c.fallback.set(key, item.Value)
+			}
 		}
 	}
 
-	return hits
+	return hits, err
 }
 
 func (c *memcachedClient) getMultiBatched(ctx context.Context, keys []string) ([]map[string]*memcache.Item, error) {
@@ -462,18 +1421,19 @@ func (c *memcachedClient) getMultiBatched(ctx context.Context, keys []string) ([
 		// still counts against the concurrency limit.
 		if c.config.MaxGetMultiConcurrency > 0 {
 			if err := c.getMultiGate.Start(ctx); err != nil {
-				return nil, errors.Wrapf(err, "failed to wait for turn. Instance: %s", c.name)
+				return nil, withTimedOutKeys(errors.Wrapf(err, "failed to wait for turn. Instance: %s", c.name), keys)
 			}
 
 			defer c.getMultiGate.Done()
 		}
 
+		// Keep the results of the reachable servers, if any, along with the error.
 		items, err := c.getMultiSingle(ctx, keys)
-		if err != nil {
+		if err != nil && len(items) == 0 {
 			return nil, err
 		}
 
-		return []map[string]*memcache.Item{items}, nil
+		return []map[string]*memcache.Item{items}, err
 	}
 
 	// Calculate the number of expected results.
@@ -517,34 +1477,63 @@ func (c *memcachedClient) getMultiBatched(ctx context.Context, keys []string) ([
 	})
 
 	// Wait for all batch results. In case of error, we keep
-	// track of the last error occurred.
+	// track of the last error occurred, along with the keys
+	// of all the batches which timed out.
 	items := make([]map[string]*memcache.Item, 0, numResults)
-	var lastErr error
+	var (
+		lastErr  error
+		timedOut []string
+	)
 
 	for i := 0; i < numResults; i++ {
 		result := <-results
 		if result.err != nil {
 			lastErr = result.err
-			continue
+			timedOut = append(timedOut, timedOutKeys(result.err)...)
+		}
+		if result.err == nil || len(result.items) > 0 {
+			items = append(items, result.items)
 		}
-
-		items = append(items, result.items)
 	}
 
-	return items, lastErr
+	return items, joinTimedOutKeys(lastErr, timedOut)
 }
 
 func (c *memcachedClient) getMultiSingle(ctx context.Context, keys []string) (items map[string]*memcache.Item, err error) {
 	start := time.Now()
-	c.operations.WithLabelValues(opGetMulti).Inc()
 
 	select {
 	case <-ctx.Done():
 		// Make sure our context hasn't been canceled before fetching cache items using
 		// cache client backend.
-		return nil, ctx.Err()
+		return nil, withTimedOutKeys(ctx.Err(), keys)
 	default:
-		items, err = c.client.GetMulti(keys)
+		items, err = c.getMultiThroughCircuitBreaker(keys)
+	}
+
+	// Retry transient connection errors, backing off exponentially and giving up as soon
+	// as the context is done.
+	backoff := c.config.RetryBaseBackoff
+	for retry := 0; err != nil && retry < c.config.MaxRetries && isConnectionError(err); retry++ {
+		level.Debug(c.logger).Log("msg", "retrying to get multiple items from memcached", "err", err, "retry", retry+1)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			c.trackError(opGetMulti, err)
+			return nil, withTimedOutKeys(ctx.Err(), keys)
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		c.retries.WithLabelValues(opGetMulti).Inc()
+		items, err = c.getMultiThroughCircuitBreaker(keys)
+	}
+
+	if isCircuitBreakerOpen(err) {
+		c.skipped.WithLabelValues(opGetMulti, reasonCircuitOpen).Inc()
+		return nil, err
 	}
 
 	if err != nil {
@@ -562,6 +1551,92 @@ func (c *memcachedClient) getMultiSingle(ctx context.Context, keys []string) (it
 	return items, err
 }
 
+// getMultiThroughCircuitBreaker fetches the keys from the backend, unless short-circuited
+// by the circuit breaker.
+func (c *memcachedClient) getMultiThroughCircuitBreaker(keys []string) (items map[string]*memcache.Item, err error) {
+	err = c.withCircuitBreaker(func() (err error) {
+		c.operations.WithLabelValues(opGetMulti).Inc()
+		items, err = c.getMultiByServer(keys)
+		return err
+	})
+	return items, err
+}
+
+// getMultiByServer fetches the keys from the backend with a request per server, so that
+// failures are tracked per server while the items fetched from the reachable servers are
+// still returned along with the error.
+func (c *memcachedClient) getMultiByServer(keys []string) (map[string]*memcache.Item, error) {
+	keysByServer := make(map[string][]string)
+	for _, key := range keys {
+		addr, err := c.selector.PickServer(key)
+		if err != nil {
+			// Let the backend fail the request as a whole.
+			items, err := c.client.GetMulti(keys)
+			return items, withTimedOutKeys(err, keys)
+		}
+		keysByServer[addr.String()] = append(keysByServer[addr.String()], key)
+	}
+
+	var (
+		mtx      sync.Mutex
+		wg       sync.WaitGroup
+		items    = make(map[string]*memcache.Item, len(keys))
+		lastErr  error
+		timedOut []string
+	)
+	for server, serverKeys := range keysByServer {
+		if len(keysByServer) == 1 {
+			serverItems, err := c.client.GetMulti(serverKeys)
+			c.trackServerError(server, err)
+			return serverItems, withTimedOutKeys(err, serverKeys)
+		}
+
+		wg.Add(1)
+		go func(server string, serverKeys []string) {
+			defer wg.Done()
+
+			serverItems, err := c.client.GetMulti(serverKeys)
+			c.trackServerError(server, err)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			for key, item := range serverItems {
+				items[key] = item
+			}
+			if err != nil {
+				lastErr = err
+			}
+			if isTimeoutError(err) {
+				timedOut = append(timedOut, serverKeys...)
+			}
+		}(server, serverKeys)
+	}
+	wg.Wait()
+
+	return items, joinTimedOutKeys(lastErr, timedOut)
+}
+
+// trackServerError tracks the failure of a request to a server. Malformed keys aren't
+// tracked, given they don't depend on the server.
+func (c *memcachedClient) trackServerError(server string, err error) {
+	if err == nil || errors.Is(err, memcache.ErrMalformedKey) {
+		return
+	}
+	level.Debug(c.logger).Log("msg", "failed to get multiple items from memcached server", "server", server, "err", err)
+	c.serverFailures.WithLabelValues(server).Inc()
+}
+
+// withCircuitBreaker runs the operation through the circuit breaker, if enabled.
+func (c *memcachedClient) withCircuitBreaker(op func() error) error {
+	if c.breaker == nil {
+		return op()
+	}
+	_, err := c.breaker.Execute(func() (interface{}, error) {
+		return nil, op()
+	})
+	return err
+}
+
 // sortKeysByServer sorts cache keys within a slice based on which server they are
 // sharded to using a memcache.ServerSelector instance. The keys are ordered so keys
 // on the same server are next to each other. Any errors encountered determining which
@@ -612,11 +1687,89 @@ func (c *memcachedClient) trackError(op string, err error) {
 	}
 }
 
-func (c *memcachedClient) enqueueAsync(op func()) error {
+// timeoutError is the error of a GetMulti() request, carrying the keys which weren't
+// fetched because of a timeout.
+type timeoutError struct {
+	keys []string
+	err  error
+}
+
+func (e *timeoutError) Error() string { return e.err.Error() }
+
+func (e *timeoutError) Unwrap() error { return e.err }
+
+// withTimedOutKeys returns the error of a request for the given keys, annotated with
+// them if it's a timeout.
+func withTimedOutKeys(err error, keys []string) error {
+	if !isTimeoutError(err) {
+		return err
+	}
+	return &timeoutError{keys: keys, err: err}
+}
+
+// joinTimedOutKeys returns the error annotated with the given timed out keys, if any,
+// replacing the ones it was annotated with.
+func joinTimedOutKeys(err error, keys []string) error {
+	if err == nil || len(keys) == 0 {
+		return err
+	}
+	if timeoutErr, ok := err.(*timeoutError); ok {
+		err = timeoutErr.err
+	}
+	return &timeoutError{keys: keys, err: err}
+}
+
+// timedOutKeys returns the keys the error reports as timed out, if any.
+func timedOutKeys(err error) []string {
+	var timeoutErr *timeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.keys
+	}
+	return nil
+}
+
+// isTimeoutError returns whether the error is caused by a timeout or the context deadline.
+func isTimeoutError(err error) bool {
+	var connErr *memcache.ConnectTimeoutError
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &connErr) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isConnectionError returns whether the error is a connection-level error, which
+// is likely transient and thus worth a retry.
+func isConnectionError(err error) bool {
+	var connErr *memcache.ConnectTimeoutError
+	var netErr net.Error
+	return errors.As(err, &connErr) || errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (c *memcachedClient) AsyncQueueFullRatio() float64 {
+	if cap(c.asyncQueue) == 0 {
+		return 1
+	}
+	return float64(len(c.asyncQueue)) / float64(cap(c.asyncQueue))
+}
+
+// asyncOp is an async operation, storing the given number of items.
+type asyncOp struct {
+	items int
+	op    func()
+}
+
+func (c *memcachedClient) enqueueAsync(items int, op func()) error {
+	c.stoppingMtx.RLock()
+	defer c.stoppingMtx.RUnlock()
+
+	if c.stopping {
+		return errMemcachedClientStopped
+	}
+
+	c.asyncOps.Add(1)
 	select {
-	case c.asyncQueue <- op:
+	case c.asyncQueue <- asyncOp{items: items, op: op}:
 		return nil
 	default:
+		c.asyncOps.Done()
 		return errMemcachedAsyncBufferFull
 	}
 }
@@ -625,9 +1778,18 @@ func (c *memcachedClient) asyncQueueProcessLoop() {
 	defer c.workers.Done()
 
 	for {
+		// Stop processing as soon as stopping, even if operations are still enqueued,
+		// so that they're accounted as dropped.
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+
 		select {
 		case op := <-c.asyncQueue:
-			op()
+			op.op()
+			c.asyncOps.Done()
 		case <-c.stop:
 			return
 		}
@@ -637,7 +1799,7 @@ func (c *memcachedClient) asyncQueueProcessLoop() {
 func (c *memcachedClient) resolveAddrsLoop() {
 	defer c.workers.Done()
 
-	ticker := time.NewTicker(c.config.DNSProviderUpdateInterval)
+	ticker := time.NewTicker(c.config.addressesUpdateInterval())
 	defer ticker.Stop()
 
 	for {
@@ -668,5 +1830,14 @@ func (c *memcachedClient) resolveAddrs() error {
 		return fmt.Errorf("no server address resolved for %s", c.name)
 	}
 
-	return c.selector.SetServers(servers...)
+	selectorServers := make([]string, len(servers))
+	for i, server := range servers {
+		// The server list expects the UNIX socket addresses as bare paths.
+		selectorServers[i] = strings.TrimPrefix(server, unixSocketAddressPrefix)
+	}
+	if err := c.selector.SetServers(selectorServers...); err != nil {
+		return err
+	}
+	c.clusterMembers.Set(float64(len(servers)))
+	return nil
 }
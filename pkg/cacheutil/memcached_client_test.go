@@ -4,10 +4,16 @@
 package cacheutil
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -16,11 +22,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sony/gobreaker"
 	"go.uber.org/atomic"
 
 	"github.com/efficientgo/core/testutil"
 	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
 func TestMemcachedClientConfig_validate(t *testing.T) {
@@ -59,6 +68,122 @@ func TestMemcachedClientConfig_validate(t *testing.T) {
 			},
 			expected: errMemcachedDNSUpdateIntervalNotPositive,
 		},
+		"should fail on circuit breaker failure_ratio <= 0": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				CircuitBreaker:            CircuitBreakerConfig{Enabled: true, OpenDuration: time.Second},
+			},
+			expected: errCircuitBreakerFailureRatio,
+		},
+		"should fail on set_batch_flush_interval <= 0 with set batching enabled": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				SetBatchSize:              10,
+			},
+			expected: errMemcachedSetBatchFlushIntervalNotPositive,
+		},
+		"should fail on auto-discovery update interval < 0": {
+			config: MemcachedClientConfig{
+				Addresses:                   []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:         1,
+				DNSProviderUpdateInterval:   time.Second,
+				AutoDiscoveryUpdateInterval: -time.Second,
+			},
+			expected: errMemcachedAutoDiscoveryIntervalNegative,
+		},
+		"should fail on unknown server selection": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				ServerSelection:           "modulo",
+			},
+			expected: errMemcachedUnknownServerSelection,
+		},
+		"should fail on fallback without circuit breaker": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				FallbackMaxSize:           1024,
+			},
+			expected: errMemcachedFallbackRequiresCircuitBreaker,
+		},
+		"should fail on min_idle_connections > max_idle_connections": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				MaxIdleConnections:        1,
+				MinIdleConnections:        2,
+			},
+			expected: errMemcachedMinIdleConnectionsInvalid,
+		},
+		"should fail on idle_timeout < 0": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				IdleTimeout:               -time.Second,
+			},
+			expected: errMemcachedIdleTimeoutNegative,
+		},
+		"should fail on reconnect_backoff_base > reconnect_backoff_max": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				ReconnectBackoffBase:      time.Second,
+			},
+			expected: errMemcachedReconnectBackoffInvalid,
+		},
+		"should fail on max_retries < 0": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				MaxRetries:                -1,
+			},
+			expected: errMemcachedMaxRetriesNegative,
+		},
+		"should fail on retry_base_backoff <= 0 with retries enabled": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				MaxRetries:                1,
+			},
+			expected: errMemcachedRetryBaseBackoffNotPositive,
+		},
+		"should fail on read replica with no addresses": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+				ReadReplicas:              []MemcachedReadReplicaConfig{{Addresses: []string{"127.0.0.2:11211"}}, {}},
+			},
+			expected: errMemcachedReadReplicaNoAddrs,
+		},
+		"should pass on absolute UNIX socket address": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"127.0.0.1:11211", "unix:///var/run/memcached.sock"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+			},
+			expected: nil,
+		},
+		"should fail on relative UNIX socket address": {
+			config: MemcachedClientConfig{
+				Addresses:                 []string{"unix://memcached.sock"},
+				MaxAsyncConcurrency:       1,
+				DNSProviderUpdateInterval: time.Second,
+			},
+			expected: errMemcachedUnixSocketPathNotAbsolute,
+		},
 	}
 
 	for testName, testData := range tests {
@@ -114,6 +239,9 @@ max_get_multi_concurrency: 1
 max_item_size: 1MiB
 max_get_multi_batch_size: 1
 dns_provider_update_interval: 1s
+read_replicas:
+  - addresses:
+      - 127.0.0.3:11211
 `)
 	cache, err = NewMemcachedClient(log.NewNopLogger(), "test", conf, nil)
 	testutil.Ok(t, err)
@@ -128,6 +256,10 @@ dns_provider_update_interval: 1s
 	testutil.Equals(t, 1, cache.config.MaxGetMultiConcurrency)
 	testutil.Equals(t, 1, cache.config.MaxGetMultiBatchSize)
 	testutil.Equals(t, model.Bytes(1024*1024), cache.config.MaxItemSize)
+	testutil.Equals(t, []MemcachedReadReplicaConfig{{Addresses: []string{"127.0.0.3:11211"}}}, cache.config.ReadReplicas)
+	testutil.Equals(t, 1, len(cache.replicas))
+	testutil.Equals(t, []string{"127.0.0.3:11211"}, cache.replicas[0].config.Addresses)
+	testutil.Equals(t, 1*time.Second, cache.replicas[0].config.Timeout)
 }
 
 func TestMemcachedClient_SetAsync(t *testing.T) {
@@ -179,6 +311,69 @@ func TestMemcachedClient_SetAsyncWithCustomMaxItemSize(t *testing.T) {
 	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.operations.WithLabelValues(opGetMulti)))
 	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(client.failures.WithLabelValues(opSet, reasonOther)))
 	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.skipped.WithLabelValues(opSet, reasonMaxItemSize)))
+	testutil.Equals(t, float64(len("value-2-too-long-to-be-stored")), prom_testutil.ToFloat64(client.skippedBytes.WithLabelValues(opSet, reasonMaxItemSize)))
+}
+
+func TestMemcachedClient_Set(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.MaxItemSize = model.Bytes(10)
+	backendMock := newMemcachedClientBackendMock()
+
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	// Items are stored by the time Set returns.
+	testutil.Ok(t, client.Set(ctx, "key-1", []byte("value-1"), time.Second))
+	testutil.Ok(t, client.Set(ctx, "key-2", []byte("value-2-too-long-to-be-stored"), time.Second))
+	testutil.Equals(t, 1, len(backendMock.items))
+	testutil.Equals(t, []byte("value-1"), backendMock.items["key-1"].Value)
+
+	// Failures are returned.
+	backendMock.setErr = errors.New("mocked Set error")
+	testutil.NotOk(t, client.Set(ctx, "key-3", []byte("value-3"), time.Second))
+
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(client.operations.WithLabelValues(opSet)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.failures.WithLabelValues(opSet, reasonOther)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.skipped.WithLabelValues(opSet, reasonMaxItemSize)))
+}
+
+func TestMemcachedClient_AddAsync(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	backendMock := newMemcachedClientBackendMock()
+
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	existing := atomic.NewInt64(0)
+	exists := func() { existing.Inc() }
+
+	testutil.Ok(t, client.AddAsync(ctx, "key-1", []byte("value-1"), time.Second, exists))
+	testutil.Ok(t, backendMock.waitItems(1))
+
+	// Only the first added value is stored.
+	retryCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	testutil.Ok(t, client.AddAsync(ctx, "key-1", []byte("value-2"), time.Second, exists))
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if existing.Load() != 1 {
+			return errors.New("the existing key wasn't reported yet")
+		}
+		return nil
+	}))
+
+	actual, err := client.getMultiSingle(ctx, []string{"key-1"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []byte("value-1"), actual["key-1"].Value)
+
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(client.operations.WithLabelValues(opAdd)))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(client.operations.WithLabelValues(opSet)))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(client.failures.WithLabelValues(opAdd, reasonOther)))
 }
 
 func TestMemcachedClient_GetMulti(t *testing.T) {
@@ -408,6 +603,757 @@ func TestMemcachedClient_GetMulti(t *testing.T) {
 	}
 }
 
+func TestMemcachedClient_GetMultiWithError(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.MaxGetMultiBatchSize = 1
+	config.MaxGetMultiConcurrency = 1
+
+	backendMock := newMemcachedClientBackendMock()
+	backendMock.getMultiErrors = 1
+
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Second))
+	testutil.Ok(t, client.SetAsync(ctx, "key-2", []byte("value-2"), time.Second))
+	testutil.Ok(t, backendMock.waitItems(2))
+
+	// The first batch fails while the second one succeeds, so we expect
+	// both a partial result and the error to be returned.
+	hits, err := client.GetMultiWithError(ctx, []string{"key-1", "key-2"})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 1, len(hits))
+
+	// No error is expected once the backend recovers.
+	hits, err = client.GetMultiWithError(ctx, []string{"key-1", "key-2"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(hits))
+}
+
+func TestMemcachedClient_GetMulti_Retries(t *testing.T) {
+	connErr := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+	for name, tc := range map[string]struct {
+		maxRetries       int
+		getMultiErrors   int
+		getMultiErr      error
+		expectedErr      bool
+		expectedHits     int
+		expectedCalls    int
+		expectedRetries  float64
+		expectedFailures float64
+	}{
+		"should retry connection errors until success": {
+			maxRetries:      2,
+			getMultiErrors:  2,
+			getMultiErr:     connErr,
+			expectedHits:    1,
+			expectedCalls:   3,
+			expectedRetries: 2,
+		},
+		"should give up after max retries": {
+			maxRetries:       2,
+			getMultiErrors:   3,
+			getMultiErr:      connErr,
+			expectedErr:      true,
+			expectedCalls:    3,
+			expectedRetries:  2,
+			expectedFailures: 1,
+		},
+		"should not retry other errors": {
+			maxRetries:       2,
+			getMultiErrors:   1,
+			getMultiErr:      memcache.ErrServerError,
+			expectedErr:      true,
+			expectedCalls:    1,
+			expectedFailures: 1,
+		},
+		"should not retry when disabled": {
+			getMultiErrors:   1,
+			getMultiErr:      connErr,
+			expectedErr:      true,
+			expectedCalls:    1,
+			expectedFailures: 1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			config := defaultMemcachedClientConfig
+			config.Addresses = []string{"127.0.0.1:11211"}
+			config.MaxRetries = tc.maxRetries
+			config.RetryBaseBackoff = time.Millisecond
+
+			backendMock := newMemcachedClientBackendMock()
+			client, err := prepare(config, backendMock)
+			testutil.Ok(t, err)
+			defer client.Stop()
+
+			testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Second))
+			testutil.Ok(t, backendMock.waitItems(1))
+
+			backendMock.getMultiErrors = tc.getMultiErrors
+			backendMock.getMultiErr = tc.getMultiErr
+
+			hits, err := client.GetMultiWithError(ctx, []string{"key-1"})
+			if tc.expectedErr {
+				testutil.NotOk(t, err)
+			} else {
+				testutil.Ok(t, err)
+			}
+			testutil.Equals(t, tc.expectedHits, len(hits))
+			testutil.Equals(t, tc.expectedCalls, backendMock.getMultiCount)
+			testutil.Equals(t, tc.expectedRetries, prom_testutil.ToFloat64(client.retries.WithLabelValues(opGetMulti)))
+			testutil.Equals(t, tc.expectedFailures, prom_testutil.ToFloat64(client.failures.WithLabelValues(opGetMulti, reasonNetworkError))+
+				prom_testutil.ToFloat64(client.failures.WithLabelValues(opGetMulti, reasonServerError)))
+		})
+	}
+}
+
+func TestMemcachedClient_GetMulti_ShouldStopRetryingOnContextDone(t *testing.T) {
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.MaxRetries = 10
+	config.RetryBaseBackoff = time.Hour
+
+	backendMock := newMemcachedClientBackendMock()
+	backendMock.getMultiErrors = 1
+	backendMock.getMultiErr = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = client.GetMultiWithError(ctx, []string{"key-1"})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 1, backendMock.getMultiCount)
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(client.retries.WithLabelValues(opGetMulti)))
+}
+
+func TestMemcachedClient_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.CircuitBreaker = CircuitBreakerConfig{
+		Enabled:             true,
+		HalfOpenMaxRequests: 1,
+		OpenDuration:        50 * time.Millisecond,
+		MinRequests:         2,
+		FailureRatio:        0.5,
+	}
+
+	backendMock := newMemcachedClientBackendMock()
+	backendMock.getMultiErrors = 2
+
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Second))
+	testutil.Ok(t, backendMock.waitItems(1))
+
+	// The circuit breaker should open once the failure ratio is exceeded.
+	for i := 0; i < 2; i++ {
+		_, err := client.GetMultiWithError(ctx, []string{"key-1"})
+		testutil.NotOk(t, err)
+	}
+	testutil.Equals(t, gobreaker.StateOpen, client.breaker.State())
+
+	// While open, requests should be short-circuited without hitting the backend.
+	testutil.Equals(t, map[string][]byte(nil), client.GetMulti(ctx, []string{"key-1"}))
+	testutil.Equals(t, 2, backendMock.getMultiCount)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.skipped.WithLabelValues(opGetMulti, reasonCircuitOpen)))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(client.operations.WithLabelValues(opGetMulti)))
+
+	// Once half-open, a successful probe should close the circuit breaker again.
+	time.Sleep(2 * config.CircuitBreaker.OpenDuration)
+	testutil.Equals(t, gobreaker.StateHalfOpen, client.breaker.State())
+
+	hits, err := client.GetMultiWithError(ctx, []string{"key-1"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(hits))
+	testutil.Equals(t, gobreaker.StateClosed, client.breaker.State())
+}
+
+func TestMemcachedClient_SetBatch(t *testing.T) {
+	ctx := context.Background()
+
+	newClient := func(t *testing.T, batchSize int, flushInterval time.Duration) (*memcachedClient, *memcachedClientBackendMock) {
+		config := defaultMemcachedClientConfig
+		config.Addresses = []string{"127.0.0.1:11211"}
+		config.SetBatchSize = batchSize
+		config.SetBatchFlushInterval = flushInterval
+
+		backendMock := newMemcachedClientBackendMock()
+		client, err := prepare(config, backendMock)
+		testutil.Ok(t, err)
+		return client, backendMock
+	}
+
+	t.Run("should enqueue the batch once full", func(t *testing.T) {
+		client, backendMock := newClient(t, 3, time.Hour)
+		defer client.Stop()
+
+		testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Hour))
+		// Setting a pending item again should coalesce it.
+		testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1-updated"), time.Hour))
+		testutil.Ok(t, client.SetAsync(ctx, "key-2", []byte("value-2"), time.Hour))
+		testutil.Ok(t, client.SetAsync(ctx, "key-3", []byte("value-3"), time.Hour))
+		testutil.Ok(t, backendMock.waitItems(3))
+
+		hits := client.GetMulti(ctx, []string{"key-1", "key-2", "key-3"})
+		testutil.Equals(t, []byte("value-1-updated"), hits["key-1"])
+		testutil.Equals(t, 3.0, prom_testutil.ToFloat64(client.operations.WithLabelValues(opSet)))
+		testutil.Equals(t, uint64(1), histogramSampleCount(t, client.batchSize))
+	})
+
+	t.Run("should enqueue the batch on flush interval", func(t *testing.T) {
+		client, backendMock := newClient(t, 100, 10*time.Millisecond)
+		defer client.Stop()
+
+		testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Hour))
+		testutil.Ok(t, client.SetAsync(ctx, "key-2", []byte("value-2"), time.Hour))
+		testutil.Ok(t, backendMock.waitItems(2))
+	})
+
+	t.Run("should store the pending batch on stop, preserving each item TTL", func(t *testing.T) {
+		client, backendMock := newClient(t, 100, time.Hour)
+
+		testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Hour))
+		testutil.Ok(t, client.SetAsync(ctx, "key-2", []byte("value-2"), 2*time.Hour))
+		client.Stop()
+
+		testutil.Equals(t, 2, len(backendMock.items))
+		ttlDiff := backendMock.items["key-2"].Expiration - backendMock.items["key-1"].Expiration
+		testutil.Assert(t, ttlDiff >= 3599 && ttlDiff <= 3601, "unexpected expirations difference %d", ttlDiff)
+	})
+
+	t.Run("should add multiple items to the pending batch at once", func(t *testing.T) {
+		client, backendMock := newClient(t, 2, time.Hour)
+		defer client.Stop()
+
+		testutil.Ok(t, client.SetMultiAsync(ctx, []RemoteCacheItem{
+			{Key: "key-1", Value: []byte("value-1"), TTL: time.Hour},
+			{Key: "key-2", Value: []byte("value-2"), TTL: time.Hour},
+			{Key: "key-3", Value: []byte("value-3"), TTL: time.Hour},
+		}))
+		testutil.Ok(t, backendMock.waitItems(2))
+		testutil.Equals(t, 1, len(client.takeSetBatch()))
+	})
+}
+
+func TestMemcachedClient_SetMultiAsync(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.MaxItemSize = 10
+
+	backendMock := newMemcachedClientBackendMock()
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Ok(t, client.SetMultiAsync(ctx, []RemoteCacheItem{
+		{Key: "key-1", Value: []byte("value-1"), TTL: time.Hour},
+		{Key: "key-2", Value: []byte("value-2"), TTL: 2 * time.Hour},
+		{Key: "key-3", Value: []byte("value-too-big"), TTL: time.Hour},
+	}))
+	testutil.Ok(t, backendMock.waitItems(2))
+
+	hits := client.GetMulti(ctx, []string{"key-1", "key-2", "key-3"})
+	testutil.Equals(t, map[string][]byte{"key-1": []byte("value-1"), "key-2": []byte("value-2")}, hits)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.skipped.WithLabelValues(opSet, reasonMaxItemSize)))
+	// The items are stored by a single async operation.
+	testutil.Equals(t, uint64(1), histogramSampleCount(t, client.batchSize))
+}
+
+func TestMemcachedClient_ClusterMembers(t *testing.T) {
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211", "127.0.0.2:11211"}
+
+	client, err := prepare(config, newMemcachedClientBackendMock())
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(client.clusterMembers))
+}
+
+func TestMemcachedClientConfig_AddressesUpdateInterval(t *testing.T) {
+	config := defaultMemcachedClientConfig
+	config.AutoDiscoveryUpdateInterval = time.Minute
+	testutil.Equals(t, config.DNSProviderUpdateInterval, config.addressesUpdateInterval())
+
+	config.AutoDiscovery = true
+	testutil.Equals(t, time.Minute, config.addressesUpdateInterval())
+
+	config.AutoDiscoveryUpdateInterval = 0
+	testutil.Equals(t, config.DNSProviderUpdateInterval, config.addressesUpdateInterval())
+}
+
+func TestMemcachedClient_StopWithContext(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.MaxAsyncConcurrency = 1
+
+	t.Run("should flush the enqueued items and the pending batch", func(t *testing.T) {
+		config := config
+		config.SetBatchSize = 100
+		config.SetBatchFlushInterval = time.Hour
+
+		backendMock := newMemcachedClientBackendMock()
+		client, err := prepare(config, backendMock)
+		testutil.Ok(t, err)
+
+		for i := 0; i < 10; i++ {
+			testutil.Ok(t, client.SetAsync(ctx, fmt.Sprintf("key-%d", i), []byte("value"), time.Hour))
+		}
+		testutil.Equals(t, 0, client.StopWithContext(ctx))
+		testutil.Equals(t, 10, len(backendMock.items))
+
+		// New items should be skipped once stopped.
+		testutil.Equals(t, errMemcachedClientStopped, client.SetAsync(ctx, "key-10", []byte("value"), time.Hour))
+		testutil.Equals(t, errMemcachedClientStopped, client.SetMultiAsync(ctx, []RemoteCacheItem{
+			{Key: "key-11", Value: []byte("value"), TTL: time.Hour},
+			{Key: "key-12", Value: []byte("value"), TTL: time.Hour},
+		}))
+		client.Stop()
+		testutil.Equals(t, 10, len(backendMock.items))
+		testutil.Equals(t, 3.0, prom_testutil.ToFloat64(client.skipped.WithLabelValues(opSet, reasonStopped)))
+	})
+
+	t.Run("should store all the items accepted while stopping", func(t *testing.T) {
+		config := config
+		config.SetBatchSize = 3
+		config.SetBatchFlushInterval = time.Hour
+
+		backendMock := newMemcachedClientBackendMock()
+		client, err := prepare(config, backendMock)
+		testutil.Ok(t, err)
+
+		var (
+			wg       sync.WaitGroup
+			accepted atomic.Int64
+		)
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					err := client.SetAsync(ctx, fmt.Sprintf("key-%d-%d", w, i), []byte("value"), time.Hour)
+					if err == errMemcachedClientStopped {
+						return
+					}
+					testutil.Ok(t, err)
+					accepted.Inc()
+				}
+			}(w)
+		}
+		// Stop while the items are being set.
+		for accepted.Load() < 10 {
+			time.Sleep(time.Millisecond)
+		}
+		testutil.Equals(t, 0, client.StopWithContext(ctx))
+		wg.Wait()
+
+		testutil.Equals(t, int(accepted.Load()), len(backendMock.items))
+	})
+
+	t.Run("should drop the items not stored yet once the context is done", func(t *testing.T) {
+		backendMock := &memcachedBlockingSetMock{
+			memcachedClientBackendMock: newMemcachedClientBackendMock(),
+			release:                    make(chan struct{}),
+		}
+		client, err := prepare(config, backendMock)
+		testutil.Ok(t, err)
+
+		for i := 0; i < 5; i++ {
+			testutil.Ok(t, client.SetAsync(ctx, fmt.Sprintf("key-%d", i), []byte("value"), time.Hour))
+		}
+
+		// Release the item being stored once stopping.
+		go func() {
+			<-client.stop
+			close(backendMock.release)
+		}()
+
+		stopCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		// The item being stored when the context is done should not be dropped.
+		testutil.Equals(t, 4, client.StopWithContext(stopCtx))
+		testutil.Equals(t, 1, len(backendMock.items))
+		testutil.Equals(t, 4.0, prom_testutil.ToFloat64(client.skipped.WithLabelValues(opSet, reasonStopped)))
+	})
+}
+
+// memcachedBlockingSetMock is a backend mock whose Set() blocks until released.
+type memcachedBlockingSetMock struct {
+	*memcachedClientBackendMock
+
+	release chan struct{}
+}
+
+func (c *memcachedBlockingSetMock) Set(item *memcache.Item) error {
+	<-c.release
+	return c.memcachedClientBackendMock.Set(item)
+}
+
+func TestMemcachedClient_GetMulti_ShouldReturnPartialResultsOnServerFailure(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211", "127.0.0.2:11211"}
+
+	selector := &MemcachedJumpHashSelector{}
+	backendMock := &memcachedServerFailingMock{
+		memcachedClientBackendMock: newMemcachedClientBackendMock(),
+		selector:                   selector,
+		failingServer:              "127.0.0.2:11211",
+	}
+	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, backendMock, selector, config, prometheus.NewPedanticRegistry(), "test")
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	// Store items until both servers own some of them.
+	var keys []string
+	owners := map[string]int{}
+	for i := 0; len(owners) < 2 || len(keys) < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		addr, err := selector.PickServer(key)
+		testutil.Ok(t, err)
+		owners[addr.String()]++
+		keys = append(keys, key)
+		testutil.Ok(t, client.SetAsync(ctx, key, []byte(key), time.Hour))
+	}
+	testutil.Ok(t, backendMock.waitItems(len(keys)))
+
+	hits, err := client.GetMultiWithError(ctx, keys)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, owners["127.0.0.1:11211"], len(hits))
+	for key := range hits {
+		addr, err := selector.PickServer(key)
+		testutil.Ok(t, err)
+		testutil.Equals(t, "127.0.0.1:11211", addr.String())
+	}
+
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.serverFailures.WithLabelValues("127.0.0.2:11211")))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(client.serverFailures.WithLabelValues("127.0.0.1:11211")))
+}
+
+// memcachedServerFailingMock is a backend mock whose GetMulti() fails for the keys
+// sharded to the failing server.
+type memcachedServerFailingMock struct {
+	*memcachedClientBackendMock
+
+	selector      memcache.ServerSelector
+	failingServer string
+	// failingErr is the error returned for the failing server, if set.
+	failingErr error
+}
+
+func (c *memcachedServerFailingMock) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	for _, key := range keys {
+		if addr, err := c.selector.PickServer(key); err == nil && addr.String() == c.failingServer {
+			if c.failingErr != nil {
+				return nil, c.failingErr
+			}
+			return nil, errors.New("mocked server failure")
+		}
+	}
+	return c.memcachedClientBackendMock.GetMulti(keys)
+}
+
+func TestMemcachedClient_GetMultiPartial(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211", "127.0.0.2:11211"}
+	config.MaxRetries = 0
+
+	selector := &MemcachedJumpHashSelector{}
+	backendMock := &memcachedServerFailingMock{
+		memcachedClientBackendMock: newMemcachedClientBackendMock(),
+		selector:                   selector,
+		failingServer:              "127.0.0.2:11211",
+		failingErr:                 os.ErrDeadlineExceeded,
+	}
+	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, backendMock, selector, config, prometheus.NewPedanticRegistry(), "test")
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	// Store items until both servers own some of them, leaving a missing key for each server.
+	var (
+		keys, stored  []string
+		failingKeys   []string
+		missingByAddr = map[string]bool{}
+	)
+	for i := 0; len(missingByAddr) < 2 || len(stored) < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		addr, err := selector.PickServer(key)
+		testutil.Ok(t, err)
+		keys = append(keys, key)
+		if addr.String() == backendMock.failingServer {
+			failingKeys = append(failingKeys, key)
+		}
+		if !missingByAddr[addr.String()] {
+			missingByAddr[addr.String()] = true
+			continue
+		}
+		stored = append(stored, key)
+		testutil.Ok(t, client.SetAsync(ctx, key, []byte(key), time.Hour))
+	}
+	testutil.Ok(t, backendMock.waitItems(len(stored)))
+
+	// Only the keys of the timed out server should be reported as timed out, not the missing ones.
+	hits, timedOut, err := client.GetMultiPartial(ctx, keys)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, len(stored)-len(failingKeys)+1, len(hits))
+	sort.Strings(timedOut)
+	sort.Strings(failingKeys)
+	testutil.Equals(t, failingKeys, timedOut)
+
+	// Other failures should not be reported as timeouts.
+	backendMock.failingErr = errors.New("mocked server failure")
+	hits, timedOut, err = client.GetMultiPartial(ctx, keys)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, len(stored)-len(failingKeys)+1, len(hits))
+	testutil.Equals(t, 0, len(timedOut))
+
+	// All the keys should be reported as timed out once the context deadline is exceeded.
+	deadlineCtx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	hits, timedOut, err = client.GetMultiPartial(deadlineCtx, keys)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 0, len(hits))
+	testutil.Equals(t, keys, timedOut)
+}
+
+func TestMemcachedClient_Fallback(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.FallbackMaxSize = 1024
+	config.CircuitBreaker = CircuitBreakerConfig{
+		Enabled:             true,
+		HalfOpenMaxRequests: 1,
+		OpenDuration:        50 * time.Millisecond,
+		MinRequests:         1,
+		FailureRatio:        0.5,
+	}
+
+	backendMock := newMemcachedClientBackendMock()
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Second))
+	testutil.Ok(t, backendMock.waitItems(1))
+
+	// Open the circuit breaker, given the set counts as a successful request.
+	backendMock.getMultiErrors = 2
+	for i := 0; i < 2; i++ {
+		_, err = client.GetMultiWithError(ctx, []string{"key-2"})
+		testutil.NotOk(t, err)
+	}
+	testutil.Equals(t, gobreaker.StateOpen, client.breaker.State())
+
+	// While open, the recent values should be served from the fallback.
+	hits, err := client.GetMultiWithError(ctx, []string{"key-1", "key-2"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string][]byte{"key-1": []byte("value-1")}, hits)
+	testutil.Equals(t, 2, backendMock.getMultiCount)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.fallbackHits))
+
+	// Once the backend recovers, the fallback should be bypassed.
+	time.Sleep(2 * config.CircuitBreaker.OpenDuration)
+	hits, err = client.GetMultiWithError(ctx, []string{"key-1"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string][]byte{"key-1": []byte("value-1")}, hits)
+	testutil.Equals(t, 3, backendMock.getMultiCount)
+	testutil.Equals(t, gobreaker.StateClosed, client.breaker.State())
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.fallbackHits))
+}
+
+func TestMemcachedClient_Delete(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+
+	backendMock := newMemcachedClientBackendMock()
+	client, err := prepare(config, backendMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Second))
+	testutil.Ok(t, backendMock.waitItems(1))
+
+	testutil.Ok(t, client.Delete(ctx, "key-1"))
+	testutil.Equals(t, 0, len(client.GetMulti(ctx, []string{"key-1"})))
+
+	// Deleting a missing key should not fail.
+	testutil.Ok(t, client.Delete(ctx, "key-1"))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(client.operations.WithLabelValues(opDelete)))
+}
+
+func TestMemcachedClient_HealthCheck(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+
+	selector := &MemcachedJumpHashSelector{}
+	backendMock := &memcachedServerFailingMock{
+		memcachedClientBackendMock: newMemcachedClientBackendMock(),
+		selector:                   selector,
+	}
+	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, backendMock, selector, config, nil, "test")
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Ok(t, client.HealthCheck(ctx))
+
+	// The health check fails if the server is unreachable, or the context is done.
+	backendMock.failingServer = "127.0.0.1:11211"
+	testutil.NotOk(t, client.HealthCheck(ctx))
+
+	backendMock.failingServer = ""
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	testutil.NotOk(t, client.HealthCheck(canceledCtx))
+}
+
+func TestMemcachedClient_ReadReplicas(t *testing.T) {
+	ctx := context.Background()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+
+	primaryMock := newMemcachedClientBackendMock()
+	client, err := prepare(config, primaryMock)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	replicaMocks := []*memcachedClientBackendMock{newMemcachedClientBackendMock(), newMemcachedClientBackendMock()}
+	for _, replicaMock := range replicaMocks {
+		replica, err := prepare(config, replicaMock)
+		testutil.Ok(t, err)
+		defer replica.Stop()
+		client.replicas = append(client.replicas, replica)
+	}
+	client.replicaMisses = prometheus.NewCounter(prometheus.CounterOpts{})
+
+	// Items are only stored to the primary servers.
+	testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Second))
+	testutil.Ok(t, client.SetAsync(ctx, "key-2", []byte("value-2"), time.Second))
+	testutil.Ok(t, primaryMock.waitItems(2))
+	testutil.Equals(t, 0, len(replicaMocks[0].items)+len(replicaMocks[1].items))
+
+	// The first replica only has one of the keys, the other is fetched from the primary servers.
+	testutil.Ok(t, replicaMocks[0].Set(&memcache.Item{Key: "key-1", Value: []byte("replicated-1")}))
+	hits := client.GetMulti(ctx, []string{"key-1", "key-2"})
+	testutil.Equals(t, map[string][]byte{"key-1": []byte("replicated-1"), "key-2": []byte("value-2")}, hits)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.replicaMisses))
+	testutil.Equals(t, 1, primaryMock.getMultiCount)
+
+	// The next GetMulti() goes to the second replica, failing, so all keys are fetched from the primary servers.
+	replicaMocks[1].getMultiErrors = 1
+	hits = client.GetMulti(ctx, []string{"key-1", "key-2"})
+	testutil.Equals(t, map[string][]byte{"key-1": []byte("value-1"), "key-2": []byte("value-2")}, hits)
+	testutil.Equals(t, 3.0, prom_testutil.ToFloat64(client.replicaMisses))
+	testutil.Equals(t, 1, replicaMocks[1].getMultiCount)
+
+	// Then back to the first replica, which doesn't hit the primary servers if it has all the keys.
+	testutil.Ok(t, replicaMocks[0].Set(&memcache.Item{Key: "key-2", Value: []byte("replicated-2")}))
+	hits = client.GetMulti(ctx, []string{"key-1", "key-2"})
+	testutil.Equals(t, map[string][]byte{"key-1": []byte("replicated-1"), "key-2": []byte("replicated-2")}, hits)
+	testutil.Equals(t, 3.0, prom_testutil.ToFloat64(client.replicaMisses))
+	testutil.Equals(t, 2, primaryMock.getMultiCount)
+}
+
+func TestMemcachedClient_UnixSocket(t *testing.T) {
+	ctx := context.Background()
+	socketPath := filepath.Join(t.TempDir(), "memcached.sock")
+	unixServer := newGetsMemcachedServer(t, "unix", socketPath)
+	defer unixServer.Close()
+	tcpServer := newGetsMemcachedServer(t, "tcp", "127.0.0.1:0")
+	defer tcpServer.Close()
+
+	reg := prometheus.NewRegistry()
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"unix://" + socketPath, tcpServer.Addr().String()}
+	client, err := NewMemcachedClientWithConfig(log.NewNopLogger(), "test", config, reg)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	// Enough keys for both servers to be picked.
+	var keys []string
+	expected := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		expected[key] = []byte(key)
+	}
+	hits, err := client.GetMultiWithError(ctx, keys)
+	testutil.Ok(t, err)
+	testutil.Equals(t, expected, hits)
+
+	// The connections are tracked by transport.
+	mfs, err := reg.Gather()
+	testutil.Ok(t, err)
+	open := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "thanos_memcached_connections_open" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "transport" {
+					open[l.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	testutil.Equals(t, map[string]float64{"tcp": 1, "unix": 1}, open)
+}
+
+// newGetsMemcachedServer returns a fake memcached server listening on the given network and address,
+// replying to a gets command with values equal to the requested keys, and closing the connection right after.
+func newGetsMemcachedServer(t *testing.T, network, address string) net.Listener {
+	l, err := net.Listen(network, address)
+	testutil.Ok(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				fields := strings.Fields(line)
+				if len(fields) == 0 || fields[0] != "gets" {
+					return
+				}
+				for _, key := range fields[1:] {
+					if _, err := fmt.Fprintf(conn, "VALUE %s 0 %d 1\r\n%s\r\n", key, len(key), key); err != nil {
+						return
+					}
+				}
+				_, _ = fmt.Fprint(conn, "END\r\n")
+			}()
+		}
+	}()
+	return l
+}
+
 func TestMemcachedClient_sortKeysByServer(t *testing.T) {
 	config := defaultMemcachedClientConfig
 	config.Addresses = []string{"127.0.0.1:11211", "127.0.0.2:11211"}
@@ -423,7 +1369,7 @@ func TestMemcachedClient_sortKeysByServer(t *testing.T) {
 		},
 	}
 
-	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, selector, config, nil, "test")
+	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, backendMock, selector, config, nil, "test")
 	testutil.Ok(t, err)
 	defer client.Stop()
 
@@ -478,10 +1424,10 @@ func (m *mockServerSelector) SetServers(...string) error {
 	return nil
 }
 
-func prepare(config MemcachedClientConfig, backendMock *memcachedClientBackendMock) (*memcachedClient, error) {
+func prepare(config MemcachedClientConfig, backendMock memcachedClientBackend) (*memcachedClient, error) {
 	logger := log.NewNopLogger()
 	selector := &MemcachedJumpHashSelector{}
-	client, err := newMemcachedClient(logger, backendMock, selector, config, nil, "test")
+	client, err := newMemcachedClient(logger, backendMock, backendMock, selector, config, nil, "test")
 
 	return client, err
 }
@@ -491,6 +1437,10 @@ type memcachedClientBackendMock struct {
 	items          map[string]*memcache.Item
 	getMultiCount  int
 	getMultiErrors int
+	// getMultiErr is the error returned by the first getMultiErrors calls, if set.
+	getMultiErr error
+	// setErr is the error returned by Set, if set.
+	setErr error
 }
 
 func newMemcachedClientBackendMock() *memcachedClientBackendMock {
@@ -505,6 +1455,9 @@ func (c *memcachedClientBackendMock) GetMulti(keys []string) (map[string]*memcac
 
 	c.getMultiCount++
 	if c.getMultiCount <= c.getMultiErrors {
+		if c.getMultiErr != nil {
+			return nil, c.getMultiErr
+		}
 		return nil, errors.New("mocked GetMulti error")
 	}
 
@@ -522,11 +1475,38 @@ func (c *memcachedClientBackendMock) Set(item *memcache.Item) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	if c.setErr != nil {
+		return c.setErr
+	}
+	c.items[item.Key] = item
+
+	return nil
+}
+
+func (c *memcachedClientBackendMock) Add(item *memcache.Item) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.items[item.Key]; ok {
+		return memcache.ErrNotStored
+	}
 	c.items[item.Key] = item
 
 	return nil
 }
 
+func (c *memcachedClientBackendMock) Delete(key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.items[key]; !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(c.items, key)
+
+	return nil
+}
+
 func (c *memcachedClientBackendMock) waitItems(expected int) error {
 	deadline := time.Now().Add(1 * time.Second)
 
@@ -598,7 +1578,7 @@ func TestMemcachedClient_GetMulti_ContextCancelled(t *testing.T) {
 	selector := &MemcachedJumpHashSelector{}
 	backendMock := newMemcachedClientBlockingMock(backendCtx)
 
-	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, selector, config, prometheus.NewPedanticRegistry(), "test")
+	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, backendMock, selector, config, prometheus.NewPedanticRegistry(), "test")
 	testutil.Ok(t, err)
 	defer client.Stop()
 
@@ -612,6 +1592,39 @@ func TestMemcachedClient_GetMulti_ContextCancelled(t *testing.T) {
 	testutil.Equals(t, 0, len(items))
 }
 
+func TestMemcachedClient_AsyncQueueFullRatio(t *testing.T) {
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{"127.0.0.1:11211"}
+	config.MaxAsyncConcurrency = 1
+	config.MaxAsyncBufferSize = 4
+
+	backendCtx, backendCancel := context.WithCancel(context.Background())
+	backendMock := newMemcachedClientBlockingMock(backendCtx)
+
+	client, err := newMemcachedClient(log.NewNopLogger(), backendMock, backendMock, &MemcachedJumpHashSelector{}, config, prometheus.NewPedanticRegistry(), "test")
+	testutil.Ok(t, err)
+	defer client.Stop()
+	// Unblock the backend before stopping the client, so that the async workers can terminate.
+	defer backendCancel()
+
+	testutil.Equals(t, 0.0, client.AsyncQueueFullRatio())
+
+	// The only worker blocks on the first item, while the others stay enqueued.
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		testutil.Ok(t, client.SetAsync(ctx, fmt.Sprintf("key-%d", i), []byte("value"), time.Second))
+	}
+
+	retryCtx, retryCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer retryCancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if ratio := client.AsyncQueueFullRatio(); ratio != 0.5 {
+			return errors.Errorf("expected ratio 0.5, got %f", ratio)
+		}
+		return nil
+	}))
+}
+
 type memcachedClientBlockingMock struct {
 	ctx context.Context
 }
@@ -628,5 +1641,159 @@ func (c *memcachedClientBlockingMock) GetMulti([]string) (map[string]*memcache.I
 }
 
 func (c *memcachedClientBlockingMock) Set(*memcache.Item) error {
+	<-c.ctx.Done()
+	return nil
+}
+
+func (c *memcachedClientBlockingMock) Add(*memcache.Item) error {
+	<-c.ctx.Done()
+	return nil
+}
+
+func (c *memcachedClientBlockingMock) Delete(string) error {
 	return nil
 }
+
+func TestMemcachedClient_PerOperationTimeouts(t *testing.T) {
+	// The server replies slower than the get timeout, but faster than the set timeout.
+	server := newSlowMemcachedServer(t, 200*time.Millisecond)
+	defer server.Close()
+
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{server.Addr()}
+	config.Timeout = time.Second
+	config.GetTimeout = 50 * time.Millisecond
+
+	client, err := NewMemcachedClientWithConfig(log.NewNopLogger(), "test", config, prometheus.NewRegistry())
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	// A slow set should not be cut off by the get timeout.
+	ctx := context.Background()
+	testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Hour))
+	retryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if stored := histogramSampleCount(t, client.duration.WithLabelValues(opSet)); stored != 1 {
+			return errors.Errorf("expected 1 stored item, got %d", stored)
+		}
+		return nil
+	}))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(client.failures.WithLabelValues(opSet, reasonTimeout)))
+
+	// A slow get should be cut off by the get timeout instead.
+	_, err = client.GetMultiWithError(ctx, []string{"key-1"})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(client.failures.WithLabelValues(opGetMulti, reasonTimeout)))
+}
+
+func TestMemcachedClientConfig_Timeouts(t *testing.T) {
+	config := MemcachedClientConfig{Timeout: time.Second}
+	testutil.Equals(t, time.Second, config.getTimeout())
+	testutil.Equals(t, time.Second, config.setTimeout())
+
+	config.GetTimeout = 100 * time.Millisecond
+	config.SetTimeout = 2 * time.Second
+	testutil.Equals(t, 100*time.Millisecond, config.getTimeout())
+	testutil.Equals(t, 2*time.Second, config.setTimeout())
+}
+
+func histogramSampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	testutil.Ok(t, o.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+// slowMemcachedServer is a fake memcached server replying to set and gets commands
+// after a delay, as if it was overloaded. Sets always succeed while gets always miss.
+type slowMemcachedServer struct {
+	listener net.Listener
+	delay    time.Duration
+	done     chan struct{}
+
+	mtx   sync.Mutex
+	conns []net.Conn
+	wg    sync.WaitGroup
+}
+
+func newSlowMemcachedServer(t *testing.T, delay time.Duration) *slowMemcachedServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+
+	s := &slowMemcachedServer{listener: l, delay: delay, done: make(chan struct{})}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s
+}
+
+func (s *slowMemcachedServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *slowMemcachedServer) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mtx.Lock()
+		s.conns = append(s.conns, conn)
+		s.mtx.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *slowMemcachedServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch {
+		case strings.HasPrefix(line, "set "):
+			// Consume the value.
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			reply = "STORED\r\n"
+		case strings.HasPrefix(line, "gets "):
+			reply = "END\r\n"
+		default:
+			reply = "ERROR\r\n"
+		}
+
+		select {
+		case <-time.After(s.delay):
+		case <-s.done:
+			return
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *slowMemcachedServer) Close() {
+	close(s.done)
+	_ = s.listener.Close()
+
+	s.mtx.Lock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+}
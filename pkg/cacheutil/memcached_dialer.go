@@ -0,0 +1,456 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cacheutil
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	errMemcachedTextAuthFailed   = errors.New("memcached text protocol authentication failed")
+	errMemcachedReconnectBackoff = errors.New("memcached server unreachable, waiting for the reconnect backoff")
+)
+
+// instrumentedDialer dials the connections to memcached, tracking the number of open
+// connections, split between the ones in use and the idle ones, and the time spent dialing
+// them. The memcached client never waits for a connection to be released: when no idle
+// connection is available, it dials a new one, so the time spent waiting for a connection
+// is the time spent dialing it, or replacing it, if it's been idle for too long.
+// If credentials are set, each connection is authenticated with the text protocol once dialed.
+//
+// The connections pre-warmed by warmUp are handed out first, instead of dialing new
+// ones. If an idle timeout is set, the connections idle for longer are transparently
+// replaced by new ones when taken out of the pool, before the backend closes them.
+//
+// If a reconnect backoff is set, the dials to an address which failed to be dialed fail
+// fast until a jittered exponential backoff elapses, so that the clients of a restarting
+// server spread their reconnection attempts out instead of hammering it all at once.
+type instrumentedDialer struct {
+	username    string
+	password    string
+	idleTimeout time.Duration
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	now         func() time.Time
+
+	mtx      sync.Mutex
+	warm     map[string][]net.Conn
+	backoffs map[string]*reconnectBackoff
+	// openConns and inUseConns are the number of open and in use connections, by transport.
+	openConns  map[string]int
+	inUseConns map[string]int
+
+	// Metrics, by transport, that is the network of the dialed address.
+	open       *prometheus.GaugeVec
+	inUse      *prometheus.GaugeVec
+	idle       *prometheus.GaugeVec
+	wait       *prometheus.HistogramVec
+	failures   *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	reaped     *prometheus.CounterVec
+	reconnects *prometheus.CounterVec
+}
+
+// reconnectBackoff is the state of the reconnection to an address which failed to be dialed.
+type reconnectBackoff struct {
+	failures int
+	until    time.Time
+}
+
+func newInstrumentedDialer(reg prometheus.Registerer, username, password string, idleTimeout, backoffBase, backoffMax time.Duration) *instrumentedDialer {
+	d := &instrumentedDialer{
+		username:    username,
+		password:    password,
+		idleTimeout: idleTimeout,
+		backoffBase: backoffBase,
+		backoffMax:  backoffMax,
+		warm:        map[string][]net.Conn{},
+		backoffs:    map[string]*reconnectBackoff{},
+		openConns:   map[string]int{},
+		inUseConns:  map[string]int{},
+		now:         time.Now,
+		open: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_memcached_connections_open",
+			Help: "Number of connections to memcached currently open, either in use or idle in the pool.",
+		}, []string{"transport"}),
+		inUse: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_cache_memcached_connections_in_use",
+			Help: "Number of connections to memcached currently held by in-flight operations.",
+		}, []string{"transport"}),
+		idle: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_cache_memcached_connections_idle",
+			Help: "Number of connections to memcached currently open and idle in the pool.",
+		}, []string{"transport"}),
+		wait: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "thanos_cache_memcached_get_wait_duration_seconds",
+			Help:    "Time spent waiting for a connection to memcached to be available, that is taken out of the pool, or dialed if none is idle.",
+			Buckets: []float64{0.0001, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.2, 0.5, 1},
+		}, []string{"transport"}),
+		failures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_memcached_connection_dial_failures_total",
+			Help: "Total number of connections to memcached that failed to be dialed.",
+		}, []string{"transport"}),
+		duration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "thanos_memcached_connection_dial_duration_seconds",
+			Help:    "Time spent dialing a new connection to memcached because no idle connection was available in the pool.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.2, 0.5, 1},
+		}, []string{"transport"}),
+		reaped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_memcached_connections_idle_reaped_total",
+			Help: "Total number of connections to memcached closed and replaced by new ones because they were idle for longer than the idle timeout.",
+		}, []string{"transport"}),
+		reconnects: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_memcached_reconnects_total",
+			Help: "Total number of connections to memcached dialed successfully after the previous dials to the same server failed.",
+		}, []string{"transport"}),
+	}
+	for _, transport := range []string{"tcp", "unix"} {
+		d.open.WithLabelValues(transport)
+		d.inUse.WithLabelValues(transport)
+		d.idle.WithLabelValues(transport)
+		d.wait.WithLabelValues(transport)
+		d.failures.WithLabelValues(transport)
+		d.duration.WithLabelValues(transport)
+		d.reaped.WithLabelValues(transport)
+		d.reconnects.WithLabelValues(transport)
+	}
+	return d
+}
+
+// DialTimeout hands out a pre-warmed connection, if any, or dials a new connection,
+// and tracks it until closed. It's called by the memcached client when no idle connection
+// is available in the pool.
+func (d *instrumentedDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	start := time.Now()
+	defer func() { d.wait.WithLabelValues(network).Observe(time.Since(start).Seconds()) }()
+
+	if conn := d.takeWarm(address); conn != nil {
+		conn.(*instrumentedConn).taken = true
+		return conn, nil
+	}
+	conn, err := d.dialInstrumented(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.(*instrumentedConn).taken = true
+	return conn, nil
+}
+
+// dialInstrumented dials a new connection tracked until closed, unless the address is in
+// reconnect backoff.
+func (d *instrumentedDialer) dialInstrumented(network, address string, timeout time.Duration) (net.Conn, error) {
+	if err := d.checkBackoff(address); err != nil {
+		return nil, err
+	}
+	conn, err := d.dial(network, address, timeout)
+	d.updateBackoff(network, address, err)
+	if err != nil {
+		return nil, err
+	}
+
+	d.updateConns(network, 1, 0)
+	return &instrumentedConn{
+		Conn:     conn,
+		dialer:   d,
+		network:  network,
+		address:  address,
+		timeout:  timeout,
+		lastUsed: time.Now(),
+	}, nil
+}
+
+// updateConns adds the deltas to the number of open and in use connections of the transport. The idle
+// connections are the open ones which aren't in use. The connections are accounted as in use for the
+// whole duration of the operations, including while they're taken out of the pool or dialed, so the
+// number of idle connections is approximate, and never lower than zero.
+func (d *instrumentedDialer) updateConns(network string, openDelta, inUseDelta int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.openConns[network] += openDelta
+	d.inUseConns[network] += inUseDelta
+	idle := d.openConns[network] - d.inUseConns[network]
+	if idle < 0 {
+		idle = 0
+	}
+
+	d.open.WithLabelValues(network).Set(float64(d.openConns[network]))
+	d.inUse.WithLabelValues(network).Set(float64(d.inUseConns[network]))
+	d.idle.WithLabelValues(network).Set(float64(idle))
+}
+
+// dial dials and authenticates a new connection, without tracking it.
+func (d *instrumentedDialer) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err == nil && d.username != "" {
+		if err = authenticateTextProtocol(conn, d.username, d.password, timeout); err != nil {
+			_ = conn.Close()
+		}
+	}
+	d.duration.WithLabelValues(network).Observe(time.Since(start).Seconds())
+	if err != nil {
+		d.failures.WithLabelValues(network).Inc()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// checkBackoff returns an error if the address failed to be dialed and its reconnect backoff
+// hasn't elapsed yet.
+func (d *instrumentedDialer) checkBackoff(address string) error {
+	if d.backoffBase <= 0 {
+		return nil
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if b, ok := d.backoffs[address]; ok && d.now().Before(b.until) {
+		return errors.Wrapf(errMemcachedReconnectBackoff, "server %s", address)
+	}
+	return nil
+}
+
+// updateBackoff backs off the reconnection to the address if the dial failed, for longer with each
+// consecutive failure, or resets its backoff otherwise, counting the reconnection.
+func (d *instrumentedDialer) updateBackoff(network, address string, dialErr error) {
+	if d.backoffBase <= 0 {
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	b, ok := d.backoffs[address]
+	if dialErr == nil {
+		if ok {
+			delete(d.backoffs, address)
+			d.reconnects.WithLabelValues(network).Inc()
+		}
+		return
+	}
+	if !ok {
+		b = &reconnectBackoff{}
+		d.backoffs[address] = b
+	}
+	b.failures++
+	b.until = d.now().Add(jitteredBackoff(d.backoffBase, d.backoffMax, b.failures))
+}
+
+// jitteredBackoff returns the backoff after the given number of consecutive failures, doubling
+// from base with each failure up to maxBackoff, and randomly jittered between its half and itself.
+func jitteredBackoff(base, maxBackoff time.Duration, failures int) time.Duration {
+	backoff := base
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// warmUp dials up to n connections to the address ahead of time, handed out by DialTimeout
+// then, so that the first requests don't pay for dialing them. It stops at the first failure.
+func (d *instrumentedDialer) warmUp(network, address string, n int, timeout time.Duration) error {
+	for i := 0; i < n; i++ {
+		conn, err := d.dialInstrumented(network, address, timeout)
+		if err != nil {
+			return err
+		}
+
+		d.mtx.Lock()
+		d.warm[address] = append(d.warm[address], conn)
+		d.mtx.Unlock()
+	}
+	return nil
+}
+
+// takeWarm returns a pre-warmed connection to the address, or nil if there's none left.
+func (d *instrumentedDialer) takeWarm(address string) net.Conn {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	conns := d.warm[address]
+	if len(conns) == 0 {
+		return nil
+	}
+	conn := conns[len(conns)-1]
+	d.warm[address] = conns[:len(conns)-1]
+	return conn
+}
+
+// closeWarm closes the pre-warmed connections which haven't been handed out.
+func (d *instrumentedDialer) closeWarm() {
+	d.mtx.Lock()
+	warm := d.warm
+	d.warm = map[string][]net.Conn{}
+	d.mtx.Unlock()
+
+	// The connections are closed without holding the lock, given closing them takes it.
+	for _, conns := range warm {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}
+}
+
+// instrumentedConn is a net.Conn decrementing the open connections once closed.
+// It's used by a single request at a time, as any connection of the memcached client.
+type instrumentedConn struct {
+	net.Conn
+
+	dialer   *instrumentedDialer
+	network  string
+	address  string
+	timeout  time.Duration
+	lastUsed time.Time
+	// taken is whether the connection has just been handed out by DialTimeout, where the wait
+	// for it has already been observed.
+	taken bool
+
+	closeOnce sync.Once
+}
+
+func (c *instrumentedConn) Read(b []byte) (int, error) {
+	c.lastUsed = time.Now()
+	return c.Conn.Read(b)
+}
+
+func (c *instrumentedConn) Write(b []byte) (int, error) {
+	c.lastUsed = time.Now()
+	return c.Conn.Write(b)
+}
+
+// SetDeadline is called by the memcached client each time the connection is taken out of
+// the pool, before it's used, which is when the connection is replaced if idle for too long.
+func (c *instrumentedConn) SetDeadline(t time.Time) error {
+	if c.taken {
+		// It's called right after the connection has been handed out by DialTimeout too.
+		c.taken = false
+		return c.Conn.SetDeadline(t)
+	}
+
+	start := time.Now()
+	if c.dialer.idleTimeout > 0 && time.Since(c.lastUsed) > c.dialer.idleTimeout {
+		c.replace()
+	}
+	c.dialer.wait.WithLabelValues(c.network).Observe(time.Since(start).Seconds())
+	return c.Conn.SetDeadline(t)
+}
+
+// replace closes the underlying connection and replaces it by a new one. It's safe given
+// no request is in flight on the connection while it's in the pool. If the new connection
+// can't be dialed, the idle one is kept, in case it's still usable.
+func (c *instrumentedConn) replace() {
+	conn, err := c.dialer.dial(c.network, c.address, c.timeout)
+	if err != nil {
+		return
+	}
+	_ = c.Conn.Close()
+	c.Conn = conn
+	c.lastUsed = time.Now()
+	c.dialer.reaped.WithLabelValues(c.network).Inc()
+}
+
+func (c *instrumentedConn) Close() error {
+	c.closeOnce.Do(func() { c.dialer.updateConns(c.network, -1, 0) })
+	return c.Conn.Close()
+}
+
+// pooledBackend is a memcachedClientBackend accounting the connections in use by its operations,
+// given each of them holds a connection to each of the servers of its keys until it returns.
+type pooledBackend struct {
+	memcachedClientBackend
+
+	selector memcache.ServerSelector
+	dialer   *instrumentedDialer
+}
+
+func newPooledBackend(backend memcachedClientBackend, selector memcache.ServerSelector, dialer *instrumentedDialer) *pooledBackend {
+	return &pooledBackend{memcachedClientBackend: backend, selector: selector, dialer: dialer}
+}
+
+func (b *pooledBackend) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	defer b.use(keys...)()
+	return b.memcachedClientBackend.GetMulti(keys)
+}
+
+func (b *pooledBackend) Set(item *memcache.Item) error {
+	defer b.use(item.Key)()
+	return b.memcachedClientBackend.Set(item)
+}
+
+func (b *pooledBackend) Add(item *memcache.Item) error {
+	defer b.use(item.Key)()
+	return b.memcachedClientBackend.Add(item)
+}
+
+func (b *pooledBackend) Delete(key string) error {
+	defer b.use(key)()
+	return b.memcachedClientBackend.Delete(key)
+}
+
+// use accounts a connection in use to each of the servers of the keys, returning the function
+// releasing them once the operation is done.
+func (b *pooledBackend) use(keys ...string) func() {
+	servers := make(map[string]string, 1)
+	for _, key := range keys {
+		if addr, err := b.selector.PickServer(key); err == nil {
+			servers[addr.String()] = addr.Network()
+		}
+	}
+	for _, network := range servers {
+		b.dialer.updateConns(network, 0, 1)
+	}
+	return func() {
+		for _, network := range servers {
+			b.dialer.updateConns(network, 0, -1)
+		}
+	}
+}
+
+// authenticateTextProtocol authenticates the connection using the memcached text protocol
+// authentication, supported by servers started with an authentication file (-Y), given
+// the client doesn't speak the binary protocol required by SASL.
+func authenticateTextProtocol(conn net.Conn, username, password string, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	credentials := username + " " + password
+	if _, err := fmt.Fprintf(conn, "set auth 0 0 %d\r\n%s\r\n", len(credentials), credentials); err != nil {
+		return err
+	}
+
+	// Read the reply byte by byte, so that nothing past it is consumed from the connection.
+	var reply []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(reply, []byte("\r\n")) {
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+		reply = append(reply, b[0])
+	}
+
+	if reply := string(bytes.TrimSpace(reply)); reply != "STORED" {
+		return errors.Wrapf(errMemcachedTextAuthFailed, "server %s replied %q", conn.RemoteAddr(), reply)
+	}
+	return nil
+}
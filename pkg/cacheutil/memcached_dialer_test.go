@@ -0,0 +1,334 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cacheutil
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestInstrumentedDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	defer l.Close()
+
+	dialer := newInstrumentedDialer(prometheus.NewRegistry(), "", "", 0, 0, 0)
+
+	conn, err := dialer.DialTimeout("tcp", l.Addr().String(), time.Second)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+	testutil.Equals(t, 1, prom_testutil.CollectAndCount(dialer.duration.WithLabelValues("tcp").(prometheus.Histogram)))
+
+	// Closing a connection multiple times should decrement the gauge once.
+	testutil.Ok(t, conn.Close())
+	testutil.NotOk(t, conn.Close())
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+
+	// Failed dials should be tracked too.
+	testutil.Ok(t, l.Close())
+	_, err = dialer.DialTimeout("tcp", l.Addr().String(), time.Second)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.failures.WithLabelValues("tcp")))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.failures.WithLabelValues("unix")))
+}
+
+func TestInstrumentedDialer_ConnectionsInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	defer l.Close()
+
+	dialer := newInstrumentedDialer(prometheus.NewRegistry(), "", "", 0, 0, 0)
+	conns := make([]net.Conn, 0, 2)
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.DialTimeout("tcp", l.Addr().String(), time.Second)
+		testutil.Ok(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+
+		// The wait for the connection is observed once, when dialed, then each time it's taken out of the pool.
+		testutil.Ok(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		testutil.Ok(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	}
+	testutil.Equals(t, 4, int(histogramSampleCount(t, dialer.wait.WithLabelValues("tcp"))))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.inUse.WithLabelValues("tcp")))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(dialer.idle.WithLabelValues("tcp")))
+
+	// The operations hold a connection to each of the servers of their keys while in flight.
+	selector := &MemcachedJumpHashSelector{}
+	testutil.Ok(t, selector.SetServers("127.0.0.1:11211", "127.0.0.1:11212"))
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	backend := newPooledBackend(&inUseObservingBackend{observe: func() {
+		testutil.Equals(t, 2.0, prom_testutil.ToFloat64(dialer.inUse.WithLabelValues("tcp")))
+		testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.idle.WithLabelValues("tcp")))
+	}}, selector, dialer)
+	_, err = backend.GetMulti(keys)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.inUse.WithLabelValues("tcp")))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(dialer.idle.WithLabelValues("tcp")))
+
+	// The closed connections aren't idle anymore.
+	testutil.Ok(t, conns[0].Close())
+	backend = newPooledBackend(&inUseObservingBackend{observe: func() {
+		testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.inUse.WithLabelValues("tcp")))
+		testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.idle.WithLabelValues("tcp")))
+	}}, selector, dialer)
+	testutil.Ok(t, backend.Set(&memcache.Item{Key: keys[0]}))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.idle.WithLabelValues("tcp")))
+}
+
+// inUseObservingBackend is a memcachedClientBackend calling observe during each of its operations.
+type inUseObservingBackend struct {
+	observe func()
+}
+
+func (b *inUseObservingBackend) GetMulti([]string) (map[string]*memcache.Item, error) {
+	b.observe()
+	return nil, nil
+}
+
+func (b *inUseObservingBackend) Set(*memcache.Item) error {
+	b.observe()
+	return nil
+}
+
+func (b *inUseObservingBackend) Add(*memcache.Item) error {
+	b.observe()
+	return nil
+}
+
+func (b *inUseObservingBackend) Delete(string) error {
+	b.observe()
+	return nil
+}
+
+func TestInstrumentedDialer_IdleTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	defer l.Close()
+
+	dialer := newInstrumentedDialer(prometheus.NewRegistry(), "", "", 50*time.Millisecond, 0, 0)
+	conn, err := dialer.DialTimeout("tcp", l.Addr().String(), time.Second)
+	testutil.Ok(t, err)
+	defer conn.Close()
+	underlying := conn.(*instrumentedConn).Conn
+
+	// A connection used recently is kept.
+	testutil.Ok(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	testutil.Equals(t, underlying, conn.(*instrumentedConn).Conn)
+
+	// A connection idle for longer than the timeout is replaced once taken out of the pool.
+	time.Sleep(100 * time.Millisecond)
+	testutil.Ok(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	testutil.Assert(t, underlying != conn.(*instrumentedConn).Conn)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.reaped.WithLabelValues("tcp")))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+
+	// The idle connection is kept if it can't be replaced.
+	underlying = conn.(*instrumentedConn).Conn
+	time.Sleep(100 * time.Millisecond)
+	testutil.Ok(t, l.Close())
+	testutil.Ok(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	testutil.Equals(t, underlying, conn.(*instrumentedConn).Conn)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.reaped.WithLabelValues("tcp")))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.failures.WithLabelValues("tcp")))
+}
+
+func TestInstrumentedDialer_WarmUp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	defer l.Close()
+
+	dialer := newInstrumentedDialer(prometheus.NewRegistry(), "", "", 0, 0, 0)
+	testutil.Ok(t, dialer.warmUp("tcp", l.Addr().String(), 3, time.Second))
+	testutil.Equals(t, 3.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+	warm := append([]net.Conn{}, dialer.warm[l.Addr().String()]...)
+
+	// The pre-warmed connections are handed out before new ones are dialed.
+	for i := 0; i < 4; i++ {
+		conn, err := dialer.DialTimeout("tcp", l.Addr().String(), time.Second)
+		testutil.Ok(t, err)
+		defer conn.Close()
+
+		if i < len(warm) {
+			testutil.Equals(t, warm[len(warm)-1-i], conn)
+		}
+	}
+	testutil.Equals(t, 4.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+
+	// The pre-warmed connections not handed out are closed.
+	testutil.Ok(t, dialer.warmUp("tcp", l.Addr().String(), 2, time.Second))
+	testutil.Equals(t, 6.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+	dialer.closeWarm()
+	testutil.Equals(t, 4.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+
+	// The warm up stops at the first failure.
+	testutil.Ok(t, l.Close())
+	testutil.NotOk(t, dialer.warmUp("tcp", l.Addr().String(), 2, time.Second))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.failures.WithLabelValues("tcp")))
+}
+
+func TestInstrumentedDialer_ReconnectBackoff(t *testing.T) {
+	address := filepath.Join(t.TempDir(), "memcached.sock")
+	now := time.Unix(1000, 0)
+
+	dialer := newInstrumentedDialer(prometheus.NewRegistry(), "", "", 0, time.Second, 4*time.Second)
+	dialer.now = func() time.Time { return now }
+
+	// The dials fail fast, without dialing, until the backoff after the failed dial elapses.
+	_, err := dialer.DialTimeout("unix", address, time.Second)
+	testutil.NotOk(t, err)
+	_, err = dialer.DialTimeout("unix", address, time.Second)
+	testutil.Equals(t, errMemcachedReconnectBackoff, errors.Cause(err))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.failures.WithLabelValues("unix")))
+
+	// The dials are attempted again once it elapses, backing off for longer on failure.
+	now = now.Add(time.Second)
+	_, err = dialer.DialTimeout("unix", address, time.Second)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(dialer.failures.WithLabelValues("unix")))
+	now = now.Add(time.Second - time.Nanosecond)
+	_, err = dialer.DialTimeout("unix", address, time.Second)
+	testutil.Equals(t, errMemcachedReconnectBackoff, errors.Cause(err))
+
+	// The reconnection resets the backoff.
+	l, err := net.Listen("unix", address)
+	testutil.Ok(t, err)
+	defer l.Close()
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.DialTimeout("unix", address, time.Second)
+		testutil.Ok(t, err)
+		testutil.Ok(t, conn.Close())
+	}
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.reconnects.WithLabelValues("unix")))
+	testutil.Equals(t, 0, len(dialer.backoffs))
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 1, expected: time.Second},
+		{failures: 2, expected: 2 * time.Second},
+		{failures: 3, expected: 4 * time.Second},
+		{failures: 10, expected: 5 * time.Second},
+	} {
+		for i := 0; i < 100; i++ {
+			backoff := jitteredBackoff(time.Second, 5*time.Second, tc.failures)
+			testutil.Assert(t, backoff >= tc.expected/2 && backoff <= tc.expected, "backoff %v after %d failures", backoff, tc.failures)
+		}
+	}
+}
+
+func TestInstrumentedDialer_Authentication(t *testing.T) {
+	server := newAuthMemcachedServer(t, "user", "pass")
+	defer server.Close()
+
+	t.Run("should authenticate with valid credentials", func(t *testing.T) {
+		dialer := newInstrumentedDialer(prometheus.NewRegistry(), "user", "pass", 0, 0, 0)
+		conn, err := dialer.DialTimeout("tcp", server.Addr().String(), time.Second)
+		testutil.Ok(t, err)
+		testutil.Ok(t, conn.Close())
+	})
+
+	t.Run("should fail with invalid credentials", func(t *testing.T) {
+		dialer := newInstrumentedDialer(prometheus.NewRegistry(), "user", "wrong", 0, 0, 0)
+		_, err := dialer.DialTimeout("tcp", server.Addr().String(), time.Second)
+		testutil.Assert(t, errors.Is(err, errMemcachedTextAuthFailed), "unexpected error %v", err)
+		testutil.Equals(t, 0.0, prom_testutil.ToFloat64(dialer.open.WithLabelValues("tcp")))
+		testutil.Equals(t, 1.0, prom_testutil.ToFloat64(dialer.failures.WithLabelValues("tcp")))
+	})
+}
+
+func TestNewMemcachedClient_Authentication(t *testing.T) {
+	server := newAuthMemcachedServer(t, "user", "pass")
+	defer server.Close()
+
+	config := defaultMemcachedClientConfig
+	config.Addresses = []string{server.Addr().String()}
+	config.TextAuthUsername = "user"
+
+	config.TextAuthPassword = "pass"
+	client, err := NewMemcachedClientWithConfig(log.NewNopLogger(), "test", config, nil)
+	testutil.Ok(t, err)
+	client.Stop()
+
+	config.TextAuthPassword = "wrong"
+	_, err = NewMemcachedClientWithConfig(log.NewNopLogger(), "test", config, nil)
+	testutil.Assert(t, errors.Is(err, errMemcachedTextAuthFailed), "unexpected error %v", err)
+
+	// An unreachable memcached should not prevent the client from being created.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	testutil.Ok(t, l.Close())
+	config.Addresses = []string{l.Addr().String()}
+	client, err = NewMemcachedClientWithConfig(log.NewNopLogger(), "test", config, nil)
+	testutil.Ok(t, err)
+	client.Stop()
+}
+
+func TestMemcachedClientConfig_TextAuthPasswordShouldBeRedacted(t *testing.T) {
+	config := defaultMemcachedClientConfig
+	config.TextAuthPassword = "pass"
+
+	out, err := yaml.Marshal(config)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !strings.Contains(string(out), "pass\n"), "password not redacted in %s", out)
+	testutil.Assert(t, strings.Contains(string(out), "text_auth_password: <secret>"), "password not redacted in %s", out)
+}
+
+// newAuthMemcachedServer returns a fake memcached server accepting the text protocol
+// authentication with the given credentials, and closing the connection right after.
+func newAuthMemcachedServer(t *testing.T, username, password string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				if _, err := r.ReadString('\n'); err != nil {
+					return
+				}
+				credentials, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if strings.TrimSpace(credentials) == username+" "+password {
+					_, _ = conn.Write([]byte("STORED\r\n"))
+				} else {
+					_, _ = conn.Write([]byte("CLIENT_ERROR authentication failure\r\n"))
+				}
+			}()
+		}
+	}()
+	return l
+}
@@ -4,7 +4,11 @@
 package cacheutil
 
 import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
 	"net"
+	"sort"
 	"sync"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -102,3 +106,117 @@ func (s *MemcachedJumpHashSelector) PickServer(key string) (net.Addr, error) {
 func (s *MemcachedJumpHashSelector) Each(f func(net.Addr) error) error {
 	return s.servers.Each(f)
 }
+
+const (
+	// ketamaPointsPerServer is the number of points each server is given on the ring,
+	// derived from 40 MD5 hashes of 4 points each, as in libmemcached.
+	ketamaPointsPerServer = 160
+	ketamaHashesPerServer = ketamaPointsPerServer / 4
+)
+
+// ketamaPoint is a point of the ring, owned by the server at the given index.
+type ketamaPoint struct {
+	hash   uint32
+	server int
+}
+
+// MemcachedKetamaSelector implements the memcache.ServerSelector
+// interface, utilizing a ketama consistent hashing ring to distribute
+// keys to servers.
+//
+// Unlike MemcachedJumpHashSelector, any server can be added or removed
+// while only requiring ~1/N keys to move, so it works best for servers
+// whose membership changes arbitrarily (ie. autoscaled fleets).
+type MemcachedKetamaSelector struct {
+	mtx    sync.RWMutex
+	addrs  []net.Addr
+	points []ketamaPoint
+
+	// To avoid copy and pasting all memcache server list logic,
+	// we embed it and implement our features on top of it.
+	servers memcache.ServerList
+}
+
+// SetServers changes a MemcachedKetamaSelector's set of servers at
+// runtime and is safe for concurrent use by multiple goroutines.
+//
+// Each server is given equal weight. Servers are placed onto the
+// ring based on their name, so the order they are listed in doesn't
+// affect the keys distribution.
+//
+// SetServers returns an error if any of the server names fail to
+// resolve. No attempt is made to connect to the server. If any
+// error occurs, no changes are made to the internal server list.
+func (s *MemcachedKetamaSelector) SetServers(servers ...string) error {
+	sortedServers := make([]string, len(servers))
+	copy(sortedServers, servers)
+	natsort.Sort(sortedServers)
+
+	if err := s.servers.SetServers(sortedServers...); err != nil {
+		return err
+	}
+
+	// The server list keeps the addresses in the same order as the servers.
+	addrs := make([]net.Addr, 0, len(sortedServers))
+	_ = s.servers.Each(func(addr net.Addr) error {
+		addrs = append(addrs, addr)
+		return nil
+	})
+
+	points := make([]ketamaPoint, 0, len(sortedServers)*ketamaPointsPerServer)
+	for idx, server := range sortedServers {
+		for i := 0; i < ketamaHashesPerServer; i++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", server, i)))
+			for p := 0; p < 4; p++ {
+				points = append(points, ketamaPoint{
+					hash:   binary.LittleEndian.Uint32(digest[p*4:]),
+					server: idx,
+				})
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].server < points[j].server
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.addrs = addrs
+	s.points = points
+	return nil
+}
+
+// PickServer returns the server address that a given item
+// should be shared onto.
+func (s *MemcachedKetamaSelector) PickServer(key string) (net.Addr, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	// No need of a ring lookup in case of 0 or 1 servers.
+	if len(s.addrs) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+	if len(s.addrs) == 1 {
+		return s.addrs[0], nil
+	}
+
+	// Pick the first point clockwise from the key hash, wrapping around the ring.
+	digest := md5.Sum([]byte(key))
+	hash := binary.LittleEndian.Uint32(digest[:4])
+	idx := sort.Search(len(s.points), func(i int) bool { return s.points[i].hash >= hash })
+	if idx == len(s.points) {
+		idx = 0
+	}
+	return s.addrs[s.points[idx].server], nil
+}
+
+// Each iterates over each server and calls the given function.
+// If f returns a non-nil error, iteration will stop and that
+// error will be returned.
+func (s *MemcachedKetamaSelector) Each(f func(net.Addr) error) error {
+	return s.servers.Each(f)
+}
@@ -201,6 +201,122 @@ func TestMemcachedJumpHashSelector_PickServer_ShouldReturnErrNoServersOnNoServer
 	testutil.Equals(t, memcache.ErrNoServers, err)
 }
 
+func TestMemcachedKetamaSelector_PickServer(t *testing.T) {
+	s := MemcachedKetamaSelector{}
+
+	_, err := s.PickServer("test-1")
+	testutil.Equals(t, memcache.ErrNoServers, err)
+
+	testutil.Ok(t, s.SetServers("127.0.0.1:11211"))
+	addr, err := s.PickServer("test-1")
+	testutil.Ok(t, err)
+	testutil.Equals(t, "127.0.0.1:11211", addr.String())
+
+	// The same key should always be picked onto the same server, whatever the servers order.
+	testutil.Ok(t, s.SetServers("127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.3:11211"))
+	expected := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		addr, err := s.PickServer(key)
+		testutil.Ok(t, err)
+		expected[key] = addr.String()
+	}
+
+	testutil.Ok(t, s.SetServers("127.0.0.3:11211", "127.0.0.1:11211", "127.0.0.2:11211"))
+	for key, expectedAddr := range expected {
+		addr, err := s.PickServer(key)
+		testutil.Ok(t, err)
+		testutil.Equals(t, expectedAddr, addr.String())
+	}
+}
+
+func TestMemcachedKetamaSelector_PickServer_ShouldEvenlyDistributeKeysToServers(t *testing.T) {
+	servers := []string{"127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.3:11211"}
+	selector := MemcachedKetamaSelector{}
+	testutil.Ok(t, selector.SetServers(servers...))
+
+	// Calculate the distribution of keys.
+	distribution := make(map[string]int)
+	numKeys := 1000
+
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		addr, err := selector.PickServer(key)
+		testutil.Ok(t, err)
+		distribution[addr.String()]++
+	}
+
+	// Expect each server got at least 25% of keys, where the perfect split would be 33.3% each.
+	minKeysPerServer := int(float64(numKeys) * 0.25)
+	testutil.Equals(t, len(servers), len(distribution))
+
+	for addr, count := range distribution {
+		if count < minKeysPerServer {
+			testutil.Ok(t, errors.Errorf("expected %s to have received at least %d keys instead it received %d", addr, minKeysPerServer, count))
+		}
+	}
+}
+
+func TestMemcachedKetamaSelector_PickServer_ShouldUseConsistentHashing(t *testing.T) {
+	servers := []string{
+		"127.0.0.1:11211",
+		"127.0.0.2:11211",
+		"127.0.0.3:11211",
+		"127.0.0.4:11211",
+		"127.0.0.5:11211",
+		"127.0.0.6:11211",
+		"127.0.0.7:11211",
+		"127.0.0.8:11211",
+		"127.0.0.9:11211",
+	}
+
+	for name, updated := range map[string][]string{
+		// Unlike the jump hash, the added server doesn't need to be the last one.
+		"server added in the middle": append([]string{"127.0.0.10:11211"}, servers...),
+		"server added at the end":    append(append([]string{}, servers...), "127.0.0.10:11211"),
+	} {
+		updated := updated
+		t.Run(name, func(t *testing.T) {
+			selector := MemcachedKetamaSelector{}
+			testutil.Ok(t, selector.SetServers(servers...))
+
+			// Pick a server for each key.
+			distribution := make(map[string]string)
+			numKeys := 10000
+
+			for i := 0; i < numKeys; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				addr, err := selector.PickServer(key)
+				testutil.Ok(t, err)
+				distribution[key] = addr.String()
+			}
+
+			testutil.Ok(t, selector.SetServers(updated...))
+
+			// Calculate the number of keys who has been moved due to the resharding,
+			// all of them expected to be moved to the added server.
+			moved := 0
+
+			for i := 0; i < numKeys; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				addr, err := selector.PickServer(key)
+				testutil.Ok(t, err)
+
+				if distribution[key] != addr.String() {
+					testutil.Equals(t, "127.0.0.10:11211", addr.String())
+					moved++
+				}
+			}
+
+			// Expect we haven't moved more than (1/shards)% of the keys.
+			maxExpectedMoved := numKeys / len(updated)
+			if moved > maxExpectedMoved {
+				testutil.Ok(t, errors.Errorf("expected resharding moved no more then %d keys while %d have been moved", maxExpectedMoved, moved))
+			}
+		})
+	}
+}
+
 func BenchmarkMemcachedJumpHashSelector_PickServer(b *testing.B) {
 	// Create a pretty long list of servers.
 	servers := make([]string, 0)
@@ -223,3 +339,26 @@ func BenchmarkMemcachedJumpHashSelector_PickServer(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkMemcachedKetamaSelector_PickServer(b *testing.B) {
+	// Create a pretty long list of servers.
+	servers := make([]string, 0)
+	for i := 1; i <= 60; i++ {
+		servers = append(servers, fmt.Sprintf("127.0.0.%d:11211", i))
+	}
+
+	selector := MemcachedKetamaSelector{}
+	err := selector.SetServers(servers...)
+	if err != nil {
+		b.Error(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := selector.PickServer(fmt.Sprint(i))
+		if err != nil {
+			b.Error(err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"net"
 	"strings"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -38,6 +39,8 @@ var (
 		GetMultiBatchSize:      100,
 		MaxSetMultiConcurrency: 100,
 		SetMultiBatchSize:      100,
+		MaxAsyncConcurrency:    20,
+		MaxAsyncBufferSize:     10000,
 		TLSEnabled:             false,
 		TLSConfig:              TLSConfig{},
 	}
@@ -114,6 +117,18 @@ type RedisClientConfig struct {
 	// SetMultiBatchSize specifies the maximum size per batch for pipeline set.
 	SetMultiBatchSize int `yaml:"set_multi_batch_size"`
 
+	// MaxAsyncConcurrency specifies the maximum number of SetAsync goroutines.
+	MaxAsyncConcurrency int `yaml:"max_async_concurrency"`
+
+	// MaxAsyncBufferSize specifies the queue buffer size for SetAsync operations.
+	// Queued items are pipelined to redis in batches of up to SetMultiBatchSize items.
+	MaxAsyncBufferSize int `yaml:"max_async_buffer_size"`
+
+	// MaxItemSize specifies the maximum size of an item stored in redis.
+	// Items bigger than MaxItemSize are skipped.
+	// If set to 0, no maximum size is enforced.
+	MaxItemSize model.Bytes `yaml:"max_item_size"`
+
 	// TLSEnabled enable tls for redis connection.
 	TLSEnabled bool `yaml:"tls_enabled"`
 
@@ -136,6 +151,11 @@ func (c *RedisClientConfig) validate() error {
 		return errors.New("no redis addr provided")
 	}
 
+	// Set async only available when MaxAsyncConcurrency > 0.
+	if c.MaxAsyncConcurrency <= 0 {
+		return errors.New("max async concurrency must be positive")
+	}
+
 	if c.TLSEnabled {
 		if (c.TLSConfig.CertFile != "") != (c.TLSConfig.KeyFile != "") {
 			return errors.New("both client key and certificate must be provided")
@@ -145,6 +165,18 @@ func (c *RedisClientConfig) validate() error {
 	return nil
 }
 
+// redisAsyncItem is an item enqueued to be asynchronously stored to redis.
+type redisAsyncItem struct {
+	key   string
+	value []byte
+	ttl   time.Duration
+
+	// add is whether the item is only stored if the key doesn't exist yet, in which
+	// case exists is called, if not nil.
+	add    bool
+	exists func()
+}
+
 type RedisClient struct {
 	client rueidis.Client
 
@@ -155,10 +187,22 @@ type RedisClient struct {
 	// setMultiGate used to enforce the max number of concurrent SetMulti() operations.
 	setMultiGate gate.Gate
 
+	// Channel used to enqueue async set operations.
+	asyncQueue chan redisAsyncItem
+
+	// Channel used to notify workers to stop.
+	stop chan struct{}
+	// Wait group used to wait all workers on stopping.
+	workers sync.WaitGroup
+
 	logger           log.Logger
+	operations       *prometheus.CounterVec
+	failures         *prometheus.CounterVec
+	skipped          *prometheus.CounterVec
 	durationSet      prometheus.Observer
 	durationSetMulti prometheus.Observer
 	durationGetMulti prometheus.Observer
+	durationDelete   prometheus.Observer
 }
 
 // NewRedisClient makes a new RedisClient.
@@ -227,9 +271,11 @@ func NewRedisClientWithConfig(logger log.Logger, name string, config RedisClient
 	}
 
 	c := &RedisClient{
-		client: client,
-		config: config,
-		logger: logger,
+		client:     client,
+		config:     config,
+		logger:     logger,
+		asyncQueue: make(chan redisAsyncItem, config.MaxAsyncBufferSize),
+		stop:       make(chan struct{}),
 		getMultiGate: gate.New(
 			extprom.WrapRegistererWithPrefix("thanos_redis_getmulti_", reg),
 			config.MaxGetMultiConcurrency,
@@ -241,6 +287,31 @@ func NewRedisClientWithConfig(logger log.Logger, name string, config RedisClient
 			gate.Sets,
 		),
 	}
+
+	c.operations = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_redis_operations_total",
+		Help: "Total number of operations against redis.",
+	}, []string{"operation"})
+	c.failures = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_redis_operation_failures_total",
+		Help: "Total number of operations against redis that failed.",
+	}, []string{"operation", "reason"})
+	for _, op := range []string{opGetMulti, opSet, opAdd, opSetMulti, opDelete} {
+		c.operations.WithLabelValues(op)
+		for _, reason := range []string{reasonTimeout, reasonServerError, reasonNetworkError, reasonOther} {
+			c.failures.WithLabelValues(op, reason)
+		}
+	}
+
+	c.skipped = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_redis_operation_skipped_total",
+		Help: "Total number of operations against redis that have been skipped.",
+	}, []string{"operation", "reason"})
+	c.skipped.WithLabelValues(opSet, reasonMaxItemSize)
+	c.skipped.WithLabelValues(opSet, reasonAsyncBufferFull)
+	c.skipped.WithLabelValues(opAdd, reasonMaxItemSize)
+	c.skipped.WithLabelValues(opAdd, reasonAsyncBufferFull)
+
 	duration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "thanos_redis_operation_duration_seconds",
 		Help:    "Duration of operations against redis.",
@@ -249,16 +320,142 @@ func NewRedisClientWithConfig(logger log.Logger, name string, config RedisClient
 	c.durationSet = duration.WithLabelValues(opSet)
 	c.durationSetMulti = duration.WithLabelValues(opSetMulti)
 	c.durationGetMulti = duration.WithLabelValues(opGetMulti)
+	c.durationDelete = duration.WithLabelValues(opDelete)
+
+	// Start a number of goroutines - processing async operations - equal
+	// to the max concurrency we have.
+	c.workers.Add(c.config.MaxAsyncConcurrency)
+	for i := 0; i < c.config.MaxAsyncConcurrency; i++ {
+		go c.asyncQueueProcessLoop()
+	}
+
 	return c, nil
 }
 
-// SetAsync implement RemoteCacheClient.
-func (c *RedisClient) SetAsync(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+// SetAsync implement RemoteCacheClient. The item is enqueued to a bounded buffer and
+// later stored, pipelined together with the other items enqueued in the meanwhile.
+func (c *RedisClient) SetAsync(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.enqueueAsync(opSet, redisAsyncItem{key: key, value: value, ttl: ttl})
+	return nil
+}
+
+// AddAsync implements RemoteCacheClientWithAdd, storing the item with SET NX.
+func (c *RedisClient) AddAsync(_ context.Context, key string, value []byte, ttl time.Duration, exists func()) error {
+	c.enqueueAsync(opAdd, redisAsyncItem{key: key, value: value, ttl: ttl, add: true, exists: exists})
+	return nil
+}
+
+// enqueueAsync enqueues the item to the async buffer, unless it's bigger than the max
+// item size or the buffer is full.
+func (c *RedisClient) enqueueAsync(op string, item redisAsyncItem) {
+	// Skip hitting redis at all if the item is bigger than the max allowed size.
+	if c.config.MaxItemSize > 0 && uint64(len(item.value)) > uint64(c.config.MaxItemSize) {
+		c.skipped.WithLabelValues(op, reasonMaxItemSize).Inc()
+		return
+	}
+
+	select {
+	case c.asyncQueue <- item:
+	default:
+		c.skipped.WithLabelValues(op, reasonAsyncBufferFull).Inc()
+		level.Debug(c.logger).Log("msg", "failed to store item to redis because the async buffer is full", "size", len(c.asyncQueue))
+	}
+}
+
+// AsyncQueueFullRatio implement RemoteCacheClientWithAsyncQueue.
+func (c *RedisClient) AsyncQueueFullRatio() float64 {
+	if cap(c.asyncQueue) == 0 {
+		return 1
+	}
+	return float64(len(c.asyncQueue)) / float64(cap(c.asyncQueue))
+}
+
+func (c *RedisClient) asyncQueueProcessLoop() {
+	defer c.workers.Done()
+
+	for {
+		select {
+		case item := <-c.asyncQueue:
+			c.setBatch(c.drainAsyncQueue(item))
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// drainAsyncQueue returns a batch made of the given item and the items already
+// enqueued, up to the set multi batch size.
+func (c *RedisClient) drainAsyncQueue(first redisAsyncItem) []redisAsyncItem {
+	batch := []redisAsyncItem{first}
+	for c.config.SetMultiBatchSize <= 0 || len(batch) < c.config.SetMultiBatchSize {
+		select {
+		case item := <-c.asyncQueue:
+			batch = append(batch, item)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// setBatch stores the items to redis with a single pipeline.
+func (c *RedisClient) setBatch(items []redisAsyncItem) {
+	ctx := context.Background()
+	if c.config.WriteTimeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(ctx, c.config.WriteTimeout)
+		defer cancel()
+		ctx = timeoutCtx
+	}
+
 	start := time.Now()
-	if err := c.client.Do(ctx, c.client.B().Set().Key(key).Value(rueidis.BinaryString(value)).ExSeconds(int64(ttl.Seconds())).Build()).Error(); err != nil {
-		level.Warn(c.logger).Log("msg", "failed to set item into redis", "err", err, "key", key, "value_size", len(value))
+	sets := make(rueidis.Commands, 0, len(items))
+	for _, item := range items {
+		set := c.client.B().Set().Key(item.key).Value(rueidis.BinaryString(item.value))
+		if item.add {
+			sets = append(sets, set.Nx().ExSeconds(int64(item.ttl.Seconds())).Build())
+			c.operations.WithLabelValues(opAdd).Inc()
+			continue
+		}
+		sets = append(sets, set.ExSeconds(int64(item.ttl.Seconds())).Build())
+		c.operations.WithLabelValues(opSet).Inc()
+	}
+
+	for i, resp := range c.client.DoMulti(ctx, sets...) {
+		err := resp.Error()
+		if err == nil {
+			continue
+		}
+		// SET NX replies nil if the key already exists.
+		if items[i].add && rueidis.IsRedisNil(err) {
+			if items[i].exists != nil {
+				items[i].exists()
+			}
+			continue
+		}
+		level.Debug(c.logger).Log("msg", "failed to store item to redis", "err", err, "key", items[i].key, "value_size", len(items[i].value))
+		if items[i].add {
+			c.trackError(opAdd, err)
+		} else {
+			c.trackError(opSet, err)
+		}
+	}
+	c.durationSet.Observe(time.Since(start).Seconds())
+}
+
+// Set implements RemoteCacheClientWithSet.
+func (c *RedisClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	// Skip hitting redis at all if the item is bigger than the max allowed size.
+	if c.config.MaxItemSize > 0 && uint64(len(value)) > uint64(c.config.MaxItemSize) {
+		c.skipped.WithLabelValues(opSet, reasonMaxItemSize).Inc()
 		return nil
 	}
+
+	start := time.Now()
+	c.operations.WithLabelValues(opSet).Inc()
+	if err := c.client.Do(ctx, c.client.B().Set().Key(key).Value(rueidis.BinaryString(value)).ExSeconds(int64(ttl.Seconds())).Build()).Error(); err != nil {
+		c.trackError(opSet, err)
+		return errors.Wrapf(err, "store item to redis")
+	}
 	c.durationSet.Observe(time.Since(start).Seconds())
 	return nil
 }
@@ -274,9 +471,11 @@ func (c *RedisClient) SetMulti(ctx context.Context, data map[string][]byte, ttl
 	for k, v := range data {
 		sets = append(sets, c.client.B().Setex().Key(k).Seconds(ittl).Value(rueidis.BinaryString(v)).Build())
 	}
+	c.operations.WithLabelValues(opSetMulti).Inc()
 	for _, resp := range c.client.DoMulti(ctx, sets...) {
 		if err := resp.Error(); err != nil {
 			level.Warn(c.logger).Log("msg", "failed to set multi items from redis", "err", err, "items", len(data))
+			c.trackError(opSetMulti, err)
 			return
 		}
 	}
@@ -285,8 +484,17 @@ func (c *RedisClient) SetMulti(ctx context.Context, data map[string][]byte, ttl
 
 // GetMulti implement RemoteCacheClient.
 func (c *RedisClient) GetMulti(ctx context.Context, keys []string) map[string][]byte {
+	results, err := c.GetMultiWithError(ctx, keys)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to mget items from redis", "err", err, "items", len(results))
+	}
+	return results
+}
+
+// GetMultiWithError implement RemoteCacheClientWithError.
+func (c *RedisClient) GetMultiWithError(ctx context.Context, keys []string) (map[string][]byte, error) {
 	if len(keys) == 0 {
-		return nil
+		return nil, nil
 	}
 	start := time.Now()
 	results := make(map[string][]byte, len(keys))
@@ -298,9 +506,10 @@ func (c *RedisClient) GetMulti(ctx context.Context, keys []string) map[string][]
 	}
 
 	// NOTE(GiedriusS): TTL is the default one in case PTTL fails. 8 hours should be good enough IMHO.
+	c.operations.WithLabelValues(opGetMulti).Inc()
 	resps, err := rueidis.MGetCache(c.client, ctx, 8*time.Hour, keys)
 	if err != nil {
-		level.Warn(c.logger).Log("msg", "failed to mget items from redis", "err", err, "items", len(resps))
+		c.trackError(opGetMulti, err)
 	}
 	for key, resp := range resps {
 		if val, err := resp.ToString(); err == nil {
@@ -308,14 +517,59 @@ func (c *RedisClient) GetMulti(ctx context.Context, keys []string) map[string][]
 		}
 	}
 	c.durationGetMulti.Observe(time.Since(start).Seconds())
-	return results
+	return results, err
+}
+
+// Delete implement RemoteCacheClientWithDelete.
+func (c *RedisClient) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	c.operations.WithLabelValues(opDelete).Inc()
+	if err := c.client.Do(ctx, c.client.B().Del().Key(key).Build()).Error(); err != nil {
+		c.trackError(opDelete, err)
+		return err
+	}
+	c.durationDelete.Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// HealthCheck implements RemoteCacheClientWithHealthCheck, sending a PING to redis.
+func (c *RedisClient) HealthCheck(ctx context.Context) error {
+	if c.config.ReadTimeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(ctx, c.config.ReadTimeout)
+		defer cancel()
+		ctx = timeoutCtx
+	}
+	return errors.Wrap(c.client.Do(ctx, c.client.B().Ping().Build()).Error(), "redis health check")
 }
 
 // Stop implement RemoteCacheClient.
 func (c *RedisClient) Stop() {
+	close(c.stop)
+
+	// Wait until all workers have terminated before closing the connections.
+	c.workers.Wait()
 	c.client.Close()
 }
 
+func (c *RedisClient) trackError(op string, err error) {
+	var redisErr *rueidis.RedisError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		c.failures.WithLabelValues(op, reasonTimeout).Inc()
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			c.failures.WithLabelValues(op, reasonTimeout).Inc()
+		} else {
+			c.failures.WithLabelValues(op, reasonNetworkError).Inc()
+		}
+	case errors.As(err, &redisErr):
+		c.failures.WithLabelValues(op, reasonServerError).Inc()
+	default:
+		c.failures.WithLabelValues(op, reasonOther).Inc()
+	}
+}
+
 // stringToBytes converts string to byte slice (copied from vendor/github.com/go-redis/redis/v8/internal/util/unsafe.go).
 func stringToBytes(s string) []byte {
 	return *(*[]byte)(unsafe.Pointer(
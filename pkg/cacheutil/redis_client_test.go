@@ -12,7 +12,11 @@ import (
 	"github.com/alicebob/miniredis/v2"
 	"github.com/efficientgo/core/testutil"
 	"github.com/go-kit/log"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
 func TestRedisClient(t *testing.T) {
@@ -137,6 +141,142 @@ func TestRedisClient(t *testing.T) {
 	}
 }
 
+func TestRedisClient_Delete(t *testing.T) {
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+	defer s.Close()
+
+	cfg := DefaultRedisClientConfig
+	cfg.Addr = s.Addr()
+	c, err := NewRedisClientWithConfig(log.NewNopLogger(), t.Name(), cfg, prometheus.NewRegistry())
+	testutil.Ok(t, err)
+	defer c.Stop()
+
+	ctx := context.Background()
+	c.SetMulti(ctx, map[string][]byte{"key1": {1}}, time.Hour)
+	testutil.Ok(t, c.Delete(ctx, "key1"))
+	testutil.Equals(t, map[string][]byte{}, c.GetMulti(ctx, []string{"key1"}))
+
+	// Deleting a missing key should not fail.
+	testutil.Ok(t, c.Delete(ctx, "key1"))
+}
+
+func TestRedisClient_HealthCheck(t *testing.T) {
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+	defer s.Close()
+
+	cfg := DefaultRedisClientConfig
+	cfg.Addr = s.Addr()
+	c, err := NewRedisClientWithConfig(log.NewNopLogger(), t.Name(), cfg, prometheus.NewRegistry())
+	testutil.Ok(t, err)
+	defer c.Stop()
+
+	ctx := context.Background()
+	testutil.Ok(t, c.HealthCheck(ctx))
+
+	// The health check fails once redis is unreachable.
+	s.Close()
+	testutil.NotOk(t, c.HealthCheck(ctx))
+}
+
+func TestRedisClient_SetAsync(t *testing.T) {
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+	defer s.Close()
+
+	cfg := DefaultRedisClientConfig
+	cfg.Addr = s.Addr()
+	cfg.MaxItemSize = 2
+	c, err := NewRedisClientWithConfig(log.NewNopLogger(), t.Name(), cfg, prometheus.NewRegistry())
+	testutil.Ok(t, err)
+	defer c.Stop()
+
+	ctx := context.Background()
+	testutil.Ok(t, c.SetAsync(ctx, "key1", []byte{1}, time.Hour))
+	testutil.Ok(t, c.SetAsync(ctx, "key2", []byte{2, 2}, time.Hour))
+	// Items bigger than the max item size should be skipped.
+	testutil.Ok(t, c.SetAsync(ctx, "key3", []byte{3, 3, 3}, time.Hour))
+
+	// Wait until the items have been asynchronously stored.
+	retryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if hits := c.GetMulti(ctx, []string{"key1", "key2"}); len(hits) != 2 {
+			return errors.Errorf("expected 2 hits, got %d", len(hits))
+		}
+		return nil
+	}))
+
+	testutil.Equals(t, map[string][]byte{"key1": {1}, "key2": {2, 2}}, c.GetMulti(ctx, []string{"key1", "key2", "key3"}))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.operations.WithLabelValues(opSet)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.skipped.WithLabelValues(opSet, reasonMaxItemSize)))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.failures.WithLabelValues(opSet, reasonOther)))
+	testutil.Equals(t, 0.0, c.AsyncQueueFullRatio())
+}
+
+func TestRedisClient_AddAsync(t *testing.T) {
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+	defer s.Close()
+
+	cfg := DefaultRedisClientConfig
+	cfg.Addr = s.Addr()
+	c, err := NewRedisClientWithConfig(log.NewNopLogger(), t.Name(), cfg, prometheus.NewRegistry())
+	testutil.Ok(t, err)
+	defer c.Stop()
+
+	ctx := context.Background()
+	existing := make(chan string, 1)
+	testutil.Ok(t, c.AddAsync(ctx, "key1", []byte{1}, time.Hour, func() { existing <- "key1" }))
+
+	// Wait until the item has been asynchronously stored.
+	retryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if hits := c.GetMulti(ctx, []string{"key1"}); len(hits) != 1 {
+			return errors.Errorf("expected 1 hit, got %d", len(hits))
+		}
+		return nil
+	}))
+
+	// Only the first added value is stored.
+	testutil.Ok(t, c.AddAsync(ctx, "key1", []byte{2}, time.Hour, func() { existing <- "key1" }))
+	select {
+	case key := <-existing:
+		testutil.Equals(t, "key1", key)
+	case <-retryCtx.Done():
+		t.Fatal("the existing key wasn't reported")
+	}
+	testutil.Equals(t, map[string][]byte{"key1": {1}}, c.GetMulti(ctx, []string{"key1"}))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.operations.WithLabelValues(opAdd)))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.failures.WithLabelValues(opAdd, reasonOther)))
+}
+
+func TestRedisClient_SetAsync_ShouldSkipWhenBufferFull(t *testing.T) {
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+	defer s.Close()
+
+	cfg := DefaultRedisClientConfig
+	cfg.Addr = s.Addr()
+	cfg.MaxAsyncBufferSize = 1
+	c, err := NewRedisClientWithConfig(log.NewNopLogger(), t.Name(), cfg, prometheus.NewRegistry())
+	testutil.Ok(t, err)
+
+	// Stop the workers, so that enqueued items are never processed.
+	close(c.stop)
+	c.workers.Wait()
+	defer c.client.Close()
+
+	ctx := context.Background()
+	testutil.Ok(t, c.SetAsync(ctx, "key1", []byte{1}, time.Hour))
+	testutil.Ok(t, c.SetAsync(ctx, "key2", []byte{2}, time.Hour))
+
+	testutil.Equals(t, 1.0, c.AsyncQueueFullRatio())
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.skipped.WithLabelValues(opSet, reasonAsyncBufferFull)))
+}
+
 func TestValidateRedisConfig(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -197,6 +337,16 @@ func TestValidateRedisConfig(t *testing.T) {
 			},
 			expect_err: true,
 		},
+		{
+			name: "asyncConcurrencyNotPositive",
+			config: func() RedisClientConfig {
+				cfg := DefaultRedisClientConfig
+				cfg.Addr = "127.0.0.1:6789"
+				cfg.MaxAsyncConcurrency = 0
+				return cfg
+			},
+			expect_err: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -223,8 +373,10 @@ func TestMultipleRedisClient(t *testing.T) {
 	cfg.Addr = s.Addr()
 	logger := log.NewLogfmtLogger(os.Stderr)
 	reg := prometheus.NewRegistry()
-	_, err = NewRedisClientWithConfig(logger, "test1", cfg, reg)
+	cl, err := NewRedisClientWithConfig(logger, "test1", cfg, reg)
 	testutil.Ok(t, err)
-	_, err = NewRedisClientWithConfig(logger, "test2", cfg, reg)
+	defer cl.Stop()
+	cl, err = NewRedisClientWithConfig(logger, "test2", cfg, reg)
 	testutil.Ok(t, err)
+	defer cl.Stop()
 }
@@ -39,12 +39,7 @@ func BestEffortCleanAbortedPartialUploads(
 	// * being uploaded and started after their partialUploadThresholdAge
 	// can be assumed in this case. Keep partialUploadThresholdAge long for now.
 	// Mitigate this by adding ModifiedTime to bkt and check that instead of ULID (block creation time).
-	for id := range partial {
-		if ulid.Now()-id.Time() <= uint64(PartialUploadThresholdAge/time.Millisecond) {
-			// Minimum delay has not expired, ignore for now.
-			continue
-		}
-
+	for _, id := range AbortedPartialUploads(partial) {
 		deleteAttempts.Inc()
 		level.Info(logger).Log("msg", "found partially uploaded block; marking for deletion", "block", id)
 		// We don't gather any information about deletion marks for partial blocks, so let's simply remove it. We waited
@@ -60,3 +55,17 @@ func BestEffortCleanAbortedPartialUploads(
 	}
 	level.Info(logger).Log("msg", "cleaning of aborted partial uploads done")
 }
+
+// AbortedPartialUploads returns the partially uploaded blocks old enough to be considered aborted,
+// which BestEffortCleanAbortedPartialUploads deletes.
+func AbortedPartialUploads(partial map[ulid.ULID]error) []ulid.ULID {
+	var ids []ulid.ULID
+	for id := range partial {
+		if ulid.Now()-id.Time() <= uint64(PartialUploadThresholdAge/time.Millisecond) {
+			// Minimum delay has not expired, ignore for now.
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
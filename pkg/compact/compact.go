@@ -166,21 +166,7 @@ func (s *Syncer) GarbageCollect(ctx context.Context) error {
 
 	begin := time.Now()
 
-	// Ignore filter exists before deduplicate filter.
-	deletionMarkMap := s.ignoreDeletionMarkFilter.DeletionMarkBlocks()
-	duplicateIDs := s.duplicateBlocksFilter.DuplicateIDs()
-
-	// GarbageIDs contains the duplicateIDs, since these blocks can be replaced with other blocks.
-	// We also remove ids present in deletionMarkMap since these blocks are already marked for deletion.
-	garbageIDs := []ulid.ULID{}
-	for _, id := range duplicateIDs {
-		if _, exists := deletionMarkMap[id]; exists {
-			continue
-		}
-		garbageIDs = append(garbageIDs, id)
-	}
-
-	for _, id := range garbageIDs {
+	for _, id := range s.garbageBlocks() {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
@@ -206,6 +192,32 @@ func (s *Syncer) GarbageCollect(ctx context.Context) error {
 	return nil
 }
 
+// GarbageBlocks returns the blocks GarbageCollect would mark for deletion.
+// Call to SyncMetas function is required to populate duplicateIDs in duplicateBlocksFilter.
+func (s *Syncer) GarbageBlocks() []ulid.ULID {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.garbageBlocks()
+}
+
+func (s *Syncer) garbageBlocks() []ulid.ULID {
+	// Ignore filter exists before deduplicate filter.
+	deletionMarkMap := s.ignoreDeletionMarkFilter.DeletionMarkBlocks()
+	duplicateIDs := s.duplicateBlocksFilter.DuplicateIDs()
+
+	// GarbageIDs contains the duplicateIDs, since these blocks can be replaced with other blocks.
+	// We also remove ids present in deletionMarkMap since these blocks are already marked for deletion.
+	garbageIDs := []ulid.ULID{}
+	for _, id := range duplicateIDs {
+		if _, exists := deletionMarkMap[id]; exists {
+			continue
+		}
+		garbageIDs = append(garbageIDs, id)
+	}
+	return garbageIDs
+}
+
 // Grouper is responsible to group all known blocks into sub groups which are safe to be
 // compacted concurrently.
 type Grouper interface {
@@ -974,28 +986,48 @@ func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket,
 	return nil
 }
 
-func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp Compactor) (shouldRerun bool, compID ulid.ULID, _ error) {
-	cg.mtx.Lock()
-	defer cg.mtx.Unlock()
-
+// plan returns the blocks of the group to compact, if any, and whether they overlap, after checking
+// the metadata of the blocks allow for compacting them.
+func (cg *Group) plan(ctx context.Context, planner Planner) (toCompact []*metadata.Meta, overlappingBlocks bool, _ error) {
 	// Check for overlapped blocks.
-	overlappingBlocks := false
 	if err := cg.areBlocksOverlapping(nil); err != nil {
 		// TODO(bwplotka): It would really nice if we could still check for other overlaps than replica. In fact this should be checked
 		// in syncer itself. Otherwise with vertical compaction enabled we will sacrifice this important check.
 		if !cg.enableVerticalCompaction {
-			return false, ulid.ULID{}, halt(errors.Wrap(err, "pre compaction overlap check"))
+			return nil, false, halt(errors.Wrap(err, "pre compaction overlap check"))
 		}
 
 		overlappingBlocks = true
 	}
 
-	var toCompact []*metadata.Meta
 	if err := tracing.DoInSpanWithErr(ctx, "compaction_planning", func(ctx context.Context) (e error) {
 		toCompact, e = planner.Plan(ctx, cg.metasByMinTime)
 		return e
 	}); err != nil {
-		return false, ulid.ULID{}, errors.Wrap(err, "plan compaction")
+		return nil, false, errors.Wrap(err, "plan compaction")
+	}
+
+	// Due to #183 we verify that none of the blocks in the plan have overlapping sources.
+	// This is one potential source of how we could end up with duplicated chunks.
+	uniqueSources := map[ulid.ULID]struct{}{}
+	for _, m := range toCompact {
+		for _, s := range m.Compaction.Sources {
+			if _, ok := uniqueSources[s]; ok {
+				return nil, false, halt(errors.Errorf("overlapping sources detected for plan %v", toCompact))
+			}
+			uniqueSources[s] = struct{}{}
+		}
+	}
+	return toCompact, overlappingBlocks, nil
+}
+
+func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp Compactor) (shouldRerun bool, compID ulid.ULID, _ error) {
+	cg.mtx.Lock()
+	defer cg.mtx.Unlock()
+
+	toCompact, overlappingBlocks, err := cg.plan(ctx, planner)
+	if err != nil {
+		return false, ulid.ULID{}, err
 	}
 	if len(toCompact) == 0 {
 		// Nothing to do.
@@ -1004,10 +1036,6 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 
 	level.Info(cg.logger).Log("msg", "compaction available and planned; downloading blocks", "plan", fmt.Sprintf("%v", toCompact))
 
-	// Due to #183 we verify that none of the blocks in the plan have overlapping sources.
-	// This is one potential source of how we could end up with duplicated chunks.
-	uniqueSources := map[ulid.ULID]struct{}{}
-
 	// Once we have a plan we need to download the actual data.
 	groupCompactionBegin := time.Now()
 	begin := groupCompactionBegin
@@ -1017,12 +1045,6 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 	toCompactDirs := make([]string, 0, len(toCompact))
 	for _, m := range toCompact {
 		bdir := filepath.Join(dir, m.ULID.String())
-		for _, s := range m.Compaction.Sources {
-			if _, ok := uniqueSources[s]; ok {
-				return false, ulid.ULID{}, halt(errors.Errorf("overlapping sources detected for plan %v", toCompact))
-			}
-			uniqueSources[s] = struct{}{}
-		}
 		func(ctx context.Context, meta *metadata.Meta) {
 			g.Go(func() error {
 				if err := tracing.DoInSpanWithErr(ctx, "compaction_block_download", func(ctx context.Context) error {
@@ -1356,6 +1378,50 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 	return nil
 }
 
+// PlannedCompaction is a compaction planned for the blocks of a group.
+type PlannedCompaction struct {
+	Group  *Group
+	Blocks []*metadata.Meta
+}
+
+// Plan returns the compactions the next pass of Compact would start with, without garbage collecting,
+// downloading, compacting nor marking any block. Blocks are checked the same way as before compacting
+// them, but only from their metadata: issues only found in their index are not reported.
+func (c *BucketCompactor) Plan(ctx context.Context) ([]PlannedCompaction, error) {
+	if err := c.sy.SyncMetas(ctx); err != nil {
+		return nil, errors.Wrap(err, "sync")
+	}
+
+	// Leave out the blocks garbage collection would mark for deletion, as Compact does.
+	metas := c.sy.Metas()
+	for _, id := range c.sy.GarbageBlocks() {
+		delete(metas, id)
+	}
+
+	groups, err := c.grouper.Groups(metas)
+	if err != nil {
+		return nil, errors.Wrap(err, "build compaction groups")
+	}
+
+	var plans []PlannedCompaction
+	for _, g := range groups {
+		// Ignore groups with only one block because there is nothing to compact.
+		if len(g.IDs()) == 1 {
+			continue
+		}
+		g.mtx.Lock()
+		toCompact, _, err := g.plan(ctx, c.planner)
+		g.mtx.Unlock()
+		if err != nil {
+			return nil, errors.Wrapf(err, "group %s", g.Key())
+		}
+		if len(toCompact) > 0 {
+			plans = append(plans, PlannedCompaction{Group: g, Blocks: toCompact})
+		}
+	}
+	return plans, nil
+}
+
 var _ block.MetadataFilter = &GatherNoCompactionMarkFilter{}
 
 // GatherNoCompactionMarkFilter is a block.Fetcher filter that passes all metas. While doing it, it gathers all no-compact-mark.json markers.
@@ -319,6 +319,26 @@ func testGroupCompactE2e(t *testing.T, mergeFunc storage.VerticalChunkSeriesMerg
 		groupKey1 := metas[0].Thanos.GroupKey()
 		groupKey2 := metas[6].Thanos.GroupKey()
 
+		// Planning reports the first compaction of each group, without touching the bucket. The block with
+		// out-of-order chunks is planned, as it can only be found out once its index is downloaded.
+		plans, err := bComp.Plan(ctx)
+		testutil.Ok(t, err)
+		planned := map[string][]ulid.ULID{}
+		for _, p := range plans {
+			for _, m := range p.Blocks {
+				planned[p.Group.Key()] = append(planned[p.Group.Key()], m.ULID)
+			}
+		}
+		testutil.Equals(t, map[string][]ulid.ULID{
+			groupKey1: {metas[9].ULID, metas[0].ULID, metas[2].ULID, metas[1].ULID},
+			groupKey2: {metas[7].ULID, metas[6].ULID},
+		}, planned)
+		marked, err := listBlocksMarkedForDeletion(ctx, bkt)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, len(marked))
+		testutil.Equals(t, 0.0, promtest.ToFloat64(grouper.compactionRunsStarted.WithLabelValues(groupKey1)))
+		testutil.Equals(t, 0.0, promtest.ToFloat64(grouper.compactionRunsStarted.WithLabelValues(groupKey2)))
+
 		testutil.Ok(t, bComp.Compact(ctx))
 		testutil.Equals(t, 5.0, promtest.ToFloat64(sy.metrics.garbageCollectedBlocks))
 		testutil.Equals(t, 5.0, promtest.ToFloat64(sy.metrics.blocksMarkedForDeletion))
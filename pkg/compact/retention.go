@@ -30,6 +30,19 @@ func ApplyRetentionPolicyByResolution(
 	blocksMarkedForDeletion prometheus.Counter,
 ) error {
 	level.Info(logger).Log("msg", "start optional retention")
+	for id, retentionDuration := range BlocksExceedingRetention(metas, retentionByResolution) {
+		level.Info(logger).Log("msg", "applying retention: marking block for deletion", "id", id, "maxTime", time.Unix(metas[id].MaxTime/1000, 0).String())
+		if err := block.MarkForDeletion(ctx, logger, bkt, id, fmt.Sprintf("block exceeding retention of %v", retentionDuration), blocksMarkedForDeletion); err != nil {
+			return errors.Wrap(err, "delete block")
+		}
+	}
+	level.Info(logger).Log("msg", "optional retention apply done")
+	return nil
+}
+
+// BlocksExceedingRetention returns the blocks exceeding the retention of their resolution, along with that retention.
+func BlocksExceedingRetention(metas map[ulid.ULID]*metadata.Meta, retentionByResolution map[ResolutionLevel]time.Duration) map[ulid.ULID]time.Duration {
+	res := map[ulid.ULID]time.Duration{}
 	for id, m := range metas {
 		retentionDuration := retentionByResolution[ResolutionLevel(m.Thanos.Downsample.Resolution)]
 		if retentionDuration.Seconds() == 0 {
@@ -38,12 +51,8 @@ func ApplyRetentionPolicyByResolution(
 
 		maxTime := time.Unix(m.MaxTime/1000, 0)
 		if time.Now().After(maxTime.Add(retentionDuration)) {
-			level.Info(logger).Log("msg", "applying retention: marking block for deletion", "id", id, "maxTime", maxTime.String())
-			if err := block.MarkForDeletion(ctx, logger, bkt, id, fmt.Sprintf("block exceeding retention of %v", retentionDuration), blocksMarkedForDeletion); err != nil {
-				return errors.Wrap(err, "delete block")
-			}
+			res[id] = retentionDuration
 		}
 	}
-	level.Info(logger).Log("msg", "optional retention apply done")
-	return nil
+	return res
 }
@@ -74,9 +74,11 @@ func (p *Provider) Resolve(ctx context.Context, addresses []string) error {
 			errs.Add(err)
 			p.resolverFailuresCount.Inc()
 
-			// Use cached values.
+			// Use cached values, if any.
 			p.RLock()
-			clusterConfigs[address] = p.clusterConfigs[address]
+			if cached, ok := p.clusterConfigs[address]; ok {
+				clusterConfigs[address] = cached
+			}
 			p.RUnlock()
 		} else {
 			clusterConfigs[address] = clusterConfig
@@ -81,3 +81,12 @@ func (r *mockResolver) Resolve(_ context.Context, address string) (*clusterConfi
 	}
 	return r.configs[address], nil
 }
+
+func TestProviderDoesNotPanicIfFirstResolutionFailed(t *testing.T) {
+	ctx := context.TODO()
+	provider := NewProvider(log.NewNopLogger(), nil, 5*time.Second)
+	provider.resolver = &mockResolver{err: errors.New("oops")}
+
+	testutil.NotOk(t, provider.Resolve(ctx, []string{"memcached-cluster-1"}))
+	testutil.Equals(t, 0, len(provider.Addresses()))
+}
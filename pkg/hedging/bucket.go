@@ -0,0 +1,266 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package hedging implements client-side hedging of the object storage GET requests,
+// to cut the tail latency caused by rare slow requests.
+package hedging
+
+import (
+	"context"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+)
+
+const (
+	opGet      = "get"
+	opGetRange = "get_range"
+
+	// Number of recent GET latencies the hedge delay is derived from.
+	latencyWindow = 1000
+	// Number of GET latencies observed between two updates of the hedge delay.
+	delayUpdateInterval = 100
+)
+
+// Config configures the hedging of GET requests.
+type Config struct {
+	// Percentile of the recent GET latencies after which a second request is issued
+	// for a GET which hasn't returned yet, in (0, 1).
+	Percentile float64
+	// Minimum delay before hedging a GET, also used until enough GETs have been observed.
+	// It must be positive, as hedging every GET right away would double the requests.
+	MinDelay time.Duration
+	// Maximum number of hedged requests in flight at once. GETs are not hedged beyond it.
+	MaxInFlight int
+}
+
+func (cfg Config) validate() error {
+	if cfg.Percentile <= 0 || cfg.Percentile >= 1 {
+		return errors.Errorf("hedging percentile must be in (0, 1), got %v", cfg.Percentile)
+	}
+	if cfg.MinDelay <= 0 {
+		return errors.New("hedging min delay must be positive")
+	}
+	if cfg.MaxInFlight <= 0 {
+		return errors.New("hedging max in-flight requests must be positive")
+	}
+	return nil
+}
+
+// Bucket is a bucket hedging the Get and GetRange requests: if a request hasn't returned
+// within the hedge delay, a second request is issued, and the first one to succeed is
+// returned, the other being cancelled. Reading the returned object is not hedged.
+type Bucket struct {
+	objstore.Bucket
+
+	inFlight chan struct{}
+	delay    *delayEstimator
+
+	hedged *prometheus.CounterVec
+}
+
+// NewBucket returns a bucket hedging the GET requests to the given bucket.
+func NewBucket(bkt objstore.Bucket, cfg Config, reg prometheus.Registerer) (*Bucket, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	b := &Bucket{
+		Bucket:   bkt,
+		inFlight: make(chan struct{}, cfg.MaxInFlight),
+		delay:    newDelayEstimator(cfg.Percentile, cfg.MinDelay),
+		hedged: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_objstore_hedged_requests_total",
+			Help: "Total number of object storage requests hedged because they haven't returned within the hedge delay.",
+		}, []string{"operation"}),
+	}
+	b.hedged.WithLabelValues(opGet)
+	b.hedged.WithLabelValues(opGetRange)
+	return b, nil
+}
+
+func (b *Bucket) Name() string {
+	return "hedged: " + b.Bucket.Name()
+}
+
+func (b *Bucket) WithExpectedErrs(expectedFunc objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	if ib, ok := b.Bucket.(objstore.InstrumentedBucket); ok {
+		// Make a copy, but replace bucket with instrumented one.
+		res := &Bucket{}
+		*res = *b
+		res.Bucket = ib.WithExpectedErrs(expectedFunc)
+		return res
+	}
+
+	return b
+}
+
+func (b *Bucket) ReaderWithExpectedErrs(expectedFunc objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return b.WithExpectedErrs(expectedFunc)
+}
+
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.hedge(ctx, opGet, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.Bucket.Get(ctx, name)
+	})
+}
+
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.hedge(ctx, opGetRange, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	})
+}
+
+type attempt struct {
+	rc     io.ReadCloser
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+}
+
+func (b *Bucket) hedge(ctx context.Context, op string, get func(context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	// Buffered so that the loser never blocks once the winner is returned.
+	results := make(chan attempt, 2)
+	var cancels []context.CancelFunc
+	start := func(hedge bool) {
+		actx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			rc, err := get(actx)
+			results <- attempt{rc: rc, err: err, cancel: cancel, hedge: hedge}
+		}()
+	}
+
+	// The latency is observed from the start of the first request, whichever wins, so that the
+	// hedge delay is derived from the latencies of the GETs rather than of the hedged requests,
+	// which only start once the delay has elapsed and would bring it down otherwise.
+	begin := time.Now()
+	start(false)
+	timer := time.NewTimer(b.delay.get())
+	defer timer.Stop()
+
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			select {
+			case b.inFlight <- struct{}{}:
+			default:
+				// Too many hedged requests in flight, wait for the first request only.
+				continue
+			}
+			b.hedged.WithLabelValues(op).Inc()
+			pending++
+			start(true)
+		case a := <-results:
+			pending--
+			if a.hedge {
+				<-b.inFlight
+			}
+			if a.err != nil && pending > 0 {
+				// Give the other request a chance to succeed.
+				a.cancel()
+				continue
+			}
+			if a.err != nil {
+				a.cancel()
+				return nil, a.err
+			}
+
+			b.delay.observe(time.Since(begin))
+			if pending > 0 {
+				// Cancel the request which lost the race, then release what it returns.
+				loser := 1
+				if a.hedge {
+					loser = 0
+				}
+				cancels[loser]()
+				go b.discard(results)
+			}
+			return &readCloser{ReadCloser: a.rc, cancel: a.cancel}, nil
+		}
+	}
+}
+
+// discard releases whatever the request which lost the race returned.
+func (b *Bucket) discard(results <-chan attempt) {
+	a := <-results
+	if a.hedge {
+		<-b.inFlight
+	}
+	if a.rc != nil {
+		_ = a.rc.Close()
+	}
+}
+
+// readCloser cancels the context of the request it has been returned by, once closed.
+type readCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *readCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// delayEstimator derives the hedge delay from a percentile of the recent GET latencies.
+type delayEstimator struct {
+	percentile float64
+	minDelay   time.Duration
+
+	mtx       sync.Mutex
+	latencies []time.Duration
+	next      int
+	observed  int
+	delay     time.Duration
+}
+
+func newDelayEstimator(percentile float64, minDelay time.Duration) *delayEstimator {
+	return &delayEstimator{
+		percentile: percentile,
+		minDelay:   minDelay,
+		latencies:  make([]time.Duration, 0, latencyWindow),
+		delay:      minDelay,
+	}
+}
+
+func (e *delayEstimator) get() time.Duration {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return e.delay
+}
+
+func (e *delayEstimator) observe(latency time.Duration) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if len(e.latencies) < latencyWindow {
+		e.latencies = append(e.latencies, latency)
+	} else {
+		e.latencies[e.next] = latency
+		e.next = (e.next + 1) % latencyWindow
+	}
+
+	e.observed++
+	if e.observed%delayUpdateInterval != 0 {
+		return
+	}
+
+	sorted := make([]time.Duration, len(e.latencies))
+	copy(sorted, e.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	e.delay = sorted[int(math.Ceil(e.percentile*float64(len(sorted))))-1]
+	if e.delay < e.minDelay {
+		e.delay = e.minDelay
+	}
+}
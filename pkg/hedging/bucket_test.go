@@ -0,0 +1,170 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package hedging
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore"
+)
+
+// slowBucket blocks the first GET requests until their context is cancelled.
+type slowBucket struct {
+	objstore.Bucket
+
+	mtx       sync.Mutex
+	slow      int
+	fail      int
+	cancelled int
+}
+
+func (b *slowBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.mtx.Lock()
+	slow := b.slow > 0
+	if slow {
+		b.slow--
+	}
+	fail := !slow && b.fail > 0
+	if fail {
+		b.fail--
+	}
+	b.mtx.Unlock()
+
+	if fail {
+		return nil, errors.New("failed")
+	}
+	if slow {
+		<-ctx.Done()
+		b.mtx.Lock()
+		b.cancelled++
+		b.mtx.Unlock()
+		return nil, ctx.Err()
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func (b *slowBucket) getCancelled() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.cancelled
+}
+
+func TestBucket_Get(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	testutil.Ok(t, inmem.Upload(ctx, "obj", bytes.NewReader([]byte("content"))))
+
+	newBucket := func(t *testing.T, slow, fail, maxInFlight int) (*Bucket, *slowBucket) {
+		sb := &slowBucket{Bucket: inmem, slow: slow, fail: fail}
+		b, err := NewBucket(sb, Config{Percentile: 0.9, MinDelay: 10 * time.Millisecond, MaxInFlight: maxInFlight}, prometheus.NewRegistry())
+		testutil.Ok(t, err)
+		return b, sb
+	}
+	read := func(t *testing.T, b *Bucket) {
+		rc, err := b.Get(ctx, "obj")
+		testutil.Ok(t, err)
+		content, err := io.ReadAll(rc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rc.Close())
+		testutil.Equals(t, "content", string(content))
+	}
+
+	t.Run("fast request is not hedged", func(t *testing.T) {
+		b, _ := newBucket(t, 0, 0, 1)
+		read(t, b)
+		testutil.Equals(t, 0.0, promtest.ToFloat64(b.hedged.WithLabelValues(opGet)))
+	})
+
+	t.Run("slow request is hedged and cancelled", func(t *testing.T) {
+		b, sb := newBucket(t, 1, 0, 1)
+		read(t, b)
+		testutil.Equals(t, 1.0, promtest.ToFloat64(b.hedged.WithLabelValues(opGet)))
+
+		// The latency is observed from the start of the first request, not of the hedged one.
+		testutil.Equals(t, 1, len(b.delay.latencies))
+		testutil.Assert(t, b.delay.latencies[0] >= 10*time.Millisecond, "unexpected latency %v", b.delay.latencies[0])
+
+		// The slow request is cancelled in the background, then its hedging slot released.
+		testutil.Ok(t, runUntil(func() bool { return sb.getCancelled() == 1 && len(b.inFlight) == 0 }))
+	})
+
+	t.Run("failed request is returned", func(t *testing.T) {
+		b, _ := newBucket(t, 0, 1, 1)
+		_, err := b.Get(ctx, "obj")
+		testutil.NotOk(t, err)
+		testutil.Equals(t, 0.0, promtest.ToFloat64(b.hedged.WithLabelValues(opGet)))
+	})
+
+	t.Run("hedges beyond the max in flight are not issued", func(t *testing.T) {
+		b, _ := newBucket(t, 1, 0, 1)
+		// Take the only hedging slot.
+		b.inFlight <- struct{}{}
+
+		cctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			_, err := b.Get(cctx, "obj")
+			done <- err
+		}()
+
+		// The slow request is not hedged, so it only returns once cancelled.
+		time.Sleep(50 * time.Millisecond)
+		testutil.Equals(t, 0.0, promtest.ToFloat64(b.hedged.WithLabelValues(opGet)))
+		cancel()
+		testutil.NotOk(t, <-done)
+	})
+}
+
+func TestNewBucket_ShouldValidateConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Percentile: 0, MinDelay: time.Millisecond, MaxInFlight: 1},
+		{Percentile: 1, MinDelay: time.Millisecond, MaxInFlight: 1},
+		{Percentile: 0.9, MinDelay: 0, MaxInFlight: 1},
+		{Percentile: 0.9, MinDelay: time.Millisecond, MaxInFlight: 0},
+	} {
+		_, err := NewBucket(objstore.NewInMemBucket(), cfg, nil)
+		testutil.NotOk(t, err, "config %+v", cfg)
+	}
+
+	_, err := NewBucket(objstore.NewInMemBucket(), Config{Percentile: 0.9, MinDelay: time.Millisecond, MaxInFlight: 1}, nil)
+	testutil.Ok(t, err)
+}
+
+func TestDelayEstimator(t *testing.T) {
+	e := newDelayEstimator(0.9, 5*time.Millisecond)
+	testutil.Equals(t, 5*time.Millisecond, e.get())
+
+	for i := 1; i <= delayUpdateInterval; i++ {
+		e.observe(time.Duration(i) * time.Millisecond)
+		if i < delayUpdateInterval {
+			testutil.Equals(t, 5*time.Millisecond, e.get())
+		}
+	}
+	testutil.Equals(t, 90*time.Millisecond, e.get())
+
+	// The delay never goes below the min delay.
+	for i := 0; i < latencyWindow; i++ {
+		e.observe(time.Millisecond)
+	}
+	testutil.Equals(t, 5*time.Millisecond, e.get())
+}
+
+func runUntil(f func() bool) error {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return errors.New("condition not met")
+}
@@ -5,6 +5,7 @@ package queryfrontend
 
 import (
 	"fmt"
+	"net/url"
 
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
@@ -24,8 +25,11 @@ func newThanosCacheKeyGenerator(intervalFn queryrange.IntervalFn) thanosCacheKey
 }
 
 // GenerateCacheKey generates a cache key based on the Request and interval.
+// The key is prefixed by the tenant ID, escaped so that it can't contain the ':' separator
+// and the entries of different tenants can't collide.
 // TODO(yeya24): Add other request params as request key.
 func (t thanosCacheKeyGenerator) GenerateCacheKey(userID string, r queryrange.Request) string {
+	userID = url.QueryEscape(userID)
 	currentInterval := r.GetStart() / t.interval(r).Milliseconds()
 	switch tr := r.(type) {
 	case *ThanosQueryRangeRequest:
@@ -153,3 +153,16 @@ func TestGenerateCacheKey(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateCacheKey_Tenant(t *testing.T) {
+	intervalFn := func(r queryrange.Request) time.Duration { return hour }
+	splitter := newThanosCacheKeyGenerator(intervalFn)
+
+	req := &ThanosQueryRangeRequest{Query: "up", Start: 0, Step: 10 * seconds}
+	testutil.Equals(t, "fe:team-a:up:10000:0:2:-:0", splitter.GenerateCacheKey("team-a", req))
+
+	// The tenant ID can't be mistaken for the following key components.
+	testutil.Equals(t, "fe:team-a%3Aup:up:10000:0:2:-:0", splitter.GenerateCacheKey("team-a:up", req))
+	testutil.Assert(t, splitter.GenerateCacheKey("a:b", &ThanosQueryRangeRequest{Query: "c"}) !=
+		splitter.GenerateCacheKey("a", &ThanosQueryRangeRequest{Query: "b:c"}))
+}
@@ -216,6 +216,8 @@ type QueryRangeConfig struct {
 
 	ResultsCacheConfig *queryrange.ResultsCacheConfig
 	CachePathOrContent extflag.PathOrContent
+	// CacheStaleWhileRevalidate is how long expired results are served while recomputed.
+	CacheStaleWhileRevalidate time.Duration
 
 	AlignRangeWithStep     bool
 	RequestDownsampled     bool
@@ -225,6 +227,9 @@ type QueryRangeConfig struct {
 	HorizontalShards       int64
 	MaxRetries             int
 	Limits                 *cortexvalidation.Limits
+
+	// CacheAwareSplit further splits the queries at the boundaries of their cached results.
+	CacheAwareSplit bool
 }
 
 // LabelsConfig holds the config for labels tripperware.
@@ -254,6 +259,10 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if cfg.QueryRangeConfig.CacheAwareSplit && (cfg.QueryRangeConfig.ResultsCacheConfig == nil || !cfg.isStaticSplitSet()) {
+		return errors.New("cache aware split requires the response cache and a split queries interval")
+	}
+
 	if cfg.isDynamicSplitSet() && cfg.isStaticSplitSet() {
 		return errors.New("split queries interval and dynamic query split interval cannot be set at the same time")
 	}
@@ -72,6 +72,16 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			err: "min query split interval should be greater than 0 when query split threshold is enabled",
 		},
+		{
+			name: "cache aware split without response cache",
+			config: Config{
+				QueryRangeConfig: QueryRangeConfig{
+					SplitQueriesByInterval: 10 * time.Hour,
+					CacheAwareSplit:        true,
+				},
+			},
+			err: "cache aware split requires the response cache and a split queries interval",
+		},
 		{
 			name: "valid config with caching",
 			config: Config{
@@ -86,6 +96,7 @@ func TestConfig_Validate(t *testing.T) {
 						Compression:                "",
 						CacheQueryableSamplesStats: false,
 					},
+					CacheAwareSplit: true,
 				},
 				LabelsConfig: LabelsConfig{
 					DefaultTimeRange: day,
@@ -314,12 +314,7 @@ func (c labelsCodec) parseLabelsRequest(r *http.Request, op string, forwardHeade
 		}
 	}
 
-	for _, value := range r.Header.Values(cacheControlHeader) {
-		if strings.Contains(value, noStoreValue) {
-			result.CachingOptions.Disabled = true
-			break
-		}
-	}
+	result.CachingOptions.Disabled = isCachingDisabled(r.Header)
 
 	// Include the specified headers from http request in prometheusRequest.
 	for _, header := range forwardHeaders {
@@ -370,12 +365,7 @@ func (c labelsCodec) parseSeriesRequest(r *http.Request, forwardHeaders []string
 
 	result.Path = r.URL.Path
 
-	for _, value := range r.Header.Values(cacheControlHeader) {
-		if strings.Contains(value, noStoreValue) {
-			result.CachingOptions.Disabled = true
-			break
-		}
-	}
+	result.CachingOptions.Disabled = isCachingDisabled(r.Header)
 
 	// Include the specified headers from http request in prometheusRequest.
 	for _, header := range forwardHeaders {
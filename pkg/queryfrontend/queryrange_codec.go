@@ -32,6 +32,9 @@ const (
 
 	// Value that cacheControlHeader has if the response indicates that the results should not be cached.
 	noStoreValue = "no-store"
+
+	// Value that cacheControlHeader has if the request indicates that the results should not be served from the cache.
+	noCacheValue = "no-cache"
 )
 
 var (
@@ -131,12 +134,7 @@ func (c queryRangeCodec) DecodeRequest(_ context.Context, r *http.Request, forwa
 	result.Query = r.FormValue("query")
 	result.Path = r.URL.Path
 
-	for _, value := range r.Header.Values(cacheControlHeader) {
-		if strings.Contains(value, noStoreValue) {
-			result.CachingOptions.Disabled = true
-			break
-		}
-	}
+	result.CachingOptions.Disabled = isCachingDisabled(r.Header)
 
 	for _, header := range forwardHeaders {
 		for h, hv := range r.Header {
@@ -201,6 +199,17 @@ func (c queryRangeCodec) EncodeRequest(ctx context.Context, r queryrange.Request
 	return req.WithContext(ctx), nil
 }
 
+// isCachingDisabled returns whether the request Cache-Control header asks for the results
+// to be neither stored in nor served from the results cache.
+func isCachingDisabled(h http.Header) bool {
+	for _, value := range h.Values(cacheControlHeader) {
+		if strings.Contains(value, noStoreValue) || strings.Contains(value, noCacheValue) {
+			return true
+		}
+	}
+	return false
+}
+
 func parseDurationMillis(s string) (int64, error) {
 	if d, err := strconv.ParseFloat(s, 64); err == nil {
 		ts := d * float64(time.Second/time.Millisecond)
@@ -177,13 +177,41 @@ func newQueryRangeTripperware(
 		)
 	}
 
+	// The results cache is created ahead of the split by interval, which may rely on it.
+	var (
+		queryCacheMiddleware queryrange.Middleware
+		cachedExtents        queryrange.CachedExtentsReporter
+	)
+	if config.ResultsCacheConfig != nil {
+		cacheKeyGenerator := newThanosCacheKeyGenerator(dynamicIntervalFn(config))
+		cacheMiddleware, c, err := queryrange.NewResultsCacheMiddleware(
+			logger,
+			*config.ResultsCacheConfig,
+			cacheKeyGenerator,
+			limits,
+			codec,
+			queryrange.PrometheusResponseExtractor{},
+			nil,
+			shouldCache,
+			reg,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "create results cache middleware")
+		}
+		queryCacheMiddleware = cacheMiddleware
+		// The results of the queries sharded vertically are cached under the keys of the shards.
+		if config.CacheAwareSplit && numShards == 0 {
+			cachedExtents = queryrange.NewCachedExtentsReporter(logger, c, cacheKeyGenerator)
+		}
+	}
+
 	if config.SplitQueriesByInterval != 0 || config.MinQuerySplitInterval != 0 {
 		queryIntervalFn := dynamicIntervalFn(config)
 
 		queryRangeMiddleware = append(
 			queryRangeMiddleware,
 			queryrange.InstrumentMiddleware("split_by_interval", m),
-			SplitByIntervalMiddleware(queryIntervalFn, limits, codec, reg),
+			CacheAwareSplitByIntervalMiddleware(queryIntervalFn, limits, codec, cachedExtents, reg),
 		)
 	}
 
@@ -195,22 +223,7 @@ func newQueryRangeTripperware(
 		)
 	}
 
-	if config.ResultsCacheConfig != nil {
-		queryCacheMiddleware, _, err := queryrange.NewResultsCacheMiddleware(
-			logger,
-			*config.ResultsCacheConfig,
-			newThanosCacheKeyGenerator(dynamicIntervalFn(config)),
-			limits,
-			codec,
-			queryrange.PrometheusResponseExtractor{},
-			nil,
-			shouldCache,
-			reg,
-		)
-		if err != nil {
-			return nil, errors.Wrap(err, "create results cache middleware")
-		}
-
+	if queryCacheMiddleware != nil {
 		queryRangeMiddleware = append(
 			queryRangeMiddleware,
 			queryrange.InstrumentMiddleware("results_cache", m),
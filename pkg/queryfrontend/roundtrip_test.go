@@ -526,6 +526,70 @@ func TestRoundTripQueryRangeCacheMiddleware(t *testing.T) {
 	}
 }
 
+func TestRoundTripQueryRangeCacheMiddleware_TenantIsolation(t *testing.T) {
+	testRequest := &ThanosQueryRangeRequest{
+		Path:  "/api/v1/query_range",
+		Start: 0,
+		End:   2 * hour,
+		Step:  10 * seconds,
+		Dedup: true,
+		Query: "foo",
+	}
+
+	cacheConf := &queryrange.ResultsCacheConfig{
+		CacheConfig: cortexcache.Config{
+			EnableFifoCache: true,
+			Fifocache: cortexcache.FifoCacheConfig{
+				MaxSizeBytes: "1MiB",
+				MaxSizeItems: 1000,
+				Validity:     time.Hour,
+			},
+		},
+	}
+
+	tpw, err := NewTripperware(
+		Config{
+			QueryRangeConfig: QueryRangeConfig{
+				Limits:                 defaultLimits,
+				ResultsCacheConfig:     cacheConf,
+				SplitQueriesByInterval: day,
+			},
+		}, nil, log.NewNopLogger(),
+	)
+	testutil.Ok(t, err)
+
+	rt, err := newFakeRoundTripper()
+	testutil.Ok(t, err)
+	defer rt.Close()
+	res, handler := promqlResults(false)
+	rt.setHandler(handler)
+
+	for _, tc := range []struct {
+		name     string
+		tenant   string
+		expected int
+	}{
+		{name: "first tenant, first request", tenant: "team-a", expected: 1},
+		{name: "first tenant, same request, use cache", tenant: "team-a", expected: 1},
+		{name: "second tenant, same request, won't use the first tenant cache", tenant: "team-b", expected: 2},
+		{name: "second tenant, same request, use cache", tenant: "team-b", expected: 2},
+		{name: "tenant containing the key separator, won't use other tenants cache", tenant: "team-a:foo", expected: 3},
+	} {
+		if !t.Run(tc.name, func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), tc.tenant)
+			httpReq, err := NewThanosQueryRangeCodec(true).EncodeRequest(ctx, testRequest)
+			testutil.Ok(t, err)
+
+			_, err = tpw(rt).RoundTrip(httpReq)
+			testutil.Ok(t, err)
+
+			testutil.Equals(t, tc.expected, *res)
+		}) {
+			break
+		}
+	}
+}
+
 func TestRoundTripQueryCacheWithShardingMiddleware(t *testing.T) {
 	testRequest := &ThanosQueryRangeRequest{
 		Path:    "/api/v1/query_range",
@@ -694,6 +758,7 @@ func TestRoundTripLabelsCacheMiddleware(t *testing.T) {
 		name             string
 		req              queryrange.Request
 		handlerAndResult func() (*int, http.Handler)
+		cacheControl     string
 		expected         int
 	}{
 		{name: "first request", req: testRequest, expected: 1},
@@ -706,6 +771,9 @@ func TestRoundTripLabelsCacheMiddleware(t *testing.T) {
 		{name: "label values query with matchers, won't go to cache", req: testLabelValuesRequestFooWithMatchers, expected: 5},
 		{name: "same label values query with matchers, use cache", req: testLabelValuesRequestFooWithMatchers, expected: 5},
 		{name: "label values request different label", req: testLabelValuesRequestBar, expected: 6},
+		{name: "same label values query with no-cache header, bypass cache", req: testLabelValuesRequestBar, cacheControl: noCacheValue, expected: 7},
+		{name: "same label values query with no-store header, bypass cache", req: testLabelValuesRequestBar, cacheControl: noStoreValue, expected: 8},
+		{name: "same label values query, use cache", req: testLabelValuesRequestBar, expected: 8},
 		{
 			name: "request but will be partitioned",
 			req: &ThanosLabelsRequest{
@@ -713,7 +781,7 @@ func TestRoundTripLabelsCacheMiddleware(t *testing.T) {
 				Start: 0,
 				End:   25 * hour,
 			},
-			expected: 8,
+			expected: 10,
 		},
 		{
 			name: "same query as the previous one",
@@ -722,13 +790,16 @@ func TestRoundTripLabelsCacheMiddleware(t *testing.T) {
 				Start: 0,
 				End:   25 * hour,
 			},
-			expected: 8,
+			expected: 10,
 		},
 	} {
 		if !t.Run(tc.name, func(t *testing.T) {
 			ctx := user.InjectOrgID(context.Background(), "1")
 			httpReq, err := NewThanosLabelsCodec(true, 24*time.Hour).EncodeRequest(ctx, tc.req)
 			testutil.Ok(t, err)
+			if tc.cacheControl != "" {
+				httpReq.Header.Set(cacheControlHeader, tc.cacheControl)
+			}
 
 			_, err = tpw(rt).RoundTrip(httpReq)
 			testutil.Ok(t, err)
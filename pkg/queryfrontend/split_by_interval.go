@@ -18,12 +18,23 @@ import (
 
 // SplitByIntervalMiddleware creates a new Middleware that splits requests by a given interval.
 func SplitByIntervalMiddleware(interval queryrange.IntervalFn, limits queryrange.Limits, merger queryrange.Merger, registerer prometheus.Registerer) queryrange.Middleware {
+	return CacheAwareSplitByIntervalMiddleware(interval, limits, merger, nil, registerer)
+}
+
+// CacheAwareSplitByIntervalMiddleware is like SplitByIntervalMiddleware, but further splits the
+// range query requests at the boundaries of the extents of their results cached, as reported by
+// extents if not nil, so that the cached sub-ranges are served from the results cache while only
+// the uncached ones are recomputed. Unlike the results cache itself, it reuses the cached extents
+// regardless of how small they are. The entries fetched to split the requests are reused by the
+// results cache, which doesn't fetch them again.
+func CacheAwareSplitByIntervalMiddleware(interval queryrange.IntervalFn, limits queryrange.Limits, merger queryrange.Merger, extents queryrange.CachedExtentsReporter, registerer prometheus.Registerer) queryrange.Middleware {
 	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
 		return splitByInterval{
 			next:     next,
 			limits:   limits,
 			merger:   merger,
 			interval: interval,
+			extents:  extents,
 			splitByCounter: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
 				Namespace: "thanos",
 				Name:      "frontend_split_queries_total",
@@ -38,6 +49,7 @@ type splitByInterval struct {
 	limits   queryrange.Limits
 	merger   queryrange.Merger
 	interval queryrange.IntervalFn
+	extents  queryrange.CachedExtentsReporter
 
 	// Metrics.
 	splitByCounter prometheus.Counter
@@ -50,6 +62,11 @@ func (s splitByInterval) Do(ctx context.Context, r queryrange.Request) (queryran
 	if err != nil {
 		return nil, err
 	}
+	if s.extents != nil {
+		// The results cache reuses the entries fetched to split the requests.
+		ctx = queryrange.ContextWithReportedExtents(ctx)
+		reqs = s.splitAtCachedExtents(ctx, reqs)
+	}
 	s.splitByCounter.Add(float64(len(reqs)))
 
 	reqResps, err := queryrange.DoRequests(ctx, s.next, reqs, s.limits)
@@ -105,6 +122,57 @@ func splitQuery(r queryrange.Request, interval time.Duration) ([]queryrange.Requ
 	return reqs, nil
 }
 
+// splitAtCachedExtents splits the range query requests at the boundaries of the extents of their
+// results cached. The requests whose cached extents can't be reported are left as is.
+func (s splitByInterval) splitAtCachedExtents(ctx context.Context, reqs []queryrange.Request) []queryrange.Request {
+	aligned := make([]queryrange.Request, 0, len(reqs))
+	for _, r := range reqs {
+		if _, ok := r.(*ThanosQueryRangeRequest); !ok || r.GetStart() == r.GetEnd() {
+			aligned = append(aligned, r)
+			continue
+		}
+		extents, err := s.extents.CachedExtents(ctx, r)
+		if err != nil {
+			aligned = append(aligned, r)
+			continue
+		}
+		aligned = append(aligned, splitAtExtents(r, extents)...)
+	}
+	return aligned
+}
+
+// splitAtExtents splits the request at the boundaries of the extents overlapping it, which must
+// be ordered by start time, so that each sub-request is either fully cached or fully uncached.
+// As when the results cache partitions a request, adjacent sub-requests share their boundary.
+func splitAtExtents(r queryrange.Request, extents []queryrange.Extent) []queryrange.Request {
+	var (
+		reqs  []queryrange.Request
+		start = r.GetStart()
+	)
+	for _, extent := range extents {
+		if extent.End <= start || extent.Start >= r.GetEnd() {
+			continue
+		}
+		if start < extent.Start {
+			reqs = append(reqs, r.WithStartEnd(start, extent.Start))
+			start = extent.Start
+		}
+		end := extent.End
+		if end > r.GetEnd() {
+			end = r.GetEnd()
+		}
+		reqs = append(reqs, r.WithStartEnd(start, end))
+		start = end
+	}
+	if len(reqs) == 0 {
+		return []queryrange.Request{r}
+	}
+	if start < r.GetEnd() {
+		reqs = append(reqs, r.WithStartEnd(start, r.GetEnd()))
+	}
+	return reqs
+}
+
 // Round up to the step before the next interval boundary.
 func nextIntervalBoundary(t, step int64, interval time.Duration) int64 {
 	msPerInterval := int64(interval / time.Millisecond)
@@ -4,15 +4,21 @@
 package queryfrontend
 
 import (
+	"context"
 	"encoding/json"
+	"sort"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
 
 	"github.com/stretchr/testify/require"
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/internal/cortex/util/validation"
 )
 
 func TestSplitQuery(t *testing.T) {
@@ -258,3 +264,89 @@ func TestSplitQuery_PromQLErrorReturnsJson(t *testing.T) {
 
 	require.True(t, json.Valid(resp.Body), "error message is not valid JSON: %s", resp.Body)
 }
+
+func TestSplitAtExtents(t *testing.T) {
+	req := &ThanosQueryRangeRequest{Start: 100, End: 200, Step: 10, Query: "foo"}
+	for i, tc := range []struct {
+		extents  []queryrange.Extent
+		expected [][2]int64
+	}{
+		{expected: [][2]int64{{100, 200}}},
+		{extents: []queryrange.Extent{{Start: 0, End: 100}, {Start: 200, End: 300}}, expected: [][2]int64{{100, 200}}},
+		{extents: []queryrange.Extent{{Start: 0, End: 300}}, expected: [][2]int64{{100, 200}}},
+		{extents: []queryrange.Extent{{Start: 0, End: 120}}, expected: [][2]int64{{100, 120}, {120, 200}}},
+		{extents: []queryrange.Extent{{Start: 180, End: 300}}, expected: [][2]int64{{100, 180}, {180, 200}}},
+		{
+			extents:  []queryrange.Extent{{Start: 110, End: 120}, {Start: 150, End: 160}},
+			expected: [][2]int64{{100, 110}, {110, 120}, {120, 150}, {150, 160}, {160, 200}},
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var ranges [][2]int64
+			for _, r := range splitAtExtents(req, tc.extents) {
+				require.Equal(t, "foo", r.GetQuery())
+				ranges = append(ranges, [2]int64{r.GetStart(), r.GetEnd()})
+			}
+			require.Equal(t, tc.expected, ranges)
+		})
+	}
+}
+
+type mockedCachedExtentsReporter map[int64][]queryrange.Extent
+
+func (m mockedCachedExtentsReporter) CachedExtents(_ context.Context, r queryrange.Request) ([]queryrange.Extent, error) {
+	if r.GetStart() < 0 {
+		return nil, errors.New("failed")
+	}
+	return m[r.GetStart()], nil
+}
+
+func TestCacheAwareSplitByInterval(t *testing.T) {
+	const dayMs = int64(day / time.Millisecond)
+	extents := mockedCachedExtentsReporter{
+		0:     {{Start: 0, End: 3600 * seconds}},
+		dayMs: {{Start: dayMs, End: dayMs + 60*seconds}},
+	}
+	limits, err := validation.NewOverrides(*defaultLimits, nil)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		req      queryrange.Request
+		expected [][2]int64
+	}{
+		{
+			name: "split at the cached extents",
+			req:  &ThanosQueryRangeRequest{Start: 0, End: 2*dayMs - 15*seconds, Step: 15 * seconds, Query: "foo"},
+			expected: [][2]int64{
+				{0, 3600 * seconds}, {3600 * seconds, dayMs - 15*seconds},
+				{dayMs, dayMs + 60*seconds}, {dayMs + 60*seconds, 2*dayMs - 15*seconds},
+			},
+		},
+		{
+			name:     "extents not reported",
+			req:      &ThanosQueryRangeRequest{Start: -dayMs, End: -dayMs + 3600*seconds, Step: 15 * seconds, Query: "foo"},
+			expected: [][2]int64{{-dayMs, -dayMs + 3600*seconds}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mtx    sync.Mutex
+				ranges [][2]int64
+			)
+			next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				ranges = append(ranges, [2]int64{r.GetStart(), r.GetEnd()})
+				return &queryrange.PrometheusResponse{Status: queryrange.StatusSuccess}, nil
+			})
+			interval := func(queryrange.Request) time.Duration { return day }
+			mw := CacheAwareSplitByIntervalMiddleware(interval, limits, NewThanosQueryRangeCodec(true), extents, nil)
+
+			_, err := mw.Wrap(next).Do(user.InjectOrgID(context.Background(), "1"), tc.req)
+			require.NoError(t, err)
+			sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+			require.Equal(t, tc.expected, ranges)
+		})
+	}
+}
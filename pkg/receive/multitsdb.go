@@ -56,6 +56,9 @@ type MultiTSDB struct {
 	tenants               map[string]*tenant
 	allowOutOfOrderUpload bool
 	hashFunc              metadata.HashFunc
+
+	// Functions called with the ID of each removed tenant, protected by mtx.
+	tenantRemovedHooks []func(tenantID string)
 }
 
 // NewMultiTSDB creates new MultiTSDB.
@@ -248,12 +251,29 @@ func (t *MultiTSDB) Flush() error {
 	return merr.Err()
 }
 
+// OnTenantRemoved registers a function called with the ID of each tenant whose TSDB is pruned,
+// or closed along with the MultiTSDB, so that the state kept for the tenant can be released.
+// The function is called with the lock held, so it must not call the MultiTSDB.
+func (t *MultiTSDB) OnTenantRemoved(f func(tenantID string)) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.tenantRemovedHooks = append(t.tenantRemovedHooks, f)
+}
+
+// tenantRemoved calls the hooks for the removed tenant. It must be called with the lock held.
+func (t *MultiTSDB) tenantRemoved(tenantID string) {
+	for _, f := range t.tenantRemovedHooks {
+		f(tenantID)
+	}
+}
+
 func (t *MultiTSDB) Close() error {
 	t.mtx.Lock()
 	defer t.mtx.Unlock()
 
 	merr := errutil.MultiError{}
 	for id, tenant := range t.tenants {
+		t.tenantRemoved(id)
 		db := tenant.readyStorage().Get()
 		if db == nil {
 			level.Error(t.logger).Log("msg", "closing TSDB failed; not ready", "tenant", id)
@@ -313,6 +333,7 @@ func (t *MultiTSDB) Prune(ctx context.Context) error {
 
 		level.Info(t.logger).Log("msg", "Pruned tenant", "tenant", tenantID)
 		delete(t.tenants, tenantID)
+		t.tenantRemoved(tenantID)
 	}
 
 	return merr.Err()
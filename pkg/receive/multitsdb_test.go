@@ -447,6 +447,9 @@ func TestMultiTSDBPrune(t *testing.T) {
 			)
 			defer func() { testutil.Ok(t, m.Close()) }()
 
+			var removed []string
+			m.OnTenantRemoved(func(tenantID string) { removed = append(removed, tenantID) })
+
 			for i := 0; i < 100; i++ {
 				testutil.Ok(t, appendSample(m, "deleted-tenant", time.UnixMilli(int64(10+i))))
 				testutil.Ok(t, appendSample(m, "compacted-tenant", time.Now().Add(-4*time.Hour)))
@@ -456,6 +459,7 @@ func TestMultiTSDBPrune(t *testing.T) {
 
 			testutil.Ok(t, m.Prune(context.Background()))
 			testutil.Equals(t, test.expectedTenants, len(m.TSDBLocalClients()))
+			testutil.Equals(t, []string{"deleted-tenant"}, removed)
 
 			var shippedBlocks int
 			if test.bucket != nil {
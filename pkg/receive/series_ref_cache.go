@@ -0,0 +1,140 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"math"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// seriesRefCaches holds the series references cache of each tenant.
+type seriesRefCaches struct {
+	mtx     sync.Mutex
+	size    int
+	tenants map[string]*seriesRefCache
+
+	requests *prometheus.CounterVec
+	hits     *prometheus.CounterVec
+}
+
+func newSeriesRefCaches(reg prometheus.Registerer, size int) *seriesRefCaches {
+	return &seriesRefCaches{
+		size:    size,
+		tenants: map[string]*seriesRefCache{},
+		requests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_series_ref_cache_requests_total",
+			Help: "Total number of series references looked up in the write path cache.",
+		}, []string{"tenant"}),
+		hits: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_series_ref_cache_hits_total",
+			Help: "Total number of series references found in the write path cache.",
+		}, []string{"tenant"}),
+	}
+}
+
+// forTenant returns the cache of the tenant whose series are stored in the given TSDB,
+// purged if the TSDB has been replaced or its head truncated since it was last used.
+func (c *seriesRefCaches) forTenant(tenantID string, db *tsdb.DB) *seriesRefCache {
+	c.mtx.Lock()
+	tc, ok := c.tenants[tenantID]
+	if !ok {
+		l, _ := lru.NewLRU(c.size, nil)
+		tc = &seriesRefCache{
+			lru:      l,
+			requests: c.requests.WithLabelValues(tenantID),
+			hits:     c.hits.WithLabelValues(tenantID),
+		}
+		c.tenants[tenantID] = tc
+	}
+	c.mtx.Unlock()
+
+	tc.validate(db, db.Head().MinTime())
+	return tc
+}
+
+// remove drops the cache of the tenant, along with its metrics.
+func (c *seriesRefCaches) remove(tenantID string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.tenants, tenantID)
+	c.requests.DeleteLabelValues(tenantID)
+	c.hits.DeleteLabelValues(tenantID)
+}
+
+// seriesRefCache caches the references of the series recently appended to a tenant TSDB,
+// by hash of their labels, to skip the lookup of hot series in the TSDB head.
+type seriesRefCache struct {
+	mtx sync.Mutex
+	lru *lru.LRU
+
+	// The cached references are only valid for this TSDB, until its head is truncated.
+	db          *tsdb.DB
+	headMinTime int64
+
+	requests prometheus.Counter
+	hits     prometheus.Counter
+}
+
+type seriesRefCacheEntry struct {
+	ref  storage.SeriesRef
+	lset labels.Labels
+}
+
+// validate purges the cache if the references it holds might not be valid anymore. A new TSDB,
+// e.g. after the tenant has been pruned, starts the references over, so they must be dropped.
+// Within a TSDB, references are never reused, but the series they point to are removed when the
+// head is truncated, and appending with a reference to a removed series falls back to looking
+// up the labels: the cache is purged once the head min time has moved, other than by the first
+// samples appended to an empty head, to not keep those references around for nothing.
+func (c *seriesRefCache) validate(db *tsdb.DB, headMinTime int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.db == db && (c.headMinTime == headMinTime || c.headMinTime == math.MaxInt64) {
+		c.headMinTime = headMinTime
+		return
+	}
+	c.lru.Purge()
+	c.db = db
+	c.headMinTime = headMinTime
+}
+
+// get returns the reference and labels stored in the TSDB for the given labels, if cached.
+func (c *seriesRefCache) get(lset labels.Labels, hash uint64) (storage.SeriesRef, labels.Labels, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	v, ok := c.lru.Get(hash)
+	if !ok {
+		return 0, nil, false
+	}
+	// Guard against hash collisions.
+	e := v.(seriesRefCacheEntry)
+	if !labels.Equal(e.lset, lset) {
+		return 0, nil, false
+	}
+	return e.ref, e.lset, true
+}
+
+// set caches the reference of the series with the given labels, as stored in the TSDB.
+func (c *seriesRefCache) set(lset labels.Labels, hash uint64, ref storage.SeriesRef) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.lru.Add(hash, seriesRefCacheEntry{ref: ref, lset: lset})
+}
+
+// observe records the number of series looked up by a write request, and found, in the cache.
+func (c *seriesRefCache) observe(requests, hits int) {
+	c.requests.Add(float64(requests))
+	c.hits.Add(float64(hits))
+}
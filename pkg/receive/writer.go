@@ -9,7 +9,9 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 
@@ -26,18 +28,51 @@ type TenantStorage interface {
 	TenantAppendable(string) (Appendable, error)
 }
 
+// tenantRemovalNotifier is implemented by the TenantStorage notifying of the removed tenants.
+type tenantRemovalNotifier interface {
+	OnTenantRemoved(func(tenantID string))
+}
+
+// tsdbStorage is implemented by the tenant appendables backed by a TSDB.
+type tsdbStorage interface {
+	Get() *tsdb.DB
+}
+
+// WriterOptions holds the options of the Writer.
+type WriterOptions struct {
+	// Intern enables string interning of the labels of the series added to the TSDB.
+	Intern bool
+	// SeriesRefCacheSize is the maximum number of series references cached per tenant,
+	// to skip looking up hot series in the TSDB head. 0 disables the cache.
+	SeriesRefCacheSize int
+}
+
 type Writer struct {
 	logger    log.Logger
 	multiTSDB TenantStorage
 	intern    bool
+
+	seriesRefCaches *seriesRefCaches
 }
 
-func NewWriter(logger log.Logger, multiTSDB TenantStorage, intern bool) *Writer {
-	return &Writer{
+func NewWriter(logger log.Logger, reg prometheus.Registerer, multiTSDB TenantStorage, opts *WriterOptions) *Writer {
+	if opts == nil {
+		opts = &WriterOptions{}
+	}
+
+	w := &Writer{
 		logger:    logger,
 		multiTSDB: multiTSDB,
-		intern:    intern,
+		intern:    opts.Intern,
 	}
+	if opts.SeriesRefCacheSize > 0 {
+		w.seriesRefCaches = newSeriesRefCaches(reg, opts.SeriesRefCacheSize)
+		// Drop the caches of the removed tenants, which would keep their closed TSDB around.
+		if n, ok := multiTSDB.(tenantRemovalNotifier); ok {
+			n.OnTenantRemoved(w.seriesRefCaches.remove)
+		}
+	}
+	return w
 }
 
 func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteRequest) error {
@@ -72,9 +107,22 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 	}
 	getRef := app.(storage.GetRef)
 
+	// The cached references can only be trusted for TSDBs whose head truncations can be tracked.
+	var refCache *seriesRefCache
+	if r.seriesRefCaches != nil {
+		if ts, ok := s.(tsdbStorage); ok {
+			if db := ts.Get(); db != nil {
+				refCache = r.seriesRefCaches.forTenant(tenantID, db)
+			}
+		}
+	}
+
 	var (
 		ref  storage.SeriesRef
 		errs writeErrors
+
+		numRefCacheRequests = 0
+		numRefCacheHits     = 0
 	)
 	for _, t := range wreq.Timeseries {
 		// Check if time series labels are valid. If not, skip the time series
@@ -99,9 +147,21 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		}
 
 		lset := labelpb.ZLabelsToPromLabels(t.Labels)
+		hash := lset.Hash()
 
-		// Check if the TSDB has cached reference for those labels.
-		ref, lset = getRef.GetRef(lset, lset.Hash())
+		// Check if the writer, or else the TSDB, has cached reference for those labels.
+		cached := false
+		if refCache != nil {
+			var cachedLset labels.Labels
+			numRefCacheRequests++
+			if ref, cachedLset, cached = refCache.get(lset, hash); cached {
+				numRefCacheHits++
+				lset = cachedLset
+			}
+		}
+		if !cached {
+			ref, lset = getRef.GetRef(lset, hash)
+		}
 		if ref == 0 {
 			// If not, copy labels, as TSDB will hold those strings long term. Given no
 			// copy unmarshal we don't want to keep memory for whole protobuf, only for labels.
@@ -155,6 +215,10 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 			}
 		}
 
+		if refCache != nil && !cached && ref != 0 {
+			refCache.set(lset, hash, ref)
+		}
+
 		// Current implemetation of app.AppendExemplar doesn't create a new series, so it must be already present.
 		// We drop the exemplars in case the series doesn't exist.
 		if ref != 0 && len(t.Exemplars) > 0 {
@@ -188,6 +252,10 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		}
 	}
 
+	if refCache != nil {
+		refCache.observe(numRefCacheRequests, numRefCacheHits)
+	}
+
 	if numLabelsOutOfOrder > 0 {
 		level.Warn(tLogger).Log("msg", "Error on series with out-of-order labels", "numDropped", numLabelsOutOfOrder)
 		errs.Add(errors.Wrapf(labelpb.ErrOutOfOrderLabels, "add %d series", numLabelsOutOfOrder))
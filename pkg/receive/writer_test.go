@@ -13,11 +13,13 @@ import (
 	"github.com/go-kit/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
 
 	"github.com/efficientgo/core/testutil"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
@@ -299,7 +301,7 @@ func TestWriter(t *testing.T) {
 				return err
 			}))
 
-			w := NewWriter(logger, m, false)
+			w := NewWriter(logger, nil, m, &WriterOptions{})
 
 			for idx, req := range testData.reqs {
 				err = w.Write(context.Background(), DefaultTenant, req)
@@ -328,6 +330,107 @@ func TestWriter(t *testing.T) {
 	}
 }
 
+func TestWriter_SeriesRefCache(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewNopLogger()
+
+	m := NewMultiTSDB(dir, logger, prometheus.NewRegistry(), &tsdb.Options{
+		MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+		MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+		RetentionDuration: (6 * time.Hour).Milliseconds(),
+		NoLockfile:        true,
+	},
+		labels.FromStrings("replica", "01"),
+		"tenant_id",
+		nil,
+		false,
+		metadata.NoneFunc,
+	)
+	t.Cleanup(func() { testutil.Ok(t, m.Close()) })
+
+	testutil.Ok(t, m.Flush())
+	testutil.Ok(t, m.Open())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	app, err := m.TenantAppendable(DefaultTenant)
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, runutil.Retry(1*time.Second, ctx.Done(), func() error {
+		_, err = app.Appender(context.Background())
+		return err
+	}))
+
+	reg := prometheus.NewRegistry()
+	w := NewWriter(logger, reg, m, &WriterOptions{SeriesRefCacheSize: 10})
+
+	write := func(ts int64) {
+		testutil.Ok(t, w.Write(context.Background(), DefaultTenant, &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  []labelpb.ZLabel{{Name: "__name__", Value: "test"}, {Name: "a", Value: "1"}},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: ts}},
+				},
+				{
+					Labels:  []labelpb.ZLabel{{Name: "__name__", Value: "test"}, {Name: "a", Value: "2"}},
+					Samples: []prompb.Sample{{Value: 2, Timestamp: ts}},
+				},
+			},
+		}))
+	}
+	requests := w.seriesRefCaches.requests.WithLabelValues(DefaultTenant)
+	hits := w.seriesRefCaches.hits.WithLabelValues(DefaultTenant)
+
+	// The first write resolves the series from the TSDB, the following ones from the cache.
+	write(10)
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(requests))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(hits))
+
+	write(20)
+	testutil.Equals(t, 4.0, promtestutil.ToFloat64(requests))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(hits))
+
+	// Once the head is truncated, the cached references are dropped.
+	testutil.Ok(t, app.(*ReadyStorage).Get().Head().Truncate(15))
+
+	write(30)
+	testutil.Equals(t, 6.0, promtestutil.ToFloat64(requests))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(hits))
+
+	write(40)
+	testutil.Equals(t, 8.0, promtestutil.ToFloat64(requests))
+	testutil.Equals(t, 4.0, promtestutil.ToFloat64(hits))
+
+	// The samples have been appended to their series after the truncation.
+	q, err := app.(*ReadyStorage).Querier(context.Background(), 25, 100)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	set := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "test"))
+	var numSeries int
+	for set.Next() {
+		numSeries++
+		var ts []int64
+		it := set.At().Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			ts = append(ts, it.AtT())
+		}
+		testutil.Ok(t, it.Err())
+		testutil.Equals(t, []int64{30, 40}, ts)
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, 2, numSeries)
+
+	// Once the tenant is removed, its cache and metrics are dropped.
+	m.mtx.Lock()
+	m.tenantRemoved(DefaultTenant)
+	m.mtx.Unlock()
+	testutil.Equals(t, 0, len(w.seriesRefCaches.tenants))
+	testutil.Equals(t, 0, promtestutil.CollectAndCount(w.seriesRefCaches.requests))
+	testutil.Equals(t, 0, promtestutil.CollectAndCount(w.seriesRefCaches.hits))
+}
+
 func BenchmarkWriterTimeSeriesWithSingleLabel_10(b *testing.B)   { benchmarkWriter(b, 1, 10, false) }
 func BenchmarkWriterTimeSeriesWithSingleLabel_100(b *testing.B)  { benchmarkWriter(b, 1, 100, false) }
 func BenchmarkWriterTimeSeriesWithSingleLabel_1000(b *testing.B) { benchmarkWriter(b, 1, 1000, false) }
@@ -398,7 +501,7 @@ func benchmarkWriter(b *testing.B, labelsNum int, seriesNum int, generateHistogr
 	}
 
 	b.Run("without interning", func(b *testing.B) {
-		w := NewWriter(logger, m, false)
+		w := NewWriter(logger, nil, m, &WriterOptions{})
 
 		b.ReportAllocs()
 		b.ResetTimer()
@@ -409,7 +512,18 @@ func benchmarkWriter(b *testing.B, labelsNum int, seriesNum int, generateHistogr
 	})
 
 	b.Run("with interning", func(b *testing.B) {
-		w := NewWriter(logger, m, true)
+		w := NewWriter(logger, nil, m, &WriterOptions{Intern: true})
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			testutil.Ok(b, w.Write(ctx, "foo", wreq))
+		}
+	})
+
+	b.Run("with series ref cache", func(b *testing.B) {
+		w := NewWriter(logger, prometheus.NewRegistry(), m, &WriterOptions{SeriesRefCacheSize: seriesNum})
 
 		b.ReportAllocs()
 		b.ResetTimer()
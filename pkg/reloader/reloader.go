@@ -96,6 +96,7 @@ type Reloader struct {
 	lastCfgHash         []byte
 	lastWatchedDirsHash []byte
 	forceReload         bool
+	onReload            func()
 
 	reloads                    prometheus.Counter
 	reloadErrors               prometheus.Counter
@@ -378,6 +379,9 @@ func (r *Reloader) apply(ctx context.Context) error {
 			"watched_dirs", strings.Join(r.watchedDirs, ", "))
 		r.lastReloadSuccess.Set(1)
 		r.lastReloadSuccessTimestamp.SetToCurrentTime()
+		if r.onReload != nil {
+			r.onReload()
+		}
 		return nil
 	}); err != nil {
 		r.forceReload = true
@@ -434,6 +438,13 @@ func (r *Reloader) SetHttpClient(client http.Client) {
 	r.httpClient = client
 }
 
+// SetOnReload sets a function called after each successful reload of Prometheus,
+// e.g. to refresh what's derived from its configuration. It must be set before
+// starting to watch.
+func (r *Reloader) SetOnReload(f func()) {
+	r.onReload = f
+}
+
 // ReloadURLFromBase returns the standard Prometheus reload URL from its base URL.
 func ReloadURLFromBase(u *url.URL) *url.URL {
 	r := *u
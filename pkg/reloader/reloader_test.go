@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
@@ -697,3 +698,38 @@ config:
 	// Check no reload request made
 	testutil.Equals(t, 0, reloads.Load().(int))
 }
+
+func TestReloader_OnReload(t *testing.T) {
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	reloadURL, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	input := filepath.Join(t.TempDir(), "cfg.yaml")
+	testutil.Ok(t, os.WriteFile(input, []byte("a: 1"), os.ModePerm))
+
+	reloader := New(nil, nil, &Options{
+		ReloadURL:     reloadURL,
+		CfgFile:       input,
+		WatchInterval: time.Hour,
+		RetryInterval: 100 * time.Millisecond,
+	})
+	reloaded := 0
+	reloader.SetOnReload(func() { reloaded++ })
+
+	testutil.Ok(t, reloader.apply(ctx))
+	testutil.Equals(t, 1, reloaded)
+
+	// Nothing is reloaded while the config is unchanged.
+	testutil.Ok(t, reloader.apply(ctx))
+	testutil.Equals(t, 1, reloaded)
+
+	testutil.Ok(t, os.WriteFile(input, []byte("a: 2"), os.ModePerm))
+	testutil.Ok(t, reloader.apply(ctx))
+	testutil.Equals(t, 2, reloaded)
+}
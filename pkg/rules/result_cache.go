@@ -0,0 +1,216 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"golang.org/x/crypto/blake2b"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/cache"
+	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// QueryResultCacheProvider is the type of backend of the query result cache.
+type QueryResultCacheProvider string
+
+const (
+	InMemoryQueryResultCacheProvider  QueryResultCacheProvider = "IN-MEMORY"
+	MemcachedQueryResultCacheProvider QueryResultCacheProvider = "MEMCACHED"
+	RedisQueryResultCacheProvider     QueryResultCacheProvider = "REDIS"
+
+	queryResultCacheName = "rule-query-results"
+
+	rejectReasonClockSkew = "clock-skew"
+	rejectReasonMismatch  = "mismatch"
+	rejectReasonCorrupted = "corrupted"
+)
+
+// QueryResultCacheConfig is the configuration of the cache of the rule query results.
+type QueryResultCacheConfig struct {
+	Type          QueryResultCacheProvider `yaml:"type"`
+	BackendConfig interface{}              `yaml:"config"`
+
+	// TTL of the cached results. If zero, the default evaluation interval is used,
+	// given the results are only reused for the same evaluation timestamp.
+	TTL time.Duration `yaml:"ttl"`
+
+	// Maximum clock skew tolerated between the replicas. Results evaluated by a replica
+	// whose clock is ahead by more than that are discarded, given they may have been
+	// evaluated before the samples at the evaluation timestamp were all ingested.
+	MaxClockSkew time.Duration `yaml:"max_clock_skew"`
+}
+
+// QueryResultCache caches the results of the rule queries by expression and evaluation
+// timestamp in a cache shared by the ruler replicas, so that replicas evaluating identical
+// rule groups at the same timestamps reuse the peer results instead of querying again.
+type QueryResultCache struct {
+	logger       log.Logger
+	cache        cache.Cache
+	ttl          time.Duration
+	maxClockSkew time.Duration
+	now          func() time.Time
+
+	rejected *prometheus.CounterVec
+}
+
+// NewQueryResultCacheFromYaml makes a new QueryResultCache from its YAML configuration,
+// using defaultTTL as TTL if none is configured.
+func NewQueryResultCacheFromYaml(logger log.Logger, yamlContent []byte, defaultTTL time.Duration, reg prometheus.Registerer) (*QueryResultCache, error) {
+	config := QueryResultCacheConfig{MaxClockSkew: 10 * time.Second}
+	if err := yaml.UnmarshalStrict(yamlContent, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing config YAML file")
+	}
+	if config.TTL == 0 {
+		config.TTL = defaultTTL
+	}
+	if config.TTL < 0 || config.MaxClockSkew < 0 {
+		return nil, errors.New("query result cache TTL and max clock skew must not be negative")
+	}
+
+	backendConfig, err := yaml.Marshal(config.BackendConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of cache backend configuration")
+	}
+
+	var c cache.Cache
+	switch strings.ToUpper(string(config.Type)) {
+	case string(MemcachedQueryResultCacheProvider):
+		memcached, err := cacheutil.NewMemcachedClient(logger, queryResultCacheName, backendConfig, reg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create memcached client")
+		}
+		c = cache.NewMemcachedCache(queryResultCacheName, logger, memcached, reg)
+	case string(RedisQueryResultCacheProvider):
+		redis, err := cacheutil.NewRedisClient(logger, queryResultCacheName, backendConfig, reg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create redis client")
+		}
+		c = cache.NewRedisCache(queryResultCacheName, logger, redis, reg)
+	case string(InMemoryQueryResultCacheProvider):
+		c, err = cache.NewInMemoryCache(queryResultCacheName, logger, reg, backendConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create inmemory cache")
+		}
+	default:
+		return nil, errors.Errorf("unsupported cache type: %s", config.Type)
+	}
+
+	return NewQueryResultCache(logger, cache.NewTracingCache(c), config.TTL, config.MaxClockSkew, reg), nil
+}
+
+// NewQueryResultCache makes a new QueryResultCache storing the results to the given cache.
+func NewQueryResultCache(logger log.Logger, c cache.Cache, ttl, maxClockSkew time.Duration, reg prometheus.Registerer) *QueryResultCache {
+	rc := &QueryResultCache{
+		logger:       logger,
+		cache:        c,
+		ttl:          ttl,
+		maxClockSkew: maxClockSkew,
+		now:          time.Now,
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_rule_query_result_cache_rejected_total",
+			Help: "Total number of cached rule query results discarded instead of being reused, by reason.",
+		}, []string{"reason"}),
+	}
+	rc.rejected.WithLabelValues(rejectReasonClockSkew)
+	rc.rejected.WithLabelValues(rejectReasonMismatch)
+	rc.rejected.WithLabelValues(rejectReasonCorrupted)
+	return rc
+}
+
+// cachedQueryResult is a query result stored in the cache, along with the query it's
+// the result of and the time it was evaluated at, according to the evaluating replica.
+type cachedQueryResult struct {
+	Strategy    storepb.PartialResponseStrategy
+	Query       string
+	Timestamp   int64
+	EvaluatedAt int64
+	Vector      promql.Vector
+}
+
+// Wrap returns a QueryFunc looking up the results of the queries in the cache before
+// running them with queryFunc, storing the results so that they can be reused by peers.
+// Failed queries aren't cached.
+func (c *QueryResultCache) Wrap(strategy storepb.PartialResponseStrategy, queryFunc rules.QueryFunc) rules.QueryFunc {
+	return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		key := queryResultCacheKey(strategy, q, t)
+		if v, ok := c.fetch(ctx, key, strategy, q, t); ok {
+			return v, nil
+		}
+
+		v, err := queryFunc(ctx, q, t)
+		if err != nil {
+			return nil, err
+		}
+		c.store(ctx, key, cachedQueryResult{
+			Strategy:    strategy,
+			Query:       q,
+			Timestamp:   t.UnixMilli(),
+			EvaluatedAt: c.now().UnixMilli(),
+			Vector:      v,
+		})
+		return v, nil
+	}
+}
+
+func (c *QueryResultCache) fetch(ctx context.Context, key string, strategy storepb.PartialResponseStrategy, q string, t time.Time) (promql.Vector, bool) {
+	data, ok := c.cache.Fetch(ctx, []string{key})[key]
+	if !ok {
+		return nil, false
+	}
+
+	var res cachedQueryResult
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&res); err != nil {
+		level.Debug(c.logger).Log("msg", "failed to decode cached rule query result", "query", q, "err", err)
+		c.rejected.WithLabelValues(rejectReasonCorrupted).Inc()
+		return nil, false
+	}
+	// Guard against hash collisions.
+	if res.Strategy != strategy || res.Query != q || res.Timestamp != t.UnixMilli() {
+		c.rejected.WithLabelValues(rejectReasonMismatch).Inc()
+		return nil, false
+	}
+	// A result can't have been evaluated later than now, unless the clock of the replica which
+	// evaluated it is ahead, in which case it may have run before all the samples were ingested.
+	if res.EvaluatedAt > c.now().Add(c.maxClockSkew).UnixMilli() {
+		c.rejected.WithLabelValues(rejectReasonClockSkew).Inc()
+		return nil, false
+	}
+	return res.Vector, true
+}
+
+func (c *QueryResultCache) store(ctx context.Context, key string, res cachedQueryResult) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(res); err != nil {
+		level.Debug(c.logger).Log("msg", "failed to encode rule query result", "query", res.Query, "err", err)
+		return
+	}
+	c.cache.Store(ctx, map[string][]byte{key: buf.Bytes()}, c.ttl)
+}
+
+// queryResultCacheKey returns the cache key of the result of the query evaluated at t.
+func queryResultCacheKey(strategy storepb.PartialResponseStrategy, q string, t time.Time) string {
+	var tmp [binary.MaxVarintLen64]byte
+
+	d, _ := blake2b.New256(nil)
+	_, _ = d.Write(tmp[:binary.PutUvarint(tmp[:], uint64(strategy))])
+	_, _ = d.Write(tmp[:binary.PutVarint(tmp[:], t.UnixMilli())])
+	_, _ = d.Write([]byte(q))
+	return "RQ:" + base64.RawURLEncoding.EncodeToString(d.Sum(nil))
+}
@@ -0,0 +1,101 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/thanos/pkg/cache"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+func TestQueryResultCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	backend, err := cache.NewInMemoryCache("test", log.NewNopLogger(), nil, nil)
+	testutil.Ok(t, err)
+
+	newReplica := func(clock time.Time) (*QueryResultCache, *int, rules.QueryFunc) {
+		c := NewQueryResultCache(log.NewNopLogger(), backend, time.Minute, 10*time.Second, nil)
+		c.now = func() time.Time { return clock }
+
+		queries := 0
+		return c, &queries, c.Wrap(storepb.PartialResponseStrategy_WARN, func(_ context.Context, q string, t time.Time) (promql.Vector, error) {
+			queries++
+			if q == "fail" {
+				return nil, errors.New("query failed")
+			}
+			return promql.Vector{
+				{Point: promql.Point{T: t.UnixMilli(), V: 1}, Metric: labels.FromStrings("query", q)},
+				{Point: promql.Point{T: t.UnixMilli(), V: math.Inf(1)}, Metric: labels.FromStrings("query", q, "inf", "true")},
+			}, nil
+		})
+	}
+	_, queriesA, queryA := newReplica(now)
+	cacheB, queriesB, queryB := newReplica(now)
+
+	// A replica evaluating the same query at the same timestamp reuses the peer result.
+	expected, err := queryA(ctx, "up", now)
+	testutil.Ok(t, err)
+	v, err := queryB(ctx, "up", now)
+	testutil.Ok(t, err)
+	testutil.Equals(t, expected, v)
+	testutil.Equals(t, 1, *queriesA)
+	testutil.Equals(t, 0, *queriesB)
+
+	// Results evaluated at another timestamp or with another partial response strategy aren't reused.
+	_, err = queryB(ctx, "up", now.Add(time.Minute))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, *queriesB)
+
+	queryAbort := cacheB.Wrap(storepb.PartialResponseStrategy_ABORT, func(context.Context, string, time.Time) (promql.Vector, error) {
+		return promql.Vector{}, nil
+	})
+	v, err = queryAbort(ctx, "up", now)
+	testutil.Ok(t, err)
+	testutil.Equals(t, promql.Vector{}, v)
+
+	// Failed queries aren't cached.
+	_, err = queryA(ctx, "fail", now)
+	testutil.NotOk(t, err)
+	_, err = queryB(ctx, "fail", now)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 2, *queriesB)
+
+	// Results evaluated by a replica whose clock is too far ahead are discarded.
+	_, _, queryAhead := newReplica(now.Add(time.Minute))
+	_, err = queryAhead(ctx, "skewed", now)
+	testutil.Ok(t, err)
+	_, err = queryB(ctx, "skewed", now)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, *queriesB)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(cacheB.rejected.WithLabelValues(rejectReasonClockSkew)))
+}
+
+func TestNewQueryResultCacheFromYaml(t *testing.T) {
+	c, err := NewQueryResultCacheFromYaml(log.NewNopLogger(), []byte(`
+type: IN-MEMORY
+config:
+  max_size: 1MB
+  max_item_size: 1KB
+max_clock_skew: 5s
+`), time.Minute, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, time.Minute, c.ttl)
+	testutil.Equals(t, 5*time.Second, c.maxClockSkew)
+
+	_, err = NewQueryResultCacheFromYaml(log.NewNopLogger(), []byte(`type: UNKNOWN`), time.Minute, nil)
+	testutil.NotOk(t, err)
+}
@@ -38,7 +38,9 @@ import (
 	"github.com/prometheus/prometheus/tsdb/chunks"
 	"github.com/prometheus/prometheus/tsdb/encoding"
 	"github.com/prometheus/prometheus/tsdb/index"
+	promgate "github.com/prometheus/prometheus/util/gate"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -102,21 +104,30 @@ const (
 	labelEncode = "encode"
 	labelDecode = "decode"
 
+	chunkFetchHit     = "hit"
+	chunkFetchMiss    = "miss"
+	chunkFetchRefetch = "refetch"
+
 	minBlockSyncConcurrency = 1
 
 	enableChunkHashCalculation = true
 
 	// SeriesBatchSize is the default batch size when fetching series from object storage.
 	SeriesBatchSize = 10000
+
+	// indexCacheWarmingBatchSize is the max number of postings fetched at once while warming the index cache.
+	indexCacheWarmingBatchSize = 100
 )
 
 var (
 	errBlockSyncConcurrencyNotValid = errors.New("the block sync concurrency must be equal or greater than 1.")
+	errBlockLoadConcurrencyNotValid = errors.New("the block load concurrency must be equal or greater than 0.")
 	hashPool                        = sync.Pool{New: func() interface{} { return xxhash.New() }}
 )
 
 type bucketStoreMetrics struct {
 	blocksLoaded          prometheus.Gauge
+	blockLoadsInFlight    prometheus.Gauge
 	blockLoads            prometheus.Counter
 	blockLoadFailures     prometheus.Counter
 	lastLoadedBlock       prometheus.Gauge
@@ -145,6 +156,10 @@ type bucketStoreMetrics struct {
 	seriesFetchDuration   prometheus.Histogram
 	postingsFetchDuration prometheus.Histogram
 	chunkFetchDuration    prometheus.Histogram
+	chunkFetches          *prometheus.CounterVec
+
+	indexCacheWarmedEntries prometheus.Counter
+	postingsCoalesced       prometheus.Counter
 }
 
 func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
@@ -154,6 +169,10 @@ func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
 		Name: "thanos_bucket_store_block_loads_total",
 		Help: "Total number of remote block loading attempts.",
 	})
+	m.blockLoadsInFlight = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_bucket_store_block_loads_in_flight",
+		Help: "Number of remote blocks currently being loaded.",
+	})
 	m.blockLoadFailures = promauto.With(reg).NewCounter(prometheus.CounterOpts{
 		Name: "thanos_bucket_store_block_load_failures_total",
 		Help: "Total number of failed remote block loading attempts.",
@@ -285,17 +304,35 @@ func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
 		Buckets: []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120},
 	})
 
+	m.postingsCoalesced = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_postings_coalesced_total",
+		Help: "Total number of postings missing from the index cache whose fetch from the object storage was coalesced with the one of another request to the same block already in flight.",
+	})
+
 	m.chunkFetchDuration = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
 		Name:    "thanos_bucket_store_chunks_fetch_duration_seconds",
 		Help:    "The total time spent fetching chunks within a single request a store gateway.",
 		Buckets: []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120},
 	})
 
+	m.chunkFetches = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_chunk_fetches_total",
+		Help: "Total number of chunk ranges fetched by the store gateway, by whether they were entirely served by the chunks cache (hit), partially or entirely fetched from the object storage (miss), or fetched again because a chunk was bigger than estimated (refetch).",
+	}, []string{"result"})
+	m.chunkFetches.WithLabelValues(chunkFetchHit)
+	m.chunkFetches.WithLabelValues(chunkFetchMiss)
+	m.chunkFetches.WithLabelValues(chunkFetchRefetch)
+
 	m.emptyPostingCount = promauto.With(reg).NewCounter(prometheus.CounterOpts{
 		Name: "thanos_bucket_store_empty_postings_total",
 		Help: "Total number of empty postings when fetching block series.",
 	})
 
+	m.indexCacheWarmedEntries = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_warmed_entries_total",
+		Help: "Total number of postings fetched from the bucket and stored to the index cache while warming it.",
+	})
+
 	return &m
 }
 
@@ -319,6 +356,9 @@ type BucketStore struct {
 	dir             string
 	indexCache      storecache.IndexCache
 	indexReaderPool *indexheader.ReaderPool
+	// Index-headers of the blocks not held, whose index cache items are loaded by this store.
+	unheldIndexHeaders *unheldIndexHeaders
+
 	buffers         sync.Pool
 	chunkPool       pool.Bytes
 	seriesBatchSize int
@@ -332,6 +372,9 @@ type BucketStore struct {
 	debugLogging bool
 	// Number of goroutines to use when syncing blocks from object storage.
 	blockSyncConcurrency int
+	// Maximum number of blocks loaded concurrently, and gate enforcing it.
+	blockLoadConcurrency int
+	blockLoadGate        gate.Gate
 
 	// Query gate which limits the maximum amount of concurrent queries.
 	queryGate gate.Gate
@@ -344,7 +387,10 @@ type BucketStore struct {
 
 	// bytesLimiterFactory creates a new limiter used to limit the amount of bytes fetched/touched by each Series() call.
 	bytesLimiterFactory BytesLimiterFactory
-	partitioner         Partitioner
+	// cacheBytesLimiterFactory creates a new limiter used to limit the amount of bytes fetched from the index cache
+	// by each Series() call.
+	cacheBytesLimiterFactory BytesLimiterFactory
+	partitioner              Partitioner
 
 	filterConfig             *FilterConfig
 	advLabelSets             []labelpb.ZLabelSet
@@ -357,12 +403,25 @@ type BucketStore struct {
 	enableSeriesResponseHints bool
 
 	enableChunkHashCalculation bool
+
+	// Label names whose postings are proactively stored to the index cache by WarmIndexCache,
+	// and limiter of the number of postings fetched per second while doing it.
+	indexCacheWarmingLabelNames []string
+	indexCacheWarmingLimiter    *rate.Limiter
+
+	// Whether the label values of the series matching the LabelValues() matchers are cached.
+	cacheLabelValues bool
+	// Whether the label names of the series matching the LabelNames() matchers are cached.
+	cacheLabelNames bool
 }
 
 func (s *BucketStore) validate() error {
 	if s.blockSyncConcurrency < minBlockSyncConcurrency {
 		return errBlockSyncConcurrencyNotValid
 	}
+	if s.blockLoadConcurrency < 0 {
+		return errBlockLoadConcurrencyNotValid
+	}
 	return nil
 }
 
@@ -378,6 +437,16 @@ func (noopCache) FetchMultiSeries(_ context.Context, _ ulid.ULID, ids []storage.
 	return map[storage.SeriesRef][]byte{}, ids
 }
 
+func (noopCache) StoreLabelValues(context.Context, ulid.ULID, string, []*labels.Matcher, []byte) {}
+func (noopCache) FetchLabelValues(context.Context, ulid.ULID, string, []*labels.Matcher) ([]byte, bool) {
+	return nil, false
+}
+
+func (noopCache) StoreLabelNames(context.Context, ulid.ULID, []*labels.Matcher, []byte) {}
+func (noopCache) FetchLabelNames(context.Context, ulid.ULID, []*labels.Matcher) ([]byte, bool) {
+	return nil, false
+}
+
 // BucketStoreOption are functions that configure BucketStore.
 type BucketStoreOption func(s *BucketStore)
 
@@ -442,6 +511,54 @@ func WithSeriesBatchSize(seriesBatchSize int) BucketStoreOption {
 	}
 }
 
+// WithIndexCacheWarming sets the label names whose postings are stored to the index cache
+// by WarmIndexCache, fetching at most postingsPerSecond postings per second. If postingsPerSecond
+// is 0, the warming is not rate limited.
+func WithIndexCacheWarming(labelNames []string, postingsPerSecond float64) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.indexCacheWarmingLabelNames = labelNames
+		if postingsPerSecond > 0 {
+			s.indexCacheWarmingLimiter = rate.NewLimiter(rate.Limit(postingsPerSecond), indexCacheWarmingBatchSize)
+		}
+	}
+}
+
+// WithLabelValuesCache enables caching the label values of the series matching the matchers of
+// LabelValues() calls in the index cache, for each block the request covers entirely. Calls
+// without matchers are answered from the index header, and are never cached.
+func WithLabelValuesCache(enabled bool) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.cacheLabelValues = enabled
+	}
+}
+
+// WithLabelNamesCache enables caching the label names of the series matching the matchers of
+// LabelNames() calls in the index cache, for each block the request covers entirely. Calls
+// without matchers are answered from the index header, and are never cached.
+func WithLabelNamesCache(enabled bool) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.cacheLabelNames = enabled
+	}
+}
+
+// WithBlockLoadConcurrency sets the maximum number of blocks loaded concurrently, so that
+// loading all blocks on startup doesn't overwhelm the index cache. If 0, the number of
+// blocks loaded concurrently is only bounded by the block sync concurrency.
+func WithBlockLoadConcurrency(blockLoadConcurrency int) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.blockLoadConcurrency = blockLoadConcurrency
+	}
+}
+
+// WithCacheBytesLimiterFactory sets the factory of the limiters used to limit the amount of bytes
+// fetched from the index cache by each Series() call, which fails with a ResourceExhausted error
+// once exceeded.
+func WithCacheBytesLimiterFactory(cacheBytesLimiterFactory BytesLimiterFactory) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.cacheBytesLimiterFactory = cacheBytesLimiterFactory
+	}
+}
+
 // NewBucketStore creates a new bucket backed store that implements the store API against
 // an object store bucket. It is optimized to work against high latency backends.
 func NewBucketStore(
@@ -478,6 +595,7 @@ func NewBucketStore(
 		chunksLimiterFactory:        chunksLimiterFactory,
 		seriesLimiterFactory:        seriesLimiterFactory,
 		bytesLimiterFactory:         bytesLimiterFactory,
+		cacheBytesLimiterFactory:    NewBytesLimiterFactory(0),
 		partitioner:                 partitioner,
 		enableCompatibilityLabel:    enableCompatibilityLabel,
 		postingOffsetsInMemSampling: postingOffsetsInMemSampling,
@@ -493,8 +611,19 @@ func NewBucketStore(
 	// Depend on the options
 	indexReaderPoolMetrics := indexheader.NewReaderPoolMetrics(extprom.WrapRegistererWithPrefix("thanos_bucket_store_", s.reg))
 	s.indexReaderPool = indexheader.NewReaderPool(s.logger, lazyIndexReaderEnabled, lazyIndexReaderIdleTimeout, indexReaderPoolMetrics)
+	unheldIndexHeadersDir := ""
+	if s.dir != "" {
+		unheldIndexHeadersDir = filepath.Join(s.dir, unheldIndexHeadersDirname)
+	}
+	s.unheldIndexHeaders = newUnheldIndexHeaders(s.logger, s.bkt, unheldIndexHeadersDir, s.postingOffsetsInMemSampling)
 	s.metrics = newBucketStoreMetrics(s.reg) // TODO(metalmatze): Might be possible via Option too
 
+	s.blockLoadGate = gate.NewNoop()
+	if s.blockLoadConcurrency > 0 {
+		s.blockLoadGate = promgate.New(s.blockLoadConcurrency)
+	}
+	s.blockLoadGate = gate.InstrumentGateInFlight(s.metrics.blockLoadsInFlight, s.blockLoadGate)
+
 	if err := s.validate(); err != nil {
 		return nil, errors.Wrap(err, "validate config")
 	}
@@ -520,6 +649,7 @@ func (s *BucketStore) Close() (err error) {
 	}
 
 	s.indexReaderPool.Close()
+	s.unheldIndexHeaders.close()
 	return err
 }
 
@@ -539,7 +669,7 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		wg.Add(1)
 		go func() {
 			for meta := range blockc {
-				if err := s.addBlock(ctx, meta); err != nil {
+				if err := s.loadBlock(ctx, meta); err != nil {
 					continue
 				}
 			}
@@ -626,15 +756,201 @@ func (s *BucketStore) InitialSync(ctx context.Context) error {
 		}
 	}
 
+	return s.unheldIndexHeaders.removeStale()
+}
+
+// WarmIndexCache proactively fetches from the bucket the postings of the configured label names
+// for all the loaded blocks, storing them to the index cache so that the first queries after
+// a restart don't hit a cold cache. Postings already in the cache are not fetched again, while the
+// fetched ones are stored synchronously, so that they're in the cache once it returns. It returns as
+// soon as the context is canceled.
+func (s *BucketStore) WarmIndexCache(ctx context.Context) error {
+	if len(s.indexCacheWarmingLabelNames) == 0 {
+		return nil
+	}
+
+	s.mtx.RLock()
+	ids := make([]ulid.ULID, 0, len(s.blocks))
+	for id := range s.blocks {
+		ids = append(ids, id)
+	}
+	s.mtx.RUnlock()
+
+	for _, id := range ids {
+		if err := s.warmBlockIndexCache(ctx, id); err != nil {
+			return errors.Wrapf(err, "warm index cache for block %s", id)
+		}
+	}
 	return nil
 }
 
+func (s *BucketStore) warmBlockIndexCache(ctx context.Context, id ulid.ULID) error {
+	// Get the index reader while holding the lock, so that the block can't be closed
+	// in the meanwhile if it gets dropped by a concurrent sync.
+	s.mtx.RLock()
+	b, ok := s.blocks[id]
+	if !ok {
+		s.mtx.RUnlock()
+		return nil
+	}
+	indexr := b.indexReader()
+	s.mtx.RUnlock()
+	defer runutil.CloseWithLogOnErr(s.logger, indexr, "warm index cache index reader")
+
+	// The warmed postings are stored synchronously, so that the block is warm once this returns.
+	indexr.syncPostingsStores = true
+
+	bytesLimiter := NewBytesLimiterFactory(0)(nil)
+	for _, name := range s.indexCacheWarmingLabelNames {
+		values, err := b.indexHeaderReader.LabelValues(name)
+		if err != nil {
+			return errors.Wrapf(err, "label values of %s", name)
+		}
+
+		for start := 0; start < len(values); start += indexCacheWarmingBatchSize {
+			end := start + indexCacheWarmingBatchSize
+			if end > len(values) {
+				end = len(values)
+			}
+
+			keys := make([]labels.Label, 0, end-start)
+			for _, value := range values[start:end] {
+				keys = append(keys, labels.Label{Name: name, Value: value})
+			}
+
+			if s.indexCacheWarmingLimiter != nil {
+				if err := s.indexCacheWarmingLimiter.WaitN(ctx, len(keys)); err != nil {
+					return err
+				}
+			} else if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// Postings missing from the cache are fetched from the bucket and stored to the cache.
+			fetched := indexr.stats.postingsFetched
+			if _, err := indexr.fetchPostings(ctx, keys, bytesLimiter); err != nil {
+				return errors.Wrapf(err, "fetch postings of %s", name)
+			}
+			s.metrics.indexCacheWarmedEntries.Add(float64(indexr.stats.postingsFetched - fetched))
+		}
+	}
+	return nil
+}
+
+// heldIndexReaderOf returns the index reader of the block if held by the store, holding the lock
+// so that the block can't be closed in the meanwhile if it gets dropped by a concurrent sync.
+func (s *BucketStore) heldIndexReaderOf(id ulid.ULID) (*bucketBlock, *bucketIndexReader, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	b, ok := s.blocks[id]
+	if !ok {
+		return nil, nil, false
+	}
+	return b, b.indexReader(), true
+}
+
+// readUnheldIndexRange reads a range of the index of a block not held by the store.
+func (s *BucketStore) readUnheldIndexRange(ctx context.Context, id ulid.ULID, off, length int64) ([]byte, error) {
+	r, err := s.bkt.GetRange(ctx, path.Join(id.String(), block.IndexFilename), off, length)
+	if err != nil {
+		return nil, errors.Wrap(err, "get range reader")
+	}
+	defer runutil.CloseWithLogOnErr(s.logger, r, "readUnheldIndexRange close range reader")
+
+	buf := bytes.NewBuffer(make([]byte, 0, length+bytes.MinRead))
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, errors.Wrap(err, "read range")
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadPostings loads the postings of a label of a block from the object storage, encoded as
+// they're stored to the index cache. It implements storecache.IndexCacheLoader. The blocks not
+// held by the store are loaded too, with their index-header built from the object storage.
+func (s *BucketStore) LoadPostings(ctx context.Context, blockID ulid.ULID, l labels.Label) ([]byte, error) {
+	var (
+		ptr       index.Range
+		readRange func(ctx context.Context, off, length int64) ([]byte, error)
+		err       error
+	)
+	if b, indexr, ok := s.heldIndexReaderOf(blockID); ok {
+		defer runutil.CloseWithLogOnErr(s.logger, indexr, "load postings index reader")
+		ptr, err = b.indexHeaderReader.PostingsOffset(l.Name, l.Value)
+		readRange = b.readIndexRange
+	} else {
+		ptr, err = s.unheldIndexHeaders.postingsOffset(ctx, blockID, l)
+		readRange = func(ctx context.Context, off, length int64) ([]byte, error) {
+			return s.readUnheldIndexRange(ctx, blockID, off, length)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "index header PostingsOffset")
+	}
+	data, err := readRange(ctx, ptr.Start, ptr.End-ptr.Start)
+	if err != nil {
+		return nil, errors.Wrap(err, "read postings range")
+	}
+	// index-header can estimate endings, which means we need to resize the endings.
+	pBytes, err := resizePostings(data)
+	if err != nil {
+		return nil, err
+	}
+	bep := newBigEndianPostings(pBytes[4:])
+	if encoded, err := encodePostingsForCache(bep, bep.length()); err == nil {
+		return encoded, nil
+	}
+	// Store the original bytes if the postings can't be reencoded, as fetchPostings does.
+	return pBytes, nil
+}
+
+// LoadSeries loads a series of a block from the object storage, as it's stored to the index
+// cache. It implements storecache.IndexCacheLoader. The blocks not held by the store are loaded
+// too, given the series are read at their reference without index-header.
+func (s *BucketStore) LoadSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef) ([]byte, error) {
+	readRange := func(ctx context.Context, off, length int64) ([]byte, error) {
+		return s.readUnheldIndexRange(ctx, blockID, off, length)
+	}
+	if b, indexr, ok := s.heldIndexReaderOf(blockID); ok {
+		defer runutil.CloseWithLogOnErr(s.logger, indexr, "load series index reader")
+		readRange = b.readIndexRange
+	}
+
+	data, err := readRange(ctx, int64(id), maxSeriesSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "read series range")
+	}
+	l, n := binary.Uvarint(data)
+	if n < 1 {
+		return nil, errors.New("reading series length failed")
+	}
+	if len(data) < n+int(l) {
+		// Inefficient, but should be rare.
+		if data, err = readRange(ctx, int64(id), int64(n+int(l))); err != nil {
+			return nil, errors.Wrap(err, "read series range")
+		}
+		if len(data) < n+int(l) {
+			return nil, errors.Errorf("invalid remaining size, even after refetch, remaining: %d, expected %d", len(data), n+int(l))
+		}
+	}
+	return data[n : n+int(l)], nil
+}
+
 func (s *BucketStore) getBlock(id ulid.ULID) *bucketBlock {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 	return s.blocks[id]
 }
 
+// loadBlock adds the block, waiting for its turn if the max number of blocks are already being loaded.
+func (s *BucketStore) loadBlock(ctx context.Context, meta *metadata.Meta) error {
+	if err := s.blockLoadGate.Start(ctx); err != nil {
+		return err
+	}
+	defer s.blockLoadGate.Done()
+
+	return s.addBlock(ctx, meta)
+}
+
 func (s *BucketStore) addBlock(ctx context.Context, meta *metadata.Meta) (err error) {
 	var dir string
 	if s.dir != "" {
@@ -735,6 +1051,7 @@ func (s *BucketStore) removeBlock(id ulid.ULID) error {
 	}
 
 	s.metrics.blocksLoaded.Dec()
+	storecache.ForgetBlock(s.indexCache, id)
 	if err := b.Close(); err != nil {
 		return errors.Wrap(err, "close block")
 	}
@@ -867,6 +1184,7 @@ func newBlockSeriesClient(
 	req *storepb.SeriesRequest,
 	limiter ChunksLimiter,
 	bytesLimiter BytesLimiter,
+	cacheBytesLimiter BytesLimiter,
 	shardMatcher *storepb.ShardMatcher,
 	calculateChunkHash bool,
 	batchSize int,
@@ -883,13 +1201,18 @@ func newBlockSeriesClient(
 		extLset = rmLabels(extLset.Copy(), extLsetToRemove)
 	}
 
+	indexr := b.indexReader()
+	if cacheBytesLimiter != nil {
+		indexr.cacheBytesLimiter = cacheBytesLimiter
+	}
+
 	return &blockSeriesClient{
 		ctx:                ctx,
 		logger:             logger,
 		extLset:            extLset,
 		mint:               req.MinTime,
 		maxt:               req.MaxTime,
-		indexr:             b.indexReader(),
+		indexr:             indexr,
 		chunkr:             chunkr,
 		chunksLimiter:      limiter,
 		bytesLimiter:       bytesLimiter,
@@ -1194,16 +1517,17 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 	req.MaxTime = s.limitMaxTime(req.MaxTime)
 
 	var (
-		bytesLimiter     = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes"))
-		ctx              = srv.Context()
-		stats            = &queryStats{}
-		respSets         []respSet
-		mtx              sync.Mutex
-		g, gctx          = errgroup.WithContext(ctx)
-		resHints         = &hintspb.SeriesResponseHints{}
-		reqBlockMatchers []*labels.Matcher
-		chunksLimiter    = s.chunksLimiterFactory(s.metrics.queriesDropped.WithLabelValues("chunks"))
-		seriesLimiter    = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series"))
+		bytesLimiter      = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes"))
+		cacheBytesLimiter = s.cacheBytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("cache_bytes"))
+		ctx               = srv.Context()
+		stats             = &queryStats{}
+		respSets          []respSet
+		mtx               sync.Mutex
+		g, gctx           = errgroup.WithContext(ctx)
+		resHints          = &hintspb.SeriesResponseHints{}
+		reqBlockMatchers  []*labels.Matcher
+		chunksLimiter     = s.chunksLimiterFactory(s.metrics.queriesDropped.WithLabelValues("chunks"))
+		seriesLimiter     = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series"))
 	)
 
 	if req.Hints != nil {
@@ -1257,6 +1581,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 				req,
 				chunksLimiter,
 				bytesLimiter,
+				cacheBytesLimiter,
 				shardMatcher,
 				s.enableChunkHashCalculation,
 				s.seriesBatchSize,
@@ -1484,7 +1809,16 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 			defer span.Finish()
 			defer runutil.CloseWithLogOnErr(s.logger, indexr, "label names")
 
+			// The names of the series matching the matchers only depend on the block
+			// if the request covers the whole block, in which case they are cached.
+			cacheable := s.cacheLabelNames && len(reqSeriesMatchersNoExtLabels) > 0 && req.Start <= b.meta.MinTime && req.End >= b.meta.MaxTime
+
 			var result []string
+			var cached bool
+			if cacheable {
+				result, cached = s.fetchCachedLabelNames(newCtx, b, reqSeriesMatchersNoExtLabels)
+			}
+
 			if len(reqSeriesMatchersNoExtLabels) == 0 {
 				// Do it via index reader to have pending reader registered correctly.
 				// LabelNames are already sorted.
@@ -1502,7 +1836,7 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 				}
 
 				result = strutil.MergeSlices(res, extRes)
-			} else {
+			} else if !cached {
 				seriesReq := &storepb.SeriesRequest{
 					MinTime:    req.Start,
 					MaxTime:    req.End,
@@ -1516,6 +1850,7 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 					nil,
 					bytesLimiter,
 					nil,
+					nil,
 					true,
 					SeriesBatchSize,
 					s.metrics.chunkFetchDuration,
@@ -1559,6 +1894,10 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 					result = append(result, n)
 				}
 				sort.Strings(result)
+
+				if cacheable {
+					b.indexCache.StoreLabelNames(newCtx, b.meta.ULID, reqSeriesMatchersNoExtLabels, encodeLabelValues(result))
+				}
 			}
 
 			if len(result) > 0 {
@@ -1676,7 +2015,16 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 			defer span.Finish()
 			defer runutil.CloseWithLogOnErr(s.logger, indexr, "label values")
 
+			// The values of the series matching the matchers only depend on the block
+			// if the request covers the whole block, in which case they are cached if enabled.
+			cacheable := s.cacheLabelValues && len(reqSeriesMatchersNoExtLabels) > 0 && req.Start <= b.meta.MinTime && req.End >= b.meta.MaxTime
+
 			var result []string
+			var cached bool
+			if cacheable {
+				result, cached = s.fetchCachedLabelValues(newCtx, b, req.Label, reqSeriesMatchersNoExtLabels)
+			}
+
 			if len(reqSeriesMatchersNoExtLabels) == 0 {
 				// Do it via index reader to have pending reader registered correctly.
 				res, err := indexr.block.indexHeaderReader.LabelValues(req.Label)
@@ -1689,7 +2037,7 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 					res = strutil.MergeSlices(res, []string{extLabelValue})
 				}
 				result = res
-			} else {
+			} else if !cached {
 				seriesReq := &storepb.SeriesRequest{
 					MinTime:    req.Start,
 					MaxTime:    req.End,
@@ -1703,6 +2051,7 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 					nil,
 					bytesLimiter,
 					nil,
+					nil,
 					true,
 					SeriesBatchSize,
 					s.metrics.chunkFetchDuration,
@@ -1747,6 +2096,10 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 					result = append(result, n)
 				}
 				sort.Strings(result)
+
+				if cacheable {
+					b.indexCache.StoreLabelValues(newCtx, b.meta.ULID, req.Label, reqSeriesMatchersNoExtLabels, encodeLabelValues(result))
+				}
 			}
 
 			if len(result) > 0 {
@@ -1776,6 +2129,77 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 	}, nil
 }
 
+// fetchCachedLabelValues fetches from the index cache the values of the label name for the
+// series of the block matching the matchers. Cached values failing to be decoded are misses.
+func (s *BucketStore) fetchCachedLabelValues(ctx context.Context, b *bucketBlock, labelName string, matchers []*labels.Matcher) ([]string, bool) {
+	v, ok := b.indexCache.FetchLabelValues(ctx, b.meta.ULID, labelName, matchers)
+	if !ok {
+		return nil, false
+	}
+
+	values, err := decodeLabelValues(v)
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "failed to decode cached label values", "block", b.meta.ULID, "label", labelName, "err", err)
+		return nil, false
+	}
+	return values, true
+}
+
+// fetchCachedLabelNames fetches from the index cache the label names of the series of the block
+// matching the matchers. Cached names failing to be decoded are misses.
+func (s *BucketStore) fetchCachedLabelNames(ctx context.Context, b *bucketBlock, matchers []*labels.Matcher) ([]string, bool) {
+	v, ok := b.indexCache.FetchLabelNames(ctx, b.meta.ULID, matchers)
+	if !ok {
+		return nil, false
+	}
+
+	// Label names are encoded the same way as label values.
+	names, err := decodeLabelValues(v)
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "failed to decode cached label names", "block", b.meta.ULID, "err", err)
+		return nil, false
+	}
+	return names, true
+}
+
+// encodeLabelValues encodes the label values to be stored in the index cache, as the
+// number of values followed by each value prefixed by its length.
+func encodeLabelValues(values []string) []byte {
+	e := encoding.Encbuf{}
+	e.PutUvarint(len(values))
+	for _, v := range values {
+		e.PutUvarintStr(v)
+	}
+	return e.Get()
+}
+
+// decodeLabelValues decodes the label values encoded by encodeLabelValues.
+func decodeLabelValues(b []byte) ([]string, error) {
+	d := encoding.Decbuf{B: b}
+	n := d.Uvarint()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	// Each value takes at least the byte of its length, so that a corrupted number of values
+	// can't make the preallocation exceed the size of the encoded ones.
+	if n > d.Len() {
+		return nil, errors.Errorf("%d values can't fit in %d bytes", n, d.Len())
+	}
+
+	values := make([]string, 0, n)
+	for i := 0; i < n && d.Err() == nil; i++ {
+		// Copy the value, so that it doesn't retain the cached buffer.
+		values = append(values, string(d.UvarintBytes()))
+	}
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Len() > 0 {
+		return nil, errors.Errorf("%d unexpected trailing bytes", d.Len())
+	}
+	return values, nil
+}
+
 // bucketBlockSet holds all blocks of an equal label set. It internally splits
 // them up by downsampling resolution and allows querying.
 type bucketBlockSet struct {
@@ -1931,6 +2355,9 @@ type bucketBlock struct {
 
 	partitioner Partitioner
 
+	// Postings being fetched from the object storage, shared by the concurrent requests missing them.
+	postingsInFlight inFlightPostings
+
 	// Block's labels used by block-level matchers to filter blocks to query. These are used to select blocks using
 	// request hints' BlockMatchers.
 	relabelLabels labels.Labels
@@ -2085,6 +2512,13 @@ type bucketIndexReader struct {
 	dec   *index.Decoder
 	stats *queryStats
 
+	// cacheBytesLimiter limits the amount of bytes fetched from the index cache.
+	cacheBytesLimiter BytesLimiter
+
+	// syncPostingsStores makes the postings fetched from the bucket stored to the index cache
+	// synchronously, failing the fetch if they can't be stored, e.g. when warming the cache.
+	syncPostingsStores bool
+
 	mtx          sync.Mutex
 	loadedSeries map[storage.SeriesRef][]byte
 }
@@ -2095,11 +2529,21 @@ func newBucketIndexReader(block *bucketBlock) *bucketIndexReader {
 		dec: &index.Decoder{
 			LookupSymbol: block.indexHeaderReader.LookupSymbol,
 		},
-		stats:        &queryStats{},
-		loadedSeries: map[storage.SeriesRef][]byte{},
+		stats:             &queryStats{},
+		cacheBytesLimiter: NewBytesLimiterFactory(0)(nil),
+		loadedSeries:      map[storage.SeriesRef][]byte{},
 	}
 	return r
 }
+
+// reserveCacheBytes reserves the bytes fetched from the index cache out of the cache bytes limit.
+func (r *bucketIndexReader) reserveCacheBytes(n int) error {
+	if err := r.cacheBytesLimiter.Reserve(uint64(n)); err != nil {
+		return httpgrpc.Errorf(int(codes.ResourceExhausted), "exceeded index cache bytes limit: %s", err)
+	}
+	return nil
+}
+
 func (r *bucketIndexReader) reset() {
 	r.loadedSeries = map[storage.SeriesRef][]byte{}
 }
@@ -2299,6 +2743,57 @@ type postingPtr struct {
 	ptr   index.Range
 }
 
+// inFlightPostings tracks the postings of a block being fetched from the object storage, so that
+// concurrent requests missing the same postings in the index cache share a single fetch, and
+// a single store to the index cache. The zero value is ready to use.
+type inFlightPostings struct {
+	mtx   sync.Mutex
+	calls map[labels.Label]*postingsCall
+}
+
+// postingsCall is an in-flight fetch of the postings of a label, whose result is set once done is closed.
+type postingsCall struct {
+	done chan struct{}
+	// Raw postings, including their length, if the fetch succeeded.
+	data []byte
+	err  error
+}
+
+// postingsWaiter is a key whose postings are being fetched by another request.
+type postingsWaiter struct {
+	keyID int
+	call  *postingsCall
+}
+
+var errPostingsFetchAborted = errors.New("postings fetch aborted")
+
+// join returns the in-flight fetch of the postings of the label, creating it if there's none,
+// along with whether it has been created, in which case the caller must complete it.
+func (f *inFlightPostings) join(l labels.Label) (*postingsCall, bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if call, ok := f.calls[l]; ok {
+		return call, false
+	}
+	if f.calls == nil {
+		f.calls = map[labels.Label]*postingsCall{}
+	}
+	call := &postingsCall{done: make(chan struct{})}
+	f.calls[l] = call
+	return call, true
+}
+
+// complete sets the result of the fetch of the postings of the label and releases its waiters.
+func (f *inFlightPostings) complete(l labels.Label, call *postingsCall, data []byte, err error) {
+	f.mtx.Lock()
+	delete(f.calls, l)
+	f.mtx.Unlock()
+
+	call.data, call.err = data, err
+	close(call.done)
+}
+
 // fetchPostings fill postings requested by posting groups.
 // It returns one postings for each key, in the same order.
 // If postings for given key is not fetched, entry at given index will be nil.
@@ -2306,7 +2801,21 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 	timer := prometheus.NewTimer(r.block.metrics.postingsFetchDuration)
 	defer timer.ObserveDuration()
 
-	var ptrs []postingPtr
+	var (
+		ptrs []postingPtr
+
+		// Postings missing from the index cache which are fetched by this request, keyed by key ID,
+		// and the ones already being fetched by another request.
+		leading = map[int]*postingsCall{}
+		waiting []postingsWaiter
+	)
+
+	// The fetches which have not been completed, because of a failure, are released anyway.
+	defer func() {
+		for keyID, call := range leading {
+			r.block.postingsInFlight.complete(keys[keyID], call, nil, errPostingsFetchAborted)
+		}
+	}()
 
 	output := make([]index.Postings, len(keys))
 
@@ -2316,6 +2825,9 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 		if err := bytesLimiter.Reserve(uint64(len(dataFromCache))); err != nil {
 			return nil, errors.Wrap(err, "bytes limit exceeded while loading postings from index cache")
 		}
+		if err := r.reserveCacheBytes(len(dataFromCache)); err != nil {
+			return nil, errors.Wrap(err, "loading postings from index cache")
+		}
 	}
 
 	// Iterate over all groups and fetch posting from cache.
@@ -2341,6 +2853,17 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 				if err != nil {
 					r.stats.cachedPostingsDecompressionErrors += 1
 				}
+			} else if isDiffVarintSnappyStreamedEncodedPostings(b) {
+				// Large postings are decompressed on demand while iterated, so is the decompression time accounted.
+				l, err = diffVarintSnappyStreamedDecode(b, func(d time.Duration) {
+					r.mtx.Lock()
+					r.stats.CachedPostingsDecompressionTimeSum += d
+					r.mtx.Unlock()
+				})
+				r.stats.cachedPostingsDecompressions += 1
+				if err != nil {
+					r.stats.cachedPostingsDecompressionErrors += 1
+				}
 			} else {
 				_, l, err = r.dec.Postings(b)
 			}
@@ -2365,6 +2888,14 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 			return nil, errors.Wrap(err, "index header PostingsOffset")
 		}
 
+		call, leader := r.block.postingsInFlight.join(key)
+		if !leader {
+			r.block.metrics.postingsCoalesced.Inc()
+			waiting = append(waiting, postingsWaiter{keyID: ix, call: call})
+			continue
+		}
+		leading[ix] = call
+
 		r.stats.postingsToFetch++
 		ptrs = append(ptrs, postingPtr{ptr: ptr, keyID: ix})
 	}
@@ -2388,7 +2919,7 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 		}
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
+	g, gctx := errgroup.WithContext(ctx)
 	for _, part := range parts {
 		i, j := part.ElemRng[0], part.ElemRng[1]
 
@@ -2397,10 +2928,10 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 		length := int64(part.End) - start
 
 		// Fetch from object storage concurrently and update stats and posting list.
-		g.Go(func() error {
+		g.Go(func() (err error) {
 			begin := time.Now()
 
-			b, err := r.block.readIndexRange(ctx, start, length)
+			b, err := r.block.readIndexRange(gctx, start, length)
 			if err != nil {
 				return errors.Wrap(err, "read postings range")
 			}
@@ -2413,6 +2944,22 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 			r.stats.PostingsFetchedSizeSum += units.Base2Bytes(int(length))
 			r.mtx.Unlock()
 
+			toCache := make(map[labels.Label][]byte, j-i)
+			defer func() {
+				if len(toCache) == 0 {
+					return
+				}
+				if !r.syncPostingsStores {
+					storecache.StoreMultiPostings(gctx, r.block.indexCache, r.block.meta.ULID, toCache)
+					return
+				}
+				for l, v := range toCache {
+					if storeErr := storecache.StorePostingsSync(gctx, r.block.indexCache, r.block.meta.ULID, l, v); storeErr != nil && err == nil {
+						err = errors.Wrapf(storeErr, "store postings of %s", l)
+					}
+				}
+			}()
+
 			for _, p := range ptrs[i:j] {
 				// index-header can estimate endings, which means we need to resize the endings.
 				pBytes, err := resizePostings(b[p.ptr.Start-start : p.ptr.End-start])
@@ -2432,7 +2979,7 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 				compressions++
 				s := time.Now()
 				bep := newBigEndianPostings(pBytes[4:])
-				data, err := diffVarintSnappyEncode(bep, bep.length())
+				data, err := encodePostingsForCache(bep, bep.length())
 				compressionTime = time.Since(s)
 				if err == nil {
 					dataToCache = data
@@ -2446,7 +2993,9 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 				// Truncate first 4 bytes which are length of posting.
 				output[p.keyID] = newBigEndianPostings(pBytes[4:])
 
-				r.block.indexCache.StorePostings(ctx, r.block.meta.ULID, keys[p.keyID], dataToCache)
+				toCache[keys[p.keyID]] = dataToCache
+				r.block.postingsInFlight.complete(keys[p.keyID], leading[p.keyID], pBytes, nil)
+				delete(leading, p.keyID)
 
 				// If we just fetched it we still have to update the stats for touched postings.
 				r.stats.postingsTouched++
@@ -2462,7 +3011,55 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 		})
 	}
 
-	return output, g.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if len(waiting) == 0 {
+		return output, nil
+	}
+	return output, r.waitPostings(ctx, keys, waiting, output, bytesLimiter)
+}
+
+// waitPostings waits for the postings being fetched by other requests and sets them to the output.
+// The postings whose fetch failed, e.g. because the other request has been canceled, are fetched again.
+func (r *bucketIndexReader) waitPostings(ctx context.Context, keys []labels.Label, waiting []postingsWaiter, output []index.Postings, bytesLimiter BytesLimiter) error {
+	var (
+		retryKeys []labels.Label
+		retryIDs  []int
+	)
+	for _, w := range waiting {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.call.done:
+		}
+
+		if w.call.err != nil {
+			retryKeys = append(retryKeys, keys[w.keyID])
+			retryIDs = append(retryIDs, w.keyID)
+			continue
+		}
+		if err := bytesLimiter.Reserve(uint64(len(w.call.data))); err != nil {
+			return errors.Wrap(err, "bytes limit exceeded while loading postings fetched by another request")
+		}
+
+		r.stats.postingsTouched++
+		r.stats.PostingsTouchedSizeSum += units.Base2Bytes(len(w.call.data))
+		// Truncate first 4 bytes which are length of posting.
+		output[w.keyID] = newBigEndianPostings(w.call.data[4:])
+	}
+	if len(retryKeys) == 0 {
+		return nil
+	}
+
+	retried, err := r.fetchPostings(ctx, retryKeys, bytesLimiter)
+	if err != nil {
+		return err
+	}
+	for i, keyID := range retryIDs {
+		output[keyID] = retried[i]
+	}
+	return nil
 }
 
 func resizePostings(b []byte) ([]byte, error) {
@@ -2546,6 +3143,9 @@ func (r *bucketIndexReader) PreloadSeries(ctx context.Context, ids []storage.Ser
 		if err := bytesLimiter.Reserve(uint64(len(b))); err != nil {
 			return errors.Wrap(err, "exceeded bytes limit while loading series from index cache")
 		}
+		if err := r.reserveCacheBytes(len(b)); err != nil {
+			return errors.Wrap(err, "loading series from index cache")
+		}
 	}
 
 	parts := r.block.partitioner.Partition(len(ids), func(i int) (start, end uint64) {
@@ -2585,6 +3185,13 @@ func (r *bucketIndexReader) loadSeries(ctx context.Context, ids []storage.Series
 	r.stats.SeriesFetchedSizeSum += units.Base2Bytes(int(end - start))
 	r.mtx.Unlock()
 
+	toCache := make(map[storage.SeriesRef][]byte, len(ids))
+	defer func() {
+		if len(toCache) > 0 {
+			storecache.StoreMultiSeries(ctx, r.block.indexCache, r.block.meta.ULID, toCache)
+		}
+	}()
+
 	for i, id := range ids {
 		c := b[uint64(id)-start:]
 
@@ -2607,8 +3214,8 @@ func (r *bucketIndexReader) loadSeries(ctx context.Context, ids []storage.Series
 		c = c[n : n+int(l)]
 		r.mtx.Lock()
 		r.loadedSeries[id] = c
-		r.block.indexCache.StoreSeries(ctx, r.block.meta.ULID, id, c)
 		r.mtx.Unlock()
+		toCache[id] = c
 	}
 	return nil
 }
@@ -2865,11 +3472,17 @@ func (r *bucketChunkReader) loadChunks(ctx context.Context, res []seriesEntry, a
 		r.stats.ChunksFetchDurationSum += time.Since(fetchBegin)
 	}()
 
-	// Get a reader for the required range.
-	reader, err := r.block.chunkRangeReader(ctx, seq, int64(part.Start), int64(part.End-part.Start))
+	// Get a reader for the required range, tracking whether it's served by the chunks cache.
+	cacheStats := &storecache.GetRangeStats{}
+	reader, err := r.block.chunkRangeReader(storecache.ContextWithGetRangeStats(ctx, cacheStats), seq, int64(part.Start), int64(part.End-part.Start))
 	if err != nil {
 		return errors.Wrap(err, "get range reader")
 	}
+	if hits, misses := cacheStats.Subranges(); hits > 0 && misses == 0 {
+		r.block.metrics.chunkFetches.WithLabelValues(chunkFetchHit).Inc()
+	} else {
+		r.block.metrics.chunkFetches.WithLabelValues(chunkFetchMiss).Inc()
+	}
 	defer runutil.CloseWithLogOnErr(r.block.logger, reader, "readChunkRange close range reader")
 	bufReader := bufio.NewReaderSize(reader, EstimatedMaxChunkSize)
 
@@ -2960,6 +3573,7 @@ func (r *bucketChunkReader) loadChunks(ctx context.Context, res []seriesEntry, a
 		if err := bytesLimiter.Reserve(uint64(chunkLen)); err != nil {
 			return errors.Wrap(err, "bytes limit exceeded while fetching chunks")
 		}
+		r.block.metrics.chunkFetches.WithLabelValues(chunkFetchRefetch).Inc()
 		nb, err := r.block.readChunkRange(ctx, seq, int64(pIdx.offset), int64(chunkLen), []byteRange{{offset: 0, length: chunkLen}})
 		if err != nil {
 			return errors.Wrapf(err, "preloaded chunk too small, expecting %d, and failed to fetch full chunk", chunkLen)
@@ -6,6 +6,7 @@ package store
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,12 +17,15 @@ import (
 	"github.com/go-kit/log"
 	"github.com/gogo/status"
 	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/vimeo/galaxycache"
 	"google.golang.org/grpc/codes"
 
 	"github.com/thanos-io/objstore"
@@ -72,6 +76,22 @@ func (c *swappableCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID
 	return c.ptr.FetchMultiSeries(ctx, blockID, ids)
 }
 
+func (c *swappableCache) StoreLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte) {
+	c.ptr.StoreLabelValues(ctx, blockID, labelName, matchers, v)
+}
+
+func (c *swappableCache) FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool) {
+	return c.ptr.FetchLabelValues(ctx, blockID, labelName, matchers)
+}
+
+func (c *swappableCache) StoreLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	c.ptr.StoreLabelNames(ctx, blockID, matchers, v)
+}
+
+func (c *swappableCache) FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	return c.ptr.FetchLabelNames(ctx, blockID, matchers)
+}
+
 type storeSuite struct {
 	store            *BucketStore
 	minTime, maxTime int64
@@ -541,6 +561,102 @@ func TestBucketStore_ManyParts_e2e(t *testing.T) {
 	})
 }
 
+func TestBucketStore_GroupcacheIndexCache_e2e(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	s := prepareStoreWithTestBlocks(t, dir, objstore.NewInMemBucket(), false, NewChunksLimiterFactory(0), NewSeriesLimiterFactory(0), NewBytesLimiterFactory(0), emptyRelabelConfig, allowAllFilterConf)
+
+	// Without peers, all the items are owned and loaded by the store itself.
+	config := storecache.DefaultGroupcacheIndexCacheConfig
+	config.GroupcacheGroup = "index"
+	indexCache := storecache.NewGroupcacheIndexCacheWithUniverse(s.logger, nil, galaxycache.NewUniverse(&galaxycache.NullFetchProtocol{}, "self"), config)
+	indexCache.SetLoader(s.store)
+	s.cache.SwapWith(indexCache)
+
+	testBucketStore_e2e(t, ctx, s)
+
+	for id := range s.store.blocks {
+		hits, misses := indexCache.FetchMultiPostings(ctx, id, []labels.Label{{Name: "a", Value: "1"}, {Name: "a", Value: "3"}})
+		testutil.Equals(t, 1, len(hits))
+		testutil.Equals(t, []labels.Label{{Name: "a", Value: "3"}}, misses)
+	}
+
+	t.Run("should load the items of the blocks not held", func(t *testing.T) {
+		// With sharded stores, the owner of an item doesn't necessarily hold its block.
+		l := labels.Label{Name: "a", Value: "1"}
+		for id := range s.store.blocks {
+			postings, err := s.store.LoadPostings(ctx, id, l)
+			testutil.Ok(t, err)
+			p, err := diffVarintSnappyDecode(postings)
+			testutil.Ok(t, err)
+			testutil.Assert(t, p.Next())
+			series, err := s.store.LoadSeries(ctx, id, p.At())
+			testutil.Ok(t, err)
+
+			testutil.Ok(t, s.store.removeBlock(id))
+			unheldPostings, err := s.store.LoadPostings(ctx, id, l)
+			testutil.Ok(t, err)
+			testutil.Equals(t, postings, unheldPostings)
+			unheldSeries, err := s.store.LoadSeries(ctx, id, p.At())
+			testutil.Ok(t, err)
+			testutil.Equals(t, series, unheldSeries)
+		}
+		testutil.Equals(t, 0, len(s.store.blocks))
+
+		// The index-headers of the blocks not held are removed once closed.
+		fis, err := os.ReadDir(filepath.Join(dir, unheldIndexHeadersDirname))
+		testutil.Ok(t, err)
+		testutil.Equals(t, 6, len(fis))
+		s.store.unheldIndexHeaders.close()
+		fis, err = os.ReadDir(filepath.Join(dir, unheldIndexHeadersDirname))
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, len(fis))
+	})
+}
+
+func TestBucketStore_WarmIndexCache_e2e(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	s := prepareStoreWithTestBlocks(t, dir, objstore.NewInMemBucket(), false, NewChunksLimiterFactory(0), NewSeriesLimiterFactory(0), NewBytesLimiterFactory(0), emptyRelabelConfig, allowAllFilterConf)
+
+	indexCache, err := storecache.NewInMemoryIndexCacheWithConfig(s.logger, nil, storecache.InMemoryIndexCacheConfig{
+		MaxItemSize: 1e5,
+		MaxSize:     2e5,
+	})
+	testutil.Ok(t, err)
+	s.cache.SwapWith(indexCache)
+
+	// Warming is a no-op until label names are configured.
+	testutil.Ok(t, s.store.WarmIndexCache(ctx))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(s.store.metrics.indexCacheWarmedEntries))
+
+	WithIndexCacheWarming([]string{"a"}, 0)(s.store)
+	testutil.Ok(t, s.store.WarmIndexCache(ctx))
+
+	// Each of the 6 blocks has postings for 2 values of "a".
+	testutil.Equals(t, 12.0, promtest.ToFloat64(s.store.metrics.indexCacheWarmedEntries))
+	for id := range s.store.blocks {
+		hits, misses := indexCache.FetchMultiPostings(ctx, id, []labels.Label{{Name: "a", Value: "1"}, {Name: "a", Value: "2"}})
+		testutil.Equals(t, 2, len(hits))
+		testutil.Equals(t, 0, len(misses))
+	}
+
+	// Postings already in the cache are not fetched again.
+	testutil.Ok(t, s.store.WarmIndexCache(ctx))
+	testutil.Equals(t, 12.0, promtest.ToFloat64(s.store.metrics.indexCacheWarmedEntries))
+
+	// Warming stops as soon as the context is canceled.
+	canceledCtx, cancelWarming := context.WithCancel(ctx)
+	cancelWarming()
+	WithIndexCacheWarming([]string{"b"}, 1)(s.store)
+	testutil.Equals(t, context.Canceled, errors.Cause(s.store.WarmIndexCache(canceledCtx)))
+	testutil.Equals(t, 12.0, promtest.ToFloat64(s.store.metrics.indexCacheWarmedEntries))
+}
+
 func TestBucketStore_TimePartitioning_e2e(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -656,6 +772,46 @@ func TestBucketStore_Series_ChunksLimiter_e2e(t *testing.T) {
 	}
 }
 
+func TestBucketStore_Series_CacheBytesLimiter_e2e(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bkt := objstore.NewInMemBucket()
+
+	dir := t.TempDir()
+
+	s := prepareStoreWithTestBlocks(t, dir, bkt, false, NewChunksLimiterFactory(0), NewSeriesLimiterFactory(0), NewBytesLimiterFactory(0), emptyRelabelConfig, allowAllFilterConf)
+	testutil.Ok(t, s.store.SyncBlocks(ctx))
+
+	indexCache, err := storecache.NewInMemoryIndexCacheWithConfig(s.logger, nil, storecache.InMemoryIndexCacheConfig{
+		MaxItemSize: 1e5,
+		MaxSize:     2e5,
+	})
+	testutil.Ok(t, err)
+	s.cache.SwapWith(indexCache)
+
+	req := &storepb.SeriesRequest{
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+		},
+		MinTime: minTimeDuration.PrometheusTimestamp(),
+		MaxTime: maxTimeDuration.PrometheusTimestamp(),
+	}
+
+	// Only the bytes fetched from the index cache are limited, so the first call filling it succeeds.
+	s.store.cacheBytesLimiterFactory = NewBytesLimiterFactory(1)
+	testutil.Ok(t, s.store.Series(req, newStoreSeriesServer(ctx)))
+
+	err = s.store.Series(req, newStoreSeriesServer(ctx))
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "exceeded index cache bytes limit"))
+	status, ok := status.FromError(err)
+	testutil.Equals(t, true, ok)
+	testutil.Equals(t, codes.ResourceExhausted, status.Code())
+
+	s.store.cacheBytesLimiterFactory = NewBytesLimiterFactory(0)
+	testutil.Ok(t, s.store.Series(req, newStoreSeriesServer(ctx)))
+}
+
 func TestBucketStore_LabelNames_e2e(t *testing.T) {
 	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
 		ctx, cancel := context.WithCancel(context.Background())
@@ -760,6 +916,97 @@ func TestBucketStore_LabelNames_e2e(t *testing.T) {
 	})
 }
 
+func TestBucketStore_LabelNamesCache_e2e(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	s := prepareStoreWithTestBlocks(t, dir, objstore.NewInMemBucket(), false, NewChunksLimiterFactory(0), NewSeriesLimiterFactory(0), NewBytesLimiterFactory(0), emptyRelabelConfig, allowAllFilterConf)
+
+	indexCache, err := storecache.NewInMemoryIndexCacheWithConfig(s.logger, nil, storecache.InMemoryIndexCacheConfig{
+		MaxItemSize: 1e5,
+		MaxSize:     2e5,
+	})
+	testutil.Ok(t, err)
+	s.cache.SwapWith(indexCache)
+
+	req := &storepb.LabelNamesRequest{
+		Start:    math.MinInt64,
+		End:      math.MaxInt64,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "1"}},
+	}
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "b", "1")}
+
+	for _, enabled := range []bool{false, true} {
+		s.store.cacheLabelNames = enabled
+
+		// The names are the same whether they're cached or not.
+		for i := 0; i < 2; i++ {
+			resp, err := s.store.LabelNames(ctx, req)
+			testutil.Ok(t, err)
+			testutil.Equals(t, []string{"a", "b", "ext1"}, resp.Names)
+		}
+
+		for id := range s.store.blocks {
+			_, ok := indexCache.FetchLabelNames(ctx, id, matchers)
+			testutil.Equals(t, enabled, ok)
+		}
+	}
+
+	// The requests not covering a whole block aren't cached, given the names depend on the time range.
+	for id, b := range s.store.blocks {
+		_, err := s.store.LabelNames(ctx, &storepb.LabelNamesRequest{
+			Start:    b.meta.MinTime + 1,
+			End:      b.meta.MaxTime,
+			Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"}},
+		})
+		testutil.Ok(t, err)
+
+		_, ok := indexCache.FetchLabelNames(ctx, id, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "1")})
+		testutil.Assert(t, !ok)
+	}
+}
+
+func TestBucketStore_LabelValuesCache_e2e(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	s := prepareStoreWithTestBlocks(t, dir, objstore.NewInMemBucket(), false, NewChunksLimiterFactory(0), NewSeriesLimiterFactory(0), NewBytesLimiterFactory(0), emptyRelabelConfig, allowAllFilterConf)
+
+	indexCache, err := storecache.NewInMemoryIndexCacheWithConfig(s.logger, nil, storecache.InMemoryIndexCacheConfig{
+		MaxItemSize: 1e5,
+		MaxSize:     2e5,
+	})
+	testutil.Ok(t, err)
+	s.cache.SwapWith(indexCache)
+
+	req := &storepb.LabelValuesRequest{
+		Label:    "a",
+		Start:    math.MinInt64,
+		End:      math.MaxInt64,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "1"}},
+	}
+	// The series are selected by the request matchers along with the label name one.
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "b", "1"), labels.MustNewMatcher(labels.MatchNotEqual, "a", "")}
+
+	for _, enabled := range []bool{false, true} {
+		s.store.cacheLabelValues = enabled
+
+		// The values are the same whether they're cached or not.
+		for i := 0; i < 2; i++ {
+			resp, err := s.store.LabelValues(ctx, req)
+			testutil.Ok(t, err)
+			testutil.Equals(t, []string{"1", "2"}, resp.Values)
+		}
+
+		for id := range s.store.blocks {
+			_, ok := indexCache.FetchLabelValues(ctx, id, "a", matchers)
+			testutil.Equals(t, enabled, ok)
+		}
+	}
+}
+
 func TestBucketStore_LabelValues_e2e(t *testing.T) {
 	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
 		ctx, cancel := context.WithCancel(context.Background())
@@ -38,6 +38,7 @@ import (
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/index"
 	"go.uber.org/atomic"
 
 	"github.com/thanos-io/objstore"
@@ -622,6 +623,13 @@ func TestBucketStoreConfig_validate(t *testing.T) {
 			},
 			expected: errBlockSyncConcurrencyNotValid,
 		},
+		"should fail on blockLoadConcurrency < 0": {
+			config: &BucketStore{
+				blockSyncConcurrency: 1,
+				blockLoadConcurrency: -1,
+			},
+			expected: errBlockLoadConcurrencyNotValid,
+		},
 	}
 
 	for testName, testData := range tests {
@@ -672,6 +680,63 @@ func TestBucketStore_Info(t *testing.T) {
 	testutil.Equals(t, []labelpb.ZLabel(nil), resp.Labels)
 }
 
+func TestBucketStore_BlockLoadConcurrency(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	dir := t.TempDir()
+
+	bkt := objstore.NewInMemBucket()
+	series := []labels.Labels{labels.FromStrings("a", "1", "b", "1")}
+
+	for i := 0; i < 3; i++ {
+		id, err := e2eutil.CreateBlock(ctx, dir, series, 10, int64(i)*1000, int64(i+1)*1000, labels.Labels{{Name: "cluster", Value: "a"}}, 0, metadata.NoneFunc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+	}
+
+	metaFetcher, err := block.NewMetaFetcher(logger, 20, objstore.WithNoopInstr(bkt), dir, nil, nil)
+	testutil.Ok(t, err)
+
+	bucketStore, err := NewBucketStore(
+		objstore.WithNoopInstr(bkt),
+		metaFetcher,
+		t.TempDir(),
+		NewChunksLimiterFactory(0),
+		NewSeriesLimiterFactory(0),
+		NewBytesLimiterFactory(0),
+		NewGapBasedPartitioner(PartitionerMaxGapSize),
+		20,
+		true,
+		DefaultPostingOffsetInMemorySampling,
+		false,
+		false,
+		0,
+		WithLogger(logger),
+		WithFilterConfig(allowAllFilterConf),
+		WithBlockLoadConcurrency(1),
+	)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bucketStore.Close()) }()
+
+	// While the only block load slot is taken, no block should be loaded.
+	testutil.Ok(t, bucketStore.blockLoadGate.Start(ctx))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(bucketStore.metrics.blockLoadsInFlight))
+
+	syncCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	testutil.Ok(t, bucketStore.SyncBlocks(syncCtx))
+	testutil.Equals(t, 0, len(bucketStore.blocks))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(bucketStore.metrics.blockLoads))
+
+	// Once released, the blocks should be loaded.
+	bucketStore.blockLoadGate.Done()
+	testutil.Ok(t, bucketStore.SyncBlocks(ctx))
+	testutil.Equals(t, 3, len(bucketStore.blocks))
+	testutil.Equals(t, 3.0, promtest.ToFloat64(bucketStore.metrics.blockLoads))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(bucketStore.metrics.blockLoadsInFlight))
+}
+
 type recorder struct {
 	mtx sync.Mutex
 	objstore.Bucket
@@ -1068,6 +1133,92 @@ func TestBucketIndexReader_ExpandedPostings(t *testing.T) {
 	benchmarkExpandedPostings(tb, bkt, id, r, 500)
 }
 
+func TestBucketIndexReader_FetchPostings_ShouldCoalesceInFlightFetches(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bkt"))
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	id := uploadTestBlock(t, tmpDir, bkt, 500)
+	r, err := indexheader.NewBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, id, DefaultPostingOffsetInMemorySampling)
+	testutil.Ok(t, err)
+
+	rec := &recorder{Bucket: bkt}
+	b := &bucketBlock{
+		logger:            log.NewNopLogger(),
+		metrics:           newBucketStoreMetrics(nil),
+		indexHeaderReader: r,
+		indexCache:        noopCache{},
+		bkt:               rec,
+		meta:              &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id}},
+		partitioner:       NewGapBasedPartitioner(PartitionerMaxGapSize),
+	}
+
+	key := labels.Label{Name: "j", Value: "foo"}
+	type result struct {
+		refs []storage.SeriesRef
+		err  error
+	}
+	fetch := func() <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			p, err := newBucketIndexReader(b).fetchPostings(ctx, []labels.Label{key}, NewBytesLimiterFactory(0)(nil))
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			refs, err := index.ExpandPostings(p[0])
+			done <- result{refs: refs, err: err}
+		}()
+		return done
+	}
+	waitCoalesced := func(t *testing.T, expected float64) {
+		for i := 0; i < 100 && promtest.ToFloat64(b.metrics.postingsCoalesced) != expected; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		testutil.Equals(t, expected, promtest.ToFloat64(b.metrics.postingsCoalesced))
+	}
+
+	// Fetch the postings without any other request in flight, to know the expected ones.
+	res := <-fetch()
+	testutil.Ok(t, res.err)
+	expected := res.refs
+	testutil.Equals(t, 1, len(rec.getRangeTouched))
+	testutil.Equals(t, 0, len(b.postingsInFlight.calls))
+
+	t.Run("should share the result of the fetch in flight", func(t *testing.T) {
+		call, leader := b.postingsInFlight.join(key)
+		testutil.Assert(t, leader)
+
+		done := fetch()
+		waitCoalesced(t, 1)
+
+		// Raw postings of the series 1 and 2, prefixed by their number.
+		b.postingsInFlight.complete(key, call, []byte{0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2}, nil)
+		res := <-done
+		testutil.Ok(t, res.err)
+		testutil.Equals(t, []storage.SeriesRef{1, 2}, res.refs)
+		testutil.Equals(t, 1, len(rec.getRangeTouched))
+	})
+
+	t.Run("should fetch the postings if the fetch in flight fails", func(t *testing.T) {
+		call, leader := b.postingsInFlight.join(key)
+		testutil.Assert(t, leader)
+
+		done := fetch()
+		waitCoalesced(t, 2)
+
+		b.postingsInFlight.complete(key, call, nil, context.Canceled)
+		res := <-done
+		testutil.Ok(t, res.err)
+		testutil.Equals(t, expected, res.refs)
+		testutil.Equals(t, 2, len(rec.getRangeTouched))
+		testutil.Equals(t, 0, len(b.postingsInFlight.calls))
+	})
+}
+
 func BenchmarkBucketIndexReader_ExpandedPostings(b *testing.B) {
 	tb := testutil.NewTB(b)
 
@@ -1408,6 +1559,13 @@ func benchBucketSeries(t testutil.TB, sampleType chunkenc.ValueType, skipChunk b
 			// NOTE(bwplotka): It is 4 x 1.0 for 100mln samples. Kind of make sense: long series.
 			testutil.Equals(t, 0.0, promtest.ToFloat64(b.metrics.seriesRefetches))
 		}
+
+		// Without a caching bucket, all the chunks are fetched from the object storage.
+		testutil.Equals(t, 0.0, promtest.ToFloat64(st.metrics.chunkFetches.WithLabelValues(chunkFetchHit)))
+		testutil.Equals(t, 0.0, promtest.ToFloat64(st.metrics.chunkFetches.WithLabelValues(chunkFetchRefetch)))
+		if !skipChunk {
+			testutil.Assert(t, promtest.ToFloat64(st.metrics.chunkFetches.WithLabelValues(chunkFetchMiss)) > 0)
+		}
 	}
 }
 
@@ -1569,10 +1727,11 @@ func TestBucketSeries_OneBlock_InMemIndexCacheSegfault(t *testing.T) {
 			b1.meta.ULID: b1,
 			b2.meta.ULID: b2,
 		},
-		queryGate:            gate.NewNoop(),
-		chunksLimiterFactory: NewChunksLimiterFactory(0),
-		seriesLimiterFactory: NewSeriesLimiterFactory(0),
-		bytesLimiterFactory:  NewBytesLimiterFactory(0),
+		queryGate:                gate.NewNoop(),
+		chunksLimiterFactory:     NewChunksLimiterFactory(0),
+		seriesLimiterFactory:     NewSeriesLimiterFactory(0),
+		bytesLimiterFactory:      NewBytesLimiterFactory(0),
+		cacheBytesLimiterFactory: NewBytesLimiterFactory(0),
 	}
 
 	t.Run("invoke series for one block. Fill the cache on the way.", func(t *testing.T) {
@@ -2463,6 +2622,7 @@ func benchmarkBlockSeriesWithConcurrency(b *testing.B, concurrency int, blockMet
 					chunksLimiter,
 					NewBytesLimiterFactory(0)(nil),
 					nil,
+					nil,
 					false,
 					SeriesBatchSize,
 					dummyHistogram,
@@ -2493,3 +2653,29 @@ func BenchmarkDownsampledBlockSeries(b *testing.B) {
 		}
 	}
 }
+
+func TestLabelValuesCodec(t *testing.T) {
+	for _, values := range [][]string{
+		{},
+		{""},
+		{"a"},
+		{"1", "10", "2", "prometheus", strings.Repeat("x", 300)},
+	} {
+		decoded, err := decodeLabelValues(encodeLabelValues(values))
+		testutil.Ok(t, err)
+		testutil.Equals(t, values, decoded)
+	}
+
+	encoded := encodeLabelValues([]string{"a", "b"})
+	_, err := decodeLabelValues(encoded[:len(encoded)-1])
+	testutil.NotOk(t, err)
+	_, err = decodeLabelValues(append(encoded, 0))
+	testutil.NotOk(t, err)
+
+	// A number of values exceeding the encoded ones should be rejected before being allocated.
+	e := encoding.Encbuf{}
+	e.PutUvarint(math.MaxInt32)
+	e.PutUvarintStr("a")
+	_, err = decodeLabelValues(e.Get())
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/cacheutil"
+)
+
+const (
+	blockKeysIndexComplete   byte = 0
+	blockKeysIndexOverflowed byte = 1
+)
+
+// blockKeysIndex lists the keys stored for a block, mapped to the index of the client
+// they've been stored to. Once overflowed, it only lists part of them.
+type blockKeysIndex struct {
+	keys       map[string]int
+	overflowed bool
+}
+
+// encode encodes the index as a flag telling whether it has overflowed, followed by the
+// number of keys and, for each key, its client index and its length-prefixed string.
+func (i blockKeysIndex) encode() []byte {
+	size := 1 + binary.MaxVarintLen64
+	for key := range i.keys {
+		size += 2*binary.MaxVarintLen64 + len(key)
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	appendUvarint := func(buf []byte, v uint64) []byte {
+		return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+	}
+
+	buf := make([]byte, 0, size)
+	if i.overflowed {
+		buf = append(buf, blockKeysIndexOverflowed)
+	} else {
+		buf = append(buf, blockKeysIndexComplete)
+	}
+	buf = appendUvarint(buf, uint64(len(i.keys)))
+	for key, client := range i.keys {
+		buf = appendUvarint(buf, uint64(client))
+		buf = appendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+	}
+	return buf
+}
+
+func decodeBlockKeysIndex(b []byte) (blockKeysIndex, error) {
+	if len(b) == 0 || b[0] > blockKeysIndexOverflowed {
+		return blockKeysIndex{}, errors.New("invalid block keys index")
+	}
+	index := blockKeysIndex{overflowed: b[0] == blockKeysIndexOverflowed}
+	b = b[1:]
+
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, errors.New("truncated block keys index")
+		}
+		b = b[n:]
+		return v, nil
+	}
+
+	count, err := readUvarint()
+	if err != nil {
+		return blockKeysIndex{}, err
+	}
+	if count > uint64(len(b)) {
+		return blockKeysIndex{}, errors.New("truncated block keys index")
+	}
+	index.keys = make(map[string]int, count)
+	for j := uint64(0); j < count; j++ {
+		client, err := readUvarint()
+		if err != nil {
+			return blockKeysIndex{}, err
+		}
+		keyLen, err := readUvarint()
+		if err != nil {
+			return blockKeysIndex{}, err
+		}
+		if keyLen > uint64(len(b)) {
+			return blockKeysIndex{}, errors.New("truncated block keys index")
+		}
+		index.keys[string(b[:keyLen])] = int(client)
+		b = b[keyLen:]
+	}
+	return index, nil
+}
+
+// scheduleBlockKeysIndexFlush marks the keys index of the block as having new keys and, unless
+// a flush is already pending, schedules one after the flush interval, so that the index is stored
+// once for all the keys stored in the meantime rather than once per key.
+func (c *RemoteIndexCache) scheduleBlockKeysIndexFlush(ctx context.Context, blockID ulid.ULID) {
+	indexKey := c.key(ctx, cacheKey{blockID, cacheKeyBlockKeys{}})
+	if !c.blockKeys.markDirty(blockID, indexKey) {
+		return
+	}
+	time.AfterFunc(c.config.BlockKeysIndexFlushInterval, func() {
+		c.flushBlockKeysIndex(blockID, indexKey)
+	})
+}
+
+// flushBlockKeysIndexes flushes the keys index of all the blocks having new keys, without
+// waiting for their scheduled flush.
+func (c *RemoteIndexCache) flushBlockKeysIndexes() {
+	for blockID, indexKey := range c.blockKeys.dirtyBlocks() {
+		c.flushBlockKeysIndex(blockID, indexKey)
+	}
+}
+
+func (c *RemoteIndexCache) flushBlockKeysIndex(blockID ulid.ULID, indexKey string) {
+	dirty, unmerged := c.blockKeys.clearDirty(blockID)
+	if !dirty {
+		return
+	}
+	c.storeBlockKeysIndex(blockID, indexKey, unmerged)
+}
+
+// storeBlockKeysIndex stores the keys index of the block, listing the keys tracked by this
+// process. Unless already done, the index already stored, if any, is first merged into the
+// tracked keys so that the keys stored before by other processes aren't dropped from it.
+// Once the index exceeds the max size, it's stored one last time with the keys it can hold
+// and flagged as overflowed, leaving the others to expire with their TTL.
+func (c *RemoteIndexCache) storeBlockKeysIndex(blockID ulid.ULID, indexKey string, merge bool) {
+	if c.closed.Load() {
+		return
+	}
+
+	// The flush runs outside of any request, and the index key already accounts for the tenant.
+	ctx := context.Background()
+	if merge {
+		stored, _, err := c.fetchBlockKeysIndex(ctx, indexKey)
+		if err != nil {
+			c.blockKeysIndexFailures.Inc()
+		} else {
+			// Merging the empty index of a missing one still marks it as merged.
+			c.blockKeys.merge(blockID, stored)
+		}
+	}
+
+	index, ok := c.blockKeys.indexSnapshot(blockID, c.config.MaxBlockKeysIndexSize)
+	if !ok {
+		return
+	}
+	if index.overflowed {
+		c.blockKeysIndexOverflows.Inc()
+	}
+
+	ttl := c.config.PostingsTTL
+	if c.config.SeriesTTL > ttl {
+		ttl = c.config.SeriesTTL
+	}
+	item := cacheutil.RemoteCacheItem{Key: indexKey, Value: index.encode(), TTL: c.ttl(blockID, ttl)}
+	if err := c.setItem(ctx, 0, item); err != nil {
+		c.blockKeysIndexFailures.Inc()
+	}
+}
+
+// fetchBlockKeysIndex fetches the keys index stored under the key, returning whether it was found.
+func (c *RemoteIndexCache) fetchBlockKeysIndex(ctx context.Context, key string) (blockKeysIndex, bool, error) {
+	results, err := c.getMulti(ctx, 0, []string{key})
+	if err != nil {
+		return blockKeysIndex{}, false, err
+	}
+	v, ok := results[key]
+	if !ok {
+		return blockKeysIndex{}, false, nil
+	}
+	index, err := decodeBlockKeysIndex(v)
+	if err != nil {
+		return blockKeysIndex{}, false, errors.Wrapf(err, "decode block keys index %s", key)
+	}
+	return index, true, nil
+}
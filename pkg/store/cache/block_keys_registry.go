@@ -0,0 +1,163 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"sync"
+
+	"github.com/oklog/ulid"
+)
+
+// blockKeysRegistry keeps track of the remote cache keys stored for each block by
+// this process, along with the index of the client they've been stored to, so that
+// they can be deleted once the block is gone. Keys stored by other processes sharing
+// the same remote cache are not tracked.
+type blockKeysRegistry struct {
+	mtx  sync.Mutex
+	keys map[ulid.ULID]map[string]int
+
+	// Blocks whose keys index has overflowed, and is no longer updated.
+	overflowed map[ulid.ULID]struct{}
+	// Blocks whose keys index has new keys to be flushed, mapped to the key of their index.
+	dirty map[ulid.ULID]string
+	// Blocks whose keys index already stored has been merged into their tracked keys.
+	merged map[ulid.ULID]struct{}
+}
+
+func newBlockKeysRegistry() *blockKeysRegistry {
+	return &blockKeysRegistry{
+		keys:       map[ulid.ULID]map[string]int{},
+		overflowed: map[ulid.ULID]struct{}{},
+		dirty:      map[ulid.ULID]string{},
+		merged:     map[ulid.ULID]struct{}{},
+	}
+}
+
+// add tracks the key of the block and returns whether it wasn't tracked yet.
+func (r *blockKeysRegistry) add(blockID ulid.ULID, key string, client int) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	keys, ok := r.keys[blockID]
+	if !ok {
+		keys = map[string]int{}
+		r.keys[blockID] = keys
+	}
+	_, tracked := keys[key]
+	keys[key] = client
+	return !tracked
+}
+
+// markDirty marks the keys index of the block, stored under indexKey, as having keys to be
+// flushed, and returns whether it wasn't already, i.e. whether a flush should be scheduled.
+func (r *blockKeysRegistry) markDirty(blockID ulid.ULID, indexKey string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.overflowed[blockID]; ok {
+		return false
+	}
+	if _, ok := r.dirty[blockID]; ok {
+		return false
+	}
+	r.dirty[blockID] = indexKey
+	return true
+}
+
+// dirtyBlocks returns the blocks whose keys index has keys to be flushed, mapped to the key
+// of their index.
+func (r *blockKeysRegistry) dirtyBlocks() map[ulid.ULID]string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	dirty := make(map[ulid.ULID]string, len(r.dirty))
+	for blockID, indexKey := range r.dirty {
+		dirty[blockID] = indexKey
+	}
+	return dirty
+}
+
+// clearDirty marks the keys index of the block as flushed, and returns whether it had keys
+// to be flushed, along with whether the index already stored is yet to be merged.
+func (r *blockKeysRegistry) clearDirty(blockID ulid.ULID) (dirty, unmerged bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, dirty = r.dirty[blockID]; !dirty {
+		return false, false
+	}
+	delete(r.dirty, blockID)
+	_, merged := r.merged[blockID]
+	return true, !merged
+}
+
+// merge tracks the keys of a block loaded from its keys index, which may have overflowed.
+func (r *blockKeysRegistry) merge(blockID ulid.ULID, index blockKeysIndex) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	keys, ok := r.keys[blockID]
+	if !ok {
+		keys = map[string]int{}
+		r.keys[blockID] = keys
+	}
+	for key, client := range index.keys {
+		if _, tracked := keys[key]; !tracked {
+			keys[key] = client
+		}
+	}
+	r.merged[blockID] = struct{}{}
+	if index.overflowed {
+		r.overflowed[blockID] = struct{}{}
+	}
+}
+
+// indexSnapshot returns the keys index of the block to be stored, holding up to maxSize keys,
+// and whether it should be stored at all, which isn't the case once it has overflowed.
+func (r *blockKeysRegistry) indexSnapshot(blockID ulid.ULID, maxSize int) (blockKeysIndex, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.overflowed[blockID]; ok {
+		return blockKeysIndex{}, false
+	}
+
+	keys := r.keys[blockID]
+	index := blockKeysIndex{keys: make(map[string]int, len(keys)), overflowed: len(keys) > maxSize}
+	for key, client := range keys {
+		if len(index.keys) == maxSize {
+			break
+		}
+		index.keys[key] = client
+	}
+	if index.overflowed {
+		r.overflowed[blockID] = struct{}{}
+	}
+	return index, true
+}
+
+func (r *blockKeysRegistry) remove(blockID ulid.ULID, key string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.keys[blockID], key)
+	if len(r.keys[blockID]) == 0 {
+		delete(r.keys, blockID)
+	}
+}
+
+// take returns all the keys tracked for the block, mapped to their client index,
+// and stops tracking them.
+func (r *blockKeysRegistry) take(blockID ulid.ULID) map[string]int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	keys := r.keys[blockID]
+	delete(r.keys, blockID)
+	delete(r.overflowed, blockID)
+	delete(r.dirty, blockID)
+	delete(r.merged, blockID)
+
+	return keys
+}
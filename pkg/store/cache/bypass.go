@@ -0,0 +1,82 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"net/http"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// The index cache bypass of a request is plumbed from the querier to the store gateways as follows:
+//   - The querier API handlers are wrapped by BypassHTTPMiddleware, which turns the BypassHTTPHeader
+//     of a request into the bypassMetadataKey gRPC metadata of the outgoing context, forwarded along
+//     with the StoreAPI requests issued on behalf of the request.
+//   - The store gateway gRPC server is configured with BypassUnaryServerInterceptor and
+//     BypassStreamServerInterceptor, if the bypass is allowed, which turn the incoming metadata back
+//     into a context carrying the bypass, which the RemoteIndexCache honors.
+//
+// The header has to be forwarded by the query-frontend, if any, with --query-frontend.forward-header.
+const (
+	// BypassHTTPHeader is the HTTP header making the index cache bypassed for a query, whatever its value.
+	BypassHTTPHeader = "X-Thanos-Bypass-Index-Cache"
+
+	// bypassMetadataKey is the gRPC metadata key carrying the bypass from the querier to the store gateways.
+	bypassMetadataKey = "thanos-bypass-index-cache"
+)
+
+type bypassContextKey struct{}
+
+// ContextWithBypass returns a context making the fetches from the remote index cache return misses
+// and the stores to it no-ops, for the request it's used for only, e.g. to debug a specific query.
+func ContextWithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassContextKey{}, true)
+}
+
+// bypassFromContext returns whether the context carries the bypass of the index cache.
+func bypassFromContext(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassContextKey{}).(bool)
+	return bypass
+}
+
+// BypassHTTPMiddleware forwards the bypass of the index cache requested with BypassHTTPHeader to
+// the StoreAPI servers the request fans out to.
+func BypassHTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(BypassHTTPHeader) != "" {
+			r = r.WithContext(metadata.AppendToOutgoingContext(r.Context(), bypassMetadataKey, "true"))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bypassFromIncomingContext returns the context carrying the bypass of the index cache if it's
+// requested by the incoming gRPC metadata, or the context as is otherwise.
+func bypassFromIncomingContext(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(bypassMetadataKey)) > 0 {
+		return ContextWithBypass(ctx)
+	}
+	return ctx
+}
+
+// BypassUnaryServerInterceptor returns a gRPC unary server interceptor bypassing the index cache
+// for the requests whose metadata asks for it.
+func BypassUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(bypassFromIncomingContext(ctx), req)
+	}
+}
+
+// BypassStreamServerInterceptor returns a gRPC stream server interceptor bypassing the index cache
+// for the requests whose metadata asks for it.
+func BypassStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrappedStream := grpc_middleware.WrapServerStream(stream)
+		wrappedStream.WrappedContext = bypassFromIncomingContext(stream.Context())
+		return handler(srv, wrappedStream)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestRemoteIndexCache_Bypass(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+
+	memcached := newMockedMemcachedClient(nil)
+	config := DefaultRemoteIndexCacheConfig
+	config.PrefetchTTL = time.Minute
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	c.StorePostings(ctx, block, label, []byte{1})
+	c.StoreSeries(ctx, block, 1, []byte{2})
+	c.StoreLabelValues(ctx, block, "instance", nil, []byte{3})
+
+	// The fetches of the bypassed requests miss, without calling the client.
+	bypassCtx := ContextWithBypass(ctx)
+	postings, misses := c.FetchMultiPostings(bypassCtx, block, []labels.Label{label})
+	testutil.Equals(t, 0, len(postings))
+	testutil.Equals(t, []labels.Label{label}, misses)
+	series, seriesMisses := c.FetchMultiSeries(bypassCtx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, 0, len(series))
+	testutil.Equals(t, []storage.SeriesRef{1}, seriesMisses)
+	_, ok := c.FetchLabelValues(bypassCtx, block, "instance", nil)
+	testutil.Assert(t, !ok)
+	c.Prefetch(bypassCtx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, 0, memcached.getMultiCalls)
+
+	// The stores of the bypassed requests are no-ops.
+	c.StorePostings(bypassCtx, block, labels.Label{Name: "instance", Value: "b"}, []byte{1})
+	c.StoreMultiSeries(bypassCtx, block, map[storage.SeriesRef][]byte{2: {2}})
+	testutil.Equals(t, 3, len(memcached.cache))
+
+	// The other requests keep using the cache.
+	postings, misses = c.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, postings)
+	testutil.Equals(t, 0, len(misses))
+}
+
+func TestBypass_Plumbing(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		header   string
+		expected bool
+	}{
+		{header: "", expected: false},
+		{header: "true", expected: true},
+	} {
+		// The querier forwards the header as metadata of the outgoing requests.
+		var outgoing metadata.MD
+		handler := BypassHTTPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			outgoing, _ = metadata.FromOutgoingContext(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+		if tc.header != "" {
+			req.Header.Set(BypassHTTPHeader, tc.header)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		// The store gateway turns the incoming metadata back into the bypass.
+		incomingCtx := metadata.NewIncomingContext(context.Background(), outgoing)
+		_, err := BypassUnaryServerInterceptor()(incomingCtx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			testutil.Equals(t, tc.expected, bypassFromContext(ctx))
+			return nil, nil
+		})
+		testutil.Ok(t, err)
+
+		err = BypassStreamServerInterceptor()(nil, &mockedServerStream{ctx: incomingCtx}, &grpc.StreamServerInfo{}, func(_ interface{}, stream grpc.ServerStream) error {
+			testutil.Equals(t, tc.expected, bypassFromContext(stream.Context()))
+			return nil
+		})
+		testutil.Ok(t, err)
+	}
+}
+
+type mockedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *mockedServerStream) Context() context.Context {
+	return s.ctx
+}
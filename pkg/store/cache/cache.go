@@ -6,19 +6,57 @@ package storecache
 import (
 	"context"
 	"encoding/base64"
+	"encoding/binary"
+	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"golang.org/x/crypto/blake2b"
+
+	"github.com/thanos-io/thanos/pkg/tracing"
 )
 
 const (
-	cacheTypePostings string = "Postings"
-	cacheTypeSeries   string = "Series"
+	cacheTypePostings         string = "Postings"
+	cacheTypeExpandedPostings string = "ExpandedPostings"
+	cacheTypeSeries           string = "Series"
+	cacheTypeLabelValues      string = "LabelValues"
+	cacheTypeLabelNames       string = "LabelNames"
 
 	sliceHeaderSize = 16
+
+	// CacheKeyVersion is the current version of the remote cache keys schema. It must be
+	// bumped whenever the keys or the entries encoding changes, so that entries written with
+	// the new encoding are namespaced away from the ones written by instances still running
+	// the old encoding, given they may share the same remote cache during a rolling upgrade.
+	// Version 2 stream compresses the large postings.
+	CacheKeyVersion = 2
+
+	// maxKeyTenantLength is the max length of a tenant used as is in a cache key.
+	maxKeyTenantLength = 64
+
+	// compactKeyPrefix is the prefix of the compact keys, which never collide with
+	// the string ones, given they always start with the "V" version prefix. It must be
+	// distinct from the prefixes of the remote cache entry formats.
+	compactKeyPrefix = "K:"
+	// compactKeyHashSize is the size of the hash of the items identified by hash in compact keys.
+	compactKeyHashSize = 16
+)
+
+// Types of the items identified by compact keys.
+const (
+	compactKeyTypePostings byte = iota + 1
+	compactKeyTypeExpandedPostings
+	compactKeyTypeSeries
+	compactKeyTypeLabelValues
+	compactKeyTypeBlockKeys
+	compactKeyTypeLabelNames
 )
 
 var (
@@ -40,6 +78,134 @@ type IndexCache interface {
 	// FetchMultiSeries fetches multiple series - each identified by ID - from the cache
 	// and returns a map containing cache hits, along with a list of missing IDs.
 	FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef)
+
+	// StoreLabelValues stores the values of a label name for the series matching the matchers.
+	StoreLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte)
+
+	// FetchLabelValues fetches the values of a label name for the series matching the matchers
+	// and returns the cached value along with a boolean telling whether it was a hit.
+	FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool)
+
+	// StoreLabelNames stores the label names of the series matching the matchers.
+	StoreLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte)
+
+	// FetchLabelNames fetches the label names of the series matching the matchers and
+	// returns the cached value along with a boolean telling whether it was a hit.
+	FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool)
+}
+
+// IndexCacheWithStoreMulti is implemented by an IndexCache able to store multiple entries
+// of a block at once, with less overhead than storing them one by one.
+type IndexCacheWithStoreMulti interface {
+	// StoreMultiPostings stores the postings of multiple labels of a block.
+	StoreMultiPostings(ctx context.Context, blockID ulid.ULID, entries map[labels.Label][]byte)
+
+	// StoreMultiSeries stores multiple series of a block.
+	StoreMultiSeries(ctx context.Context, blockID ulid.ULID, entries map[storage.SeriesRef][]byte)
+}
+
+// StoreMultiPostings stores the postings of multiple labels of a block into the cache, at once
+// if it implements IndexCacheWithStoreMulti, or one by one otherwise.
+func StoreMultiPostings(ctx context.Context, cache IndexCache, blockID ulid.ULID, entries map[labels.Label][]byte) {
+	if multi, ok := cache.(IndexCacheWithStoreMulti); ok {
+		multi.StoreMultiPostings(ctx, blockID, entries)
+		return
+	}
+	for l, v := range entries {
+		cache.StorePostings(ctx, blockID, l, v)
+	}
+}
+
+// StoreMultiSeries stores multiple series of a block into the cache, at once if it implements
+// IndexCacheWithStoreMulti, or one by one otherwise.
+func StoreMultiSeries(ctx context.Context, cache IndexCache, blockID ulid.ULID, entries map[storage.SeriesRef][]byte) {
+	if multi, ok := cache.(IndexCacheWithStoreMulti); ok {
+		multi.StoreMultiSeries(ctx, blockID, entries)
+		return
+	}
+	for id, v := range entries {
+		cache.StoreSeries(ctx, blockID, id, v)
+	}
+}
+
+// IndexCacheWithPrefetch is implemented by an IndexCache able to fetch series ahead of time, so
+// that fetching them for real afterwards is faster.
+type IndexCacheWithPrefetch interface {
+	// Prefetch asynchronously fetches the series of a block which are likely to be fetched soon.
+	// It's only a hint, which may be ignored, so it's safe to call it speculatively.
+	Prefetch(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef)
+}
+
+// Prefetch hints the cache that the series of a block are likely to be fetched soon, if it
+// implements IndexCacheWithPrefetch. Otherwise it's a no-op.
+func Prefetch(ctx context.Context, cache IndexCache, blockID ulid.ULID, ids []storage.SeriesRef) {
+	if p, ok := cache.(IndexCacheWithPrefetch); ok {
+		p.Prefetch(ctx, blockID, ids)
+	}
+}
+
+// IndexCacheWithHealthCheck is implemented by an IndexCache whose backend may be unreachable.
+type IndexCacheWithHealthCheck interface {
+	// HealthCheck returns an error if the cache backend can't be reached.
+	HealthCheck(ctx context.Context) error
+}
+
+// HealthCheck checks whether the backend of the cache can be reached, if it implements
+// IndexCacheWithHealthCheck. Otherwise the cache is considered healthy.
+func HealthCheck(ctx context.Context, cache IndexCache) error {
+	if h, ok := cache.(IndexCacheWithHealthCheck); ok {
+		return h.HealthCheck(ctx)
+	}
+	return nil
+}
+
+// IndexCacheWithStoreSync is implemented by an IndexCache whose stores are asynchronous by default,
+// but which is able to store an entry synchronously when its persistence has to be guaranteed.
+type IndexCacheWithStoreSync interface {
+	// StorePostingsSync stores postings for a single series, blocking until the write is
+	// acknowledged by the backend and returning the error if it fails.
+	StorePostingsSync(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) error
+}
+
+// StorePostingsSync stores the postings of a label of a block into the cache, blocking until the
+// write is acknowledged if it implements IndexCacheWithStoreSync. Otherwise the postings are stored
+// with StorePostings, which is assumed to be synchronous.
+func StorePostingsSync(ctx context.Context, cache IndexCache, blockID ulid.ULID, l labels.Label, v []byte) error {
+	if s, ok := cache.(IndexCacheWithStoreSync); ok {
+		return s.StorePostingsSync(ctx, blockID, l, v)
+	}
+	cache.StorePostings(ctx, blockID, l, v)
+	return nil
+}
+
+// IndexCacheWithForgetBlock is implemented by an IndexCache keeping some state for each block,
+// which can be released once the block is no longer served.
+type IndexCacheWithForgetBlock interface {
+	// ForgetBlock releases the state kept for the block, leaving its entries in the cache.
+	ForgetBlock(blockID ulid.ULID)
+}
+
+// ForgetBlock releases the state kept by the cache for the block, if it implements
+// IndexCacheWithForgetBlock. Otherwise it's a no-op.
+func ForgetBlock(cache IndexCache, blockID ulid.ULID) {
+	if f, ok := cache.(IndexCacheWithForgetBlock); ok {
+		f.ForgetBlock(blockID)
+	}
+}
+
+// addWithExemplar adds v to the requests or hits counter, attaching the ID of the trace of the
+// request as an exemplar if it's traced, so that a change in the hit ratio can be correlated with
+// the traces of the affected requests.
+func addWithExemplar(ctx context.Context, c prometheus.Counter, v float64) {
+	if v > 0 {
+		if adder, ok := c.(prometheus.ExemplarAdder); ok {
+			if traceID, ok := tracing.TraceIDFromContext(ctx); ok {
+				adder.AddWithExemplar(v, prometheus.Labels{"traceID": traceID})
+				return
+			}
+		}
+	}
+	c.Add(v)
 }
 
 type cacheKey struct {
@@ -51,8 +217,14 @@ func (c cacheKey) keyType() string {
 	switch c.key.(type) {
 	case cacheKeyPostings:
 		return cacheTypePostings
+	case cacheKeyExpandedPostings:
+		return cacheTypeExpandedPostings
 	case cacheKeySeries:
 		return cacheTypeSeries
+	case cacheKeyLabelValues:
+		return cacheTypeLabelValues
+	case cacheKeyLabelNames:
+		return cacheTypeLabelNames
 	}
 	return "<unknown>"
 }
@@ -62,8 +234,17 @@ func (c cacheKey) size() uint64 {
 	case cacheKeyPostings:
 		// ULID + 2 slice headers + number of chars in value and name.
 		return ulidSize + 2*sliceHeaderSize + uint64(len(k.Value)+len(k.Name))
+	case cacheKeyExpandedPostings:
+		// ULID + string header + number of chars in the matchers.
+		return ulidSize + sliceHeaderSize + uint64(len(k))
 	case cacheKeySeries:
 		return ulidSize + 8 // ULID + uint64.
+	case cacheKeyLabelValues:
+		// ULID + 2 string headers + number of chars in the label name and matchers.
+		return ulidSize + 2*sliceHeaderSize + uint64(len(k.name)+len(k.matchers))
+	case cacheKeyLabelNames:
+		// ULID + string header + number of chars in the matchers.
+		return ulidSize + sliceHeaderSize + uint64(len(k))
 	}
 	return 0
 }
@@ -75,13 +256,285 @@ func (c cacheKey) string() string {
 		// which would end up in wrong query results.
 		lbl := c.key.(cacheKeyPostings)
 		lblHash := blake2b.Sum256([]byte(lbl.Name + ":" + lbl.Value))
-		return "P:" + c.block.String() + ":" + base64.RawURLEncoding.EncodeToString(lblHash[0:])
+		return keyStringPrefixPostings + ":" + c.block.String() + ":" + base64.RawURLEncoding.EncodeToString(lblHash[0:])
+	case cacheKeyExpandedPostings:
+		matchersHash := blake2b.Sum256([]byte(c.key.(cacheKeyExpandedPostings)))
+		return keyStringPrefixExpandedPostings + ":" + c.block.String() + ":" + base64.RawURLEncoding.EncodeToString(matchersHash[0:])
+	case cacheKeySeries:
+		return keyStringPrefixSeries + ":" + c.block.String() + ":" + strconv.FormatUint(uint64(c.key.(cacheKeySeries)), 10)
+	case cacheKeyLabelValues:
+		lv := c.key.(cacheKeyLabelValues)
+		lvHash := blake2b.Sum256([]byte(lv.name + ":" + lv.matchers))
+		return keyStringPrefixLabelValues + ":" + c.block.String() + ":" + base64.RawURLEncoding.EncodeToString(lvHash[0:])
+	case cacheKeyLabelNames:
+		matchersHash := blake2b.Sum256([]byte(c.key.(cacheKeyLabelNames)))
+		return keyStringPrefixLabelNames + ":" + c.block.String() + ":" + base64.RawURLEncoding.EncodeToString(matchersHash[0:])
+	case cacheKeyBlockKeys:
+		return keyStringPrefixBlockKeys + ":" + c.block.String()
+	default:
+		return ""
+	}
+}
+
+// Prefixes of the key strings by type of item.
+const (
+	keyStringPrefixPostings         = "P"
+	keyStringPrefixExpandedPostings = "E"
+	keyStringPrefixSeries           = "S"
+	keyStringPrefixLabelValues      = "LV"
+	keyStringPrefixLabelNames       = "LN"
+	keyStringPrefixBlockKeys        = "BK"
+)
+
+// keyStringHashSize is the size of the hash of the items identified by hash in key strings.
+const keyStringHashSize = blake2b.Size256
+
+// cacheKeyParts holds the parts a key string is made of. The postings, expanded postings, label
+// values and label names items are hashed, so only their hash can be recovered from a key string, while
+// the series ID is recovered as is.
+type cacheKeyParts struct {
+	prefix string
+	block  ulid.ULID
+	// id is the series ID of the series keys.
+	id uint64
+	// hash is the hash of the items identified by hash.
+	hash []byte
+}
+
+// parseCacheKeyString decodes the key string as returned by cacheKey.string() into its parts.
+// Only canonical key strings are accepted, so that the parts are encoded back to the same string.
+func parseCacheKeyString(s string) (cacheKeyParts, error) {
+	prefix, rest, ok := strings.Cut(s, ":")
+	if !ok || len(rest) < ulid.EncodedSize {
+		return cacheKeyParts{}, errors.Errorf("malformed cache key %q", s)
+	}
+	block, err := ulid.ParseStrict(rest[:ulid.EncodedSize])
+	if err != nil || block.String() != rest[:ulid.EncodedSize] {
+		return cacheKeyParts{}, errors.Errorf("malformed block ID in cache key %q", s)
+	}
+	parts := cacheKeyParts{prefix: prefix, block: block}
+
+	rest = rest[ulid.EncodedSize:]
+	if prefix == keyStringPrefixBlockKeys {
+		if rest != "" {
+			return cacheKeyParts{}, errors.Errorf("unexpected suffix in cache key %q", s)
+		}
+		return parts, nil
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return cacheKeyParts{}, errors.Errorf("malformed cache key %q", s)
+	}
+	rest = rest[1:]
+
+	switch prefix {
+	case keyStringPrefixSeries:
+		parts.id, err = strconv.ParseUint(rest, 10, 64)
+		if err != nil || strconv.FormatUint(parts.id, 10) != rest {
+			return cacheKeyParts{}, errors.Errorf("malformed series ID in cache key %q", s)
+		}
+	case keyStringPrefixPostings, keyStringPrefixExpandedPostings, keyStringPrefixLabelValues, keyStringPrefixLabelNames:
+		parts.hash, err = base64.RawURLEncoding.Strict().DecodeString(rest)
+		if err != nil || len(parts.hash) != keyStringHashSize {
+			return cacheKeyParts{}, errors.Errorf("malformed hash in cache key %q", s)
+		}
+	default:
+		return cacheKeyParts{}, errors.Errorf("unknown type of cache key %q", s)
+	}
+	return parts, nil
+}
+
+// string encodes the parts back to the key string they've been decoded from.
+func (p cacheKeyParts) string() string {
+	switch p.prefix {
+	case keyStringPrefixBlockKeys:
+		return p.prefix + ":" + p.block.String()
+	case keyStringPrefixSeries:
+		return p.prefix + ":" + p.block.String() + ":" + strconv.FormatUint(p.id, 10)
+	default:
+		return p.prefix + ":" + p.block.String() + ":" + base64.RawURLEncoding.EncodeToString(p.hash)
+	}
+}
+
+// fingerprint returns a hash of the block and item the key identifies. Unlike the key string,
+// it's computed from an unambiguous encoding of the item, length-prefixing its variable size
+// fields, so that it can be used to detect two items mapping to the same key string.
+func (c cacheKey) fingerprint() uint64 {
+	d := xxhash.New()
+	_, _ = d.Write(c.block[:])
+	_, _ = d.WriteString(c.keyType())
+
+	writeString := func(s string) {
+		var buf [binary.MaxVarintLen64]byte
+		_, _ = d.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+		_, _ = d.WriteString(s)
+	}
+	switch k := c.key.(type) {
+	case cacheKeyPostings:
+		writeString(k.Name)
+		writeString(k.Value)
+	case cacheKeyExpandedPostings:
+		writeString(string(k))
 	case cacheKeySeries:
-		return "S:" + c.block.String() + ":" + strconv.FormatUint(uint64(c.key.(cacheKeySeries)), 10)
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(k))
+		_, _ = d.Write(buf[:])
+	case cacheKeyLabelValues:
+		writeString(k.name)
+		writeString(k.matchers)
+	case cacheKeyLabelNames:
+		writeString(string(k))
+	}
+	return d.Sum64()
+}
+
+// versionedString returns the string representation of the key prefixed by the given
+// keys schema version, so that keys of different versions never collide.
+func (c cacheKey) versionedString(version int) string {
+	return "V" + strconv.Itoa(version) + ":" + c.string()
+}
+
+// tenantString returns the versioned string representation of the key namespaced by the
+// given tenant. If the tenant is empty, it's the same as versionedString. Tenants which
+// aren't safe to be used as is in a key are replaced by their hash.
+func (c cacheKey) tenantString(version int, tenant string) string {
+	if tenant == "" {
+		return c.versionedString(version)
+	}
+	return "V" + strconv.Itoa(version) + ":" + keyTenant(tenant) + "/" + c.string()
+}
+
+// compactString returns the compact representation of the key, namespaced by the given
+// tenant, if any. It's the base64 encoding of a fixed layout made of the keys schema version
+// byte, the raw block ULID and the item type byte, followed by the series ID varint or the
+// 128-bit hash of the other items, which is shorter and cheaper to build than the string
+// representation. The version must fit in a byte.
+func (c cacheKey) compactString(version int, tenant string) string {
+	buf := make([]byte, 0, 2+ulidSize+binary.MaxVarintLen64+compactKeyHashSize)
+	buf = append(buf, byte(version))
+	buf = append(buf, c.block[:]...)
+
+	switch k := c.key.(type) {
+	case cacheKeyPostings:
+		buf = append(buf, compactKeyTypePostings)
+		buf = appendCompactKeyHash(buf, k.Name, k.Value)
+	case cacheKeyExpandedPostings:
+		buf = append(buf, compactKeyTypeExpandedPostings)
+		buf = appendCompactKeyHash(buf, string(k))
+	case cacheKeySeries:
+		var tmp [binary.MaxVarintLen64]byte
+		buf = append(buf, compactKeyTypeSeries)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(k))]...)
+	case cacheKeyLabelValues:
+		buf = append(buf, compactKeyTypeLabelValues)
+		buf = appendCompactKeyHash(buf, k.name, k.matchers)
+	case cacheKeyLabelNames:
+		buf = append(buf, compactKeyTypeLabelNames)
+		buf = appendCompactKeyHash(buf, string(k))
+	case cacheKeyBlockKeys:
+		buf = append(buf, compactKeyTypeBlockKeys)
 	default:
 		return ""
 	}
+
+	key := compactKeyPrefix
+	if tenant != "" {
+		key += keyTenant(tenant) + "/"
+	}
+	return key + base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// appendCompactKeyHash appends to buf the hash of the given fields. Unlike the string keys, each
+// field is length-prefixed before being hashed, so that distinct fields can't map to the same hash
+// by moving the boundary between them.
+func appendCompactKeyHash(buf []byte, fields ...string) []byte {
+	// It can only fail with an invalid size or key.
+	d, _ := blake2b.New(compactKeyHashSize, nil)
+
+	var tmp [binary.MaxVarintLen64]byte
+	for _, f := range fields {
+		_, _ = d.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(f)))])
+		_, _ = d.Write([]byte(f))
+	}
+	return d.Sum(buf)
+}
+
+// keyTenant returns the tenant as used in a cache key, that is as is if it's safe to,
+// or replaced by its hash otherwise.
+func keyTenant(tenant string) string {
+	if isKeySafeTenant(tenant) {
+		return tenant
+	}
+	tenantHash := blake2b.Sum256([]byte(tenant))
+	return "#" + base64.RawURLEncoding.EncodeToString(tenantHash[0:])
+}
+
+// isKeySafeTenant returns whether the tenant can be used as is in a cache key, that is
+// it's short enough to keep the key within the memcached max length and only contains
+// characters which can't be confused with key separators or rejected by the backend.
+func isKeySafeTenant(tenant string) bool {
+	if len(tenant) > maxKeyTenantLength {
+		return false
+	}
+	for _, r := range tenant {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+type tenantContextKey struct{}
+
+// ContextWithTenant returns a context carrying the tenant whose cache keys should be
+// namespaced by, overriding the tenant configured in the index cache, if any.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenantFromContext returns the tenant carried by the context, if any.
+func tenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenant, ok
 }
 
 type cacheKeyPostings labels.Label
+
+// cacheKeyExpandedPostings is the canonical string representation of a set of matchers.
+// We don't use []*labels.Matcher because slices are not hashable.
+type cacheKeyExpandedPostings string
 type cacheKeySeries uint64
+
+// cacheKeyBlockKeys identifies the index of the keys stored for a block.
+type cacheKeyBlockKeys struct{}
+
+// cacheKeyLabelValues is the label name whose values are requested, along with the
+// canonical string representation of the matchers selecting the series.
+type cacheKeyLabelValues struct {
+	name     string
+	matchers string
+}
+
+func newCacheKeyLabelValues(labelName string, matchers []*labels.Matcher) cacheKeyLabelValues {
+	return cacheKeyLabelValues{name: labelName, matchers: string(newCacheKeyExpandedPostings(matchers))}
+}
+
+// cacheKeyLabelNames is the canonical string representation of the matchers selecting the
+// series whose label names are requested. Matchers reducing the set of series, and so possibly
+// the label names, are part of the key, so that the names of distinct sets are kept apart.
+type cacheKeyLabelNames string
+
+func newCacheKeyLabelNames(matchers []*labels.Matcher) cacheKeyLabelNames {
+	return cacheKeyLabelNames(newCacheKeyExpandedPostings(matchers))
+}
+
+// newCacheKeyExpandedPostings builds the expanded postings key out of the input matchers. The matchers
+// are sorted so that the same set of matchers always maps to the same key, regardless of the input order.
+func newCacheKeyExpandedPostings(matchers []*labels.Matcher) cacheKeyExpandedPostings {
+	strs := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		strs = append(strs, m.String())
+	}
+	sort.Strings(strs)
+
+	return cacheKeyExpandedPostings(strings.Join(strs, ";"))
+}
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/oklog/ulid"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"golang.org/x/crypto/blake2b"
 
@@ -40,10 +41,46 @@ func TestCacheKey_string(t *testing.T) {
 				return fmt.Sprintf("P:%s:%s", uid.String(), encodedHash)
 			}(),
 		},
+		"should stringify expanded postings cache key": {
+			key: cacheKey{uid, newCacheKeyExpandedPostings([]*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "foo", "bar"),
+				labels.MustNewMatcher(labels.MatchRegexp, "baz", "qu.*"),
+			})},
+			expected: func() string {
+				hash := blake2b.Sum256([]byte(`baz=~"qu.*";foo="bar"`))
+				encodedHash := base64.RawURLEncoding.EncodeToString(hash[0:])
+
+				return fmt.Sprintf("E:%s:%s", uid.String(), encodedHash)
+			}(),
+		},
 		"should stringify series cache key": {
 			key:      cacheKey{uid, cacheKeySeries(12345)},
 			expected: fmt.Sprintf("S:%s:12345", uid.String()),
 		},
+		"should stringify label values cache key": {
+			key: cacheKey{uid, newCacheKeyLabelValues("job", []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "foo", "bar"),
+				labels.MustNewMatcher(labels.MatchNotEqual, "job", ""),
+			})},
+			expected: func() string {
+				hash := blake2b.Sum256([]byte(`job:foo="bar";job!=""`))
+				encodedHash := base64.RawURLEncoding.EncodeToString(hash[0:])
+
+				return fmt.Sprintf("LV:%s:%s", uid.String(), encodedHash)
+			}(),
+		},
+		"should stringify label names cache key": {
+			key: cacheKey{uid, newCacheKeyLabelNames([]*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "job", "a"),
+				labels.MustNewMatcher(labels.MatchEqual, "foo", "bar"),
+			})},
+			expected: func() string {
+				hash := blake2b.Sum256([]byte(`foo="bar";job="a"`))
+				encodedHash := base64.RawURLEncoding.EncodeToString(hash[0:])
+
+				return fmt.Sprintf("LN:%s:%s", uid.String(), encodedHash)
+			}(),
+		},
 	}
 
 	for testName, testData := range tests {
@@ -54,6 +91,170 @@ func TestCacheKey_string(t *testing.T) {
 	}
 }
 
+func TestParseCacheKeyString(t *testing.T) {
+	t.Parallel()
+
+	uid := ulid.MustNew(1, nil)
+
+	for _, key := range []cacheKey{
+		{uid, cacheKeyPostings(labels.Label{Name: "foo", Value: "bar"})},
+		{uid, newCacheKeyExpandedPostings([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")})},
+		{uid, cacheKeySeries(12345)},
+		{uid, newCacheKeyLabelValues("job", nil)},
+		{uid, newCacheKeyLabelNames([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")})},
+		{uid, cacheKeyBlockKeys{}},
+	} {
+		parts, err := parseCacheKeyString(key.string())
+		testutil.Ok(t, err)
+		testutil.Equals(t, uid, parts.block)
+		testutil.Equals(t, key.string(), parts.string())
+	}
+
+	parts, err := parseCacheKeyString(cacheKey{uid, cacheKeySeries(12345)}.string())
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(12345), parts.id)
+
+	for _, malformed := range []string{
+		"",
+		"S",
+		"S:" + uid.String(),
+		"S:" + strings.ToLower(ulid.MustParse("01ARZ3NDEKTSV4RRFFQ69G5FAV").String()) + ":1",
+		"S:" + uid.String() + ":01",
+		"S:" + uid.String() + ":-1",
+		"P:" + uid.String() + ":short",
+		"BK:" + uid.String() + ":1",
+		"X:" + uid.String() + ":1",
+	} {
+		_, err := parseCacheKeyString(malformed)
+		testutil.NotOk(t, err, malformed)
+	}
+}
+
+// FuzzCacheKey checks that distinct items, of any type, never map to the same key with any of the
+// keys encodings, and that key strings are decoded back to their parts. Run it with:
+// go test -run '^$' -fuzz FuzzCacheKey ./pkg/store/cache/
+func FuzzCacheKey(f *testing.F) {
+	f.Add([]byte("block-a"), uint8(0), "name", "value", uint64(1), uint8(0), uint8(1), "", []byte("block-a"), uint8(0), "name", "other", uint64(1), uint8(0), uint8(1), "")
+	f.Add([]byte("block-a"), uint8(0), "a", "b:c", uint64(1), uint8(1), uint8(1), "", []byte("block-a"), uint8(3), "a", "b:c", uint64(1), uint8(1), uint8(1), "")
+	f.Add([]byte("block-a"), uint8(2), "", "", uint64(10), uint8(3), uint8(1), "", []byte("block-a"), uint8(2), "", "", uint64(1), uint8(3), uint8(1), "")
+	f.Add([]byte("block-a"), uint8(1), `foo="bar"`, "", uint64(0), uint8(2), uint8(1), "team-a", []byte("block-b"), uint8(1), `foo="bar"`, "", uint64(0), uint8(2), uint8(1), "team-b")
+	f.Add([]byte("block-a"), uint8(4), `foo="bar"`, "", uint64(0), uint8(3), uint8(1), "", []byte("block-a"), uint8(1), `foo="bar"`, "", uint64(0), uint8(3), uint8(1), "")
+	f.Add([]byte("block-a"), uint8(5), "", "", uint64(0), uint8(1), uint8(1), "", []byte("block-a"), uint8(5), "", "", uint64(0), uint8(1), uint8(2), "")
+	f.Add([]byte("block-a"), uint8(2), "", "", uint64(1), uint8(2), uint8(1), "team/a", []byte("block-a"), uint8(2), "", "", uint64(1), uint8(3), uint8(1), "team/a")
+
+	f.Fuzz(func(t *testing.T,
+		blockA []byte, typA uint8, a1, a2 string, idA uint64, encA, versionA uint8, tenantA string,
+		blockB []byte, typB uint8, b1, b2 string, idB uint64, encB, versionB uint8, tenantB string,
+	) {
+		keyA, ok := newFuzzedCacheKey(blockA, typA, a1, a2, idA)
+		if !ok {
+			t.Skip()
+		}
+		keyB, ok := newFuzzedCacheKey(blockB, typB, b1, b2, idB)
+		if !ok {
+			t.Skip()
+		}
+		inputA := newFuzzedCacheKeyInput(keyA, encA, versionA, tenantA)
+		inputB := newFuzzedCacheKeyInput(keyB, encB, versionB, tenantB)
+
+		for _, key := range []cacheKey{keyA, keyB} {
+			parts, err := parseCacheKeyString(key.string())
+			testutil.Ok(t, err)
+			testutil.Equals(t, key.block, parts.block)
+			testutil.Equals(t, key.string(), parts.string())
+			if id, ok := key.key.(cacheKeySeries); ok {
+				testutil.Equals(t, uint64(id), parts.id)
+			}
+		}
+
+		if inputA != inputB && inputA.encode() == inputB.encode() {
+			t.Fatalf("distinct inputs %+v and %+v map to the same key %q", inputA, inputB, inputA.encode())
+		}
+	})
+}
+
+// Encodings of the keys exercised by FuzzCacheKey.
+const (
+	fuzzedKeyEncodingString = iota
+	fuzzedKeyEncodingVersioned
+	fuzzedKeyEncodingTenant
+	fuzzedKeyEncodingCompact
+)
+
+// fuzzedCacheKeyInput is a key along with the encoding it's encoded with and the inputs of
+// the encoding, the ones not used by the encoding being zeroed, so that two inputs map to
+// the same key if and only if they're equal.
+type fuzzedCacheKeyInput struct {
+	key      cacheKey
+	encoding int
+	version  int
+	tenant   string
+}
+
+func newFuzzedCacheKeyInput(key cacheKey, encoding, version uint8, tenant string) fuzzedCacheKeyInput {
+	in := fuzzedCacheKeyInput{key: key, encoding: int(encoding % 4), version: int(version), tenant: tenant}
+	switch in.encoding {
+	case fuzzedKeyEncodingString:
+		in.version, in.tenant = 0, ""
+	case fuzzedKeyEncodingVersioned:
+		in.tenant = ""
+	case fuzzedKeyEncodingTenant:
+		// The tenant keys without tenant are the versioned ones.
+		if in.tenant == "" {
+			in.encoding = fuzzedKeyEncodingVersioned
+		}
+	}
+	return in
+}
+
+func (in fuzzedCacheKeyInput) encode() string {
+	switch in.encoding {
+	case fuzzedKeyEncodingString:
+		return in.key.string()
+	case fuzzedKeyEncodingVersioned:
+		return in.key.versionedString(in.version)
+	case fuzzedKeyEncodingTenant:
+		return in.key.tenantString(in.version, in.tenant)
+	default:
+		return in.key.compactString(in.version, in.tenant)
+	}
+}
+
+// newFuzzedCacheKey returns the key of the item of the given type, made of the given fields,
+// and whether it's valid, which isn't the case of the keys made of invalid label names.
+func newFuzzedCacheKey(block []byte, typ uint8, s1, s2 string, id uint64) (cacheKey, bool) {
+	var uid ulid.ULID
+	copy(uid[:], block)
+
+	switch typ % 6 {
+	case 0:
+		return cacheKey{uid, cacheKeyPostings(labels.Label{Name: s1, Value: s2})}, model.LabelName(s1).IsValid()
+	case 1:
+		return cacheKey{uid, cacheKeyExpandedPostings(s1)}, true
+	case 2:
+		return cacheKey{uid, cacheKeySeries(id)}, true
+	case 3:
+		return cacheKey{uid, cacheKeyLabelValues{name: s1, matchers: s2}}, model.LabelName(s1).IsValid()
+	case 4:
+		return cacheKey{uid, cacheKeyLabelNames(s1)}, true
+	default:
+		return cacheKey{uid, cacheKeyBlockKeys{}}, true
+	}
+}
+
+func TestCacheKey_string_ShouldNotDependOnMatchersOrder(t *testing.T) {
+	t.Parallel()
+
+	uid := ulid.MustNew(1, nil)
+	m1 := labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")
+	m2 := labels.MustNewMatcher(labels.MatchNotEqual, "baz", "qux")
+
+	testutil.Equals(t,
+		cacheKey{uid, newCacheKeyExpandedPostings([]*labels.Matcher{m1, m2})}.string(),
+		cacheKey{uid, newCacheKeyExpandedPostings([]*labels.Matcher{m2, m1})}.string(),
+	)
+}
+
 func TestCacheKey_string_ShouldGuaranteeReasonablyShortKeyLength(t *testing.T) {
 	t.Parallel()
 
@@ -87,6 +288,85 @@ func TestCacheKey_string_ShouldGuaranteeReasonablyShortKeyLength(t *testing.T) {
 	}
 }
 
+func TestCacheKey_versionedString_ShouldNotExceedMemcachedMaxKeyLength(t *testing.T) {
+	t.Parallel()
+
+	// Memcached rejects keys longer than 250 bytes.
+	const memcachedMaxKeyLength = 250
+
+	uid := ulid.MustNew(1, nil)
+	longValue := "/" + strings.Repeat("very/long/url/path/", 1000)
+
+	for _, key := range []cacheKey{
+		{uid, cacheKeyPostings(labels.Label{Name: "path", Value: longValue})},
+		{uid, newCacheKeyExpandedPostings([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "path", longValue)})},
+		{uid, cacheKeySeries(math.MaxUint64)},
+		{uid, newCacheKeyLabelValues(longValue, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "path", longValue)})},
+		{uid, newCacheKeyLabelNames([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "path", longValue)})},
+	} {
+		testutil.Assert(t, len(key.versionedString(math.MaxInt)) <= memcachedMaxKeyLength, "key %s exceeds the max length", key.keyType())
+	}
+}
+
+func TestCacheKey_compactString(t *testing.T) {
+	t.Parallel()
+
+	uid1 := ulid.MustNew(1, nil)
+	uid2 := ulid.MustNew(2, nil)
+	longValue := "/" + strings.Repeat("very/long/url/path/", 1000)
+	keys := []cacheKey{
+		{uid1, cacheKeyPostings(labels.Label{Name: "a:b", Value: "c"})},
+		{uid1, cacheKeyPostings(labels.Label{Name: "a", Value: "b:c"})},
+		{uid1, cacheKeyPostings(labels.Label{Name: "path", Value: longValue})},
+		{uid1, newCacheKeyExpandedPostings([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "b")})},
+		{uid1, cacheKeySeries(0)},
+		{uid1, cacheKeySeries(math.MaxUint64)},
+		{uid1, newCacheKeyLabelValues("a", []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "b")})},
+		{uid1, newCacheKeyLabelNames([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "b")})},
+		{uid1, cacheKeyBlockKeys{}},
+		{uid2, cacheKeyPostings(labels.Label{Name: "a:b", Value: "c"})},
+		{uid2, cacheKeySeries(0)},
+	}
+
+	seen := map[string]cacheKey{}
+	for _, key := range keys {
+		for _, version := range []int{1, 2} {
+			for _, tenant := range []string{"", "team-a", "team a"} {
+				compact := key.compactString(version, tenant)
+				testutil.Assert(t, strings.HasPrefix(compact, compactKeyPrefix), "unexpected key %s", compact)
+				testutil.Assert(t, len(compact) < len(key.tenantString(version, tenant)), "compact key %s isn't shorter than the string one", compact)
+
+				// Compact keys should never collide with each other nor with string keys.
+				prev, ok := seen[compact]
+				testutil.Assert(t, !ok, "key %v collides with %v", key, prev)
+				seen[compact] = key
+				seen[key.tenantString(version, tenant)] = key
+
+				// Compact keys should only be made of characters accepted by memcached.
+				for _, r := range compact {
+					testutil.Assert(t, r > ' ' && r < 0x7f, "key %s contains invalid characters", compact)
+				}
+			}
+		}
+	}
+	testutil.Equals(t, 2+base64.RawURLEncoding.EncodedLen(1+16+1+compactKeyHashSize), len(keys[2].compactString(1, "")))
+	testutil.Equals(t, 2+base64.RawURLEncoding.EncodedLen(1+16+1+1), len(keys[4].compactString(1, "")))
+}
+
+func TestCacheKeyPrefixes_ShouldBeUnique(t *testing.T) {
+	t.Parallel()
+
+	// The versioned string keys start with the version prefix.
+	prefixes := []string{"V", compactKeyPrefix, seriesCodecKeyPrefix, snappyKeyPrefix, verifiedKeyPrefix, timestampedKeyPrefix, checksummedKeyPrefix}
+	for i, p1 := range prefixes {
+		for j, p2 := range prefixes {
+			if i != j {
+				testutil.Assert(t, !strings.HasPrefix(p1, p2), "key prefix %q starts with key prefix %q", p1, p2)
+			}
+		}
+	}
+}
+
 func BenchmarkCacheKey_string_Postings(b *testing.B) {
 	uid := ulid.MustNew(1, nil)
 	key := cacheKey{uid, cacheKeyPostings(labels.Label{Name: strings.Repeat("a", 100), Value: strings.Repeat("a", 1000)})}
@@ -106,3 +386,23 @@ func BenchmarkCacheKey_string_Series(b *testing.B) {
 		key.string()
 	}
 }
+
+func BenchmarkCacheKey_compactString_Postings(b *testing.B) {
+	uid := ulid.MustNew(1, nil)
+	key := cacheKey{uid, cacheKeyPostings(labels.Label{Name: strings.Repeat("a", 100), Value: strings.Repeat("a", 1000)})}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key.compactString(CacheKeyVersion, "")
+	}
+}
+
+func BenchmarkCacheKey_compactString_Series(b *testing.B) {
+	uid := ulid.MustNew(1, nil)
+	key := cacheKey{uid, cacheKeySeries(math.MaxUint64)}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key.compactString(CacheKeyVersion, "")
+	}
+}
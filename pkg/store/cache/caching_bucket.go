@@ -35,6 +35,38 @@ var (
 	errObjNotFound = errors.Errorf("object not found")
 )
 
+type getRangeStatsContextKey struct{}
+
+// GetRangeStats counts the subranges read by the GetRange() calls made with a context
+// carrying it, by whether they were served from the cache or fetched from the bucket.
+type GetRangeStats struct {
+	mtx    sync.Mutex
+	hits   int
+	misses int
+}
+
+// ContextWithGetRangeStats returns a context carrying the stats the cached GetRange() calls
+// made with it are accounted to.
+func ContextWithGetRangeStats(ctx context.Context, stats *GetRangeStats) context.Context {
+	return context.WithValue(ctx, getRangeStatsContextKey{}, stats)
+}
+
+// Subranges returns the number of subranges served from the cache and fetched from the bucket.
+func (s *GetRangeStats) Subranges() (hits, misses int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.hits, s.misses
+}
+
+func (s *GetRangeStats) add(hits, misses int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.hits += hits
+	s.misses += misses
+}
+
 // CachingBucket implementation that provides some caching features, based on passed configuration.
 type CachingBucket struct {
 	objstore.Bucket
@@ -49,6 +81,7 @@ type CachingBucket struct {
 	operationConfigs  map[string][]*cache.OperationConfig
 	operationRequests *prometheus.CounterVec
 	operationHits     *prometheus.CounterVec
+	operationBypasses *prometheus.CounterVec
 }
 
 // NewCachingBucket creates new caching bucket with provided configuration. Configuration should not be
@@ -86,12 +119,19 @@ func NewCachingBucket(b objstore.Bucket, cfg *cache.CachingBucketConfig, logger
 			Name: "thanos_store_bucket_cache_operation_hits_total",
 			Help: "Number of operations served from cache for given config.",
 		}, []string{"operation", "config"}),
+		operationBypasses: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_store_bucket_cache_operation_bypasses_total",
+			Help: "Number of operations matching given config passed through to the bucket because the object is bigger than the max cacheable object size.",
+		}, []string{"operation", "config"}),
 	}
 
 	for op, names := range cfg.AllConfigNames() {
 		for _, n := range names {
 			cb.operationRequests.WithLabelValues(op, n)
 			cb.operationHits.WithLabelValues(op, n)
+			if op == objstore.OpGet || op == objstore.OpGetRange {
+				cb.operationBypasses.WithLabelValues(op, n)
+			}
 
 			if op == objstore.OpGetRange {
 				cb.requestedGetRangeBytes.WithLabelValues(n)
@@ -252,7 +292,8 @@ func (cb *CachingBucket) Get(ctx context.Context, name string) (io.ReadCloser, e
 	}
 
 	storeExistsCacheEntry(ctx, existsKey, true, getTime, cfg.Cache, cfg.ExistsTTL, cfg.DoesntExistTTL)
-	return &getReader{
+
+	gr := &getReader{
 		c:         cfg.Cache,
 		ctx:       ctx,
 		r:         reader,
@@ -261,7 +302,21 @@ func (cb *CachingBucket) Get(ctx context.Context, name string) (io.ReadCloser, e
 		ttl:       cfg.ContentTTL,
 		cacheKey:  contentKey,
 		maxSize:   cfg.MaxCacheableSize,
-	}, nil
+	}
+
+	// Objects too big to be cached are passed through as is, rather than buffered while read. If the
+	// size of the object isn't known upfront, it's bypassed once read beyond the max size.
+	if maxSize := cb.cfg.MaxCacheableObjectSize(); maxSize > 0 {
+		if size, err := objstore.TryToGetSize(reader); err == nil && size > maxSize {
+			cb.operationBypasses.WithLabelValues(objstore.OpGet, cfgName).Inc()
+			return reader, nil
+		}
+		if maxSize <= int64(gr.maxSize) {
+			gr.maxSize = int(maxSize)
+			gr.bypasses = cb.operationBypasses.WithLabelValues(objstore.OpGet, cfgName)
+		}
+	}
+	return gr, nil
 }
 
 func (cb *CachingBucket) IsObjNotFoundErr(err error) bool {
@@ -274,7 +329,7 @@ func (cb *CachingBucket) GetRange(ctx context.Context, name string, off, length
 	}
 
 	cfgName, cfg := cb.cfg.FindGetRangeConfig(name)
-	if cfg == nil {
+	if cfg == nil || (cfg.MaxCacheableRangeSize > 0 && length > cfg.MaxCacheableRangeSize) {
 		return cb.Bucket.GetRange(ctx, name, off, length)
 	}
 
@@ -331,6 +386,13 @@ func (cb *CachingBucket) cachedGetRange(ctx context.Context, name string, offset
 		return nil, errors.Wrapf(err, "failed to get object attributes: %s", name)
 	}
 
+	// Ranges of objects too big to be cached are read from the bucket, so that they don't evict the
+	// subranges of smaller objects.
+	if maxSize := cb.cfg.MaxCacheableObjectSize(); maxSize > 0 && attrs.Size > maxSize {
+		cb.operationBypasses.WithLabelValues(objstore.OpGetRange, cfgName).Inc()
+		return cb.Bucket.GetRange(ctx, name, offset, length)
+	}
+
 	// If length goes over object size, adjust length. We use it later to limit number of read bytes.
 	if offset+length > attrs.Size {
 		length = attrs.Size - offset
@@ -377,6 +439,9 @@ func (cb *CachingBucket) cachedGetRange(ctx context.Context, name string, offset
 	}
 	cb.fetchedGetRangeBytes.WithLabelValues(originCache, cfgName).Add(float64(totalCachedBytes))
 	cb.operationHits.WithLabelValues(objstore.OpGetRange, cfgName).Add(float64(len(hits)) / float64(len(keys)))
+	if stats, ok := ctx.Value(getRangeStatsContextKey{}).(*GetRangeStats); ok {
+		stats.add(len(hits), len(keys)-len(hits))
+	}
 
 	if len(hits) < len(keys) {
 		if hits == nil {
@@ -572,6 +637,9 @@ type getReader struct {
 	ttl       time.Duration
 	cacheKey  string
 	maxSize   int
+
+	// Incremented once the object is read beyond the max size, if it's the max cacheable object size.
+	bypasses prometheus.Counter
 }
 
 func (g *getReader) Close() error {
@@ -588,6 +656,9 @@ func (g *getReader) Read(p []byte) (n int, err error) {
 		} else {
 			// Object is larger than max size, stop caching.
 			g.buf = nil
+			if g.bypasses != nil {
+				g.bypasses.Inc()
+			}
 		}
 	}
 
@@ -41,11 +41,17 @@ type CachingWithBackendConfig struct {
 	// Basic unit used to cache chunks.
 	ChunkSubrangeSize int64 `yaml:"chunk_subrange_size"`
 
+	// Maximum length of a GetRange call served by the cache. Longer ranges are read from the bucket. Zero = unlimited.
+	ChunkMaxCacheableRangeSize model.Bytes `yaml:"chunk_max_cacheable_range_size"`
+
 	// Maximum number of GetRange requests issued by this bucket for single GetRange call. Zero or negative value = unlimited.
 	MaxChunksGetRangeRequests int `yaml:"max_chunks_get_range_requests"`
 
 	MetafileMaxSize model.Bytes `yaml:"metafile_max_size"`
 
+	// Maximum size of an object whose Get and GetRange calls are served by the cache. Bigger objects are read from the bucket. Zero = unlimited.
+	MaxCacheableObjectSize model.Bytes `yaml:"max_cacheable_object_size"`
+
 	// TTLs for various cache items.
 	ChunkObjectAttrsTTL time.Duration `yaml:"chunk_object_attrs_ttl"`
 	ChunkSubrangeTTL    time.Duration `yaml:"chunk_subrange_ttl"`
@@ -89,10 +95,11 @@ func NewCachingBucketFromYaml(yamlContent []byte, bucket objstore.Bucket, logger
 
 	var c cache.Cache
 	cfg := cache.NewCachingBucketConfig()
+	cfg.SetMaxCacheableObjectSize(int64(config.MaxCacheableObjectSize))
 
 	// Configure cache paths.
 	cfg.CacheAttributes("chunks", nil, isTSDBChunkFile, config.ChunkObjectAttrsTTL)
-	cfg.CacheGetRange("chunks", nil, isTSDBChunkFile, config.ChunkSubrangeSize, config.ChunkObjectAttrsTTL, config.ChunkSubrangeTTL, config.MaxChunksGetRangeRequests)
+	cfg.CacheGetRange("chunks", nil, isTSDBChunkFile, config.ChunkSubrangeSize, int64(config.ChunkMaxCacheableRangeSize), config.ChunkObjectAttrsTTL, config.ChunkSubrangeTTL, config.MaxChunksGetRangeRequests)
 	cfg.CacheExists("meta.jsons", nil, isMetaFile, config.MetafileExistsTTL, config.MetafileDoesntExistTTL)
 	cfg.CacheGet("meta.jsons", nil, isMetaFile, int(config.MetafileMaxSize), config.MetafileContentTTL, config.MetafileExistsTTL, config.MetafileDoesntExistTTL)
 
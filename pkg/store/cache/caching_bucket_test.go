@@ -238,7 +238,7 @@ func TestChunksCaching(t *testing.T) {
 			}
 
 			cfg := thanoscache.NewCachingBucketConfig()
-			cfg.CacheGetRange("chunks", cache, isTSDBChunkFile, subrangeSize, time.Hour, time.Hour, tc.maxGetRangeRequests)
+			cfg.CacheGetRange("chunks", cache, isTSDBChunkFile, subrangeSize, 0, time.Hour, time.Hour, tc.maxGetRangeRequests)
 
 			cachingBucket, err := NewCachingBucket(inmem, cfg, nil, nil)
 			testutil.Ok(t, err)
@@ -251,6 +251,41 @@ func TestChunksCaching(t *testing.T) {
 	}
 }
 
+func TestChunksCaching_GetRangeStats(t *testing.T) {
+	subrangeSize := int64(16000)
+	name := "/test/chunks/000001"
+
+	inmem := objstore.NewInMemBucket()
+	testutil.Ok(t, inmem.Upload(context.Background(), name, bytes.NewReader(make([]byte, 10*subrangeSize))))
+
+	cfg := thanoscache.NewCachingBucketConfig()
+	cfg.CacheGetRange("chunks", newMockCache(), isTSDBChunkFile, subrangeSize, 0, time.Hour, time.Hour, 0)
+	cachingBucket, err := NewCachingBucket(inmem, cfg, nil, nil)
+	testutil.Ok(t, err)
+
+	getRange := func(offset, length int64) (int, int) {
+		stats := &GetRangeStats{}
+		r, err := cachingBucket.GetRange(ContextWithGetRangeStats(context.Background(), stats), name, offset, length)
+		testutil.Ok(t, err)
+		_, err = io.ReadAll(r)
+		testutil.Ok(t, err)
+		testutil.Ok(t, r.Close())
+		return stats.Subranges()
+	}
+
+	hits, misses := getRange(0, 2*subrangeSize)
+	testutil.Equals(t, 0, hits)
+	testutil.Equals(t, 2, misses)
+
+	hits, misses = getRange(subrangeSize, 2*subrangeSize)
+	testutil.Equals(t, 1, hits)
+	testutil.Equals(t, 1, misses)
+
+	hits, misses = getRange(0, 3*subrangeSize)
+	testutil.Equals(t, 3, hits)
+	testutil.Equals(t, 0, misses)
+}
+
 func verifyGetRange(t *testing.T, cachingBucket *CachingBucket, name string, offset, length, expectedLength int64) {
 	r, err := cachingBucket.GetRange(context.Background(), name, offset, length)
 	testutil.Ok(t, err)
@@ -356,7 +391,7 @@ func TestInvalidOffsetAndLength(t *testing.T) {
 	b := &testBucket{objstore.NewInMemBucket()}
 
 	cfg := thanoscache.NewCachingBucketConfig()
-	cfg.CacheGetRange("chunks", newMockCache(), func(string) bool { return true }, 10000, time.Hour, time.Hour, 3)
+	cfg.CacheGetRange("chunks", newMockCache(), func(string) bool { return true }, 10000, 0, time.Hour, time.Hour, 3)
 
 	c, err := NewCachingBucket(b, cfg, nil, nil)
 	testutil.Ok(t, err)
@@ -370,6 +405,32 @@ func TestInvalidOffsetAndLength(t *testing.T) {
 	testutil.NotOk(t, err)
 }
 
+func TestGetRangeTooBigRange(t *testing.T) {
+	inmem := objstore.NewInMemBucket()
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	testutil.Ok(t, inmem.Upload(context.Background(), testFilename, bytes.NewReader(data)))
+
+	cache := newMockCache()
+	cfg := thanoscache.NewCachingBucketConfig()
+	// Only cache ranges up to 200 bytes.
+	cfg.CacheGetRange("chunks", cache, matchAll, 100, 200, time.Hour, time.Hour, 3)
+
+	cb, err := NewCachingBucket(inmem, cfg, nil, nil)
+	testutil.Ok(t, err)
+
+	// Range is too big, so it is read from the bucket and not stored to cache.
+	verifyGetRange(t, cb, testFilename, 0, 500, 500)
+	testutil.Equals(t, 0, len(cache.cache))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(cb.operationRequests.WithLabelValues(objstore.OpGetRange, "chunks")))
+
+	verifyGetRange(t, cb, testFilename, 100, 200, 200)
+	testutil.Equals(t, 3, len(cache.cache)) // Two subranges, plus the object attributes.
+	testutil.Equals(t, 1.0, promtest.ToFloat64(cb.operationRequests.WithLabelValues(objstore.OpGetRange, "chunks")))
+}
+
 type testBucket struct {
 	*objstore.InMemBucket
 }
@@ -573,6 +634,35 @@ func TestGetTooBigObject(t *testing.T) {
 	verifyExists(t, cb, testFilename, true, true, cfgName)
 }
 
+func TestMaxCacheableObjectSize(t *testing.T) {
+	inmem := objstore.NewInMemBucket()
+	cache := newMockCache()
+
+	cfg := thanoscache.NewCachingBucketConfig()
+	cfg.SetMaxCacheableObjectSize(10)
+	cfg.CacheGet("metafile", cache, matchAll, 1024, 10*time.Minute, 10*time.Minute, 2*time.Minute)
+	cfg.CacheGetRange("chunks", cache, isTSDBChunkFile, 4, 0, time.Hour, time.Hour, 0)
+
+	cb, err := NewCachingBucket(inmem, cfg, nil, nil)
+	testutil.Ok(t, err)
+
+	small, big := []byte("hello"), []byte("hello world")
+	testutil.Ok(t, inmem.Upload(context.Background(), "small", bytes.NewBuffer(small)))
+	testutil.Ok(t, inmem.Upload(context.Background(), "big", bytes.NewBuffer(big)))
+	testutil.Ok(t, inmem.Upload(context.Background(), "/test/chunks/000001", bytes.NewBuffer([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11})))
+
+	// Small objects are cached, while big ones are passed through.
+	verifyGet(t, cb, "small", small, false, "metafile")
+	verifyGet(t, cb, "small", small, true, "metafile")
+	verifyGet(t, cb, "big", big, false, "metafile")
+	verifyGet(t, cb, "big", big, false, "metafile")
+	testutil.Equals(t, 2.0, promtest.ToFloat64(cb.operationBypasses.WithLabelValues(objstore.OpGet, "metafile")))
+
+	verifyGetRange(t, cb, "/test/chunks/000001", 2, 4, 4)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(cb.operationBypasses.WithLabelValues(objstore.OpGetRange, "chunks")))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(cb.fetchedGetRangeBytes.WithLabelValues(originBucket, "chunks")))
+}
+
 func TestGetPartialRead(t *testing.T) {
 	inmem := objstore.NewInMemBucket()
 
@@ -0,0 +1,60 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+)
+
+// CompressionCodec is the codec used to compress entries stored in the remote index cache.
+type CompressionCodec string
+
+// An empty codec is equivalent to CompressionNone.
+const (
+	CompressionNone   CompressionCodec = "none"
+	CompressionSnappy CompressionCodec = "snappy"
+
+	// compressionMarkerSnappy prefixes entries compressed with snappy. Whether an entry is compressed
+	// is told by its key, so the marker is only checked to catch the entries which are malformed.
+	compressionMarkerSnappy byte = 0xff
+)
+
+var errUnsupportedCompressionCodec = errors.New("unsupported compression codec")
+
+func (c CompressionCodec) validate() error {
+	switch c {
+	case "", CompressionNone, CompressionSnappy:
+		return nil
+	default:
+		return errors.Wrapf(errUnsupportedCompressionCodec, "codec %q", c)
+	}
+}
+
+// compress encodes v with the given codec, prefixing the result with the codec marker.
+// With CompressionNone the input is returned as is.
+func compress(codec CompressionCodec, v []byte) []byte {
+	if codec != CompressionSnappy {
+		return v
+	}
+
+	result := make([]byte, 1+snappy.MaxEncodedLen(len(v)))
+	result[0] = compressionMarkerSnappy
+	compressed := snappy.Encode(result[1:], v)
+
+	return result[:1+len(compressed)]
+}
+
+// decompress decodes v, as encoded by compress with the given codec. With CompressionNone the input
+// is returned as is, whatever its first byte, given the codec entries are stored with is part of their
+// key rather than sniffed from their value.
+func decompress(codec CompressionCodec, v []byte) ([]byte, error) {
+	if codec != CompressionSnappy || len(v) == 0 {
+		return v, nil
+	}
+	if v[0] != compressionMarkerSnappy {
+		return nil, errors.New("missing snappy compression marker")
+	}
+	return snappy.Decode(nil, v[1:])
+}
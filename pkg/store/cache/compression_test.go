@@ -0,0 +1,54 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	value := bytes.Repeat([]byte("postings"), 1024)
+
+	t.Run("should round trip a snappy compressed value", func(t *testing.T) {
+		compressed := compress(CompressionSnappy, value)
+		testutil.Equals(t, compressionMarkerSnappy, compressed[0])
+		testutil.Assert(t, len(compressed) < len(value))
+
+		decompressed, err := decompress(CompressionSnappy, compressed)
+		testutil.Ok(t, err)
+		testutil.Equals(t, value, decompressed)
+	})
+
+	t.Run("should not compress a value with no compression", func(t *testing.T) {
+		testutil.Equals(t, value, compress(CompressionNone, value))
+	})
+
+	t.Run("should return values as is with no compression", func(t *testing.T) {
+		// Uncompressed values can start with the snappy marker, e.g. the label values whose count
+		// uvarint starts with 0xff, even followed by a valid snappy payload.
+		for _, v := range [][]byte{nil, {}, {0, 0, 0, 1, 0, 0, 0, 2}, {compressionMarkerSnappy, 0xff, 0xff}, compress(CompressionSnappy, value)} {
+			decompressed, err := decompress(CompressionNone, v)
+			testutil.Ok(t, err)
+			testutil.Equals(t, v, decompressed)
+		}
+	})
+
+	t.Run("should fail on malformed snappy compressed values", func(t *testing.T) {
+		for _, v := range [][]byte{{0, 0, 0, 1}, {compressionMarkerSnappy, 0xff, 0xff}} {
+			_, err := decompress(CompressionSnappy, v)
+			testutil.NotOk(t, err)
+		}
+	})
+
+	t.Run("should reject unknown codecs", func(t *testing.T) {
+		testutil.Ok(t, CompressionCodec("").validate())
+		testutil.Ok(t, CompressionSnappy.validate())
+		testutil.NotOk(t, CompressionCodec("lz4").validate())
+	})
+}
@@ -0,0 +1,127 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// MaxProbedKeys is the maximum number of keys probed by a single ProbeKeys call.
+// Items beyond it are ignored.
+const MaxProbedKeys = 1000
+
+// KeyProbe reports the cache key of an item and whether it's currently cached.
+type KeyProbe struct {
+	Type string `json:"type"`
+	Item string `json:"item"`
+	Key  string `json:"key"`
+	Hit  bool   `json:"hit"`
+}
+
+// ProbeKeys returns the keys the cache generates for the postings of the given labels and the
+// given series of a block, and whether each is currently cached. It's a read-only diagnostic:
+// the keys are fetched as by a query, but the values are dropped and the hit metrics untouched.
+// At most MaxProbedKeys items are probed, postings first.
+func (c *RemoteIndexCache) ProbeKeys(ctx context.Context, blockID ulid.ULID, lbls []labels.Label, ids []storage.SeriesRef) ([]KeyProbe, error) {
+	total := len(lbls) + len(ids)
+	if total > MaxProbedKeys {
+		total = MaxProbedKeys
+	}
+
+	probes := make([]KeyProbe, 0, total)
+	keys := c.newRoutedKeys(total)
+	add := func(k cacheKey, item string) {
+		key := c.key(ctx, k)
+		keys.add(c.route(k), key)
+		probes = append(probes, KeyProbe{Type: k.keyType(), Item: item, Key: key})
+	}
+	for _, lbl := range lbls {
+		if len(probes) == total {
+			break
+		}
+		add(cacheKey{blockID, cacheKeyPostings(lbl)}, lbl.Name+"="+lbl.Value)
+	}
+	for _, id := range ids {
+		if len(probes) == total {
+			break
+		}
+		add(cacheKey{blockID, cacheKeySeries(id)}, strconv.FormatUint(uint64(id), 10))
+	}
+	if len(probes) == 0 {
+		return probes, nil
+	}
+
+	results, err := c.getMultiRouted(ctx, keys)
+	for i := range probes {
+		_, probes[i].Hit = results[probes[i].Key]
+	}
+	return probes, err
+}
+
+// NewProbeKeysHandler returns an HTTP handler probing the keys of the given cache, for the block
+// passed in the "block" parameter, the postings of the "label" parameters formatted as name=value,
+// and the "series" parameters. The probes are returned as JSON, along with the error of the fetch,
+// if any, in which case some probes may be reported as misses while being cached.
+func NewProbeKeysHandler(c *RemoteIndexCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		blockID, lbls, ids, err := parseProbeKeysRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := struct {
+			Block string     `json:"block"`
+			Keys  []KeyProbe `json:"keys"`
+			Error string     `json:"error,omitempty"`
+		}{Block: blockID.String()}
+
+		resp.Keys, err = c.ProbeKeys(r.Context(), blockID, lbls, ids)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func parseProbeKeysRequest(r *http.Request) (ulid.ULID, []labels.Label, []storage.SeriesRef, error) {
+	if err := r.ParseForm(); err != nil {
+		return ulid.ULID{}, nil, nil, err
+	}
+
+	blockID, err := ulid.Parse(r.Form.Get("block"))
+	if err != nil {
+		return ulid.ULID{}, nil, nil, errors.Wrapf(err, "invalid block %q", r.Form.Get("block"))
+	}
+
+	var lbls []labels.Label
+	for _, l := range r.Form["label"] {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok || name == "" {
+			return ulid.ULID{}, nil, nil, errors.Errorf("invalid label %q, expected name=value", l)
+		}
+		lbls = append(lbls, labels.Label{Name: name, Value: value})
+	}
+
+	var ids []storage.SeriesRef
+	for _, s := range r.Form["series"] {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return ulid.ULID{}, nil, nil, errors.Wrapf(err, "invalid series %q", s)
+		}
+		ids = append(ids, storage.SeriesRef(id))
+	}
+	return blockID, lbls, ids, nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestRemoteIndexCache_ProbeKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+	c.StorePostings(ctx, block, label1, []byte{1})
+	c.StoreSeries(ctx, block, 2, []byte{2})
+
+	probes, err := c.ProbeKeys(ctx, block, []labels.Label{label1, label2}, []storage.SeriesRef{1, 2})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []KeyProbe{
+		{Type: cacheTypePostings, Item: "instance=a", Key: c.key(ctx, cacheKey{block, cacheKeyPostings(label1)}), Hit: true},
+		{Type: cacheTypePostings, Item: "instance=b", Key: c.key(ctx, cacheKey{block, cacheKeyPostings(label2)}), Hit: false},
+		{Type: cacheTypeSeries, Item: "1", Key: c.key(ctx, cacheKey{block, cacheKeySeries(1)}), Hit: false},
+		{Type: cacheTypeSeries, Item: "2", Key: c.key(ctx, cacheKey{block, cacheKeySeries(2)}), Hit: true},
+	}, probes)
+
+	// Probing is not accounted as cache requests.
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.postingRequests))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.seriesRequests))
+
+	t.Run("http handler", func(t *testing.T) {
+		h := NewProbeKeysHandler(c)
+
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/debug/index-cache/keys?block="+block.String()+"&label=instance=a&series=1", nil))
+		testutil.Equals(t, http.StatusOK, rec.Code)
+
+		var resp struct {
+			Block string     `json:"block"`
+			Keys  []KeyProbe `json:"keys"`
+			Error string     `json:"error"`
+		}
+		testutil.Ok(t, json.NewDecoder(rec.Body).Decode(&resp))
+		testutil.Equals(t, block.String(), resp.Block)
+		testutil.Equals(t, "", resp.Error)
+		testutil.Equals(t, []KeyProbe{probes[0], probes[2]}, resp.Keys)
+
+		for _, query := range []string{"", "?block=invalid", "?block=" + block.String() + "&label=instance", "?block=" + block.String() + "&series=-1"} {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, "/debug/index-cache/keys"+query, nil))
+			testutil.Equals(t, http.StatusBadRequest, rec.Code, query)
+		}
+	})
+}
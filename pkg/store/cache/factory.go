@@ -11,6 +11,7 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/route"
 	"gopkg.in/yaml.v2"
 
 	"github.com/thanos-io/thanos/pkg/cacheutil"
@@ -22,6 +23,10 @@ const (
 	INMEMORY  IndexCacheProvider = "IN-MEMORY"
 	MEMCACHED IndexCacheProvider = "MEMCACHED"
 	REDIS     IndexCacheProvider = "REDIS"
+	NONE      IndexCacheProvider = "NONE"
+	MIGRATING IndexCacheProvider = "MIGRATING"
+	// GROUPCACHE is the index cache distributed among the store gateways themselves.
+	GROUPCACHE IndexCacheProvider = "GROUPCACHE"
 )
 
 // IndexCacheConfig specifies the index cache config.
@@ -30,8 +35,26 @@ type IndexCacheConfig struct {
 	Config interface{}        `yaml:"config"`
 }
 
+// MigratingIndexCacheConfig specifies the config of the backends of a migrating index cache.
+type MigratingIndexCacheConfig struct {
+	Primary   IndexCacheConfig `yaml:"primary"`
+	Secondary IndexCacheConfig `yaml:"secondary"`
+}
+
 // NewIndexCache initializes and returns new index cache.
 func NewIndexCache(logger log.Logger, confContentYaml []byte, reg prometheus.Registerer) (IndexCache, error) {
+	return NewIndexCacheWithRemoteConfig(logger, confContentYaml, reg, DefaultRemoteIndexCacheConfig)
+}
+
+// NewIndexCacheWithRemoteConfig is like NewIndexCache, but configures the remote index cache
+// created for the memcached and redis backends with the given config.
+func NewIndexCacheWithRemoteConfig(logger log.Logger, confContentYaml []byte, reg prometheus.Registerer, remoteConfig RemoteIndexCacheConfig) (IndexCache, error) {
+	return NewIndexCacheWithRouter(logger, confContentYaml, reg, remoteConfig, nil)
+}
+
+// NewIndexCacheWithRouter is like NewIndexCacheWithRemoteConfig, but serves the peers of the
+// groupcache backend with the router, which is required by that backend only.
+func NewIndexCacheWithRouter(logger log.Logger, confContentYaml []byte, reg prometheus.Registerer, remoteConfig RemoteIndexCacheConfig, r *route.Router) (IndexCache, error) {
 	level.Info(logger).Log("msg", "loading index cache configuration")
 	cacheConfig := &IndexCacheConfig{}
 	if err := yaml.UnmarshalStrict(confContentYaml, cacheConfig); err != nil {
@@ -47,18 +70,22 @@ func NewIndexCache(logger log.Logger, confContentYaml []byte, reg prometheus.Reg
 	switch strings.ToUpper(string(cacheConfig.Type)) {
 	case string(INMEMORY):
 		cache, err = NewInMemoryIndexCache(logger, reg, backendConfig)
-	case string(MEMCACHED):
-		var memcached cacheutil.RemoteCacheClient
-		memcached, err = cacheutil.NewMemcachedClient(logger, "index-cache", backendConfig, reg)
+	case string(MEMCACHED), string(REDIS):
+		var client cacheutil.RemoteCacheClient
+		client, err = newRemoteCacheClient(logger, cacheConfig.Type, backendConfig, reg)
 		if err == nil {
-			cache, err = NewRemoteIndexCache(logger, memcached, reg)
+			cache, err = NewRemoteIndexCacheWithConfig(logger, client, reg, remoteConfig)
 		}
-	case string(REDIS):
-		var redisCache cacheutil.RemoteCacheClient
-		redisCache, err = cacheutil.NewRedisClient(logger, "index-cache", backendConfig, reg)
-		if err == nil {
-			cache, err = NewRemoteIndexCache(logger, redisCache, reg)
+	case string(NONE):
+		cache = NopIndexCache{}
+	case string(MIGRATING):
+		cache, err = newMigratingIndexCache(logger, backendConfig, reg, remoteConfig, r)
+	case string(GROUPCACHE):
+		if r == nil {
+			err = errors.New("groupcache index cache requires a router to serve the peers")
+			break
 		}
+		cache, err = NewGroupcacheIndexCache(logger, reg, backendConfig, r)
 	default:
 		return nil, errors.Errorf("index cache with type %s is not supported", cacheConfig.Type)
 	}
@@ -67,3 +94,64 @@ func NewIndexCache(logger log.Logger, confContentYaml []byte, reg prometheus.Reg
 	}
 	return cache, nil
 }
+
+// NewRemoteCacheClient creates the client of the remote backend of the index cache configured
+// by the YAML config, as NewIndexCache does. The backend must be either memcached or redis.
+func NewRemoteCacheClient(logger log.Logger, confContentYaml []byte, reg prometheus.Registerer) (cacheutil.RemoteCacheClient, error) {
+	cacheConfig := &IndexCacheConfig{}
+	if err := yaml.UnmarshalStrict(confContentYaml, cacheConfig); err != nil {
+		return nil, errors.Wrap(err, "parsing config YAML file")
+	}
+
+	backendConfig, err := yaml.Marshal(cacheConfig.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of cache backend configuration")
+	}
+
+	client, err := newRemoteCacheClient(logger, cacheConfig.Type, backendConfig, reg)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("create %s cache client", cacheConfig.Type))
+	}
+	return client, nil
+}
+
+func newRemoteCacheClient(logger log.Logger, provider IndexCacheProvider, backendConfig []byte, reg prometheus.Registerer) (cacheutil.RemoteCacheClient, error) {
+	switch strings.ToUpper(string(provider)) {
+	case string(MEMCACHED):
+		return cacheutil.NewMemcachedClient(logger, "index-cache", backendConfig, reg)
+	case string(REDIS):
+		return cacheutil.NewRedisClient(logger, "index-cache", backendConfig, reg)
+	default:
+		return nil, errors.Errorf("remote cache with type %s is not supported", provider)
+	}
+}
+
+// newMigratingIndexCache creates the backends of a migrating index cache, whose metrics are
+// distinguished by the "backend" label.
+func newMigratingIndexCache(logger log.Logger, confContentYaml []byte, reg prometheus.Registerer, remoteConfig RemoteIndexCacheConfig, r *route.Router) (IndexCache, error) {
+	config := MigratingIndexCacheConfig{}
+	if err := yaml.UnmarshalStrict(confContentYaml, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing migrating index cache config")
+	}
+
+	backends := map[string]IndexCacheConfig{migrationBackendPrimary: config.Primary, migrationBackendSecondary: config.Secondary}
+	for backend, backendConfig := range backends {
+		if strings.ToUpper(string(backendConfig.Type)) == string(MIGRATING) {
+			return nil, errors.Errorf("the %s backend of a migrating index cache can't be migrating too", backend)
+		}
+	}
+
+	caches := map[string]IndexCache{}
+	for backend, backendConfig := range backends {
+		backendYaml, err := yaml.Marshal(backendConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal %s backend configuration", backend)
+		}
+		cache, err := NewIndexCacheWithRouter(logger, backendYaml, prometheus.WrapRegistererWith(prometheus.Labels{"backend": backend}, reg), remoteConfig, r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "create %s backend", backend)
+		}
+		caches[backend] = cache
+	}
+	return NewMigratingIndexCache(caches[migrationBackendPrimary], caches[migrationBackendSecondary], reg), nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+const (
+	// frequencySketchDepth is the number of rows of counters of the frequency sketch, each
+	// indexed by a different hash of the keys.
+	frequencySketchDepth = 4
+	// frequencySketchMaxCount is the value the counters saturate at, given that frequencies
+	// only matter relative to each other and are periodically halved anyway.
+	frequencySketchMaxCount = 15
+	// frequencySketchSamplesPerCounter is the number of increments per counter of a row after
+	// which all the counters are halved.
+	frequencySketchSamplesPerCounter = 10
+)
+
+// frequencySketch is a count-min sketch estimating how frequently keys are requested, whose
+// counters are halved once the number of increments reaches the sample size, so that the recent
+// frequency prevails, as described by the TinyLFU paper (https://arxiv.org/abs/1512.00727).
+// It's not safe for concurrent use.
+type frequencySketch struct {
+	// counters holds the frequencySketchDepth rows of width counters.
+	counters []uint8
+	width    uint64
+
+	increments int
+	sampleSize int
+}
+
+// newFrequencySketch returns a sketch whose rows have at least the given number of counters,
+// rounded up to a power of two.
+func newFrequencySketch(width int) *frequencySketch {
+	w := uint64(1)
+	for w < uint64(width) {
+		w <<= 1
+	}
+	return &frequencySketch{
+		counters:   make([]uint8, frequencySketchDepth*w),
+		width:      w,
+		sampleSize: frequencySketchSamplesPerCounter * int(w),
+	}
+}
+
+// index returns the index of the counter of the hash in the given row. The hashes of the rows
+// are derived from the two halves of the hash, which is enough for the counters to be independent.
+func (s *frequencySketch) index(hash uint64, row int) uint64 {
+	h := hash + uint64(row)*(hash>>32|1)
+	return uint64(row)*s.width + h&(s.width-1)
+}
+
+// increment records a request of the key of the given hash.
+func (s *frequencySketch) increment(hash uint64) {
+	for row := 0; row < frequencySketchDepth; row++ {
+		if i := s.index(hash, row); s.counters[i] < frequencySketchMaxCount {
+			s.counters[i]++
+		}
+	}
+
+	s.increments++
+	if s.increments >= s.sampleSize {
+		s.halve()
+	}
+}
+
+// estimate returns the estimated number of requests of the key of the given hash, which may
+// be overestimated because of collisions, but never underestimated, besides the halving.
+func (s *frequencySketch) estimate(hash uint64) uint8 {
+	min := uint8(frequencySketchMaxCount)
+	for row := 0; row < frequencySketchDepth; row++ {
+		if c := s.counters[s.index(hash, row)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *frequencySketch) halve() {
+	for i := range s.counters {
+		s.counters[i] >>= 1
+	}
+	s.increments /= 2
+}
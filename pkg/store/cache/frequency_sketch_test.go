@@ -0,0 +1,41 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestFrequencySketch(t *testing.T) {
+	t.Parallel()
+
+	s := newFrequencySketch(1000)
+	testutil.Equals(t, uint64(1024), s.width)
+
+	for i := 0; i < 5; i++ {
+		s.increment(1)
+	}
+	s.increment(2)
+	testutil.Equals(t, uint8(5), s.estimate(1))
+	testutil.Equals(t, uint8(1), s.estimate(2))
+	testutil.Equals(t, uint8(0), s.estimate(3))
+
+	// Counters saturate.
+	for i := 0; i < 2*frequencySketchMaxCount; i++ {
+		s.increment(4)
+	}
+	testutil.Equals(t, uint8(frequencySketchMaxCount), s.estimate(4))
+
+	// Counters are halved once the sample size is reached.
+	for s.increments > 0 && s.increments < s.sampleSize-1 {
+		s.increment(5)
+	}
+	s.increment(5)
+	testutil.Equals(t, uint8(2), s.estimate(1))
+	testutil.Equals(t, uint8(0), s.estimate(2))
+	testutil.Equals(t, uint8(frequencySketchMaxCount/2), s.estimate(4))
+	testutil.Equals(t, s.sampleSize/2, s.increments)
+}
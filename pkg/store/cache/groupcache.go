@@ -0,0 +1,340 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/vimeo/galaxycache"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/cache"
+	"github.com/thanos-io/thanos/pkg/extprom"
+)
+
+const (
+	// groupcacheIndexCacheBasePath is the path the peers of the groupcache index cache are
+	// served under, distinct from the one of the groupcache caching bucket.
+	groupcacheIndexCacheBasePath = "/_galaxycache_index/"
+
+	groupcacheKeySeparator = ":"
+)
+
+var (
+	DefaultGroupcacheIndexCacheConfig = GroupcacheIndexCacheConfig{
+		GroupcacheConfig:  cache.DefaultGroupcacheConfig,
+		MaxGetConcurrency: 16,
+	}
+
+	errGroupcacheIndexCacheNoLoader = errors.New("groupcache index cache loader not set")
+)
+
+// GroupcacheIndexCacheConfig is the configuration of the groupcache index cache.
+type GroupcacheIndexCacheConfig struct {
+	cache.GroupcacheConfig `yaml:",inline"`
+
+	// MaxGetConcurrency is the max number of items of a fetch got concurrently from the peers.
+	MaxGetConcurrency int `yaml:"max_get_concurrency"`
+}
+
+func (c *GroupcacheIndexCacheConfig) validate() error {
+	if err := c.GroupcacheConfig.Validate(); err != nil {
+		return err
+	}
+	if c.SelfURL == "" {
+		return errors.New("self URL of the groupcache index cache must be set")
+	}
+	if c.GroupcacheGroup == "" {
+		return errors.New("group of the groupcache index cache must be set")
+	}
+	if c.MaxGetConcurrency <= 0 {
+		return errors.New("max get concurrency of the groupcache index cache must be positive")
+	}
+	return nil
+}
+
+// IndexCacheLoader loads the index items of the blocks from the object storage, encoded as they
+// are stored to the index cache. The owner of an item is picked by the hash of its key, so that
+// the loader must be able to load the items of the blocks it doesn't hold.
+type IndexCacheLoader interface {
+	// LoadPostings loads the postings of a label of a block.
+	LoadPostings(ctx context.Context, blockID ulid.ULID, l labels.Label) ([]byte, error)
+
+	// LoadSeries loads a series of a block.
+	LoadSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef) ([]byte, error)
+}
+
+// GroupcacheIndexCache is an IndexCache distributed among the peers of a groupcache, each item
+// being owned by a single peer. The missing items are loaded from the object storage by their
+// owner, with its IndexCacheLoader, so that the stores are no-op. The expanded postings and the
+// label values, which can't be loaded independently of the query they're computed for, always miss.
+type GroupcacheIndexCache struct {
+	logger      log.Logger
+	galaxy      *galaxycache.Galaxy
+	timeout     time.Duration
+	concurrency int
+
+	mtx    sync.RWMutex
+	loader IndexCacheLoader
+
+	requests  *prometheus.CounterVec
+	hits      *prometheus.CounterVec
+	getErrors *prometheus.CounterVec
+}
+
+// NewGroupcacheIndexCache makes a new GroupcacheIndexCache from its YAML configuration, serving
+// the peers with the router.
+func NewGroupcacheIndexCache(logger log.Logger, reg prometheus.Registerer, conf []byte, r *route.Router) (*GroupcacheIndexCache, error) {
+	config := DefaultGroupcacheIndexCacheConfig
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing groupcache index cache config")
+	}
+	if len(config.Peers) == 0 {
+		config.Peers = append(config.Peers, config.SelfURL)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	universe := cache.NewGroupcacheUniverse(logger, extprom.WrapRegistererWithPrefix("thanos_store_index_cache_groupcache_", reg), config.GroupcacheConfig, groupcacheIndexCacheBasePath, r)
+	return NewGroupcacheIndexCacheWithUniverse(logger, reg, universe, config), nil
+}
+
+// NewGroupcacheIndexCacheWithUniverse makes a new GroupcacheIndexCache whose galaxy is part of
+// the given universe.
+func NewGroupcacheIndexCacheWithUniverse(logger log.Logger, reg prometheus.Registerer, universe *galaxycache.Universe, config GroupcacheIndexCacheConfig) *GroupcacheIndexCache {
+	c := &GroupcacheIndexCache{
+		logger:      logger,
+		timeout:     config.Timeout,
+		concurrency: config.MaxGetConcurrency,
+	}
+	c.galaxy = universe.NewGalaxy(config.GroupcacheGroup, int64(config.MaxSize), galaxycache.GetterFunc(c.load))
+
+	c.requests = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_requests_total",
+		Help: "Total number of requests to the cache.",
+	}, []string{"item_type"})
+	c.requests.WithLabelValues(cacheTypePostings)
+	c.requests.WithLabelValues(cacheTypeSeries)
+	c.requests.WithLabelValues(cacheTypeLabelValues)
+	c.requests.WithLabelValues(cacheTypeLabelNames)
+
+	c.hits = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_hits_total",
+		Help: "Total number of requests to the cache that were a hit.",
+	}, []string{"item_type"})
+	c.hits.WithLabelValues(cacheTypePostings)
+	c.hits.WithLabelValues(cacheTypeSeries)
+	c.hits.WithLabelValues(cacheTypeLabelValues)
+	c.hits.WithLabelValues(cacheTypeLabelNames)
+
+	c.getErrors = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_groupcache_get_errors_total",
+		Help: "Total number of items which couldn't be got from the groupcache, either from the peers or from the object storage, and were reported as misses.",
+	}, []string{"item_type"})
+	c.getErrors.WithLabelValues(cacheTypePostings)
+	c.getErrors.WithLabelValues(cacheTypeSeries)
+
+	level.Info(logger).Log("msg", "created groupcache index cache", "group", config.GroupcacheGroup, "maxSizeBytes", config.MaxSize)
+	return c
+}
+
+// SetLoader sets the loader the items owned by this peer are loaded with. Until it's set, the
+// items owned by this peer miss.
+func (c *GroupcacheIndexCache) SetLoader(loader IndexCacheLoader) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.loader = loader
+}
+
+// load is the getter of the galaxy, loading the items owned by this peer.
+func (c *GroupcacheIndexCache) load(ctx context.Context, key string, dest galaxycache.Codec) error {
+	c.mtx.RLock()
+	loader := c.loader
+	c.mtx.RUnlock()
+	if loader == nil {
+		return errGroupcacheIndexCacheNoLoader
+	}
+
+	blockID, l, id, err := parseGroupcacheKey(key)
+	if err != nil {
+		return err
+	}
+
+	var v []byte
+	if l != nil {
+		v, err = loader.LoadPostings(ctx, blockID, *l)
+	} else {
+		v, err = loader.LoadSeries(ctx, blockID, id)
+	}
+	if err != nil {
+		return err
+	}
+	// The index of a block never changes, so that the items never expire.
+	return dest.UnmarshalBinary(v, time.Time{})
+}
+
+// StorePostings is a no-op, given the postings are loaded by their owner on miss.
+func (c *GroupcacheIndexCache) StorePostings(context.Context, ulid.ULID, labels.Label, []byte) {}
+
+// FetchMultiPostings gets the postings from their owners, loading the missing ones.
+func (c *GroupcacheIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, lbls []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	addWithExemplar(ctx, c.requests.WithLabelValues(cacheTypePostings), float64(len(lbls)))
+
+	keys := make([]string, 0, len(lbls))
+	for _, lbl := range lbls {
+		keys = append(keys, groupcachePostingsKey(blockID, lbl))
+	}
+	values := c.getMulti(ctx, cacheTypePostings, keys)
+
+	hits = map[labels.Label][]byte{}
+	for i, lbl := range lbls {
+		if values[i] == nil {
+			misses = append(misses, lbl)
+			continue
+		}
+		hits[lbl] = values[i]
+	}
+	addWithExemplar(ctx, c.hits.WithLabelValues(cacheTypePostings), float64(len(hits)))
+	return hits, misses
+}
+
+// StoreSeries is a no-op, given the series are loaded by their owner on miss.
+func (c *GroupcacheIndexCache) StoreSeries(context.Context, ulid.ULID, storage.SeriesRef, []byte) {}
+
+// FetchMultiSeries gets the series from their owners, loading the missing ones.
+func (c *GroupcacheIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef) {
+	addWithExemplar(ctx, c.requests.WithLabelValues(cacheTypeSeries), float64(len(ids)))
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, groupcacheSeriesKey(blockID, id))
+	}
+	values := c.getMulti(ctx, cacheTypeSeries, keys)
+
+	hits = map[storage.SeriesRef][]byte{}
+	for i, id := range ids {
+		if values[i] == nil {
+			misses = append(misses, id)
+			continue
+		}
+		hits[id] = values[i]
+	}
+	addWithExemplar(ctx, c.hits.WithLabelValues(cacheTypeSeries), float64(len(hits)))
+	return hits, misses
+}
+
+// StoreLabelValues is a no-op, given the label values can't be loaded by their owner.
+func (c *GroupcacheIndexCache) StoreLabelValues(context.Context, ulid.ULID, string, []*labels.Matcher, []byte) {
+}
+
+// FetchLabelValues always returns a miss.
+func (c *GroupcacheIndexCache) FetchLabelValues(ctx context.Context, _ ulid.ULID, _ string, _ []*labels.Matcher) ([]byte, bool) {
+	addWithExemplar(ctx, c.requests.WithLabelValues(cacheTypeLabelValues), 1)
+	return nil, false
+}
+
+// StoreLabelNames is a no-op, given the label names can't be loaded by their owner.
+func (c *GroupcacheIndexCache) StoreLabelNames(context.Context, ulid.ULID, []*labels.Matcher, []byte) {
+}
+
+// FetchLabelNames always returns a miss.
+func (c *GroupcacheIndexCache) FetchLabelNames(ctx context.Context, _ ulid.ULID, _ []*labels.Matcher) ([]byte, bool) {
+	addWithExemplar(ctx, c.requests.WithLabelValues(cacheTypeLabelNames), 1)
+	return nil, false
+}
+
+// getMulti gets the values of the keys concurrently, returning them in the same order. The value
+// of the keys which couldn't be got is nil.
+func (c *GroupcacheIndexCache) getMulti(ctx context.Context, typ string, keys []string) [][]byte {
+	if c.timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	values := make([][]byte, len(keys))
+	g := errgroup.Group{}
+	g.SetLimit(c.concurrency)
+	for i, key := range keys {
+		i, key := i, key
+		g.Go(func() error {
+			codec := galaxycache.ByteCodec{}
+			if err := c.galaxy.Get(ctx, key, &codec); err != nil {
+				c.getErrors.WithLabelValues(typ).Inc()
+				level.Debug(c.logger).Log("msg", "failed getting item from groupcache", "key", key, "err", err)
+				return nil
+			}
+			v, _, _ := codec.MarshalBinary()
+			if v == nil {
+				v = []byte{}
+			}
+			values[i] = v
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return values
+}
+
+// groupcachePostingsKey returns the key of the postings of a label of a block. Unlike the remote
+// cache ones, the keys hold the label as is, given the owner has to load the postings from it.
+func groupcachePostingsKey(blockID ulid.ULID, l labels.Label) string {
+	return keyStringPrefixPostings + groupcacheKeySeparator + blockID.String() + groupcacheKeySeparator +
+		base64.RawURLEncoding.EncodeToString([]byte(l.Name)) + groupcacheKeySeparator +
+		base64.RawURLEncoding.EncodeToString([]byte(l.Value))
+}
+
+// groupcacheSeriesKey returns the key of a series of a block.
+func groupcacheSeriesKey(blockID ulid.ULID, id storage.SeriesRef) string {
+	return keyStringPrefixSeries + groupcacheKeySeparator + blockID.String() + groupcacheKeySeparator + strconv.FormatUint(uint64(id), 10)
+}
+
+// parseGroupcacheKey parses a key returned by groupcachePostingsKey or groupcacheSeriesKey,
+// returning either the label of the postings or the ID of the series.
+func parseGroupcacheKey(key string) (blockID ulid.ULID, l *labels.Label, id storage.SeriesRef, err error) {
+	parts := strings.Split(key, groupcacheKeySeparator)
+	if len(parts) < 3 {
+		return ulid.ULID{}, nil, 0, errors.Errorf("malformed groupcache index cache key %q", key)
+	}
+	if blockID, err = ulid.ParseStrict(parts[1]); err != nil {
+		return ulid.ULID{}, nil, 0, errors.Wrapf(err, "parse block of groupcache index cache key %q", key)
+	}
+
+	switch {
+	case parts[0] == keyStringPrefixPostings && len(parts) == 4:
+		name, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return ulid.ULID{}, nil, 0, errors.Wrapf(err, "parse label name of groupcache index cache key %q", key)
+		}
+		value, err := base64.RawURLEncoding.DecodeString(parts[3])
+		if err != nil {
+			return ulid.ULID{}, nil, 0, errors.Wrapf(err, "parse label value of groupcache index cache key %q", key)
+		}
+		return blockID, &labels.Label{Name: string(name), Value: string(value)}, 0, nil
+	case parts[0] == keyStringPrefixSeries && len(parts) == 3:
+		ref, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			return ulid.ULID{}, nil, 0, errors.Wrapf(err, "parse series of groupcache index cache key %q", key)
+		}
+		return blockID, nil, storage.SeriesRef(ref), nil
+	default:
+		return ulid.ULID{}, nil, 0, errors.Errorf("malformed groupcache index cache key %q", key)
+	}
+}
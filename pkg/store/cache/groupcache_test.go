@@ -0,0 +1,129 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/vimeo/galaxycache"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+type mockedIndexCacheLoader struct {
+	mtx   sync.Mutex
+	loads int
+	items map[string][]byte
+}
+
+func (l *mockedIndexCacheLoader) load(key string) ([]byte, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.loads++
+	v, ok := l.items[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return v, nil
+}
+
+func (l *mockedIndexCacheLoader) LoadPostings(_ context.Context, blockID ulid.ULID, lbl labels.Label) ([]byte, error) {
+	return l.load(groupcachePostingsKey(blockID, lbl))
+}
+
+func (l *mockedIndexCacheLoader) LoadSeries(_ context.Context, blockID ulid.ULID, id storage.SeriesRef) ([]byte, error) {
+	return l.load(groupcacheSeriesKey(blockID, id))
+}
+
+func TestGroupcacheIndexCache(t *testing.T) {
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	lbl1, lbl2 := labels.Label{Name: "a:b", Value: "c:d"}, labels.Label{Name: "a", Value: ""}
+
+	config := DefaultGroupcacheIndexCacheConfig
+	config.GroupcacheGroup = "index"
+	c := NewGroupcacheIndexCacheWithUniverse(log.NewNopLogger(), nil, galaxycache.NewUniverse(&galaxycache.NullFetchProtocol{}, "self"), config)
+
+	// Until the loader is set, all the items miss.
+	hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{lbl1})
+	testutil.Equals(t, map[labels.Label][]byte{}, hits)
+	testutil.Equals(t, []labels.Label{lbl1}, misses)
+
+	loader := &mockedIndexCacheLoader{items: map[string][]byte{
+		groupcachePostingsKey(block, lbl1): []byte("postings"),
+		groupcacheSeriesKey(block, 1):      []byte("series"),
+	}}
+	c.SetLoader(loader)
+
+	// The items are loaded on miss, and then got from the galaxy. The items which can't be loaded miss.
+	for i := 0; i < 2; i++ {
+		hits, misses = c.FetchMultiPostings(ctx, block, []labels.Label{lbl1, lbl2})
+		testutil.Equals(t, map[labels.Label][]byte{lbl1: []byte("postings")}, hits)
+		testutil.Equals(t, []labels.Label{lbl2}, misses)
+
+		seriesHits, seriesMisses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+		testutil.Equals(t, map[storage.SeriesRef][]byte{1: []byte("series")}, seriesHits)
+		testutil.Equals(t, []storage.SeriesRef{2}, seriesMisses)
+	}
+	testutil.Equals(t, 6, loader.loads)
+
+	// Stores are no-op and the label values always miss.
+	c.StoreLabelValues(ctx, block, "a", nil, []byte("values"))
+	_, ok := c.FetchLabelValues(ctx, block, "a", nil)
+	testutil.Assert(t, !ok)
+
+	testutil.Equals(t, 5.0, prom_testutil.ToFloat64(c.requests.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.hits.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c.getErrors.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 4.0, prom_testutil.ToFloat64(c.requests.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.hits.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.requests.WithLabelValues(cacheTypeLabelValues)))
+}
+
+func TestParseGroupcacheKey(t *testing.T) {
+	block := ulid.MustNew(1, nil)
+
+	for _, lbl := range []labels.Label{{Name: "a", Value: "b"}, {Name: "a:b", Value: ""}, {Name: "__name__", Value: "é/\n"}} {
+		parsedBlock, parsedLbl, _, err := parseGroupcacheKey(groupcachePostingsKey(block, lbl))
+		testutil.Ok(t, err)
+		testutil.Equals(t, block, parsedBlock)
+		testutil.Equals(t, &lbl, parsedLbl)
+	}
+
+	parsedBlock, parsedLbl, id, err := parseGroupcacheKey(groupcacheSeriesKey(block, 42))
+	testutil.Ok(t, err)
+	testutil.Equals(t, block, parsedBlock)
+	testutil.Assert(t, parsedLbl == nil)
+	testutil.Equals(t, storage.SeriesRef(42), id)
+
+	for _, key := range []string{"", "P", "S:" + block.String(), "S:" + block.String() + ":x", "P:" + block.String() + ":YQ", "X:" + block.String() + ":1", "S:notablock:1", "P:" + block.String() + ":!:YQ"} {
+		_, _, _, err := parseGroupcacheKey(key)
+		testutil.NotOk(t, err, key)
+	}
+}
+
+func TestNewGroupcacheIndexCache_InvalidConfig(t *testing.T) {
+	for _, conf := range []string{
+		`groupcache_group: index`,
+		`self_url: http://localhost:10902`,
+		`{self_url: "http://localhost:10902/", groupcache_group: index}`,
+		`{self_url: "http://localhost:10902", groupcache_group: index, max_get_concurrency: 0}`,
+		`{self_url: "http://localhost:10902", groupcache_group: index, unknown: true}`,
+	} {
+		_, err := NewGroupcacheIndexCache(log.NewNopLogger(), nil, []byte(conf), route.New())
+		testutil.NotOk(t, err, conf)
+	}
+
+	_, err := NewIndexCache(log.NewNopLogger(), []byte(`{type: GROUPCACHE, config: {self_url: "http://localhost:10902", groupcache_group: index}}`), nil)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,41 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hitRatioWindow tracks the hit ratio over a window of requests and publishes it
+// to a gauge each time the window is full, then starts a new window.
+type hitRatioWindow struct {
+	mtx      sync.Mutex
+	size     int
+	requests int
+	hits     int
+
+	gauge prometheus.Gauge
+}
+
+func newHitRatioWindow(size int, gauge prometheus.Gauge) *hitRatioWindow {
+	return &hitRatioWindow{size: size, gauge: gauge}
+}
+
+// observe records the outcome of a fetch of the given number of requested items.
+func (w *hitRatioWindow) observe(requests, hits int) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.requests += requests
+	w.hits += hits
+	if w.requests < w.size {
+		return
+	}
+
+	w.gauge.Set(float64(w.hits) / float64(w.requests))
+	w.requests = 0
+	w.hits = 0
+}
@@ -0,0 +1,32 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestHitRatioWindow(t *testing.T) {
+	t.Parallel()
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test"})
+	w := newHitRatioWindow(10, gauge)
+
+	// The ratio should not be published until the window is full.
+	w.observe(4, 4)
+	w.observe(4, 4)
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(gauge))
+
+	w.observe(2, 0)
+	testutil.Equals(t, 0.8, prom_testutil.ToFloat64(gauge))
+
+	// A new window should start once the previous one has been published.
+	w.observe(20, 5)
+	testutil.Equals(t, 0.25, prom_testutil.ToFloat64(gauge))
+}
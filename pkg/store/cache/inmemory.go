@@ -7,6 +7,7 @@ import (
 	"context"
 	"reflect"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/go-kit/log"
@@ -25,13 +26,32 @@ import (
 
 var (
 	DefaultInMemoryIndexCacheConfig = InMemoryIndexCacheConfig{
-		MaxSize:     250 * 1024 * 1024,
-		MaxItemSize: 125 * 1024 * 1024,
+		MaxSize:          250 * 1024 * 1024,
+		MaxItemSize:      125 * 1024 * 1024,
+		TTLSweepInterval: time.Minute,
 	}
 )
 
 const maxInt = int(^uint(0) >> 1)
 
+// InMemoryAdmissionPolicy is the policy deciding whether an item is admitted in the in-memory
+// index cache when items have to be evicted to make room for it.
+type InMemoryAdmissionPolicy string
+
+const (
+	// AdmissionPolicyLRU always admits the items, evicting the least recently used ones.
+	AdmissionPolicyLRU InMemoryAdmissionPolicy = "lru"
+	// AdmissionPolicyTinyLFU only admits the items estimated to be requested more frequently
+	// than the least recently used item they would evict.
+	AdmissionPolicyTinyLFU InMemoryAdmissionPolicy = "tinylfu"
+
+	// admissionSketchBytesPerItem is the average size of the items the frequency sketch of the
+	// TinyLFU admission policy is sized for, according to the max size of the cache.
+	admissionSketchBytesPerItem = 512
+	minAdmissionSketchWidth     = 1 << 10
+	maxAdmissionSketchWidth     = 1 << 24
+)
+
 type InMemoryIndexCache struct {
 	mtx sync.Mutex
 
@@ -42,6 +62,25 @@ type InMemoryIndexCache struct {
 
 	curSize uint64
 
+	// Max size and LRU segment of the item types whose size is capped independently.
+	// The segments track the recency of the keys of each type, while the entries are
+	// stored in the main LRU, so that the overall max size still caps the sum.
+	maxSizeBytesByType map[string]uint64
+	curSizeByType      map[string]uint64
+	lruByType          map[string]*lru.LRU
+
+	// TTL of the entries of each item type, if any, and the clock their expiration is checked against.
+	ttlByType map[string]time.Duration
+	now       func() time.Time
+
+	// Frequency of the requests of the keys, tracked only with the TinyLFU admission policy.
+	sketch *frequencySketch
+
+	// Channel used to stop the expired entries sweeper, and wait group tracking it.
+	stop     chan struct{}
+	stopOnce sync.Once
+	sweeper  sync.WaitGroup
+
 	evicted          *prometheus.CounterVec
 	requests         *prometheus.CounterVec
 	hits             *prometheus.CounterVec
@@ -50,6 +89,19 @@ type InMemoryIndexCache struct {
 	currentSize      *prometheus.GaugeVec
 	totalCurrentSize *prometheus.GaugeVec
 	overflow         *prometheus.CounterVec
+	expired          *prometheus.CounterVec
+	rejected         *prometheus.CounterVec
+}
+
+// inMemoryEntry is a value held in the in-memory index cache.
+type inMemoryEntry struct {
+	value []byte
+	// expiresAt is the unix time in nanoseconds the entry expires at, 0 if it never expires.
+	expiresAt int64
+}
+
+func (e inMemoryEntry) expired(now int64) bool {
+	return e.expiresAt > 0 && now >= e.expiresAt
 }
 
 // InMemoryIndexCacheConfig holds the in-memory index cache config.
@@ -58,6 +110,26 @@ type InMemoryIndexCacheConfig struct {
 	MaxSize model.Bytes `yaml:"max_size"`
 	// MaxItemSize represents maximum size of single item.
 	MaxItemSize model.Bytes `yaml:"max_item_size"`
+	// MaxPostingsSize represents maximum number of bytes the postings can take in the cache.
+	// If set to 0, postings are only capped by MaxSize.
+	MaxPostingsSize model.Bytes `yaml:"max_postings_size"`
+	// MaxSeriesSize represents maximum number of bytes the series can take in the cache.
+	// If set to 0, series are only capped by MaxSize.
+	MaxSeriesSize model.Bytes `yaml:"max_series_size"`
+	// PostingsTTL specifies the TTL of postings, label values and label names entries, after which they're
+	// considered misses. If set to 0, they never expire and are only evicted to make room.
+	PostingsTTL time.Duration `yaml:"postings_ttl"`
+	// SeriesTTL specifies the TTL of series entries, after which they're considered misses.
+	// If set to 0, they never expire and are only evicted to make room.
+	SeriesTTL time.Duration `yaml:"series_ttl"`
+	// TTLSweepInterval specifies the interval at which the expired entries are removed from
+	// the cache, while they're removed on fetch too. It's ignored if no TTL is set.
+	TTLSweepInterval time.Duration `yaml:"ttl_sweep_interval"`
+	// AdmissionPolicy specifies whether the items are always admitted when the cache is full,
+	// evicting the least recently used ones ("lru", the default), or only if estimated to be
+	// requested more frequently than the item they would evict ("tinylfu"), so that scans of
+	// items requested once don't evict the hot ones.
+	AdmissionPolicy InMemoryAdmissionPolicy `yaml:"admission_policy"`
 }
 
 // parseInMemoryIndexCacheConfig unmarshals a buffer into a InMemoryIndexCacheConfig with default values.
@@ -87,11 +159,60 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	if config.MaxItemSize > config.MaxSize {
 		return nil, errors.Errorf("max item size (%v) cannot be bigger than overall cache size (%v)", config.MaxItemSize, config.MaxSize)
 	}
+	if config.MaxPostingsSize > config.MaxSize {
+		return nil, errors.Errorf("max postings size (%v) cannot be bigger than overall cache size (%v)", config.MaxPostingsSize, config.MaxSize)
+	}
+	if config.MaxSeriesSize > config.MaxSize {
+		return nil, errors.Errorf("max series size (%v) cannot be bigger than overall cache size (%v)", config.MaxSeriesSize, config.MaxSize)
+	}
+	if config.PostingsTTL < 0 || config.SeriesTTL < 0 {
+		return nil, errors.New("TTLs cannot be negative")
+	}
+	if (config.PostingsTTL > 0 || config.SeriesTTL > 0) && config.TTLSweepInterval <= 0 {
+		return nil, errors.New("TTL sweep interval must be positive when a TTL is set")
+	}
+	switch config.AdmissionPolicy {
+	case "", AdmissionPolicyLRU, AdmissionPolicyTinyLFU:
+	default:
+		return nil, errors.Errorf("unsupported admission policy %q", config.AdmissionPolicy)
+	}
 
 	c := &InMemoryIndexCache{
-		logger:           logger,
-		maxSizeBytes:     uint64(config.MaxSize),
-		maxItemSizeBytes: uint64(config.MaxItemSize),
+		logger:             logger,
+		maxSizeBytes:       uint64(config.MaxSize),
+		maxItemSizeBytes:   uint64(config.MaxItemSize),
+		maxSizeBytesByType: map[string]uint64{},
+		curSizeByType:      map[string]uint64{},
+		lruByType:          map[string]*lru.LRU{},
+		ttlByType:          map[string]time.Duration{},
+		now:                time.Now,
+		stop:               make(chan struct{}),
+	}
+	if config.AdmissionPolicy == AdmissionPolicyTinyLFU {
+		width := uint64(config.MaxSize) / admissionSketchBytesPerItem
+		if width < minAdmissionSketchWidth {
+			width = minAdmissionSketchWidth
+		}
+		if width > maxAdmissionSketchWidth {
+			width = maxAdmissionSketchWidth
+		}
+		c.sketch = newFrequencySketch(int(width))
+	}
+	for typ, ttl := range map[string]time.Duration{cacheTypePostings: config.PostingsTTL, cacheTypeLabelValues: config.PostingsTTL, cacheTypeLabelNames: config.PostingsTTL, cacheTypeSeries: config.SeriesTTL} {
+		if ttl > 0 {
+			c.ttlByType[typ] = ttl
+		}
+	}
+	for typ, maxSize := range map[string]model.Bytes{cacheTypePostings: config.MaxPostingsSize, cacheTypeSeries: config.MaxSeriesSize} {
+		if maxSize == 0 {
+			continue
+		}
+		l, err := lru.NewLRU(maxInt, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.maxSizeBytesByType[typ] = uint64(maxSize)
+		c.lruByType[typ] = l
 	}
 
 	c.evicted = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
@@ -100,6 +221,8 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.evicted.WithLabelValues(cacheTypePostings)
 	c.evicted.WithLabelValues(cacheTypeSeries)
+	c.evicted.WithLabelValues(cacheTypeLabelValues)
+	c.evicted.WithLabelValues(cacheTypeLabelNames)
 
 	c.added = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_store_index_cache_items_added_total",
@@ -107,6 +230,8 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.added.WithLabelValues(cacheTypePostings)
 	c.added.WithLabelValues(cacheTypeSeries)
+	c.added.WithLabelValues(cacheTypeLabelValues)
+	c.added.WithLabelValues(cacheTypeLabelNames)
 
 	c.requests = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_store_index_cache_requests_total",
@@ -114,6 +239,8 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.requests.WithLabelValues(cacheTypePostings)
 	c.requests.WithLabelValues(cacheTypeSeries)
+	c.requests.WithLabelValues(cacheTypeLabelValues)
+	c.requests.WithLabelValues(cacheTypeLabelNames)
 
 	c.overflow = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_store_index_cache_items_overflowed_total",
@@ -121,6 +248,26 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.overflow.WithLabelValues(cacheTypePostings)
 	c.overflow.WithLabelValues(cacheTypeSeries)
+	c.overflow.WithLabelValues(cacheTypeLabelValues)
+	c.overflow.WithLabelValues(cacheTypeLabelNames)
+
+	c.expired = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_items_expired_total",
+		Help: "Total number of items that were removed from the index cache because expired. They're counted as evicted too.",
+	}, []string{"item_type"})
+	c.expired.WithLabelValues(cacheTypePostings)
+	c.expired.WithLabelValues(cacheTypeSeries)
+	c.expired.WithLabelValues(cacheTypeLabelValues)
+	c.expired.WithLabelValues(cacheTypeLabelNames)
+
+	c.rejected = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_items_rejected_total",
+		Help: "Total number of items that were not added to the index cache by the admission policy, because estimated to be requested less frequently than the item they would evict.",
+	}, []string{"item_type"})
+	c.rejected.WithLabelValues(cacheTypePostings)
+	c.rejected.WithLabelValues(cacheTypeSeries)
+	c.rejected.WithLabelValues(cacheTypeLabelValues)
+	c.rejected.WithLabelValues(cacheTypeLabelNames)
 
 	c.hits = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_store_index_cache_hits_total",
@@ -128,6 +275,8 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.hits.WithLabelValues(cacheTypePostings)
 	c.hits.WithLabelValues(cacheTypeSeries)
+	c.hits.WithLabelValues(cacheTypeLabelValues)
+	c.hits.WithLabelValues(cacheTypeLabelNames)
 
 	c.current = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
 		Name: "thanos_store_index_cache_items",
@@ -135,6 +284,8 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.current.WithLabelValues(cacheTypePostings)
 	c.current.WithLabelValues(cacheTypeSeries)
+	c.current.WithLabelValues(cacheTypeLabelValues)
+	c.current.WithLabelValues(cacheTypeLabelNames)
 
 	c.currentSize = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
 		Name: "thanos_store_index_cache_items_size_bytes",
@@ -142,6 +293,8 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.currentSize.WithLabelValues(cacheTypePostings)
 	c.currentSize.WithLabelValues(cacheTypeSeries)
+	c.currentSize.WithLabelValues(cacheTypeLabelValues)
+	c.currentSize.WithLabelValues(cacheTypeLabelNames)
 
 	c.totalCurrentSize = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
 		Name: "thanos_store_index_cache_total_size_bytes",
@@ -149,6 +302,8 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}, []string{"item_type"})
 	c.totalCurrentSize.WithLabelValues(cacheTypePostings)
 	c.totalCurrentSize.WithLabelValues(cacheTypeSeries)
+	c.totalCurrentSize.WithLabelValues(cacheTypeLabelValues)
+	c.totalCurrentSize.WithLabelValues(cacheTypeLabelNames)
 
 	_ = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "thanos_store_index_cache_max_size_bytes",
@@ -171,10 +326,20 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 	}
 	c.lru = l
 
+	if len(c.ttlByType) > 0 {
+		c.sweeper.Add(1)
+		go c.runSweeper(config.TTLSweepInterval)
+	}
+
 	level.Info(logger).Log(
 		"msg", "created in-memory index cache",
 		"maxItemSizeBytes", c.maxItemSizeBytes,
 		"maxSizeBytes", c.maxSizeBytes,
+		"maxPostingsSizeBytes", c.maxSizeBytesByType[cacheTypePostings],
+		"maxSeriesSizeBytes", c.maxSizeBytesByType[cacheTypeSeries],
+		"postingsTTL", config.PostingsTTL,
+		"seriesTTL", config.SeriesTTL,
+		"admissionPolicy", config.AdmissionPolicy,
 		"maxItems", "maxInt",
 	)
 	return c, nil
@@ -182,7 +347,7 @@ func NewInMemoryIndexCacheWithConfig(logger log.Logger, reg prometheus.Registere
 
 func (c *InMemoryIndexCache) onEvict(key, val interface{}) {
 	k := key.(cacheKey).keyType()
-	entrySize := sliceHeaderSize + uint64(len(val.([]byte)))
+	entrySize := sliceHeaderSize + uint64(len(val.(inMemoryEntry).value))
 
 	c.evicted.WithLabelValues(string(k)).Inc()
 	c.current.WithLabelValues(string(k)).Dec()
@@ -190,6 +355,10 @@ func (c *InMemoryIndexCache) onEvict(key, val interface{}) {
 	c.totalCurrentSize.WithLabelValues(string(k)).Sub(float64(entrySize + key.(cacheKey).size()))
 
 	c.curSize -= entrySize
+	c.curSizeByType[k] -= entrySize
+	if l, ok := c.lruByType[k]; ok {
+		l.Remove(key)
+	}
 }
 
 func (c *InMemoryIndexCache) get(typ string, key cacheKey) ([]byte, bool) {
@@ -198,12 +367,24 @@ func (c *InMemoryIndexCache) get(typ string, key cacheKey) ([]byte, bool) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	if c.sketch != nil {
+		c.sketch.increment(key.fingerprint())
+	}
+
 	v, ok := c.lru.Get(key)
 	if !ok {
 		return nil, false
 	}
+	entry := v.(inMemoryEntry)
+	if entry.expired(c.now().UnixNano()) {
+		c.removeExpired(key)
+		return nil, false
+	}
+	if l, ok := c.lruByType[typ]; ok {
+		l.Get(key)
+	}
 	c.hits.WithLabelValues(typ).Inc()
-	return v.([]byte), true
+	return entry.value, true
 }
 
 func (c *InMemoryIndexCache) set(typ string, key cacheKey, val []byte) {
@@ -212,10 +393,18 @@ func (c *InMemoryIndexCache) set(typ string, key cacheKey, val []byte) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
-	if _, ok := c.lru.Get(key); ok {
-		return
+	now := c.now()
+	if v, ok := c.lru.Get(key); ok {
+		if !v.(inMemoryEntry).expired(now.UnixNano()) {
+			return
+		}
+		c.removeExpired(key)
 	}
 
+	if c.sketch != nil && !c.admit(typ, key, size) {
+		c.rejected.WithLabelValues(typ).Inc()
+		return
+	}
 	if !c.ensureFits(size, typ) {
 		c.overflow.WithLabelValues(typ).Inc()
 		return
@@ -225,13 +414,93 @@ func (c *InMemoryIndexCache) set(typ string, key cacheKey, val []byte) {
 	// to ensure we don't waste huge amounts of space for something small.
 	v := make([]byte, len(val))
 	copy(v, val)
-	c.lru.Add(key, v)
+	entry := inMemoryEntry{value: v}
+	if ttl, ok := c.ttlByType[typ]; ok {
+		entry.expiresAt = now.Add(ttl).UnixNano()
+	}
+	c.lru.Add(key, entry)
+	if l, ok := c.lruByType[typ]; ok {
+		l.Add(key, struct{}{})
+	}
 
 	c.added.WithLabelValues(typ).Inc()
 	c.currentSize.WithLabelValues(typ).Add(float64(size))
 	c.totalCurrentSize.WithLabelValues(typ).Add(float64(size + key.size()))
 	c.current.WithLabelValues(typ).Inc()
 	c.curSize += size
+	c.curSizeByType[typ] += size
+}
+
+// admit returns whether the item is estimated to be requested more frequently than the least
+// recently used item it would evict, if any has to be evicted to make room for it. Only the first
+// item to be evicted is compared, even if more have to be evicted. Items too big to fit at all are
+// left to be rejected by ensureFits. It must be called with the lock held.
+func (c *InMemoryIndexCache) admit(typ string, key cacheKey, size uint64) bool {
+	if size > c.maxItemSizeBytes {
+		return true
+	}
+
+	victims := c.lru
+	if maxTypeSize, ok := c.maxSizeBytesByType[typ]; ok && c.curSizeByType[typ]+size > maxTypeSize {
+		if size > maxTypeSize {
+			return true
+		}
+		victims = c.lruByType[typ]
+	} else if c.curSize+size <= c.maxSizeBytes {
+		return true
+	}
+
+	victim, _, ok := victims.GetOldest()
+	if !ok {
+		return true
+	}
+	return c.sketch.estimate(key.fingerprint()) > c.sketch.estimate(victim.(cacheKey).fingerprint())
+}
+
+// removeExpired removes the expired entry of the key. It must be called with the lock held.
+func (c *InMemoryIndexCache) removeExpired(key cacheKey) {
+	c.lru.Remove(key)
+	c.expired.WithLabelValues(key.keyType()).Inc()
+}
+
+// runSweeper periodically removes the expired entries until the cache is stopped.
+func (c *InMemoryIndexCache) runSweeper(interval time.Duration) {
+	defer c.sweeper.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.sweepExpired()
+		}
+	}
+}
+
+// sweepExpired removes all the expired entries. Given the entries of different types may have
+// different TTLs, the expiration doesn't follow the LRU order, so all the entries are checked.
+func (c *InMemoryIndexCache) sweepExpired() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now().UnixNano()
+	for _, key := range c.lru.Keys() {
+		if v, ok := c.lru.Peek(key); ok && v.(inMemoryEntry).expired(now) {
+			c.removeExpired(key.(cacheKey))
+		}
+	}
+}
+
+// Stop stops the expired entries sweeper, if running. Expired entries are still
+// removed on fetch afterwards.
+func (c *InMemoryIndexCache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	c.sweeper.Wait()
 }
 
 // ensureFits tries to make sure that the passed slice will fit into the LRU cache.
@@ -249,6 +518,27 @@ func (c *InMemoryIndexCache) ensureFits(size uint64, typ string) bool {
 		return false
 	}
 
+	if maxTypeSize, ok := c.maxSizeBytesByType[typ]; ok {
+		if size > maxTypeSize {
+			level.Debug(c.logger).Log(
+				"msg", "item bigger than the max size of its type. Ignoring..",
+				"maxTypeSizeBytes", maxTypeSize,
+				"itemSize", size,
+				"cacheType", typ,
+			)
+			return false
+		}
+
+		// Evict the oldest items of the same type first, so that other types are not affected.
+		for c.curSizeByType[typ]+size > maxTypeSize {
+			key, _, ok := c.lruByType[typ].GetOldest()
+			if !ok {
+				break
+			}
+			c.lru.Remove(key)
+		}
+	}
+
 	for c.curSize+size > c.maxSizeBytes {
 		if _, _, ok := c.lru.RemoveOldest(); !ok {
 			level.Error(c.logger).Log(
@@ -271,6 +561,10 @@ func (c *InMemoryIndexCache) reset() {
 	c.currentSize.Reset()
 	c.totalCurrentSize.Reset()
 	c.curSize = 0
+	for _, l := range c.lruByType {
+		l.Purge()
+	}
+	c.curSizeByType = map[string]uint64{}
 }
 
 func copyString(s string) string {
@@ -332,3 +626,27 @@ func (c *InMemoryIndexCache) FetchMultiSeries(_ context.Context, blockID ulid.UL
 
 	return hits, misses
 }
+
+// StoreLabelValues sets the values of the label name for the series matching the matchers,
+// identified by the ulid, to the value v, if the values already exist in the cache they are not mutated.
+func (c *InMemoryIndexCache) StoreLabelValues(_ context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte) {
+	c.set(cacheTypeLabelValues, cacheKey{blockID, newCacheKeyLabelValues(copyString(labelName), matchers)}, v)
+}
+
+// FetchLabelValues fetches the values of the label name for the series matching the matchers,
+// identified by the ulid, and returns the cached value along with a boolean telling whether it was a hit.
+func (c *InMemoryIndexCache) FetchLabelValues(_ context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool) {
+	return c.get(cacheTypeLabelValues, cacheKey{blockID, newCacheKeyLabelValues(labelName, matchers)})
+}
+
+// StoreLabelNames sets the label names of the series matching the matchers, identified by the ulid,
+// to the value v, if the names already exist in the cache they are not mutated.
+func (c *InMemoryIndexCache) StoreLabelNames(_ context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	c.set(cacheTypeLabelNames, cacheKey{blockID, newCacheKeyLabelNames(matchers)}, v)
+}
+
+// FetchLabelNames fetches the label names of the series matching the matchers, identified by the
+// ulid, and returns the cached value along with a boolean telling whether it was a hit.
+func (c *InMemoryIndexCache) FetchLabelNames(_ context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	return c.get(cacheTypeLabelNames, cacheKey{blockID, newCacheKeyLabelNames(matchers)})
+}
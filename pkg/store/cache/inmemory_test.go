@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/hashicorp/golang-lru/simplelru"
@@ -426,3 +427,235 @@ func TestInMemoryIndexCache_Eviction_WithMetrics(t *testing.T) {
 	testutil.Equals(t, float64(5), promtest.ToFloat64(cache.hits.WithLabelValues(cacheTypePostings)))
 	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.hits.WithLabelValues(cacheTypeSeries)))
 }
+
+func TestInMemoryIndexCache_MaxSizeByItemType(t *testing.T) {
+	_, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, InMemoryIndexCacheConfig{
+		MaxItemSize:   100,
+		MaxSize:       100,
+		MaxSeriesSize: 101,
+	})
+	testutil.NotOk(t, err)
+
+	conf := []byte(`
+max_size: 1MB
+max_item_size: 2KB
+max_postings_size: 512KB
+max_series_size: 256KB
+`)
+	cache, err := NewInMemoryIndexCache(log.NewNopLogger(), nil, conf)
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string]uint64{cacheTypePostings: 512 * 1024, cacheTypeSeries: 256 * 1024}, cache.maxSizeBytesByType)
+
+	// Each item takes sliceHeaderSize + 2 bytes.
+	const itemSize = sliceHeaderSize + 2
+	cache, err = NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), prometheus.NewRegistry(), InMemoryIndexCacheConfig{
+		MaxItemSize:   itemSize,
+		MaxSize:       4 * itemSize,
+		MaxSeriesSize: 2 * itemSize,
+	})
+	testutil.Ok(t, err)
+
+	id := ulid.MustNew(0, nil)
+	ctx := context.Background()
+	lbls := []labels.Label{{Name: "test", Value: "1"}, {Name: "test", Value: "2"}}
+
+	cache.StorePostings(ctx, id, lbls[0], []byte{1, 1})
+	cache.StorePostings(ctx, id, lbls[1], []byte{2, 2})
+	cache.StoreSeries(ctx, id, 1, []byte{1, 1})
+	cache.StoreSeries(ctx, id, 2, []byte{2, 2})
+
+	// Touch the first series, so that the second one is the oldest series.
+	_, misses := cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{1})
+	testutil.Equals(t, 0, len(misses))
+
+	// Storing a series above the series max size should only evict the oldest series.
+	cache.StoreSeries(ctx, id, 3, []byte{3, 3})
+	testutil.Equals(t, uint64(4*itemSize), cache.curSize)
+	testutil.Equals(t, uint64(2*itemSize), cache.curSizeByType[cacheTypeSeries])
+	testutil.Equals(t, float64(0), promtest.ToFloat64(cache.evicted.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.evicted.WithLabelValues(cacheTypeSeries)))
+
+	pHits, _ := cache.FetchMultiPostings(ctx, id, lbls)
+	testutil.Equals(t, 2, len(pHits))
+	_, misses = cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{1, 2, 3})
+	testutil.Equals(t, []storage.SeriesRef{2}, misses)
+
+	// Postings are not capped by type, so they're still bound by the overall max size.
+	cache.StorePostings(ctx, id, labels.Label{Name: "test", Value: "3"}, []byte{3, 3})
+	testutil.Equals(t, uint64(4*itemSize), cache.curSize)
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.evicted.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, uint64(2*itemSize), cache.curSizeByType[cacheTypePostings])
+	testutil.Equals(t, uint64(2*itemSize), cache.curSizeByType[cacheTypeSeries])
+}
+
+func TestInMemoryIndexCache_TinyLFU(t *testing.T) {
+	_, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, InMemoryIndexCacheConfig{
+		MaxItemSize:     100,
+		MaxSize:         100,
+		AdmissionPolicy: "lfu",
+	})
+	testutil.NotOk(t, err)
+
+	// Each item takes sliceHeaderSize + 1 bytes.
+	const itemSize = sliceHeaderSize + 1
+	cache, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, InMemoryIndexCacheConfig{
+		MaxItemSize:     itemSize,
+		MaxSize:         2 * itemSize,
+		AdmissionPolicy: AdmissionPolicyTinyLFU,
+	})
+	testutil.Ok(t, err)
+
+	id := ulid.MustNew(0, nil)
+	ctx := context.Background()
+
+	// Items are admitted as long as there's room for them.
+	cache.StoreSeries(ctx, id, 1, []byte{1})
+	cache.StoreSeries(ctx, id, 2, []byte{2})
+	for i := 0; i < 3; i++ {
+		cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{1, 2})
+	}
+
+	// An item requested less frequently than the victim is rejected.
+	cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{3})
+	cache.StoreSeries(ctx, id, 3, []byte{3})
+	hits, _ := cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{1, 2, 3})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1}, 2: {2}}, hits)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(cache.rejected.WithLabelValues(cacheTypeSeries)))
+
+	// An item requested more frequently than the victim is admitted, evicting it.
+	for i := 0; i < 5; i++ {
+		cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{3})
+	}
+	cache.StoreSeries(ctx, id, 3, []byte{3})
+	hits, _ = cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{1, 2, 3})
+	testutil.Equals(t, 2, len(hits))
+	testutil.Equals(t, []byte{3}, hits[3])
+	testutil.Equals(t, 1.0, promtest.ToFloat64(cache.evicted.WithLabelValues(cacheTypeSeries)))
+
+	// Items too big are still counted as overflowed.
+	cache.StoreSeries(ctx, id, 4, []byte{4, 4})
+	testutil.Equals(t, 1.0, promtest.ToFloat64(cache.overflow.WithLabelValues(cacheTypeSeries)))
+}
+
+func TestInMemoryIndexCache_TinyLFUScanResistance(t *testing.T) {
+	lruHitRatio := runScanHeavyTrace(t, AdmissionPolicyLRU, 20000)
+	tinyLFUHitRatio := runScanHeavyTrace(t, AdmissionPolicyTinyLFU, 20000)
+	testutil.Assert(t, tinyLFUHitRatio > 2*lruHitRatio, "expected TinyLFU hit ratio %v to be much higher than the LRU one %v", tinyLFUHitRatio, lruHitRatio)
+}
+
+func BenchmarkInMemoryIndexCache_AdmissionPolicy(b *testing.B) {
+	for _, policy := range []InMemoryAdmissionPolicy{AdmissionPolicyLRU, AdmissionPolicyTinyLFU} {
+		b.Run(string(policy), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(runScanHeavyTrace(b, policy, b.N), "hit-ratio")
+		})
+	}
+}
+
+// runScanHeavyTrace runs a trace of n requests of hot series interleaved with scans of series
+// requested once against a cache with the given admission policy, and returns the hit ratio of
+// the hot series. Missed series are stored, as the store gateway does.
+func runScanHeavyTrace(t testing.TB, policy InMemoryAdmissionPolicy, n int) float64 {
+	const (
+		itemSize     = sliceHeaderSize + 8
+		hotSeries    = 50
+		scansPerHot  = 3
+		cachedSeries = 100
+	)
+	cache, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, InMemoryIndexCacheConfig{
+		MaxItemSize:     itemSize,
+		MaxSize:         cachedSeries * itemSize,
+		AdmissionPolicy: policy,
+	})
+	testutil.Ok(t, err)
+
+	id := ulid.MustNew(0, nil)
+	ctx := context.Background()
+	value := make([]byte, 8)
+	fetch := func(ref storage.SeriesRef) bool {
+		if _, misses := cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{ref}); len(misses) == 0 {
+			return true
+		}
+		cache.StoreSeries(ctx, id, ref, value)
+		return false
+	}
+
+	hits, scanned := 0, hotSeries
+	for i := 0; i < n; i++ {
+		if fetch(storage.SeriesRef(i % hotSeries)) {
+			hits++
+		}
+		for j := 0; j < scansPerHot; j++ {
+			fetch(storage.SeriesRef(scanned))
+			scanned++
+		}
+	}
+	return float64(hits) / float64(n)
+}
+
+func TestInMemoryIndexCache_TTL(t *testing.T) {
+	_, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, InMemoryIndexCacheConfig{
+		MaxItemSize: 100,
+		MaxSize:     100,
+		PostingsTTL: time.Minute,
+	})
+	testutil.NotOk(t, err)
+
+	cache, err := NewInMemoryIndexCache(log.NewNopLogger(), prometheus.NewRegistry(), []byte(`
+postings_ttl: 1m
+series_ttl: 2m
+`))
+	testutil.Ok(t, err)
+	defer cache.Stop()
+	testutil.Equals(t, map[string]time.Duration{cacheTypePostings: time.Minute, cacheTypeLabelValues: time.Minute, cacheTypeLabelNames: time.Minute, cacheTypeSeries: 2 * time.Minute}, cache.ttlByType)
+
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	id := ulid.MustNew(0, nil)
+	ctx := context.Background()
+	lbl := labels.Label{Name: "test", Value: "1"}
+
+	cache.StorePostings(ctx, id, lbl, []byte{1})
+	cache.StoreSeries(ctx, id, 1, []byte{2})
+
+	// Postings expire first, and are lazily removed on fetch.
+	now = now.Add(time.Minute)
+	_, pMisses := cache.FetchMultiPostings(ctx, id, []labels.Label{lbl})
+	testutil.Equals(t, []labels.Label{lbl}, pMisses)
+	sHits, _ := cache.FetchMultiSeries(ctx, id, []storage.SeriesRef{1})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {2}}, sHits)
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.expired.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.evicted.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, uint64(sliceHeaderSize+1), cache.curSize)
+
+	// Expired entries are replaced when stored again.
+	now = now.Add(30 * time.Second)
+	cache.StorePostings(ctx, id, lbl, []byte{3})
+	pHits, _ := cache.FetchMultiPostings(ctx, id, []labels.Label{lbl})
+	testutil.Equals(t, map[labels.Label][]byte{lbl: {3}}, pHits)
+
+	// The sweeper removes the expired entries without them being fetched.
+	now = now.Add(30 * time.Second)
+	cache.sweepExpired()
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.expired.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, 1, cache.lru.Len())
+	testutil.Equals(t, uint64(sliceHeaderSize+1), cache.curSize)
+}
+
+func TestInMemoryIndexCache_NoTTL(t *testing.T) {
+	cache, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, DefaultInMemoryIndexCacheConfig)
+	testutil.Ok(t, err)
+	defer cache.Stop()
+
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	id := ulid.MustNew(0, nil)
+	cache.StoreSeries(context.Background(), id, 1, []byte{1})
+
+	now = now.Add(365 * 24 * time.Hour)
+	cache.sweepExpired()
+	hits, _ := cache.FetchMultiSeries(context.Background(), id, []storage.SeriesRef{1})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1}}, hits)
+}
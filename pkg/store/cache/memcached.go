@@ -5,41 +5,537 @@ package storecache
 
 import (
 	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/thanos-io/thanos/pkg/cache"
 	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/model"
 )
 
 const (
 	memcachedDefaultTTL = 24 * time.Hour
+
+	// checkContextEveryNIterations is how often the context is checked for
+	// cancellation while assembling the results of a fetch.
+	checkContextEveryNIterations = 1024
+
+	blockAgeLessThanHour = "<1h"
+	blockAgeLessThanDay  = "<1d"
+	blockAgeMoreThanDay  = ">1d"
+
+	prefetchIssued  = "issued"
+	prefetchDropped = "dropped"
+
+	opGetMulti      = "getmulti"
+	opSet           = "set"
+	opSetAsync      = "setasync"
+	opAddAsync      = "addasync"
+	opSetMultiAsync = "setmultiasync"
+
+	seriesCodecEncode = "encode"
+	seriesCodecDecode = "decode"
+
+	// fingerprintSize is the size of the fingerprint entries are prefixed by with the keys
+	// verification enabled, and verifiedKeyPrefix the prefix of their keys.
+	fingerprintSize   = 8
+	verifiedKeyPrefix = "F:"
+
+	// timestampSize is the size of the write time entries are prefixed by with the timestamps
+	// enabled, and timestampedKeyPrefix the prefix of their keys.
+	timestampSize        = 8
+	timestampedKeyPrefix = "T:"
+
+	// checksumSize is the size of the checksum entries are prefixed by with the checksums
+	// enabled, and checksummedKeyPrefix the prefix of their keys.
+	checksumSize         = 4
+	checksummedKeyPrefix = "C:"
+
+	// snappyKeyPrefix is the prefix of the keys of the entries compressed with snappy.
+	snappyKeyPrefix = "Z:"
+)
+
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// DefaultRemoteIndexCacheConfig holds the default remote index cache config.
+	DefaultRemoteIndexCacheConfig = RemoteIndexCacheConfig{
+		PostingsTTL: memcachedDefaultTTL,
+		SeriesTTL:   memcachedDefaultTTL,
+		Compression: CompressionNone,
+		KeyVersion:  CacheKeyVersion,
+
+		HitRatioWindowSize: 1000,
+
+		MaxGetMultiBatchSize:   0,
+		MaxGetMultiConcurrency: 1,
+
+		MaxBlockKeysIndexSize:       10000,
+		BlockKeysIndexFlushInterval: 10 * time.Second,
+
+		MaxPrefetchedItems:     100000,
+		MaxPrefetchConcurrency: 4,
+	}
+
+	errRemoteIndexCachePostingsTTLNotPositive        = errors.New("postings TTL must be positive")
+	errRemoteIndexCacheSeriesTTLNotPositive          = errors.New("series TTL must be positive")
+	errRemoteIndexCacheKeyVersionNotPositive         = errors.New("cache key version must be positive")
+	errRemoteIndexCacheHitRatioWindowSizeNotPositive = errors.New("hit ratio window size must be positive")
+	errRemoteIndexCacheClientNil                     = errors.New("remote index cache requires a non-nil cache client")
+	errRemoteIndexCacheKeyRouterRequired             = errors.New("remote index cache requires a key router when configured with multiple cache clients")
+	errRemoteIndexCacheAsyncQueueHighWatermark       = errors.New("async queue high watermark must be between 0 and 1")
+	errRemoteIndexCacheTTLJitter                     = errors.New("TTL jitter must be between 0 and 1")
+	errRemoteIndexCacheBlockKeysIndexUntracked       = errors.New("block keys index requires tracking the block keys")
+	errRemoteIndexCacheBlockKeysIndexSizeNotPositive = errors.New("max block keys index size must be positive")
+	errRemoteIndexCacheBlockKeysFlushNotPositive     = errors.New("block keys index flush interval must be positive")
+	errRemoteIndexCacheCompactKeysVersionTooLarge    = errors.New("cache key version must fit in a byte with compact keys")
+	errRemoteIndexCacheClosed                        = errors.New("remote index cache is closed")
+	errRemoteIndexCacheSynchronousStoreUnsupported   = errors.New("synchronous store requires cache clients able to store items synchronously")
+	errRemoteIndexCacheStoreIfAbsentUnsupported      = errors.New("store if absent requires cache clients able to add items")
+	errRemoteIndexCacheStoreIfAbsentSynchronous      = errors.New("store if absent and synchronous store are mutually exclusive")
+	errRemoteIndexCachePrefetchTTLNegative           = errors.New("prefetch TTL must not be negative")
+	errRemoteIndexCachePrefetchLimitsNotPositive     = errors.New("max prefetched items and max prefetch concurrency must be positive when prefetching is enabled")
+	errRemoteIndexCacheMaxInFlightGetMultiNegative   = errors.New("max in-flight GetMulti operations must not be negative")
+	errRemoteIndexCacheGetMultiQueueWaitNegative     = errors.New("max GetMulti queue wait must not be negative")
+	errRemoteIndexCacheGetMultiQueueTimeout          = errors.New("timed out waiting for an in-flight GetMulti slot")
+	errRemoteIndexCachePostingsLabelNameAllowDenied  = errors.New("postings label names can't be both allowed and denied")
 )
 
-// RemoteIndexCache is a memcached-based index cache.
+// RemoteIndexCacheConfig holds the remote index cache config.
+type RemoteIndexCacheConfig struct {
+	// PostingsTTL specifies the TTL of postings entries stored in the cache, which also applies
+	// to the label values and label names entries.
+	PostingsTTL time.Duration `yaml:"postings_ttl"`
+	// SeriesTTL specifies the TTL of series entries stored in the cache.
+	SeriesTTL time.Duration `yaml:"series_ttl"`
+	// Compression specifies the codec used to compress entries before storing them. The
+	// compressed entries are stored under other keys, so that they're never read as
+	// uncompressed ones: changing it starts from a cold cache.
+	Compression CompressionCodec `yaml:"compression"`
+	// KeyVersion specifies the cache keys schema version. It defaults to CacheKeyVersion
+	// and can be pinned to keep reading and writing the entries of a previous version.
+	KeyVersion int `yaml:"key_version"`
+	// CompactKeys enables the compact binary encoding of the cache keys, which is shorter
+	// and cheaper to build than the default string one, at the expense of readability.
+	// Compact keys never collide with string ones, so switching encoding starts from a
+	// cold cache. It requires a KeyVersion fitting in a byte.
+	CompactKeys bool `yaml:"compact_keys"`
+
+	// HitRatioWindowSize specifies the number of requested items after which the
+	// per item type hit ratio gauge is recomputed.
+	HitRatioWindowSize int `yaml:"hit_ratio_window_size"`
+
+	// MaxItemSize specifies the maximum size of an item stored in the cache, after
+	// compression. Bigger items are skipped without being enqueued to the client.
+	// If set to 0, no maximum size is enforced.
+	MaxItemSize model.Bytes `yaml:"max_item_size"`
+
+	// MaxGetMultiBatchSize specifies the maximum number of keys fetched with a single
+	// client GetMulti(). If more keys are requested, they are split into multiple batches
+	// whose results are merged back together. If set to 0, the max batch size is unlimited.
+	MaxGetMultiBatchSize int `yaml:"max_get_multi_batch_size"`
+
+	// MaxGetMultiConcurrency specifies the maximum number of batches fetched concurrently
+	// by a single fetch. If set to 0, concurrency is unlimited.
+	MaxGetMultiConcurrency int `yaml:"max_get_multi_concurrency"`
+
+	// MaxInFlightGetMulti specifies the maximum number of client GetMulti() operations in flight
+	// across all the fetches, protecting the backend from query storms. The operations beyond
+	// are queued until a slot is released. If set to 0, the in-flight operations are unbounded.
+	MaxInFlightGetMulti int `yaml:"max_in_flight_get_multi"`
+
+	// MaxGetMultiQueueWait specifies the maximum duration a GetMulti() operation is queued for,
+	// when MaxInFlightGetMulti is reached, after which its keys are counted as misses. If set
+	// to 0, the operations are queued until the request is canceled.
+	MaxGetMultiQueueWait time.Duration `yaml:"max_get_multi_queue_wait"`
+
+	// PostingsLabelNamesAllowlist specifies the only label names whose postings are cached, if
+	// not empty, so that the cache is kept focused on the labels whose postings are reused.
+	// The postings of the other labels are never stored, and always miss without being fetched.
+	PostingsLabelNamesAllowlist []string `yaml:"postings_label_names_allowlist"`
+
+	// PostingsLabelNamesDenylist specifies the label names whose postings are never cached, e.g.
+	// high cardinality labels whose postings would be one-hit entries. Their postings are never
+	// stored, and always miss without being fetched. It can't share label names with the allowlist.
+	PostingsLabelNamesDenylist []string `yaml:"postings_label_names_denylist"`
+
+	// TrackBlockKeys enables tracking the keys stored for each block, which is
+	// required by DeleteBlock. Only the keys stored by this process are tracked.
+	TrackBlockKeys bool `yaml:"track_block_keys"`
+
+	// BlockKeysIndex enables storing in the cache the index of the keys stored for each block,
+	// so that DeleteBlock also deletes the keys stored by other processes or before a restart.
+	// The index is rewritten once per flush interval for the blocks which got new keys, so it
+	// adds some write amplification. It requires TrackBlockKeys.
+	BlockKeysIndex bool `yaml:"block_keys_index"`
+
+	// MaxBlockKeysIndexSize specifies the maximum number of keys listed by a block keys index.
+	// Once exceeded, the index is no longer updated and the keys it misses are left to expire
+	// with their TTL.
+	MaxBlockKeysIndexSize int `yaml:"max_block_keys_index_size"`
+
+	// BlockKeysIndexFlushInterval specifies how long the keys stored for a block are batched
+	// before its keys index is rewritten. The keys stored since the last flush are missing
+	// from the index if the process stops in the meantime, and are left to expire with their TTL.
+	BlockKeysIndexFlushInterval time.Duration `yaml:"block_keys_index_flush_interval"`
+
+	// AsyncQueueHighWatermark specifies the ratio, between 0 and 1, of the client async
+	// queue in use above which stores are dropped without being enqueued, given they
+	// would likely be dropped by the client anyway. It's ignored if the client doesn't
+	// expose its async queue. If set to 0, stores are never dropped.
+	AsyncQueueHighWatermark float64 `yaml:"async_queue_high_watermark"`
+
+	// TTLJitter specifies the fraction, between 0 and 1, by which the TTL of each stored
+	// entry is randomly increased or decreased, so that entries stored at the same time
+	// don't all expire at once. If set to 0, the configured TTLs are used as is.
+	TTLJitter float64 `yaml:"ttl_jitter"`
+
+	// Tenant specifies the tenant the cache keys are namespaced by, so that multiple
+	// tenants can share the same cache without their entries colliding. It's overridden
+	// by the tenant carried by the request context, if any. If empty, keys aren't namespaced.
+	Tenant string `yaml:"tenant"`
+
+	// TTLFunc, if set, returns the TTL of the entries stored for the given block, overriding
+	// the configured TTLs, e.g. to keep the entries of old blocks longer than the ones of
+	// recent blocks, which are more likely to be compacted away. The block age can be derived
+	// from its ULID timestamp and the current time. The TTL jitter is applied to the returned TTL.
+	// If the returned TTL isn't positive, the configured TTL of the item type is used.
+	TTLFunc TTLFunc `yaml:"-"`
+
+	// OperationsLog, if set, is appended with each fetch and store of the cache, as an OperationsLogEntry
+	// per line, so that the sequence of operations can be replayed offline to reproduce a behavior of the
+	// cache. Writes are serialized and synchronous, so it's meant to be enabled while debugging only.
+	OperationsLog io.Writer `yaml:"-"`
+
+	// Clock, if set, returns the current time the TTLs are computed with, and seeds the TTL
+	// jitter, so that they can be made deterministic. If nil, the real clock is used.
+	Clock func() time.Time `yaml:"-"`
+
+	// VerifyKeys enables storing a fingerprint of the item each entry is stored for along
+	// with the entry, and verifying it on fetch, so that regressions in the encoding of the
+	// cache keys mapping two items to the same key are caught. Mismatching entries are
+	// counted and considered misses. Verified entries are stored under keys of their own,
+	// given their format differs. It's meant for testing, given it makes the entries bigger.
+	VerifyKeys bool `yaml:"verify_keys"`
+
+	// StoreTimestamps enables storing the time each entry is written at along with the entry,
+	// so that FetchMultiPostingsWithAge can report how old the fetched postings are, e.g. to
+	// debug staleness. It adds 8 bytes per entry. Timestamped entries are stored under keys
+	// of their own, given their format differs.
+	StoreTimestamps bool `yaml:"store_timestamps"`
+
+	// Checksums enables storing a CRC32 checksum of each entry along with the entry, and verifying
+	// it on fetch, so that the entries truncated or corrupted by the backend are counted and considered
+	// misses, rather than decoded. It adds 4 bytes per entry. Checksummed entries are stored under keys
+	// of their own, given their format differs.
+	Checksums bool `yaml:"checksums"`
+
+	// PrefetchTTL specifies for how long the series fetched ahead of time by Prefetch are held in
+	// memory, waiting to be fetched for real. If set to 0, Prefetch is a no-op.
+	PrefetchTTL time.Duration `yaml:"prefetch_ttl"`
+
+	// MaxPrefetchedItems specifies the maximum number of prefetched series held in memory. Once
+	// reached, the newly prefetched series are discarded until some are fetched or expire.
+	MaxPrefetchedItems int `yaml:"max_prefetched_items"`
+
+	// MaxPrefetchConcurrency specifies the maximum number of prefetches running concurrently.
+	// Prefetches beyond are dropped, given they're only hints.
+	MaxPrefetchConcurrency int `yaml:"max_prefetch_concurrency"`
+
+	// TrackHitsByBlockAge enables tracking the requests and hits by age of the block, bucketed
+	// in less than an hour, less than a day and more than a day, so that it's known whether
+	// the misses concentrate in fresh or old blocks. The block age is derived from its ULID.
+	TrackHitsByBlockAge bool `yaml:"track_hits_by_block_age"`
+
+	// SynchronousStore enables storing the entries with a synchronous Set, blocking until the
+	// entries are stored and surfacing the failures, instead of enqueuing them with SetAsync,
+	// so that the cache content is deterministic, e.g. in integration tests. It trades the
+	// throughput for correctness and requires clients implementing RemoteCacheClientWithSet.
+	SynchronousStore bool `yaml:"synchronous_store"`
+
+	// StoreIfAbsent enables storing the entries with add semantics, i.e. memcached add or redis
+	// SET NX, so that only the first of the concurrent writers backfilling the same missed entry
+	// actually stores it, reducing the write amplification. The stores of existing entries are
+	// counted. It requires clients implementing RemoteCacheClientWithAdd, and can't be combined
+	// with SynchronousStore.
+	StoreIfAbsent bool `yaml:"store_if_absent"`
+
+	// SeriesCodec, if set, encodes the series values before their compression when stored, and decodes
+	// them after their decompression when fetched. The keys of the encoded values are prefixed by the
+	// codec ID, so that the entries stored before the codec was configured, or encoded with another codec,
+	// are misses. If nil, the values are stored as is.
+	SeriesCodec *SeriesCodec `yaml:"-"`
+}
+
+// TTLFunc returns the TTL of the cache entries of a block, given the current time.
+type TTLFunc func(blockID ulid.ULID, now time.Time) time.Duration
+
+func (c *RemoteIndexCacheConfig) validate() error {
+	if c.PostingsTTL <= 0 {
+		return errRemoteIndexCachePostingsTTLNotPositive
+	}
+	if c.SeriesTTL <= 0 {
+		return errRemoteIndexCacheSeriesTTLNotPositive
+	}
+	if c.KeyVersion <= 0 {
+		return errRemoteIndexCacheKeyVersionNotPositive
+	}
+	if c.CompactKeys && c.KeyVersion > math.MaxUint8 {
+		return errRemoteIndexCacheCompactKeysVersionTooLarge
+	}
+	if c.HitRatioWindowSize <= 0 {
+		return errRemoteIndexCacheHitRatioWindowSizeNotPositive
+	}
+	if c.AsyncQueueHighWatermark < 0 || c.AsyncQueueHighWatermark > 1 {
+		return errRemoteIndexCacheAsyncQueueHighWatermark
+	}
+	if c.TTLJitter < 0 || c.TTLJitter >= 1 {
+		return errRemoteIndexCacheTTLJitter
+	}
+	if c.BlockKeysIndex && !c.TrackBlockKeys {
+		return errRemoteIndexCacheBlockKeysIndexUntracked
+	}
+	if c.BlockKeysIndex && c.MaxBlockKeysIndexSize <= 0 {
+		return errRemoteIndexCacheBlockKeysIndexSizeNotPositive
+	}
+	if c.BlockKeysIndex && c.BlockKeysIndexFlushInterval <= 0 {
+		return errRemoteIndexCacheBlockKeysFlushNotPositive
+	}
+	if c.StoreIfAbsent && c.SynchronousStore {
+		return errRemoteIndexCacheStoreIfAbsentSynchronous
+	}
+	if c.PrefetchTTL < 0 {
+		return errRemoteIndexCachePrefetchTTLNegative
+	}
+	if c.PrefetchTTL > 0 && (c.MaxPrefetchedItems <= 0 || c.MaxPrefetchConcurrency <= 0) {
+		return errRemoteIndexCachePrefetchLimitsNotPositive
+	}
+	if c.MaxInFlightGetMulti < 0 {
+		return errRemoteIndexCacheMaxInFlightGetMultiNegative
+	}
+	if c.MaxGetMultiQueueWait < 0 {
+		return errRemoteIndexCacheGetMultiQueueWaitNegative
+	}
+	for _, name := range c.PostingsLabelNamesDenylist {
+		for _, allowed := range c.PostingsLabelNamesAllowlist {
+			if name == allowed {
+				return errors.Wrapf(errRemoteIndexCachePostingsLabelNameAllowDenied, "label name %s", name)
+			}
+		}
+	}
+	if c.SeriesCodec != nil {
+		if err := c.SeriesCodec.validate(); err != nil {
+			return err
+		}
+	}
+	return c.Compression.validate()
+}
+
+// KeyRouter returns the index of the cache client a key is stored to and fetched from.
+type KeyRouter func(key cacheKey) int
+
+// NewLabelNameKeyRouter returns a KeyRouter routing the postings of the given label names
+// to the mapped client index, and any other key to the first client.
+func NewLabelNameKeyRouter(clientByLabelName map[string]int) KeyRouter {
+	return func(key cacheKey) int {
+		if l, ok := key.key.(cacheKeyPostings); ok {
+			return clientByLabelName[l.Name]
+		}
+		return 0
+	}
+}
+
+// RemoteIndexCache is a memcached-based index cache. It's a typed adapter on top of generic caches:
+// it maps the index entries to the keys and the values stored to the caches, which fetch and store
+// them. The features the generic caches don't express, e.g. the synchronous store or the deletes,
+// rely on the optional capabilities of the cache clients the caches are built on.
 type RemoteIndexCache struct {
-	logger    log.Logger
-	memcached cacheutil.RemoteCacheClient
+	logger log.Logger
+	caches []cache.CacheWithError
+	// The clients the caches are built on, by index, or nil if unknown.
+	clients []cacheutil.RemoteCacheClient
+	router  KeyRouter
+	config  RemoteIndexCacheConfig
+	now     func() time.Time
+
+	// Keys stored for each block, tracked only if enabled.
+	blockKeys *blockKeysRegistry
+
+	// Whether the cache has been closed, after which the operations are short-circuited.
+	closed atomic.Bool
+
+	// Series fetched ahead of time, and the semaphore bounding the running prefetches,
+	// only if prefetching is enabled.
+	prefetched   *prefetchedEntries
+	prefetchGate chan struct{}
+
+	// Log the operations are recorded to, only if enabled.
+	operationsLog *operationsLog
+
+	// The semaphore bounding the client GetMulti() operations in flight, only if bounded.
+	getMultiGate chan struct{}
+
+	// Label names whose postings are the only ones cached, if not empty, and the ones never cached.
+	postingsAllowlist map[string]struct{}
+	postingsDenylist  map[string]struct{}
+
+	// Random source used to jitter the TTLs, protected by rngMtx.
+	rngMtx sync.Mutex
+	rng    *rand.Rand
 
 	// Metrics.
-	postingRequests prometheus.Counter
-	seriesRequests  prometheus.Counter
-	postingHits     prometheus.Counter
-	seriesHits      prometheus.Counter
+	postingRequests         prometheus.Counter
+	seriesRequests          prometheus.Counter
+	expandedPostingRequests prometheus.Counter
+	labelValuesRequests     prometheus.Counter
+	labelNamesRequests      prometheus.Counter
+	postingHits             prometheus.Counter
+	seriesHits              prometheus.Counter
+	expandedPostingHits     prometheus.Counter
+	labelValuesHits         prometheus.Counter
+	labelNamesHits          prometheus.Counter
+	postingHitRatio         *hitRatioWindow
+	seriesHitRatio          *hitRatioWindow
+	expandedPostingHitRatio *hitRatioWindow
+	labelValuesHitRatio     *hitRatioWindow
+	labelNamesHitRatio      *hitRatioWindow
+	compressionRatio        *prometheus.HistogramVec
+	decompression           map[string]decompressionMetrics
+	storedBytes             *prometheus.CounterVec
+	fetchedBytes            *prometheus.CounterVec
+	tooBigItems             *prometheus.CounterVec
+	skippedExisting         *prometheus.CounterVec
+	keyMappingErrors        *prometheus.CounterVec
+	collisions              *prometheus.CounterVec
+	corruptedEntries        *prometheus.CounterVec
+	droppedItems            *prometheus.CounterVec
+	seriesCodecFailures     *prometheus.CounterVec
+	operationDuration       *prometheus.HistogramVec
+	keyBuildDuration        *prometheus.HistogramVec
+	deletes                 prometheus.Counter
+	deleteFailures          prometheus.Counter
+	blockKeysIndexOverflows prometheus.Counter
+	blockKeysIndexFailures  prometheus.Counter
+	partialTimeouts         prometheus.Counter
+	getMultiQueueLength     prometheus.Gauge
+	getMultiQueueWait       prometheus.Histogram
+	getMultiQueueTimeouts   prometheus.Counter
+	skippedPostings         prometheus.Counter
+	prefetches              *prometheus.CounterVec
+	prefetchHits            prometheus.Counter
+	requestsByBlockAge      *prometheus.CounterVec
+	hitsByBlockAge          *prometheus.CounterVec
 }
 
-// NewRemoteIndexCache makes a new RemoteIndexCache.
+// NewRemoteIndexCache makes a new RemoteIndexCache using the default config.
 func NewRemoteIndexCache(logger log.Logger, cacheClient cacheutil.RemoteCacheClient, reg prometheus.Registerer) (*RemoteIndexCache, error) {
+	return NewRemoteIndexCacheWithConfig(logger, cacheClient, reg, DefaultRemoteIndexCacheConfig)
+}
+
+// NewRemoteIndexCacheWithConfig makes a new RemoteIndexCache.
+func NewRemoteIndexCacheWithConfig(logger log.Logger, cacheClient cacheutil.RemoteCacheClient, reg prometheus.Registerer, config RemoteIndexCacheConfig) (*RemoteIndexCache, error) {
+	return NewRemoteIndexCacheWithRouter(logger, []cacheutil.RemoteCacheClient{cacheClient}, nil, reg, config)
+}
+
+// NewRemoteIndexCacheWithRouter makes a new RemoteIndexCache storing the keys across multiple
+// cache clients, each key being routed to the client whose index is returned by the router.
+// The router is required when there's more than one client, and keys routed to an index out
+// of range are stored to the first client.
+func NewRemoteIndexCacheWithRouter(logger log.Logger, cacheClients []cacheutil.RemoteCacheClient, router KeyRouter, reg prometheus.Registerer, config RemoteIndexCacheConfig) (*RemoteIndexCache, error) {
+	caches := make([]cache.CacheWithError, 0, len(cacheClients))
+	for _, cacheClient := range cacheClients {
+		if cacheClient == nil {
+			return nil, errRemoteIndexCacheClientNil
+		}
+		// The failures are reported to the index cache, which tracks its own metrics.
+		caches = append(caches, cache.NewMemcachedCache("index-cache", log.NewNopLogger(), cacheClient, nil))
+	}
+	return newRemoteIndexCache(logger, caches, cacheClients, router, reg, config)
+}
+
+// NewRemoteIndexCacheWithCaches makes a new RemoteIndexCache on top of the given generic caches,
+// routing the keys like NewRemoteIndexCacheWithRouter does. Given the cache clients are unknown,
+// the features relying on their optional capabilities, e.g. the synchronous store, the store of
+// absent entries only or the deletes, aren't supported.
+func NewRemoteIndexCacheWithCaches(logger log.Logger, caches []cache.CacheWithError, router KeyRouter, reg prometheus.Registerer, config RemoteIndexCacheConfig) (*RemoteIndexCache, error) {
+	for _, c := range caches {
+		if c == nil {
+			return nil, errRemoteIndexCacheClientNil
+		}
+	}
+	return newRemoteIndexCache(logger, caches, make([]cacheutil.RemoteCacheClient, len(caches)), router, reg, config)
+}
+
+func newRemoteIndexCache(logger log.Logger, caches []cache.CacheWithError, cacheClients []cacheutil.RemoteCacheClient, router KeyRouter, reg prometheus.Registerer, config RemoteIndexCacheConfig) (*RemoteIndexCache, error) {
+	if len(caches) == 0 {
+		return nil, errRemoteIndexCacheClientNil
+	}
+	if len(caches) > 1 && router == nil {
+		return nil, errRemoteIndexCacheKeyRouterRequired
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.SynchronousStore {
+		for _, cacheClient := range cacheClients {
+			if _, ok := cacheClient.(cacheutil.RemoteCacheClientWithSet); !ok {
+				return nil, errRemoteIndexCacheSynchronousStoreUnsupported
+			}
+		}
+	}
+	if config.StoreIfAbsent {
+		for _, cacheClient := range cacheClients {
+			if _, ok := cacheClient.(cacheutil.RemoteCacheClientWithAdd); !ok {
+				return nil, errRemoteIndexCacheStoreIfAbsentUnsupported
+			}
+		}
+	}
+
 	c := &RemoteIndexCache{
-		logger:    logger,
-		memcached: cacheClient,
+		logger:  logger,
+		caches:  caches,
+		clients: cacheClients,
+		router:  router,
+		config:  config,
+		now:     config.Clock,
+	}
+	if c.now == nil {
+		c.now = time.Now
 	}
+	c.rng = rand.New(rand.NewSource(c.now().UnixNano()))
+	if config.TrackBlockKeys {
+		c.blockKeys = newBlockKeysRegistry()
+	}
+	if config.PrefetchTTL > 0 {
+		c.prefetched = newPrefetchedEntries(config.MaxPrefetchedItems)
+		c.prefetchGate = make(chan struct{}, config.MaxPrefetchConcurrency)
+	}
+	if config.MaxInFlightGetMulti > 0 {
+		c.getMultiGate = make(chan struct{}, config.MaxInFlightGetMulti)
+	}
+	c.postingsAllowlist = labelNamesSet(config.PostingsLabelNamesAllowlist)
+	c.postingsDenylist = labelNamesSet(config.PostingsLabelNamesDenylist)
 
 	requests := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_store_index_cache_requests_total",
@@ -47,6 +543,9 @@ func NewRemoteIndexCache(logger log.Logger, cacheClient cacheutil.RemoteCacheCli
 	}, []string{"item_type"})
 	c.postingRequests = requests.WithLabelValues(cacheTypePostings)
 	c.seriesRequests = requests.WithLabelValues(cacheTypeSeries)
+	c.expandedPostingRequests = requests.WithLabelValues(cacheTypeExpandedPostings)
+	c.labelValuesRequests = requests.WithLabelValues(cacheTypeLabelValues)
+	c.labelNamesRequests = requests.WithLabelValues(cacheTypeLabelNames)
 
 	hits := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_store_index_cache_hits_total",
@@ -54,19 +553,299 @@ func NewRemoteIndexCache(logger log.Logger, cacheClient cacheutil.RemoteCacheCli
 	}, []string{"item_type"})
 	c.postingHits = hits.WithLabelValues(cacheTypePostings)
 	c.seriesHits = hits.WithLabelValues(cacheTypeSeries)
+	c.expandedPostingHits = hits.WithLabelValues(cacheTypeExpandedPostings)
+	c.labelValuesHits = hits.WithLabelValues(cacheTypeLabelValues)
+	c.labelNamesHits = hits.WithLabelValues(cacheTypeLabelNames)
+
+	hitRatio := promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_store_index_cache_hit_ratio",
+		Help: "Ratio of items requests to the cache that were a hit, computed over a window of requests.",
+	}, []string{"item_type"})
+	c.postingHitRatio = newHitRatioWindow(config.HitRatioWindowSize, hitRatio.WithLabelValues(cacheTypePostings))
+	c.seriesHitRatio = newHitRatioWindow(config.HitRatioWindowSize, hitRatio.WithLabelValues(cacheTypeSeries))
+	c.expandedPostingHitRatio = newHitRatioWindow(config.HitRatioWindowSize, hitRatio.WithLabelValues(cacheTypeExpandedPostings))
+	c.labelValuesHitRatio = newHitRatioWindow(config.HitRatioWindowSize, hitRatio.WithLabelValues(cacheTypeLabelValues))
+	c.labelNamesHitRatio = newHitRatioWindow(config.HitRatioWindowSize, hitRatio.WithLabelValues(cacheTypeLabelNames))
+
+	// The TTLs returned by the TTLFunc, if any, and the jitter, aren't reflected, being computed per entry.
+	configuredTTL := promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_store_index_cache_configured_ttl_seconds",
+		Help: "TTL of the entries stored to the cache, as configured, before any per block override and jitter.",
+	}, []string{"item_type"})
+	configuredTTL.WithLabelValues(cacheTypePostings).Set(config.PostingsTTL.Seconds())
+	configuredTTL.WithLabelValues(cacheTypeSeries).Set(config.SeriesTTL.Seconds())
+	configuredTTL.WithLabelValues(cacheTypeExpandedPostings).Set(config.PostingsTTL.Seconds())
+	configuredTTL.WithLabelValues(cacheTypeLabelValues).Set(config.PostingsTTL.Seconds())
+	configuredTTL.WithLabelValues(cacheTypeLabelNames).Set(config.PostingsTTL.Seconds())
+
+	c.storedBytes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_stored_bytes_total",
+		Help: "Total number of bytes of the items stored in the cache.",
+	}, []string{"item_type"})
+	c.storedBytes.WithLabelValues(cacheTypePostings)
+	c.storedBytes.WithLabelValues(cacheTypeSeries)
+	c.storedBytes.WithLabelValues(cacheTypeExpandedPostings)
+	c.storedBytes.WithLabelValues(cacheTypeLabelValues)
+	c.storedBytes.WithLabelValues(cacheTypeLabelNames)
+
+	c.fetchedBytes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_fetched_bytes_total",
+		Help: "Total number of bytes of the items fetched from the cache.",
+	}, []string{"item_type"})
+	c.fetchedBytes.WithLabelValues(cacheTypePostings)
+	c.fetchedBytes.WithLabelValues(cacheTypeSeries)
+	c.fetchedBytes.WithLabelValues(cacheTypeExpandedPostings)
+	c.fetchedBytes.WithLabelValues(cacheTypeLabelValues)
+	c.fetchedBytes.WithLabelValues(cacheTypeLabelNames)
+
+	c.tooBigItems = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_too_big_items_total",
+		Help: "Total number of items that were not stored in the cache because bigger than the max item size.",
+	}, []string{"item_type"})
+	c.tooBigItems.WithLabelValues(cacheTypePostings)
+	c.tooBigItems.WithLabelValues(cacheTypeSeries)
+	c.tooBigItems.WithLabelValues(cacheTypeExpandedPostings)
+	c.tooBigItems.WithLabelValues(cacheTypeLabelValues)
+	c.tooBigItems.WithLabelValues(cacheTypeLabelNames)
+
+	c.skippedExisting = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_store_skipped_existing_total",
+		Help: "Total number of items that were not stored in the cache because already stored, when storing if absent.",
+	}, []string{"item_type"})
+	c.skippedExisting.WithLabelValues(cacheTypePostings)
+	c.skippedExisting.WithLabelValues(cacheTypeSeries)
+	c.skippedExisting.WithLabelValues(cacheTypeExpandedPostings)
+	c.skippedExisting.WithLabelValues(cacheTypeLabelValues)
+	c.skippedExisting.WithLabelValues(cacheTypeLabelNames)
+
+	c.droppedItems = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_dropped_total",
+		Help: "Total number of items that were not stored in the cache because the client async queue was above the high watermark.",
+	}, []string{"item_type"})
+	c.droppedItems.WithLabelValues(cacheTypePostings)
+	c.droppedItems.WithLabelValues(cacheTypeSeries)
+	c.droppedItems.WithLabelValues(cacheTypeExpandedPostings)
+	c.droppedItems.WithLabelValues(cacheTypeLabelValues)
+	c.droppedItems.WithLabelValues(cacheTypeLabelNames)
+
+	var asyncQueues []cacheutil.RemoteCacheClientWithAsyncQueue
+	for _, cacheClient := range cacheClients {
+		if client, ok := cacheClient.(cacheutil.RemoteCacheClientWithAsyncQueue); ok {
+			asyncQueues = append(asyncQueues, client)
+		}
+	}
+	if len(asyncQueues) > 0 {
+		promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "thanos_store_index_cache_async_queue_full_ratio",
+			Help: "Ratio, between 0 and 1, of the cache client async queue in use. With multiple clients, the most saturated queue is reported.",
+		}, func() float64 {
+			ratio := 0.0
+			for _, q := range asyncQueues {
+				ratio = math.Max(ratio, q.AsyncQueueFullRatio())
+			}
+			return ratio
+		})
+	}
+
+	c.keyMappingErrors = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_key_mapping_errors_total",
+		Help: "Total number of inconsistencies found between the requested items and the cache keys. It should always be zero.",
+	}, []string{"item_type"})
+	c.keyMappingErrors.WithLabelValues(cacheTypePostings)
+	c.keyMappingErrors.WithLabelValues(cacheTypeSeries)
 
-	level.Info(logger).Log("msg", "created index cache")
+	c.collisions = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_collisions_total",
+		Help: "Total number of fetched items whose fingerprint didn't match the requested item, if the keys verification is enabled. It should always be zero.",
+	}, []string{"item_type"})
+	c.collisions.WithLabelValues(cacheTypePostings)
+	c.collisions.WithLabelValues(cacheTypeSeries)
+	c.collisions.WithLabelValues(cacheTypeExpandedPostings)
+	c.collisions.WithLabelValues(cacheTypeLabelValues)
+	c.collisions.WithLabelValues(cacheTypeLabelNames)
+
+	c.corruptedEntries = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_corrupted_entries_total",
+		Help: "Total number of fetched entries whose checksum didn't match, if the checksums are enabled, or which failed to be decompressed, which are considered misses.",
+	}, []string{"item_type"})
+	c.corruptedEntries.WithLabelValues(cacheTypePostings)
+	c.corruptedEntries.WithLabelValues(cacheTypeSeries)
+	c.corruptedEntries.WithLabelValues(cacheTypeExpandedPostings)
+	c.corruptedEntries.WithLabelValues(cacheTypeLabelValues)
+	c.corruptedEntries.WithLabelValues(cacheTypeLabelNames)
+
+	c.seriesCodecFailures = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_series_codec_failures_total",
+		Help: "Total number of series values which failed to be encoded, and were not stored, or to be decoded, and were considered misses, with the configured series codec.",
+	}, []string{"operation"})
+	c.seriesCodecFailures.WithLabelValues(seriesCodecEncode)
+	c.seriesCodecFailures.WithLabelValues(seriesCodecDecode)
+
+	c.operationDuration = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:                        "thanos_store_index_cache_operation_duration_seconds",
+		Help:                        "Duration of the operations issued by the index cache to the cache client.",
+		Buckets:                     []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.2, 0.5, 1, 3, 6, 10},
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"operation"})
+	c.operationDuration.WithLabelValues(opGetMulti)
+	c.operationDuration.WithLabelValues(opSet)
+	c.operationDuration.WithLabelValues(opSetAsync)
+	c.operationDuration.WithLabelValues(opAddAsync)
+	c.operationDuration.WithLabelValues(opSetMultiAsync)
+
+	c.keyBuildDuration = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:                        "thanos_store_index_cache_key_build_duration_seconds",
+		Help:                        "Duration of building the cache keys of the items of a multi-item fetch, along with their mapping back to the items.",
+		Buckets:                     []float64{0.00001, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"item_type"})
+	c.keyBuildDuration.WithLabelValues(cacheTypePostings)
+	c.keyBuildDuration.WithLabelValues(cacheTypeSeries)
+
+	c.deletes = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_deletes_total",
+		Help: "Total number of items deleted from the cache.",
+	})
+	c.deleteFailures = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_delete_failures_total",
+		Help: "Total number of items that failed to be deleted from the cache.",
+	})
+	c.blockKeysIndexOverflows = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_block_keys_index_overflows_total",
+		Help: "Total number of block keys indexes which exceeded the max size and are no longer updated.",
+	})
+	c.blockKeysIndexFailures = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_block_keys_index_failures_total",
+		Help: "Total number of block keys indexes which failed to be fetched or stored.",
+	})
+	c.partialTimeouts = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_partial_timeouts_total",
+		Help: "Total number of items requested to the remote index cache which weren't fetched because of a timeout, and are thus counted as misses despite possibly being cached.",
+	})
+	c.getMultiQueueLength = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_store_index_cache_getmulti_queue_length",
+		Help: "Number of GetMulti operations to the remote index cache queued because the max in-flight GetMulti operations is reached.",
+	})
+	c.getMultiQueueWait = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_store_index_cache_getmulti_queue_wait_seconds",
+		Help:    "Time spent by the GetMulti operations to the remote index cache waiting for an in-flight slot.",
+		Buckets: []float64{0.0001, 0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+	})
+	c.getMultiQueueTimeouts = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_getmulti_queue_timeouts_total",
+		Help: "Total number of GetMulti operations to the remote index cache which timed out waiting for an in-flight slot, whose keys are counted as misses.",
+	})
+	operationsLogFailures := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_operations_log_failures_total",
+		Help: "Total number of operations of the remote index cache which failed to be recorded to the operations log.",
+	})
+	if config.OperationsLog != nil {
+		c.operationsLog = newOperationsLog(config.OperationsLog, operationsLogFailures)
+	}
+	c.skippedPostings = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_skipped_postings_total",
+		Help: "Total number of postings not stored to the remote index cache because their label name isn't allowed or is denied.",
+	})
+
+	c.prefetches = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_prefetches_total",
+		Help: "Total number of series prefetch hints, by whether they have been issued or dropped because of too many prefetches running.",
+	}, []string{"result"})
+	c.prefetches.WithLabelValues(prefetchIssued)
+	c.prefetches.WithLabelValues(prefetchDropped)
+	c.prefetchHits = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_prefetch_hits_total",
+		Help: "Total number of series requested to the remote index cache that were served from the series fetched ahead of time.",
+	})
+
+	if config.TrackHitsByBlockAge {
+		c.requestsByBlockAge = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_store_index_cache_requests_by_block_age_total",
+			Help: "Total number of items requests to the cache, by age of the block of the items.",
+		}, []string{"item_type", "block_age"})
+		c.hitsByBlockAge = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_store_index_cache_hits_by_block_age_total",
+			Help: "Total number of items requests to the cache that were a hit, by age of the block of the items.",
+		}, []string{"item_type", "block_age"})
+		for _, typ := range []string{cacheTypePostings, cacheTypeSeries, cacheTypeExpandedPostings, cacheTypeLabelValues, cacheTypeLabelNames} {
+			for _, age := range []string{blockAgeLessThanHour, blockAgeLessThanDay, blockAgeMoreThanDay} {
+				c.requestsByBlockAge.WithLabelValues(typ, age)
+				c.hitsByBlockAge.WithLabelValues(typ, age)
+			}
+		}
+	}
+
+	c.compressionRatio = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "thanos_store_index_cache_compression_ratio",
+		Help:    "Ratio between the compressed and the uncompressed size of items stored in the cache.",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"item_type"})
+
+	decompressionDuration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:                        "thanos_store_index_cache_decompression_duration_seconds",
+		Help:                        "Duration of the decompression of the compressed items fetched from the cache.",
+		Buckets:                     []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1},
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"item_type"})
+	hitsByCompression := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_hits_by_compression_total",
+		Help: "Total number of items requests to the cache that were a hit, by whether the fetched item was compressed.",
+	}, []string{"item_type", "compressed"})
+	c.decompression = map[string]decompressionMetrics{}
+	for _, typ := range []string{cacheTypePostings, cacheTypeSeries, cacheTypeExpandedPostings, cacheTypeLabelValues, cacheTypeLabelNames} {
+		c.decompression[typ] = decompressionMetrics{
+			duration:     decompressionDuration.WithLabelValues(typ),
+			compressed:   hitsByCompression.WithLabelValues(typ, "true"),
+			uncompressed: hitsByCompression.WithLabelValues(typ, "false"),
+		}
+	}
+
+	level.Info(logger).Log("msg", "created index cache", "postingsTTL", config.PostingsTTL, "seriesTTL", config.SeriesTTL, "compression", config.Compression, "keyVersion", config.KeyVersion, "compactKeys", config.CompactKeys, "clients", len(caches))
 
 	return c, nil
 }
 
 // StorePostings sets the postings identified by the ulid and label to the value v.
 // The function enqueues the request and returns immediately: the entry will be
-// asynchronously stored in the cache.
+// asynchronously stored in the cache, unless the synchronous store is enabled.
 func (c *RemoteIndexCache) StorePostings(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) {
-	key := cacheKey{blockID, cacheKeyPostings(l)}.string()
+	if !c.cachesPostingsOf(l.Name) {
+		c.skippedPostings.Inc()
+		return
+	}
+	if err := c.set(ctx, cacheTypePostings, cacheKey{blockID, cacheKeyPostings(l)}, v, c.ttl(blockID, c.config.PostingsTTL)); err != nil {
+		level.Error(c.logger).Log("msg", "failed to cache postings in memcached", "err", err)
+	}
+}
 
-	if err := c.memcached.SetAsync(ctx, key, v, memcachedDefaultTTL); err != nil {
+// StorePostingsSync sets the postings identified by the ulid and label to the value v, blocking
+// until the write is acknowledged by the backend, whatever the synchronous store setting. It's
+// meant for the critical stores, e.g. when warming the cache, and returns the error if the write
+// fails or the clients are unable to store synchronously.
+func (c *RemoteIndexCache) StorePostingsSync(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) error {
+	if !c.cachesPostingsOf(l.Name) {
+		c.skippedPostings.Inc()
+		return nil
+	}
+	return c.setSync(ctx, cacheTypePostings, cacheKey{blockID, cacheKeyPostings(l)}, v, c.ttl(blockID, c.config.PostingsTTL))
+}
+
+// StoreMultiPostings sets multiple postings of a block to the cache, building their keys in bulk
+// and enqueuing them with a single operation if supported by the client.
+// The function enqueues the request and returns immediately: the entries will be
+// asynchronously stored in the cache, unless the synchronous store is enabled.
+func (c *RemoteIndexCache) StoreMultiPostings(ctx context.Context, blockID ulid.ULID, entries map[labels.Label][]byte) {
+	keys := make([]cacheKey, 0, len(entries))
+	values := make([][]byte, 0, len(entries))
+	for l, v := range entries {
+		if !c.cachesPostingsOf(l.Name) {
+			c.skippedPostings.Inc()
+			continue
+		}
+		keys = append(keys, cacheKey{blockID, cacheKeyPostings(l)})
+		values = append(values, v)
+	}
+	if err := c.setMulti(ctx, cacheTypePostings, blockID, keys, values, c.ttl(blockID, c.config.PostingsTTL)); err != nil {
 		level.Error(c.logger).Log("msg", "failed to cache postings in memcached", "err", err)
 	}
 }
@@ -75,33 +854,105 @@ func (c *RemoteIndexCache) StorePostings(ctx context.Context, blockID ulid.ULID,
 // and returns a map containing cache hits, along with a list of missing keys.
 // In case of error, it logs and return an empty cache hits map.
 func (c *RemoteIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, lbls []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	hits, misses, err := c.FetchMultiPostingsE(ctx, blockID, lbls)
+	if err != nil && ctx.Err() == nil {
+		level.Warn(c.logger).Log("msg", "failed to fetch postings from memcached", "err", err)
+	}
+	return hits, misses
+}
+
+// FetchMultiPostingsE is like FetchMultiPostings but also returns the error reported by
+// the cache client, if any, so that callers can distinguish a backend failure from a miss.
+// In case of error, the hits fetched before the failure (if any) are still returned, except
+// when the context gets canceled, in which case no hits are returned along with the context error.
+func (c *RemoteIndexCache) FetchMultiPostingsE(ctx context.Context, blockID ulid.ULID, lbls []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label, err error) {
+	return c.fetchMultiPostings(ctx, blockID, lbls, nil)
+}
+
+// FetchMultiPostingsWithAge is like FetchMultiPostingsE but also returns the age of each hit,
+// that is the time elapsed since it was stored, according to the clock of this process.
+// Ages are only known for the entries stored with StoreTimestamps enabled, so none
+// are returned if it's disabled.
+func (c *RemoteIndexCache) FetchMultiPostingsWithAge(ctx context.Context, blockID ulid.ULID, lbls []labels.Label) (hits map[labels.Label][]byte, ages map[labels.Label]time.Duration, misses []labels.Label, err error) {
+	if c.config.StoreTimestamps {
+		ages = map[labels.Label]time.Duration{}
+	}
+	hits, misses, err = c.fetchMultiPostings(ctx, blockID, lbls, ages)
+	return hits, ages, misses, err
+}
+
+// fetchMultiPostings implements FetchMultiPostingsE, recording the age of the hits in ages, if not nil.
+// The postings of the labels which aren't cached miss without being fetched, nor counted as requests.
+func (c *RemoteIndexCache) fetchMultiPostings(ctx context.Context, blockID ulid.ULID, lbls []labels.Label, ages map[labels.Label]time.Duration) (hits map[labels.Label][]byte, misses []labels.Label, err error) {
+	if len(c.postingsAllowlist) == 0 && len(c.postingsDenylist) == 0 {
+		return c.fetchMultiCachedPostings(ctx, blockID, lbls, ages)
+	}
+
+	cached := make([]labels.Label, 0, len(lbls))
+	var uncached []labels.Label
+	for _, lbl := range lbls {
+		if c.cachesPostingsOf(lbl.Name) {
+			cached = append(cached, lbl)
+		} else {
+			uncached = append(uncached, lbl)
+		}
+	}
+	if len(cached) == 0 {
+		return nil, lbls, nil
+	}
+
+	hits, misses, err = c.fetchMultiCachedPostings(ctx, blockID, cached, ages)
+	return hits, append(misses, uncached...), err
+}
+
+// fetchMultiCachedPostings fetches the postings of labels which are all cached.
+func (c *RemoteIndexCache) fetchMultiCachedPostings(ctx context.Context, blockID ulid.ULID, lbls []labels.Label, ages map[labels.Label]time.Duration) (hits map[labels.Label][]byte, misses []labels.Label, err error) {
+	if len(lbls) == 1 {
+		return c.fetchSinglePostings(ctx, blockID, lbls, ages)
+	}
+
 	// Build the cache keys, while keeping a map between input label and the cache key
 	// so that we can easily reverse it back after the GetMulti().
-	keys := make([]string, 0, len(lbls))
+	keysBuildStart := time.Now()
+	keys := c.newRoutedKeys(len(lbls))
 	keysMapping := map[labels.Label]string{}
 
 	for _, lbl := range lbls {
-		key := cacheKey{blockID, cacheKeyPostings(lbl)}.string()
+		k := cacheKey{blockID, cacheKeyPostings(lbl)}
+		key := c.key(ctx, k)
 
-		keys = append(keys, key)
+		keys.add(c.route(k), key)
 		keysMapping[lbl] = key
 	}
+	c.keyBuildDuration.WithLabelValues(cacheTypePostings).Observe(time.Since(keysBuildStart).Seconds())
 
 	// Fetch the keys from memcached in a single request.
-	c.postingRequests.Add(float64(len(keys)))
-	results := c.memcached.GetMulti(ctx, keys)
+	addWithExemplar(ctx, c.postingRequests, float64(len(lbls)))
+	results, err := c.getMultiRouted(ctx, keys)
 	if len(results) == 0 {
-		return nil, lbls
+		c.postingHitRatio.observe(len(lbls), 0)
+		c.observeBlockAge(cacheTypePostings, blockID, len(lbls), 0)
+		c.recordFetch(cacheTypePostings, blockID, len(lbls), 0, 0)
+		return nil, lbls, err
 	}
 
 	// Construct the resulting hits map and list of missing keys. We iterate on the input
 	// list of labels to be able to easily create the list of ones in a single iteration.
 	hits = map[labels.Label][]byte{}
+	fetchedBytes := 0
+
+	for i, lbl := range lbls {
+		// Stop assembling the results as soon as the request has been canceled, given nobody will read them.
+		if i%checkContextEveryNIterations == 0 {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, lbls, ctxErr
+			}
+		}
 
-	for _, lbl := range lbls {
 		key, ok := keysMapping[lbl]
 		if !ok {
 			level.Error(c.logger).Log("msg", "keys mapping inconsistency found in memcached index cache client", "type", "postings", "label", lbl.Name+":"+lbl.Value)
+			c.keyMappingErrors.WithLabelValues(cacheTypePostings).Inc()
 			continue
 		}
 
@@ -113,20 +964,212 @@ func (c *RemoteIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.
 			continue
 		}
 
+		fetchedBytes += len(value)
+		var storedAt time.Time
+		if value, storedAt, ok = c.unwrapEntry(cacheKey{blockID, cacheKeyPostings(lbl)}, value); !ok {
+			misses = append(misses, lbl)
+			continue
+		}
 		hits[lbl] = value
+		if ages != nil {
+			ages[lbl] = c.age(storedAt)
+		}
 	}
 
-	c.postingHits.Add(float64(len(hits)))
-	return hits, misses
+	addWithExemplar(ctx, c.postingHits, float64(len(hits)))
+	c.postingHitRatio.observe(len(lbls), len(hits))
+	c.observeBlockAge(cacheTypePostings, blockID, len(lbls), len(hits))
+	c.recordFetch(cacheTypePostings, blockID, len(lbls), len(hits), fetchedBytes)
+	c.fetchedBytes.WithLabelValues(cacheTypePostings).Add(float64(fetchedBytes))
+	return hits, misses, err
+}
+
+// fetchSinglePostings is the fast path of FetchMultiPostingsE for a single label, which is the most
+// common lookup: it skips building the intermediate keys mapping.
+func (c *RemoteIndexCache) fetchSinglePostings(ctx context.Context, blockID ulid.ULID, lbls []labels.Label, ages map[labels.Label]time.Duration) (hits map[labels.Label][]byte, misses []labels.Label, err error) {
+	k := cacheKey{blockID, cacheKeyPostings(lbls[0])}
+	key := c.key(ctx, k)
+
+	addWithExemplar(ctx, c.postingRequests, 1)
+	results, err := c.getMulti(ctx, c.route(k), []string{key})
+
+	var storedAt time.Time
+	value, ok := results[key]
+	if ok {
+		c.fetchedBytes.WithLabelValues(cacheTypePostings).Add(float64(len(value)))
+		value, storedAt, ok = c.unwrapEntry(k, value)
+	}
+	if !ok {
+		c.postingHitRatio.observe(1, 0)
+		c.observeBlockAge(cacheTypePostings, blockID, 1, 0)
+		c.recordFetch(cacheTypePostings, blockID, 1, 0, len(results[key]))
+		return nil, lbls, err
+	}
+
+	addWithExemplar(ctx, c.postingHits, 1)
+	c.postingHitRatio.observe(1, 1)
+	c.observeBlockAge(cacheTypePostings, blockID, 1, 1)
+	c.recordFetch(cacheTypePostings, blockID, 1, 1, len(results[key]))
+
+	if ages != nil {
+		ages[lbls[0]] = c.age(storedAt)
+	}
+	return map[labels.Label][]byte{lbls[0]: value}, nil, err
+}
+
+// StoreExpandedPostings sets the expanded postings identified by the ulid and matchers to the value v.
+// The function enqueues the request and returns immediately: the entry will be
+// asynchronously stored in the cache, unless the synchronous store is enabled.
+func (c *RemoteIndexCache) StoreExpandedPostings(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	if err := c.set(ctx, cacheTypeExpandedPostings, cacheKey{blockID, newCacheKeyExpandedPostings(matchers)}, v, c.ttl(blockID, c.config.PostingsTTL)); err != nil {
+		level.Error(c.logger).Log("msg", "failed to cache expanded postings in memcached", "err", err)
+	}
+}
+
+// FetchExpandedPostings fetches the expanded postings identified by the ulid and matchers
+// and returns the cached value along with a boolean telling whether it was a hit.
+// In case of error, it logs and returns a miss.
+func (c *RemoteIndexCache) FetchExpandedPostings(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	k := cacheKey{blockID, newCacheKeyExpandedPostings(matchers)}
+	key := c.key(ctx, k)
+
+	// Fetch the key from memcached.
+	addWithExemplar(ctx, c.expandedPostingRequests, 1)
+	results, err := c.getMulti(ctx, c.route(k), []string{key})
+	if err != nil && ctx.Err() == nil {
+		level.Warn(c.logger).Log("msg", "failed to fetch expanded postings from memcached", "err", err)
+	}
+	value, ok := results[key]
+	if ok {
+		c.fetchedBytes.WithLabelValues(cacheTypeExpandedPostings).Add(float64(len(value)))
+		value, _, ok = c.unwrapEntry(k, value)
+	}
+	if !ok {
+		c.expandedPostingHitRatio.observe(1, 0)
+		c.observeBlockAge(cacheTypeExpandedPostings, blockID, 1, 0)
+		c.recordFetch(cacheTypeExpandedPostings, blockID, 1, 0, len(results[key]))
+		return nil, false
+	}
+
+	addWithExemplar(ctx, c.expandedPostingHits, 1)
+	c.expandedPostingHitRatio.observe(1, 1)
+	c.observeBlockAge(cacheTypeExpandedPostings, blockID, 1, 1)
+	c.recordFetch(cacheTypeExpandedPostings, blockID, 1, 1, len(results[key]))
+	return value, true
+}
+
+// StoreLabelValues sets the values of the label name for the series matching the matchers,
+// identified by the ulid, to the value v. The function enqueues the request and returns
+// immediately: the entry will be asynchronously stored in the cache, unless the
+// synchronous store is enabled.
+func (c *RemoteIndexCache) StoreLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte) {
+	if err := c.set(ctx, cacheTypeLabelValues, cacheKey{blockID, newCacheKeyLabelValues(labelName, matchers)}, v, c.ttl(blockID, c.config.PostingsTTL)); err != nil {
+		level.Error(c.logger).Log("msg", "failed to cache label values in memcached", "err", err)
+	}
+}
+
+// FetchLabelValues fetches the values of the label name for the series matching the matchers,
+// identified by the ulid, and returns the cached value along with a boolean telling whether
+// it was a hit. In case of error, it logs and returns a miss.
+func (c *RemoteIndexCache) FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool) {
+	k := cacheKey{blockID, newCacheKeyLabelValues(labelName, matchers)}
+	key := c.key(ctx, k)
+
+	// Fetch the key from memcached.
+	addWithExemplar(ctx, c.labelValuesRequests, 1)
+	results, err := c.getMulti(ctx, c.route(k), []string{key})
+	if err != nil && ctx.Err() == nil {
+		level.Warn(c.logger).Log("msg", "failed to fetch label values from memcached", "err", err)
+	}
+	value, ok := results[key]
+	if ok {
+		c.fetchedBytes.WithLabelValues(cacheTypeLabelValues).Add(float64(len(value)))
+		value, _, ok = c.unwrapEntry(k, value)
+	}
+	if !ok {
+		c.labelValuesHitRatio.observe(1, 0)
+		c.observeBlockAge(cacheTypeLabelValues, blockID, 1, 0)
+		c.recordFetch(cacheTypeLabelValues, blockID, 1, 0, len(results[key]))
+		return nil, false
+	}
+
+	addWithExemplar(ctx, c.labelValuesHits, 1)
+	c.labelValuesHitRatio.observe(1, 1)
+	c.observeBlockAge(cacheTypeLabelValues, blockID, 1, 1)
+	c.recordFetch(cacheTypeLabelValues, blockID, 1, 1, len(results[key]))
+	return value, true
+}
+
+// StoreLabelNames sets the label names of the series matching the matchers, identified by the
+// ulid, to the value v. The function enqueues the request and returns immediately: the entry
+// will be asynchronously stored in the cache, unless the synchronous store is enabled.
+func (c *RemoteIndexCache) StoreLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	if err := c.set(ctx, cacheTypeLabelNames, cacheKey{blockID, newCacheKeyLabelNames(matchers)}, v, c.ttl(blockID, c.config.PostingsTTL)); err != nil {
+		level.Error(c.logger).Log("msg", "failed to cache label names in memcached", "err", err)
+	}
+}
+
+// FetchLabelNames fetches the label names of the series matching the matchers, identified by
+// the ulid, and returns the cached value along with a boolean telling whether it was a hit.
+// In case of error, it logs and returns a miss.
+func (c *RemoteIndexCache) FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	k := cacheKey{blockID, newCacheKeyLabelNames(matchers)}
+	key := c.key(ctx, k)
+
+	// Fetch the key from memcached.
+	addWithExemplar(ctx, c.labelNamesRequests, 1)
+	results, err := c.getMulti(ctx, c.route(k), []string{key})
+	if err != nil && ctx.Err() == nil {
+		level.Warn(c.logger).Log("msg", "failed to fetch label names from memcached", "err", err)
+	}
+	value, ok := results[key]
+	if ok {
+		c.fetchedBytes.WithLabelValues(cacheTypeLabelNames).Add(float64(len(value)))
+		value, _, ok = c.unwrapEntry(k, value)
+	}
+	if !ok {
+		c.labelNamesHitRatio.observe(1, 0)
+		c.observeBlockAge(cacheTypeLabelNames, blockID, 1, 0)
+		c.recordFetch(cacheTypeLabelNames, blockID, 1, 0, len(results[key]))
+		return nil, false
+	}
+
+	addWithExemplar(ctx, c.labelNamesHits, 1)
+	c.labelNamesHitRatio.observe(1, 1)
+	c.observeBlockAge(cacheTypeLabelNames, blockID, 1, 1)
+	c.recordFetch(cacheTypeLabelNames, blockID, 1, 1, len(results[key]))
+	return value, true
 }
 
 // StoreSeries sets the series identified by the ulid and id to the value v.
 // The function enqueues the request and returns immediately: the entry will be
-// asynchronously stored in the cache.
+// asynchronously stored in the cache, unless the synchronous store is enabled.
 func (c *RemoteIndexCache) StoreSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef, v []byte) {
-	key := cacheKey{blockID, cacheKeySeries(id)}.string()
+	v, ok := c.encodeSeries(v)
+	if !ok {
+		return
+	}
+	if err := c.set(ctx, cacheTypeSeries, cacheKey{blockID, cacheKeySeries(id)}, v, c.ttl(blockID, c.config.SeriesTTL)); err != nil {
+		level.Error(c.logger).Log("msg", "failed to cache series in memcached", "err", err)
+	}
+}
 
-	if err := c.memcached.SetAsync(ctx, key, v, memcachedDefaultTTL); err != nil {
+// StoreMultiSeries sets multiple series of a block to the cache, building their keys in bulk
+// and enqueuing them with a single operation if supported by the client.
+// The function enqueues the request and returns immediately: the entries will be
+// asynchronously stored in the cache, unless the synchronous store is enabled.
+func (c *RemoteIndexCache) StoreMultiSeries(ctx context.Context, blockID ulid.ULID, entries map[storage.SeriesRef][]byte) {
+	keys := make([]cacheKey, 0, len(entries))
+	values := make([][]byte, 0, len(entries))
+	for id, v := range entries {
+		v, ok := c.encodeSeries(v)
+		if !ok {
+			continue
+		}
+		keys = append(keys, cacheKey{blockID, cacheKeySeries(id)})
+		values = append(values, v)
+	}
+	if err := c.setMulti(ctx, cacheTypeSeries, blockID, keys, values, c.ttl(blockID, c.config.SeriesTTL)); err != nil {
 		level.Error(c.logger).Log("msg", "failed to cache series in memcached", "err", err)
 	}
 }
@@ -135,33 +1178,77 @@ func (c *RemoteIndexCache) StoreSeries(ctx context.Context, blockID ulid.ULID, i
 // and returns a map containing cache hits, along with a list of missing IDs.
 // In case of error, it logs and return an empty cache hits map.
 func (c *RemoteIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef) {
+	hits, misses, err := c.FetchMultiSeriesE(ctx, blockID, ids)
+	if err != nil && ctx.Err() == nil {
+		level.Warn(c.logger).Log("msg", "failed to fetch series from memcached", "err", err)
+	}
+	return hits, misses
+}
+
+// FetchMultiSeriesE is like FetchMultiSeries but also returns the error reported by
+// the cache client, if any, so that callers can distinguish a backend failure from a miss.
+// In case of error, the hits fetched before the failure (if any) are still returned, except
+// when the context gets canceled, in which case no hits are returned along with the context error.
+func (c *RemoteIndexCache) FetchMultiSeriesE(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef, err error) {
+	hits = map[storage.SeriesRef][]byte{}
+	misses, err = c.IterMultiSeries(ctx, blockID, ids, func(id storage.SeriesRef, v []byte) {
+		hits[id] = v
+	})
+	if len(hits) == 0 || len(misses) == len(ids) {
+		return nil, misses, err
+	}
+	return hits, misses, err
+}
+
+// IterMultiSeries fetches multiple series - each identified by ID - from the cache, like
+// FetchMultiSeriesE, but calls f with each hit as the results are assembled, in the order of
+// the given IDs, instead of returning them in a map. It returns the list of missing IDs, and
+// the error reported by the cache client, if any. When the context gets canceled, the assembly
+// stops and all the IDs are returned as missing, along with the context error, even though f
+// may have been called for some of them.
+func (c *RemoteIndexCache) IterMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef, f func(id storage.SeriesRef, v []byte)) (misses []storage.SeriesRef, err error) {
 	// Build the cache keys, while keeping a map between input id and the cache key
 	// so that we can easily reverse it back after the GetMulti().
-	keys := make([]string, 0, len(ids))
+	keysBuildStart := time.Now()
+	keys := c.newRoutedKeys(len(ids))
 	keysMapping := map[storage.SeriesRef]string{}
 
 	for _, id := range ids {
-		key := cacheKey{blockID, cacheKeySeries(id)}.string()
+		k := cacheKey{blockID, cacheKeySeries(id)}
+		key := c.key(ctx, k)
 
-		keys = append(keys, key)
+		keys.add(c.route(k), key)
 		keysMapping[id] = key
 	}
+	c.keyBuildDuration.WithLabelValues(cacheTypeSeries).Observe(time.Since(keysBuildStart).Seconds())
 
-	// Fetch the keys from memcached in a single request.
-	c.seriesRequests.Add(float64(len(ids)))
-	results := c.memcached.GetMulti(ctx, keys)
+	// Fetch the keys from memcached in a single request, except the ones prefetched.
+	addWithExemplar(ctx, c.seriesRequests, float64(len(ids)))
+	results, err := c.getMultiSeries(ctx, keys)
 	if len(results) == 0 {
-		return nil, ids
+		c.seriesHitRatio.observe(len(ids), 0)
+		c.observeBlockAge(cacheTypeSeries, blockID, len(ids), 0)
+		c.recordFetch(cacheTypeSeries, blockID, len(ids), 0, 0)
+		return ids, err
 	}
 
-	// Construct the resulting hits map and list of missing keys. We iterate on the input
+	// Pass the hits and construct the list of missing keys. We iterate on the input
 	// list of ids to be able to easily create the list of ones in a single iteration.
-	hits = map[storage.SeriesRef][]byte{}
+	hits := 0
+	fetchedBytes := 0
+
+	for i, id := range ids {
+		// Stop assembling the results as soon as the request has been canceled, given nobody will read them.
+		if i%checkContextEveryNIterations == 0 {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ids, ctxErr
+			}
+		}
 
-	for _, id := range ids {
 		key, ok := keysMapping[id]
 		if !ok {
 			level.Error(c.logger).Log("msg", "keys mapping inconsistency found in memcached index cache client", "type", "series", "id", id)
+			c.keyMappingErrors.WithLabelValues(cacheTypeSeries).Inc()
 			continue
 		}
 
@@ -173,11 +1260,865 @@ func (c *RemoteIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.UL
 			continue
 		}
 
-		hits[id] = value
+		fetchedBytes += len(value)
+		if value, _, ok = c.unwrapEntry(cacheKey{blockID, cacheKeySeries(id)}, value); !ok {
+			misses = append(misses, id)
+			continue
+		}
+
+		v, ok := c.decodeSeries(value)
+		if !ok {
+			misses = append(misses, id)
+			continue
+		}
+
+		hits++
+		f(id, v)
 	}
 
-	c.seriesHits.Add(float64(len(hits)))
-	return hits, misses
+	addWithExemplar(ctx, c.seriesHits, float64(hits))
+	c.seriesHitRatio.observe(len(ids), hits)
+	c.observeBlockAge(cacheTypeSeries, blockID, len(ids), hits)
+	c.recordFetch(cacheTypeSeries, blockID, len(ids), hits, fetchedBytes)
+	c.fetchedBytes.WithLabelValues(cacheTypeSeries).Add(float64(fetchedBytes))
+	return misses, err
+}
+
+// Prefetch asynchronously fetches the series of a block which are likely to be fetched soon, holding
+// them in memory for up to the configured prefetch TTL, so that they're served without a round trip
+// to the cache once fetched for real. It's only a hint: it's a no-op if prefetching is disabled, and
+// the prefetch is dropped if too many are running or fails. Fetching the series while the prefetch
+// is still running doesn't wait for it.
+func (c *RemoteIndexCache) Prefetch(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) {
+	if c.prefetched == nil || len(ids) == 0 || c.closed.Load() || bypassFromContext(ctx) {
+		return
+	}
+	select {
+	case c.prefetchGate <- struct{}{}:
+	default:
+		c.prefetches.WithLabelValues(prefetchDropped).Inc()
+		return
+	}
+	c.prefetches.WithLabelValues(prefetchIssued).Inc()
+
+	keys := c.newRoutedKeys(len(ids))
+	for _, id := range ids {
+		k := cacheKey{blockID, cacheKeySeries(id)}
+		keys.add(c.route(k), c.key(ctx, k))
+	}
+
+	go func() {
+		defer func() { <-c.prefetchGate }()
+
+		// Failures are ignored, given the series will be fetched for real anyway.
+		results, _ := c.getMultiRouted(ctx, keys)
+		if len(results) > 0 {
+			now := c.now()
+			c.prefetched.add(results, now, now.Add(c.config.PrefetchTTL))
+		}
+	}()
+}
+
+// getMultiSeries fetches the keys of series from the clients they're routed to, like getMultiRouted,
+// except the ones prefetched, which are served from memory and no longer held afterwards.
+func (c *RemoteIndexCache) getMultiSeries(ctx context.Context, keys routedKeys) (map[string][]byte, error) {
+	if c.prefetched == nil || bypassFromContext(ctx) {
+		return c.getMultiRouted(ctx, keys)
+	}
+
+	prefetched := c.prefetched.take(keys, c.now())
+	if len(prefetched) == 0 {
+		return c.getMultiRouted(ctx, keys)
+	}
+	c.prefetchHits.Add(float64(len(prefetched)))
+	if keys.len() == 0 {
+		return prefetched, nil
+	}
+
+	results, err := c.getMultiRouted(ctx, keys)
+	for key, value := range results {
+		prefetched[key] = value
+	}
+	return prefetched, err
+}
+
+// DeletePostings deletes the postings identified by the ulid and label from the cache.
+func (c *RemoteIndexCache) DeletePostings(ctx context.Context, blockID ulid.ULID, l labels.Label) error {
+	k := cacheKey{blockID, cacheKeyPostings(l)}
+	return c.delete(ctx, blockID, c.route(k), c.key(ctx, k))
+}
+
+// DeleteSeries deletes the series identified by the ulid and id from the cache.
+func (c *RemoteIndexCache) DeleteSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef) error {
+	k := cacheKey{blockID, cacheKeySeries(id)}
+	return c.delete(ctx, blockID, c.route(k), c.key(ctx, k))
+}
+
+// DeleteBlock deletes all the entries of a block from the cache. Given the backend doesn't
+// support deleting keys by prefix, only the keys tracked by this process (see TrackBlockKeys)
+// and, if enabled, the ones listed by the block keys index (see BlockKeysIndex) are deleted,
+// while the others are left to expire with their TTL.
+func (c *RemoteIndexCache) DeleteBlock(ctx context.Context, blockID ulid.ULID) error {
+	if c.blockKeys == nil {
+		return errors.New("tracking of block keys is disabled")
+	}
+
+	errs := errutil.MultiError{}
+	keys := c.blockKeys.take(blockID)
+	if c.config.BlockKeysIndex {
+		index, ok, err := c.fetchBlockKeysIndex(ctx, c.key(ctx, cacheKey{blockID, cacheKeyBlockKeys{}}))
+		if err != nil {
+			c.blockKeysIndexFailures.Inc()
+			errs.Add(errors.Wrap(err, "fetch block keys index"))
+		}
+		if ok {
+			if keys == nil {
+				keys = make(map[string]int, len(index.keys))
+			}
+			for key, client := range index.keys {
+				if client >= len(c.caches) {
+					client = 0
+				}
+				keys[key] = client
+			}
+			keys[c.key(ctx, cacheKey{blockID, cacheKeyBlockKeys{}})] = 0
+		}
+	}
+
+	for key, client := range keys {
+		errs.Add(c.delete(ctx, blockID, client, key))
+	}
+	return errs.Err()
+}
+
+// ForgetBlock stops tracking the keys of the block, e.g. once it's no longer served by this
+// process, without deleting them, given other processes may still serve the block. The keys
+// are left to expire with their TTL, unless the block is deleted through its keys index later.
+func (c *RemoteIndexCache) ForgetBlock(blockID ulid.ULID) {
+	if c.blockKeys != nil {
+		c.blockKeys.take(blockID)
+	}
+}
+
+func (c *RemoteIndexCache) delete(ctx context.Context, blockID ulid.ULID, clientIdx int, key string) error {
+	if c.closed.Load() {
+		return errRemoteIndexCacheClosed
+	}
+
+	client, ok := c.clients[clientIdx].(cacheutil.RemoteCacheClientWithDelete)
+	if !ok {
+		return errors.New("the cache client doesn't support deleting keys")
+	}
+
+	if err := client.Delete(ctx, key); err != nil {
+		c.deleteFailures.Inc()
+		return errors.Wrapf(err, "delete key %s", key)
+	}
+
+	c.deletes.Inc()
+	if c.blockKeys != nil {
+		c.blockKeys.remove(blockID, key)
+	}
+	return nil
+}
+
+// Close marks the cache as closed, typically once the shutdown has begun and the cache clients
+// may be stopped. From then on, fetches return misses and stores are no-ops, without calling the
+// clients, and the failures of the operations still in flight are no longer reported. Deletes
+// fail with an error. Close doesn't stop the cache clients, which are owned by the caller.
+func (c *RemoteIndexCache) Close() {
+	c.closed.Store(true)
+}
+
+// HealthCheck checks whether the cache clients can reach their backend, returning an error if any
+// of them can't. The clients not implementing RemoteCacheClientWithHealthCheck are considered healthy.
+func (c *RemoteIndexCache) HealthCheck(ctx context.Context) error {
+	if c.closed.Load() {
+		return errRemoteIndexCacheClosed
+	}
+
+	errs := errutil.MultiError{}
+	for i, client := range c.clients {
+		h, ok := client.(cacheutil.RemoteCacheClientWithHealthCheck)
+		if !ok {
+			continue
+		}
+		if err := h.HealthCheck(ctx); err != nil {
+			errs.Add(errors.Wrapf(err, "cache client %d", i))
+		}
+	}
+	return errs.Err()
+}
+
+// unlessClosed returns err, unless the cache has been closed, in which case the failure is expected.
+func (c *RemoteIndexCache) unlessClosed(err error) error {
+	if c.closed.Load() {
+		return nil
+	}
+	return err
+}
+
+// IndexCacheStats is a snapshot of the index cache counters.
+type IndexCacheStats struct {
+	PostingsRequests         uint64
+	PostingsHits             uint64
+	ExpandedPostingsRequests uint64
+	ExpandedPostingsHits     uint64
+	SeriesRequests           uint64
+	SeriesHits               uint64
+	LabelValuesRequests      uint64
+	LabelValuesHits          uint64
+	LabelNamesRequests       uint64
+	LabelNamesHits           uint64
+}
+
+// Stats returns a snapshot of the cache requests and hits counters since the cache has been created.
+func (c *RemoteIndexCache) Stats() IndexCacheStats {
+	return IndexCacheStats{
+		PostingsRequests:         counterValue(c.postingRequests),
+		PostingsHits:             counterValue(c.postingHits),
+		ExpandedPostingsRequests: counterValue(c.expandedPostingRequests),
+		ExpandedPostingsHits:     counterValue(c.expandedPostingHits),
+		SeriesRequests:           counterValue(c.seriesRequests),
+		SeriesHits:               counterValue(c.seriesHits),
+		LabelValuesRequests:      counterValue(c.labelValuesRequests),
+		LabelValuesHits:          counterValue(c.labelValuesHits),
+		LabelNamesRequests:       counterValue(c.labelNamesRequests),
+		LabelNamesHits:           counterValue(c.labelNamesHits),
+	}
+}
+
+// counterValue returns the current value of a counter.
+func counterValue(c prometheus.Counter) uint64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		return 0
+	}
+	return uint64(m.GetCounter().GetValue())
+}
+
+// key returns the string or, if configured, compact representation of k, versioned according to the config and
+// namespaced by the tenant carried by the context or, if none, the configured one. With a series codec,
+// the compression, the keys verification, the timestamps or the checksums enabled, keys are prefixed so
+// that entries are never read with a format different from the one they were stored with.
+func (c *RemoteIndexCache) key(ctx context.Context, k cacheKey) string {
+	tenant, ok := tenantFromContext(ctx)
+	if !ok {
+		tenant = c.config.Tenant
+	}
+	var key string
+	if c.config.CompactKeys {
+		key = k.compactString(c.config.KeyVersion, tenant)
+	} else {
+		key = k.tenantString(c.config.KeyVersion, tenant)
+	}
+	if _, ok := k.key.(cacheKeySeries); ok && c.config.SeriesCodec != nil {
+		key = c.config.SeriesCodec.keyPrefix() + key
+	}
+	if c.config.Compression == CompressionSnappy {
+		key = snappyKeyPrefix + key
+	}
+	if c.config.VerifyKeys {
+		key = verifiedKeyPrefix + key
+	}
+	if c.config.StoreTimestamps {
+		key = timestampedKeyPrefix + key
+	}
+	if c.config.Checksums {
+		key = checksummedKeyPrefix + key
+	}
+	return key
+}
+
+// route returns the index of the client the key is routed to.
+func (c *RemoteIndexCache) route(k cacheKey) int {
+	if c.router == nil {
+		return 0
+	}
+	if idx := c.router(k); idx >= 0 && idx < len(c.caches) {
+		return idx
+	}
+	return 0
+}
+
+// routedKeys holds the cache keys grouped by the index of the client they're routed to.
+type routedKeys [][]string
+
+func (c *RemoteIndexCache) newRoutedKeys(size int) routedKeys {
+	if len(c.caches) == 1 {
+		return routedKeys{make([]string, 0, size)}
+	}
+	return make(routedKeys, len(c.caches))
+}
+
+func (r routedKeys) add(client int, key string) {
+	r[client] = append(r[client], key)
+}
+
+// len returns the number of keys routed to any client.
+func (r routedKeys) len() int {
+	n := 0
+	for _, clientKeys := range r {
+		n += len(clientKeys)
+	}
+	return n
+}
+
+// set compresses the value according to the configured codec and enqueues it to be
+// asynchronously stored in the cache, or stores it synchronously if enabled, unless it
+// exceeds the max item size.
+func (c *RemoteIndexCache) set(ctx context.Context, typ string, k cacheKey, v []byte, ttl time.Duration) error {
+	if c.closed.Load() || bypassFromContext(ctx) {
+		return nil
+	}
+
+	clientIdx := c.route(k)
+	item, ok := c.prepareItem(ctx, typ, k, v, ttl)
+	if !ok || c.dropOnFullQueue(clientIdx, typ, 1) {
+		return nil
+	}
+
+	if err := c.storeItem(ctx, clientIdx, typ, item); err != nil {
+		return c.unlessClosed(err)
+	}
+	c.trackStored(ctx, typ, k.block, clientIdx, []cacheutil.RemoteCacheItem{item})
+	return nil
+}
+
+// setSync is like set, but always stores the item with a synchronous Set, even if absent entries
+// only are stored otherwise, so that the item is known to be stored once it returns.
+func (c *RemoteIndexCache) setSync(ctx context.Context, typ string, k cacheKey, v []byte, ttl time.Duration) error {
+	if c.closed.Load() || bypassFromContext(ctx) {
+		return nil
+	}
+
+	clientIdx := c.route(k)
+	client, ok := c.clients[clientIdx].(cacheutil.RemoteCacheClientWithSet)
+	if !ok {
+		return errRemoteIndexCacheSynchronousStoreUnsupported
+	}
+
+	item, ok := c.prepareItem(ctx, typ, k, v, ttl)
+	if !ok {
+		return nil
+	}
+
+	start := time.Now()
+	err := client.Set(ctx, item.Key, item.Value, item.TTL)
+	c.operationDuration.WithLabelValues(opSet).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return c.unlessClosed(err)
+	}
+	c.trackStored(ctx, typ, k.block, clientIdx, []cacheutil.RemoteCacheItem{item})
+	return nil
+}
+
+// setMulti is like set, but for multiple items of the same block, which are stored to each cache
+// at once, unless they're stored synchronously or only if absent, in which case one by one.
+func (c *RemoteIndexCache) setMulti(ctx context.Context, typ string, blockID ulid.ULID, keys []cacheKey, values [][]byte, ttl time.Duration) error {
+	if c.closed.Load() || bypassFromContext(ctx) {
+		return nil
+	}
+
+	itemsByClient := make([][]cacheutil.RemoteCacheItem, len(c.caches))
+	for i, k := range keys {
+		if item, ok := c.prepareItem(ctx, typ, k, values[i], ttl); ok {
+			clientIdx := c.route(k)
+			itemsByClient[clientIdx] = append(itemsByClient[clientIdx], item)
+		}
+	}
+
+	errs := errutil.MultiError{}
+	for clientIdx, items := range itemsByClient {
+		if len(items) == 0 || c.dropOnFullQueue(clientIdx, typ, len(items)) {
+			continue
+		}
+
+		if c.config.SynchronousStore || c.config.StoreIfAbsent {
+			stored := items[:0]
+			for _, item := range items {
+				if err := c.storeItem(ctx, clientIdx, typ, item); err != nil {
+					errs.Add(err)
+					continue
+				}
+				stored = append(stored, item)
+			}
+			c.trackStored(ctx, typ, blockID, clientIdx, stored)
+			continue
+		}
+
+		start := time.Now()
+		err := c.caches[clientIdx].StoreMulti(ctx, items)
+		c.operationDuration.WithLabelValues(opSetMultiAsync).Observe(time.Since(start).Seconds())
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		c.trackStored(ctx, typ, blockID, clientIdx, items)
+	}
+	return c.unlessClosed(errs.Err())
+}
+
+// storeItem stores the item of the given type to the cache, only if absent if enabled, or
+// with setItem otherwise.
+func (c *RemoteIndexCache) storeItem(ctx context.Context, clientIdx int, typ string, item cacheutil.RemoteCacheItem) error {
+	if !c.config.StoreIfAbsent {
+		return c.setItem(ctx, clientIdx, item)
+	}
+	start := time.Now()
+	err := c.clients[clientIdx].(cacheutil.RemoteCacheClientWithAdd).AddAsync(ctx, item.Key, item.Value, item.TTL, c.skippedExisting.WithLabelValues(typ).Inc)
+	c.operationDuration.WithLabelValues(opAddAsync).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// setItem stores the item to the cache, synchronously if enabled, or by enqueuing it otherwise.
+func (c *RemoteIndexCache) setItem(ctx context.Context, clientIdx int, item cacheutil.RemoteCacheItem) error {
+	start := time.Now()
+	if c.config.SynchronousStore {
+		err := c.clients[clientIdx].(cacheutil.RemoteCacheClientWithSet).Set(ctx, item.Key, item.Value, item.TTL)
+		c.operationDuration.WithLabelValues(opSet).Observe(time.Since(start).Seconds())
+		return err
+	}
+	err := c.caches[clientIdx].StoreMulti(ctx, []cacheutil.RemoteCacheItem{item})
+	c.operationDuration.WithLabelValues(opSetAsync).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// prepareItem returns the item to be stored for the value, compressed according to the configured
+// codec and with a jittered TTL, and whether it should be stored, which isn't the case if it exceeds
+// the max item size.
+func (c *RemoteIndexCache) prepareItem(ctx context.Context, typ string, k cacheKey, v []byte, ttl time.Duration) (cacheutil.RemoteCacheItem, bool) {
+	if c.config.Compression == CompressionSnappy && len(v) > 0 {
+		compressed := compress(c.config.Compression, v)
+		c.compressionRatio.WithLabelValues(typ).Observe(float64(len(compressed)) / float64(len(v)))
+		v = compressed
+	}
+
+	if c.config.VerifyKeys {
+		v = withFingerprint(k, v)
+	}
+	if c.config.StoreTimestamps {
+		v = withTimestamp(c.now(), v)
+	}
+	if c.config.Checksums {
+		v = withChecksum(v)
+	}
+
+	// Skip the item at all if it would be rejected by the backend anyway.
+	if c.config.MaxItemSize > 0 && uint64(len(v)) > uint64(c.config.MaxItemSize) {
+		c.tooBigItems.WithLabelValues(typ).Inc()
+		return cacheutil.RemoteCacheItem{}, false
+	}
+	return cacheutil.RemoteCacheItem{Key: c.key(ctx, k), Value: v, TTL: c.jitterTTL(ttl)}, true
+}
+
+// dropOnFullQueue returns whether the items should be dropped rather than enqueued to the client,
+// given its async queue is above the high watermark and they would likely be dropped anyway.
+func (c *RemoteIndexCache) dropOnFullQueue(clientIdx int, typ string, items int) bool {
+	if c.config.AsyncQueueHighWatermark <= 0 || c.config.SynchronousStore {
+		return false
+	}
+	if q, ok := c.clients[clientIdx].(cacheutil.RemoteCacheClientWithAsyncQueue); ok && q.AsyncQueueFullRatio() >= c.config.AsyncQueueHighWatermark {
+		c.droppedItems.WithLabelValues(typ).Add(float64(items))
+		return true
+	}
+	return false
+}
+
+// trackStored accounts the items enqueued to the client and, if enabled, tracks their keys,
+// scheduling the flush of the block keys index if any of them is new.
+func (c *RemoteIndexCache) trackStored(ctx context.Context, typ string, blockID ulid.ULID, clientIdx int, items []cacheutil.RemoteCacheItem) {
+	storedBytes := 0
+	for _, item := range items {
+		storedBytes += len(item.Value)
+	}
+	c.storedBytes.WithLabelValues(typ).Add(float64(storedBytes))
+	c.recordStore(typ, blockID, len(items), storedBytes)
+
+	if c.blockKeys == nil {
+		return
+	}
+	added := false
+	for _, item := range items {
+		added = c.blockKeys.add(blockID, item.Key, clientIdx) || added
+	}
+	if added && c.config.BlockKeysIndex {
+		c.scheduleBlockKeysIndexFlush(ctx, blockID)
+	}
+}
+
+// decompressionMetrics holds the decompression metrics of an item type.
+type decompressionMetrics struct {
+	duration     prometheus.Observer
+	compressed   prometheus.Counter
+	uncompressed prometheus.Counter
+}
+
+// decompress decompresses the value fetched for the item with the configured codec, tracking whether
+// it was compressed and, if so, the time spent decompressing it. It returns false if the value can't be
+// decompressed, in which case it's corrupted and treated as a miss.
+func (c *RemoteIndexCache) decompress(k cacheKey, v []byte) ([]byte, bool) {
+	m := c.decompression[k.keyType()]
+	if c.config.Compression != CompressionSnappy {
+		m.uncompressed.Inc()
+		return v, true
+	}
+
+	start := time.Now()
+	decoded, err := decompress(c.config.Compression, v)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to decompress entry found in remote index cache", "type", k.keyType(), "block", k.block, "err", err)
+		c.corruptedEntries.WithLabelValues(k.keyType()).Inc()
+		return nil, false
+	}
+	m.duration.Observe(time.Since(start).Seconds())
+	m.compressed.Inc()
+	return decoded, true
+}
+
+// encodeSeries encodes the series value with the configured codec, if any, returning false if it
+// failed to, in which case it shouldn't be stored.
+func (c *RemoteIndexCache) encodeSeries(v []byte) ([]byte, bool) {
+	encoded, err := encodeSeries(c.config.SeriesCodec, v)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to encode series for the remote index cache", "err", err)
+		c.seriesCodecFailures.WithLabelValues(seriesCodecEncode).Inc()
+		return nil, false
+	}
+	return encoded, true
+}
+
+// decodeSeries decodes the fetched series value with the configured codec, if any, returning false if it
+// failed to, in which case it should be considered a miss.
+func (c *RemoteIndexCache) decodeSeries(v []byte) ([]byte, bool) {
+	decoded, err := decodeSeries(c.config.SeriesCodec, v)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to decode series fetched from the remote index cache", "err", err)
+		c.seriesCodecFailures.WithLabelValues(seriesCodecDecode).Inc()
+		return nil, false
+	}
+	return decoded, true
+}
+
+// withFingerprint returns v prefixed by the fingerprint of the item it's stored for.
+func withFingerprint(k cacheKey, v []byte) []byte {
+	result := make([]byte, fingerprintSize+len(v))
+	binary.BigEndian.PutUint64(result, k.fingerprint())
+	copy(result[fingerprintSize:], v)
+	return result
+}
+
+// verifyFingerprint returns the value without the fingerprint it's prefixed by if the keys verification
+// is enabled, along with false if the fingerprint doesn't match the requested item. Otherwise v is returned as is.
+func (c *RemoteIndexCache) verifyFingerprint(k cacheKey, v []byte) ([]byte, bool) {
+	if !c.config.VerifyKeys {
+		return v, true
+	}
+	if len(v) < fingerprintSize || binary.BigEndian.Uint64(v) != k.fingerprint() {
+		level.Error(c.logger).Log("msg", "cache key collision found in remote index cache", "type", k.keyType(), "block", k.block)
+		c.collisions.WithLabelValues(k.keyType()).Inc()
+		return nil, false
+	}
+	return v[fingerprintSize:], true
+}
+
+// withTimestamp returns v prefixed by the time it's stored at, in milliseconds since the epoch.
+func withTimestamp(t time.Time, v []byte) []byte {
+	result := make([]byte, timestampSize+len(v))
+	binary.BigEndian.PutUint64(result, uint64(t.UnixMilli()))
+	copy(result[timestampSize:], v)
+	return result
+}
+
+// withChecksum returns v prefixed by its CRC32 checksum.
+func withChecksum(v []byte) []byte {
+	result := make([]byte, checksumSize+len(v))
+	binary.BigEndian.PutUint32(result, crc32.Checksum(v, castagnoliTable))
+	copy(result[checksumSize:], v)
+	return result
+}
+
+// verifyChecksum returns the value without the checksum it's prefixed by if the checksums are enabled,
+// along with false if the checksum doesn't match the value. Otherwise v is returned as is.
+func (c *RemoteIndexCache) verifyChecksum(k cacheKey, v []byte) ([]byte, bool) {
+	if !c.config.Checksums {
+		return v, true
+	}
+	if len(v) < checksumSize || binary.BigEndian.Uint32(v) != crc32.Checksum(v[checksumSize:], castagnoliTable) {
+		level.Warn(c.logger).Log("msg", "corrupted entry found in remote index cache", "type", k.keyType(), "block", k.block)
+		c.corruptedEntries.WithLabelValues(k.keyType()).Inc()
+		return nil, false
+	}
+	return v[checksumSize:], true
+}
+
+// unwrapEntry returns the value stored in the fetched entry, without the checksum, the timestamp and
+// the fingerprint it's prefixed by, if enabled, and decompressed, along with the time it was stored at,
+// if known, and false if the entry is malformed, corrupted or its fingerprint doesn't match the requested
+// item.
+func (c *RemoteIndexCache) unwrapEntry(k cacheKey, v []byte) ([]byte, time.Time, bool) {
+	v, ok := c.verifyChecksum(k, v)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	var storedAt time.Time
+	if c.config.StoreTimestamps {
+		if len(v) < timestampSize {
+			return nil, time.Time{}, false
+		}
+		storedAt = time.UnixMilli(int64(binary.BigEndian.Uint64(v)))
+		v = v[timestampSize:]
+	}
+	if v, ok = c.verifyFingerprint(k, v); !ok {
+		return nil, time.Time{}, false
+	}
+	v, ok = c.decompress(k, v)
+	return v, storedAt, ok
+}
+
+// age returns the time elapsed since storedAt, or 0 if it's in the future because of clock skew.
+func (c *RemoteIndexCache) age(storedAt time.Time) time.Duration {
+	if age := c.now().Sub(storedAt); age > 0 {
+		return age
+	}
+	return 0
+}
+
+// observeBlockAge records the outcome of a fetch of the given number of requested items of the block
+// by age of the block, if enabled.
+func (c *RemoteIndexCache) observeBlockAge(typ string, blockID ulid.ULID, requests, hits int) {
+	if c.requestsByBlockAge == nil {
+		return
+	}
+
+	age := blockAgeMoreThanDay
+	switch d := c.now().Sub(ulid.Time(blockID.Time())); {
+	case d < time.Hour:
+		age = blockAgeLessThanHour
+	case d < 24*time.Hour:
+		age = blockAgeLessThanDay
+	}
+	c.requestsByBlockAge.WithLabelValues(typ, age).Add(float64(requests))
+	c.hitsByBlockAge.WithLabelValues(typ, age).Add(float64(hits))
+}
+
+// ttl returns the TTL of the entries of the block, the given default one unless a TTLFunc is configured
+// and returns a positive TTL for the block. A non positive TTL would make memcached keep the entries
+// forever, or expire them right away, instead.
+func (c *RemoteIndexCache) ttl(blockID ulid.ULID, defaultTTL time.Duration) time.Duration {
+	if c.config.TTLFunc == nil {
+		return defaultTTL
+	}
+	if ttl := c.config.TTLFunc(blockID, c.now()); ttl > 0 {
+		return ttl
+	}
+	return defaultTTL
+}
+
+// jitterTTL randomly increases or decreases the TTL by up to the configured jitter fraction.
+func (c *RemoteIndexCache) jitterTTL(ttl time.Duration) time.Duration {
+	if c.config.TTLJitter <= 0 {
+		return ttl
+	}
+
+	c.rngMtx.Lock()
+	r := c.rng.Float64()
+	c.rngMtx.Unlock()
+
+	return ttl + time.Duration(float64(ttl)*c.config.TTLJitter*(2*r-1))
+}
+
+// getMultiRouted fetches the keys from the clients they're routed to, concurrently, and merges
+// the results. In case some clients fail, the results of the others are returned along with the last error.
+func (c *RemoteIndexCache) getMultiRouted(ctx context.Context, keys routedKeys) (map[string][]byte, error) {
+	// Skip the merging if all the keys are routed to the same client.
+	numClients, lastClientIdx := 0, 0
+	for clientIdx, clientKeys := range keys {
+		if len(clientKeys) > 0 {
+			numClients++
+			lastClientIdx = clientIdx
+		}
+	}
+	if numClients <= 1 {
+		return c.getMulti(ctx, lastClientIdx, keys[lastClientIdx])
+	}
+
+	var (
+		mtx     sync.Mutex
+		results = map[string][]byte{}
+		lastErr error
+	)
+
+	g := errgroup.Group{}
+	for clientIdx, clientKeys := range keys {
+		if len(clientKeys) == 0 {
+			continue
+		}
+
+		clientIdx, clientKeys := clientIdx, clientKeys
+		g.Go(func() error {
+			clientResults, err := c.getMulti(ctx, clientIdx, clientKeys)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			if err != nil {
+				lastErr = err
+			}
+			for k, v := range clientResults {
+				results[k] = v
+			}
+			return nil
+		})
+	}
+
+	// Errors are tracked in lastErr, so that a failing client doesn't prevent the others from being merged.
+	_ = g.Wait()
+
+	return results, lastErr
+}
+
+// getMulti fetches the keys from the cache client, splitting them in batches according
+// to the configured max batch size. In case some batches fail, the results of the other
+// batches are returned along with the last error.
+func (c *RemoteIndexCache) getMulti(ctx context.Context, clientIdx int, keys []string) (map[string][]byte, error) {
+	batchSize := c.config.MaxGetMultiBatchSize
+	if batchSize <= 0 || len(keys) <= batchSize {
+		return c.getMultiSingle(ctx, clientIdx, keys)
+	}
+
+	var (
+		mtx     sync.Mutex
+		results = make(map[string][]byte, len(keys))
+		lastErr error
+	)
+
+	g := errgroup.Group{}
+	if c.config.MaxGetMultiConcurrency > 0 {
+		g.SetLimit(c.config.MaxGetMultiConcurrency)
+	}
+
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[start:end]
+
+		g.Go(func() error {
+			batchResults, err := c.getMultiSingle(ctx, clientIdx, batch)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			if err != nil {
+				lastErr = err
+			}
+			for k, v := range batchResults {
+				results[k] = v
+			}
+			return nil
+		})
+	}
+
+	// Errors are tracked in lastErr, so that a failing batch doesn't prevent the others from being merged.
+	_ = g.Wait()
+
+	return results, lastErr
+}
+
+// getMultiSingle fetches the keys from the cache in a single request. The error is returned only if
+// the client supports reporting it, otherwise it's tracked/logged by the client itself. The keys which
+// timed out are tracked apart from the misses if the client supports reporting them.
+func (c *RemoteIndexCache) getMultiSingle(ctx context.Context, clientIdx int, keys []string) (map[string][]byte, error) {
+	if c.closed.Load() || bypassFromContext(ctx) {
+		return nil, nil
+	}
+
+	release, err := c.waitGetMultiSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	defer func() {
+		c.operationDuration.WithLabelValues(opGetMulti).Observe(time.Since(start).Seconds())
+	}()
+
+	if client, ok := c.clients[clientIdx].(cacheutil.RemoteCacheClientWithPartialResults); ok {
+		hits, timedOut, err := client.GetMultiPartial(ctx, keys)
+		c.partialTimeouts.Add(float64(len(timedOut)))
+		return hits, c.unlessClosed(err)
+	}
+	hits, err := c.caches[clientIdx].FetchMulti(ctx, keys)
+	return hits, c.unlessClosed(err)
+}
+
+// cachesPostingsOf returns whether the postings of the label name are cached, according to the
+// configured allowlist and denylist.
+func (c *RemoteIndexCache) cachesPostingsOf(name string) bool {
+	if _, ok := c.postingsDenylist[name]; ok {
+		return false
+	}
+	if len(c.postingsAllowlist) == 0 {
+		return true
+	}
+	_, ok := c.postingsAllowlist[name]
+	return ok
+}
+
+// labelNamesSet returns the set of the label names, or nil if there's none.
+func labelNamesSet(names []string) map[string]struct{} {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return set
+}
+
+// waitGetMultiSlot waits for a slot among the client GetMulti() operations in flight, if bounded,
+// queueing up to the max queue wait, and returns the function releasing it.
+func (c *RemoteIndexCache) waitGetMultiSlot(ctx context.Context) (func(), error) {
+	if c.getMultiGate == nil {
+		return func() {}, nil
+	}
+	release := func() { <-c.getMultiGate }
+
+	select {
+	case c.getMultiGate <- struct{}{}:
+		c.getMultiQueueWait.Observe(0)
+		return release, nil
+	default:
+	}
+
+	c.getMultiQueueLength.Inc()
+	start := time.Now()
+	defer func() {
+		c.getMultiQueueLength.Dec()
+		c.getMultiQueueWait.Observe(time.Since(start).Seconds())
+	}()
+
+	var timeout <-chan time.Time
+	if c.config.MaxGetMultiQueueWait > 0 {
+		timer := time.NewTimer(c.config.MaxGetMultiQueueWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case c.getMultiGate <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		c.getMultiQueueTimeouts.Inc()
+		return nil, errRemoteIndexCacheGetMultiQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 // NewMemcachedIndexCache is alias NewRemoteIndexCache for compatible.
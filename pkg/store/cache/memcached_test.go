@@ -4,18 +4,32 @@
 package storecache
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/oklog/ulid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 
 	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/thanos/pkg/cache"
+	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tracing"
 )
 
 func TestMemcachedIndexCache_FetchMultiPostings(t *testing.T) {
@@ -194,10 +208,1935 @@ func TestMemcachedIndexCache_FetchMultiSeries(t *testing.T) {
 			testutil.Equals(t, float64(len(testData.expectedHits)), prom_testutil.ToFloat64(c.seriesHits))
 			testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.postingRequests))
 			testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.postingHits))
+
+			// Iterating over the series is expected to pass the same hits.
+			var iterHits map[storage.SeriesRef][]byte
+			misses, err = c.IterMultiSeries(ctx, testData.fetchBlockID, testData.fetchIds, func(id storage.SeriesRef, v []byte) {
+				if iterHits == nil {
+					iterHits = map[storage.SeriesRef][]byte{}
+				}
+				iterHits[id] = v
+			})
+			testutil.Equals(t, testData.mockedErr, err)
+			testutil.Equals(t, testData.expectedHits, iterHits)
+			testutil.Equals(t, testData.expectedMisses, misses)
+		})
+	}
+}
+
+func TestMemcachedIndexCache_FetchMultiE_ShouldReturnClientError(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	mockedErr := errors.New("mocked error")
+
+	memcached := newMockedMemcachedClient(mockedErr)
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StorePostings(ctx, block, label, []byte{1})
+	c.StoreSeries(ctx, block, 1, []byte{2})
+
+	postingsHits, postingsMisses, err := c.FetchMultiPostingsE(ctx, block, []labels.Label{label})
+	testutil.Equals(t, mockedErr, err)
+	testutil.Equals(t, 0, len(postingsHits))
+	testutil.Equals(t, []labels.Label{label}, postingsMisses)
+
+	seriesHits, seriesMisses, err := c.FetchMultiSeriesE(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, mockedErr, err)
+	testutil.Equals(t, 0, len(seriesHits))
+	testutil.Equals(t, []storage.SeriesRef{1}, seriesMisses)
+
+	// A genuine miss should not be reported as an error.
+	memcached.mockedGetMultiErr = nil
+	_, postingsMisses, err = c.FetchMultiPostingsE(ctx, block, []labels.Label{{Name: "instance", Value: "b"}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(postingsMisses))
+}
+
+func TestMemcachedIndexCache_FetchMulti_ShouldStopOnContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	memcached := newMockedMemcachedClient(nil)
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+	testutil.Ok(t, err)
+
+	ids := make([]storage.SeriesRef, 0, 10*checkContextEveryNIterations)
+	lbls := make([]labels.Label, 0, 10*checkContextEveryNIterations)
+	for i := 0; i < 10*checkContextEveryNIterations; i++ {
+		ids = append(ids, storage.SeriesRef(i))
+		lbls = append(lbls, labels.Label{Name: "instance", Value: fmt.Sprintf("%d", i)})
+		c.StoreSeries(context.Background(), block, ids[i], []byte{1})
+		c.StorePostings(context.Background(), block, lbls[i], []byte{1})
+	}
+
+	// The mocked client ignores the context, so the returned results are
+	// assembled while the context has already been canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	seriesHits, seriesMisses, err := c.FetchMultiSeriesE(ctx, block, ids)
+	testutil.Equals(t, context.Canceled, err)
+	testutil.Equals(t, 0, len(seriesHits))
+	testutil.Equals(t, ids, seriesMisses)
+
+	postingsHits, postingsMisses, err := c.FetchMultiPostingsE(ctx, block, lbls)
+	testutil.Equals(t, context.Canceled, err)
+	testutil.Equals(t, 0, len(postingsHits))
+	testutil.Equals(t, lbls, postingsMisses)
+}
+
+func TestMemcachedIndexCache_Fetch_ShouldNotWarnOnContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")}
+	memcached := newMockedMemcachedClient(context.Canceled)
+	buf := &bytes.Buffer{}
+	c, err := NewRemoteIndexCache(log.NewLogfmtLogger(buf), memcached, nil)
+	testutil.Ok(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, ok := c.FetchExpandedPostings(ctx, block, matchers)
+	testutil.Assert(t, !ok)
+	_, ok = c.FetchLabelValues(ctx, block, "job", matchers)
+	testutil.Assert(t, !ok)
+	_, ok = c.FetchLabelNames(ctx, block, matchers)
+	testutil.Assert(t, !ok)
+	testutil.Assert(t, !strings.Contains(buf.String(), "level=warn"), "unexpected warning: %s", buf.String())
+}
+
+func TestMemcachedIndexCache_FetchExpandedPostings(t *testing.T) {
+	t.Parallel()
+
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	matcher1 := labels.MustNewMatcher(labels.MatchEqual, "cluster", "us")
+	matcher2 := labels.MustNewMatcher(labels.MatchRegexp, "job", "api.*")
+	value1 := []byte{1}
+
+	memcached := newMockedMemcachedClient(nil)
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StoreExpandedPostings(ctx, block1, []*labels.Matcher{matcher1, matcher2}, value1)
+
+	// The matchers order should not matter.
+	value, ok := c.FetchExpandedPostings(ctx, block1, []*labels.Matcher{matcher2, matcher1})
+	testutil.Assert(t, ok)
+	testutil.Equals(t, value1, value)
+
+	// A different block or a different set of matchers should be a miss.
+	_, ok = c.FetchExpandedPostings(ctx, block2, []*labels.Matcher{matcher1, matcher2})
+	testutil.Assert(t, !ok)
+	_, ok = c.FetchExpandedPostings(ctx, block1, []*labels.Matcher{matcher1})
+	testutil.Assert(t, !ok)
+
+	testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c.expandedPostingRequests))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.expandedPostingHits))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.postingRequests))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.seriesRequests))
+}
+
+func TestMemcachedIndexCache_FetchLabelValues(t *testing.T) {
+	t.Parallel()
+
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	matcher1 := labels.MustNewMatcher(labels.MatchEqual, "cluster", "us")
+	matcher2 := labels.MustNewMatcher(labels.MatchRegexp, "job", "api.*")
+	value1 := []byte{1}
+
+	memcached := newMockedMemcachedClient(nil)
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StoreLabelValues(ctx, block1, "instance", []*labels.Matcher{matcher1, matcher2}, value1)
+
+	// The matchers order should not matter.
+	value, ok := c.FetchLabelValues(ctx, block1, "instance", []*labels.Matcher{matcher2, matcher1})
+	testutil.Assert(t, ok)
+	testutil.Equals(t, value1, value)
+
+	// A different block, label name or set of matchers should be a miss.
+	_, ok = c.FetchLabelValues(ctx, block2, "instance", []*labels.Matcher{matcher1, matcher2})
+	testutil.Assert(t, !ok)
+	_, ok = c.FetchLabelValues(ctx, block1, "pod", []*labels.Matcher{matcher1, matcher2})
+	testutil.Assert(t, !ok)
+	_, ok = c.FetchLabelValues(ctx, block1, "instance", []*labels.Matcher{matcher1})
+	testutil.Assert(t, !ok)
+
+	// The label values should not collide with the expanded postings of the same matchers.
+	_, ok = c.FetchExpandedPostings(ctx, block1, []*labels.Matcher{matcher1, matcher2})
+	testutil.Assert(t, !ok)
+
+	testutil.Equals(t, 4.0, prom_testutil.ToFloat64(c.labelValuesRequests))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.labelValuesHits))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.expandedPostingRequests))
+}
+
+func TestMemcachedIndexCache_FetchLabelNames(t *testing.T) {
+	t.Parallel()
+
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	matcher1 := labels.MustNewMatcher(labels.MatchEqual, "cluster", "us")
+	matcher2 := labels.MustNewMatcher(labels.MatchRegexp, "job", "api.*")
+	value1 := []byte{1}
+
+	memcached := newMockedMemcachedClient(nil)
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StoreLabelNames(ctx, block1, []*labels.Matcher{matcher1, matcher2}, value1)
+
+	// The matchers order should not matter.
+	value, ok := c.FetchLabelNames(ctx, block1, []*labels.Matcher{matcher2, matcher1})
+	testutil.Assert(t, ok)
+	testutil.Equals(t, value1, value)
+
+	// A different block or set of matchers should be a miss, even if it selects more series.
+	_, ok = c.FetchLabelNames(ctx, block2, []*labels.Matcher{matcher1, matcher2})
+	testutil.Assert(t, !ok)
+	_, ok = c.FetchLabelNames(ctx, block1, []*labels.Matcher{matcher1})
+	testutil.Assert(t, !ok)
+
+	// The label names should not collide with the expanded postings of the same matchers.
+	_, ok = c.FetchExpandedPostings(ctx, block1, []*labels.Matcher{matcher1, matcher2})
+	testutil.Assert(t, !ok)
+
+	testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c.labelNamesRequests))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.labelNamesHits))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.labelValuesRequests))
+}
+
+func TestNewRemoteIndexCache_ShouldHandleNilArguments(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewRemoteIndexCache(log.NewNopLogger(), nil, nil)
+	testutil.Equals(t, errRemoteIndexCacheClientNil, err)
+
+	c, err := NewRemoteIndexCache(nil, newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+	testutil.Assert(t, c.logger != nil, "expected a default logger")
+}
+
+func TestNewRemoteIndexCacheWithCaches(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+
+	t.Run("should store and fetch the entries through the generic cache", func(t *testing.T) {
+		backend := newMockedGenericCache()
+		c, err := NewRemoteIndexCacheWithCaches(log.NewNopLogger(), []cache.CacheWithError{backend}, nil, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Ok(t, err)
+
+		c.StorePostings(ctx, block, label1, []byte{1})
+		c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{1: {2}, 2: {3}})
+		testutil.Equals(t, 3, len(backend.items))
+		testutil.Equals(t, DefaultRemoteIndexCacheConfig.PostingsTTL, backend.items[c.key(ctx, cacheKey{block, cacheKeyPostings(label1)})].TTL)
+
+		hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+		testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, hits)
+		testutil.Equals(t, []labels.Label{label2}, misses)
+
+		seriesHits, seriesMisses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+		testutil.Equals(t, map[storage.SeriesRef][]byte{1: {2}, 2: {3}}, seriesHits)
+		testutil.Equals(t, 0, len(seriesMisses))
+	})
+
+	t.Run("should report the failures of the generic cache", func(t *testing.T) {
+		backend := newMockedGenericCache()
+		c, err := NewRemoteIndexCacheWithCaches(log.NewNopLogger(), []cache.CacheWithError{backend}, nil, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Ok(t, err)
+
+		backend.err = errors.New("mocked error")
+		c.StorePostings(ctx, block, label1, []byte{1})
+		testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.storedBytes.WithLabelValues(cacheTypePostings)))
+		_, _, err = c.FetchMultiPostingsE(ctx, block, []labels.Label{label1})
+		testutil.Equals(t, backend.err, err)
+	})
+
+	t.Run("should fail with the features relying on the cache clients", func(t *testing.T) {
+		config := DefaultRemoteIndexCacheConfig
+		config.SynchronousStore = true
+		_, err := NewRemoteIndexCacheWithCaches(log.NewNopLogger(), []cache.CacheWithError{newMockedGenericCache()}, nil, nil, config)
+		testutil.Equals(t, errRemoteIndexCacheSynchronousStoreUnsupported, err)
+
+		c, err := NewRemoteIndexCacheWithCaches(log.NewNopLogger(), []cache.CacheWithError{newMockedGenericCache()}, nil, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Ok(t, err)
+		testutil.NotOk(t, c.DeletePostings(ctx, block, label1))
+	})
+
+	t.Run("should fail without caches", func(t *testing.T) {
+		_, err := NewRemoteIndexCacheWithCaches(log.NewNopLogger(), nil, nil, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Equals(t, errRemoteIndexCacheClientNil, err)
+		_, err = NewRemoteIndexCacheWithCaches(log.NewNopLogger(), []cache.CacheWithError{nil}, nil, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Equals(t, errRemoteIndexCacheClientNil, err)
+	})
+}
+
+func TestRemoteIndexCache_Compression(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	value := bytes.Repeat([]byte{0, 0, 0, 1}, 256)
+
+	memcached := newMockedMemcachedClient(nil)
+	config := DefaultRemoteIndexCacheConfig
+	config.Compression = CompressionSnappy
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StorePostings(ctx, block, label1, value)
+	c.StoreSeries(ctx, block, 1, value)
+
+	// Entries should be stored compressed.
+	stored := memcached.cache[c.key(ctx, cacheKey{block, cacheKeyPostings(label1)})]
+	testutil.Equals(t, compressionMarkerSnappy, stored[0])
+	testutil.Assert(t, len(stored) < len(value))
+
+	postings, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1})
+	testutil.Equals(t, 0, len(misses))
+	testutil.Equals(t, map[labels.Label][]byte{label1: value}, postings)
+
+	series, misses2 := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, 0, len(misses2))
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: value}, series)
+
+	testutil.Equals(t, 2, prom_testutil.CollectAndCount(c.compressionRatio))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.decompression[cacheTypePostings].compressed))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.decompression[cacheTypeSeries].compressed))
+	testutil.Equals(t, uint64(1), histogramSampleCount(t, c.decompression[cacheTypePostings].duration))
+
+	// Uncompressed entries are namespaced away from compressed ones, so that an uncompressed value looking
+	// like a compressed one, e.g. label values whose count uvarint starts with 0xff, is returned as is.
+	uncompressed, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+	rawValue := compress(CompressionSnappy, value)
+	uncompressed.StorePostings(ctx, block, label2, rawValue)
+	uncompressed.StoreLabelValues(ctx, block, "instance", nil, rawValue)
+
+	_, misses = c.FetchMultiPostings(ctx, block, []labels.Label{label2})
+	testutil.Equals(t, []labels.Label{label2}, misses)
+	_, misses = uncompressed.FetchMultiPostings(ctx, block, []labels.Label{label1})
+	testutil.Equals(t, []labels.Label{label1}, misses)
+
+	postings, misses = uncompressed.FetchMultiPostings(ctx, block, []labels.Label{label2})
+	testutil.Equals(t, 0, len(misses))
+	testutil.Equals(t, map[labels.Label][]byte{label2: rawValue}, postings)
+	labelValues, ok := uncompressed.FetchLabelValues(ctx, block, "instance", nil)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, rawValue, labelValues)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(uncompressed.decompression[cacheTypePostings].uncompressed))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(uncompressed.decompression[cacheTypePostings].compressed))
+
+	// Compressed entries which can't be decompressed are misses.
+	memcached.cache[c.key(ctx, cacheKey{block, cacheKeySeries(1)})] = value
+	_, misses2 = c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, []storage.SeriesRef{1}, misses2)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.corruptedEntries.WithLabelValues(cacheTypeSeries)))
+}
+
+func TestRemoteIndexCache_SeriesCodec(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	reverse := func(v []byte) ([]byte, error) {
+		result := make([]byte, len(v))
+		for i := range v {
+			result[i] = v[len(v)-1-i]
+		}
+		return result, nil
+	}
+	failing := func([]byte) ([]byte, error) { return nil, errors.New("failed") }
+
+	// Raw series may start with any byte, including the ones of an encoded value.
+	rawSeries := []byte{0xfe, 1, 's'}
+
+	memcached := newMockedMemcachedClient(nil)
+	config := DefaultRemoteIndexCacheConfig
+	config.Compression = CompressionSnappy
+	raw, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	config.SeriesCodec = &SeriesCodec{ID: 1, Encode: reverse, Decode: reverse}
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{1: []byte("series1"), 2: rawSeries})
+
+	// Entries should be stored encoded, and compressed afterwards, under the keys of the codec.
+	key := c.key(ctx, cacheKey{block, cacheKeySeries(1)})
+	testutil.Assert(t, strings.HasPrefix(key, snappyKeyPrefix+"X1:"), "unexpected key %s", key)
+	stored, err := decompress(CompressionSnappy, memcached.cache[key])
+	testutil.Ok(t, err)
+	testutil.Equals(t, []byte("1seires"), stored)
+
+	series, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, 0, len(misses))
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: []byte("series1"), 2: rawSeries}, series)
+
+	// The entries stored without the codec, even starting like encoded values, are kept apart from the
+	// ones stored with the codec, and the other way around.
+	raw.StoreSeries(ctx, block, 3, rawSeries)
+	series, misses = raw.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 3})
+	testutil.Equals(t, []storage.SeriesRef{1}, misses)
+	testutil.Equals(t, map[storage.SeriesRef][]byte{3: rawSeries}, series)
+
+	_, misses = c.FetchMultiSeries(ctx, block, []storage.SeriesRef{3})
+	testutil.Equals(t, []storage.SeriesRef{3}, misses)
+
+	// The entries encoded with another codec are misses.
+	config.SeriesCodec = &SeriesCodec{ID: 2, Encode: reverse, Decode: reverse}
+	other, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	_, misses = other.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 3})
+	testutil.Equals(t, []storage.SeriesRef{1, 3}, misses)
+
+	// The values failing to be encoded aren't stored, and the ones failing to be decoded miss.
+	config.SeriesCodec = &SeriesCodec{ID: 1, Encode: failing, Decode: failing}
+	c, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	c.StoreSeries(ctx, block, 4, []byte("series4"))
+	_, ok := memcached.cache[c.key(ctx, cacheKey{block, cacheKeySeries(4)})]
+	testutil.Assert(t, !ok)
+
+	_, misses = c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, []storage.SeriesRef{1}, misses)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.seriesCodecFailures.WithLabelValues(seriesCodecEncode)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.seriesCodecFailures.WithLabelValues(seriesCodecDecode)))
+
+	config.SeriesCodec = &SeriesCodec{ID: 1, Encode: reverse}
+	_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.NotOk(t, err)
+}
+
+func TestRemoteIndexCache_BytesMetrics(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StorePostings(ctx, block, label1, []byte{1, 2, 3})
+	c.StorePostings(ctx, block, label2, []byte{4, 5})
+	c.StoreSeries(ctx, block, 1, []byte{6})
+
+	testutil.Equals(t, 5.0, prom_testutil.ToFloat64(c.storedBytes.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.storedBytes.WithLabelValues(cacheTypeSeries)))
+
+	c.FetchMultiPostings(ctx, block, []labels.Label{label1, {Name: "instance", Value: "c"}})
+	c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+
+	testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c.fetchedBytes.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.fetchedBytes.WithLabelValues(cacheTypeSeries)))
+}
+
+func TestRemoteIndexCache_HitRatio(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+
+	reg := prometheus.NewPedanticRegistry()
+	config := DefaultRemoteIndexCacheConfig
+	config.HitRatioWindowSize = 4
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), reg, config)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StorePostings(ctx, block, label1, []byte{1})
+	c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+	c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+
+	testutil.Ok(t, prom_testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP thanos_store_index_cache_hit_ratio Ratio of items requests to the cache that were a hit, computed over a window of requests.
+		# TYPE thanos_store_index_cache_hit_ratio gauge
+		thanos_store_index_cache_hit_ratio{item_type="ExpandedPostings"} 0
+		thanos_store_index_cache_hit_ratio{item_type="LabelNames"} 0
+		thanos_store_index_cache_hit_ratio{item_type="LabelValues"} 0
+		thanos_store_index_cache_hit_ratio{item_type="Postings"} 0.5
+		thanos_store_index_cache_hit_ratio{item_type="Series"} 0
+	`), "thanos_store_index_cache_hit_ratio"))
+
+	config.HitRatioWindowSize = 0
+	_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+	testutil.Equals(t, errRemoteIndexCacheHitRatioWindowSizeNotPositive, err)
+}
+
+func TestRemoteIndexCache_MaxItemSize(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+
+	memcached := newMockedMemcachedClient(nil)
+	config := DefaultRemoteIndexCacheConfig
+	config.MaxItemSize = 2
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StorePostings(ctx, block, label1, []byte{1, 2})
+	c.StorePostings(ctx, block, label2, []byte{1, 2, 3})
+	c.StoreSeries(ctx, block, 1, []byte{1, 2, 3})
+
+	testutil.Equals(t, 1, len(memcached.cache))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.tooBigItems.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.tooBigItems.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.storedBytes.WithLabelValues(cacheTypePostings)))
+}
+
+func TestRemoteIndexCache_GetMultiBatching(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+
+	for _, concurrency := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			memcached := newMockedMemcachedClient(nil)
+			config := DefaultRemoteIndexCacheConfig
+			config.MaxGetMultiBatchSize = 2
+			config.MaxGetMultiConcurrency = concurrency
+			c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+			testutil.Ok(t, err)
+
+			ctx := context.Background()
+			c.StoreSeries(ctx, block, 1, []byte{1})
+			c.StoreSeries(ctx, block, 3, []byte{3})
+			c.StoreSeries(ctx, block, 5, []byte{5})
+
+			hits, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2, 3, 4, 5})
+			testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1}, 3: {3}, 5: {5}}, hits)
+			testutil.Equals(t, []storage.SeriesRef{2, 4}, misses)
+			testutil.Equals(t, 3, memcached.getMultiCount())
+		})
+	}
+}
+
+func TestRemoteIndexCache_OperationDuration(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	c.StorePostings(ctx, block, label, []byte{1})
+	c.StoreSeries(ctx, block, 1, []byte{1})
+	c.FetchMultiPostings(ctx, block, []labels.Label{label})
+
+	testutil.Equals(t, uint64(2), histogramSampleCount(t, c.operationDuration.WithLabelValues(opSetAsync)))
+	testutil.Equals(t, uint64(1), histogramSampleCount(t, c.operationDuration.WithLabelValues(opGetMulti)))
+
+	// The keys of a single label fetch are built on the fast path, which isn't measured.
+	testutil.Equals(t, uint64(0), histogramSampleCount(t, c.keyBuildDuration.WithLabelValues(cacheTypePostings)))
+	c.FetchMultiPostings(ctx, block, []labels.Label{label, {Name: "instance", Value: "b"}})
+	c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, uint64(1), histogramSampleCount(t, c.keyBuildDuration.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, uint64(1), histogramSampleCount(t, c.keyBuildDuration.WithLabelValues(cacheTypeSeries)))
+}
+
+// traceIDMockTracer is a mocktracer.MockTracer able to tell the trace ID of its spans.
+type traceIDMockTracer struct {
+	*mocktracer.MockTracer
+}
+
+func (t traceIDMockTracer) GetTraceIDFromSpanContext(ctx opentracing.SpanContext) (string, bool) {
+	return strconv.Itoa(ctx.(mocktracer.MockSpanContext).TraceID), true
+}
+
+func TestRemoteIndexCache_Exemplars(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), prometheus.NewRegistry())
+	testutil.Ok(t, err)
+
+	// Without a span in the context, no exemplar is attached.
+	c.StorePostings(context.Background(), block, label, []byte{1})
+	c.FetchMultiPostings(context.Background(), block, []labels.Label{label})
+	testutil.Assert(t, counterExemplar(t, c.postingRequests) == nil)
+
+	tracer := traceIDMockTracer{mocktracer.New()}
+	span := tracer.StartSpan("query")
+	defer span.Finish()
+	ctx := opentracing.ContextWithSpan(tracing.ContextWithTracer(context.Background(), tracer), span)
+	traceID := strconv.Itoa(span.Context().(mocktracer.MockSpanContext).TraceID)
+
+	c.FetchMultiPostings(ctx, block, []labels.Label{label})
+	c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	for _, counter := range []prometheus.Counter{c.postingRequests, c.postingHits, c.seriesRequests} {
+		exemplar := counterExemplar(t, counter)
+		testutil.Assert(t, exemplar != nil)
+		testutil.Equals(t, "traceID", exemplar.GetLabel()[0].GetName())
+		testutil.Equals(t, traceID, exemplar.GetLabel()[0].GetValue())
+	}
+
+	// No exemplar is attached to the counters which aren't incremented.
+	testutil.Assert(t, counterExemplar(t, c.seriesHits) == nil)
+}
+
+func counterExemplar(t *testing.T, c prometheus.Counter) *dto.Exemplar {
+	m := &dto.Metric{}
+	testutil.Ok(t, c.(prometheus.Metric).Write(m))
+	return m.GetCounter().GetExemplar()
+}
+
+func histogramSampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	testutil.Ok(t, o.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRemoteIndexCache_Delete(t *testing.T) {
+	t.Parallel()
+
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	ctx := context.Background()
+
+	t.Run("should delete postings and series", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+		testutil.Ok(t, err)
+
+		c.StorePostings(ctx, block1, label1, []byte{1})
+		c.StoreSeries(ctx, block1, 1, []byte{2})
+
+		testutil.Ok(t, c.DeletePostings(ctx, block1, label1))
+		testutil.Ok(t, c.DeleteSeries(ctx, block1, 1))
+		testutil.Equals(t, 0, len(memcached.cache))
+		testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.deletes))
+
+		// Deleting a block requires the block keys to be tracked.
+		testutil.NotOk(t, c.DeleteBlock(ctx, block1))
+	})
+
+	t.Run("should delete all the tracked keys of a block", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.TrackBlockKeys = true
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		c.StorePostings(ctx, block1, label1, []byte{1})
+		c.StorePostings(ctx, block1, label2, []byte{1})
+		c.StoreSeries(ctx, block1, 1, []byte{2})
+		c.StorePostings(ctx, block2, label1, []byte{3})
+
+		testutil.Ok(t, c.DeleteBlock(ctx, block1))
+		testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c.deletes))
+
+		_, misses := c.FetchMultiPostings(ctx, block1, []labels.Label{label1, label2})
+		testutil.Equals(t, []labels.Label{label1, label2}, misses)
+		hits, _ := c.FetchMultiPostings(ctx, block2, []labels.Label{label1})
+		testutil.Equals(t, map[labels.Label][]byte{label1: {3}}, hits)
+	})
+
+	t.Run("should stop tracking the keys of a forgotten block", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.TrackBlockKeys = true
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+		tracing := NewTracingIndexCache(c)
+
+		c.StorePostings(ctx, block1, label1, []byte{1})
+		c.StorePostings(ctx, block2, label1, []byte{2})
+		ForgetBlock(tracing, block1)
+		testutil.Equals(t, 1, len(c.blockKeys.keys))
+
+		// The keys of the forgotten block are left in the cache.
+		testutil.Ok(t, c.DeleteBlock(ctx, block1))
+		testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.deletes))
+		hits, _ := c.FetchMultiPostings(ctx, block1, []labels.Label{label1})
+		testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, hits)
+	})
+
+	t.Run("should reject an invalid block keys index config", func(t *testing.T) {
+		config := DefaultRemoteIndexCacheConfig
+		config.BlockKeysIndex = true
+		_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+		testutil.Equals(t, errRemoteIndexCacheBlockKeysIndexUntracked, err)
+
+		config.TrackBlockKeys = true
+		config.MaxBlockKeysIndexSize = 0
+		_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+		testutil.Equals(t, errRemoteIndexCacheBlockKeysIndexSizeNotPositive, err)
+
+		config.MaxBlockKeysIndexSize = 1
+		config.BlockKeysIndexFlushInterval = 0
+		_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+		testutil.Equals(t, errRemoteIndexCacheBlockKeysFlushNotPositive, err)
+	})
+
+	t.Run("should delete the keys listed by the block keys index", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.TrackBlockKeys = true
+		config.BlockKeysIndex = true
+		c1, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+		c2, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		c1.StorePostings(ctx, block1, label1, []byte{1})
+		c1.StoreSeries(ctx, block1, 1, []byte{2})
+		c1.flushBlockKeysIndexes()
+		// The index stored by the first cache is merged into the second one's.
+		c2.StorePostings(ctx, block1, label2, []byte{3})
+		c2.StorePostings(ctx, block2, label1, []byte{4})
+		c2.flushBlockKeysIndexes()
+
+		// A cache which didn't store any key of the block deletes them all, along with the index.
+		c3, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+		testutil.Ok(t, c3.DeleteBlock(ctx, block1))
+		testutil.Equals(t, 4.0, prom_testutil.ToFloat64(c3.deletes))
+		testutil.Equals(t, 2, len(memcached.cache))
+
+		_, misses := c3.FetchMultiPostings(ctx, block1, []labels.Label{label1, label2})
+		testutil.Equals(t, []labels.Label{label1, label2}, misses)
+		hits, _ := c3.FetchMultiPostings(ctx, block2, []labels.Label{label1})
+		testutil.Equals(t, map[labels.Label][]byte{label1: {4}}, hits)
+	})
+
+	t.Run("should stop updating the block keys index once overflowed", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.TrackBlockKeys = true
+		config.BlockKeysIndex = true
+		config.MaxBlockKeysIndexSize = 2
+		c1, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		for i := 1; i <= 4; i++ {
+			c1.StoreSeries(ctx, block1, storage.SeriesRef(i), []byte{byte(i)})
+			c1.flushBlockKeysIndexes()
+		}
+		testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c1.blockKeysIndexOverflows))
+
+		index, ok, err := c1.fetchBlockKeysIndex(ctx, c1.key(ctx, cacheKey{block1, cacheKeyBlockKeys{}}))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok)
+		testutil.Assert(t, index.overflowed)
+		testutil.Equals(t, 2, len(index.keys))
+
+		// The keys missing from the index are left to expire.
+		c2, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+		testutil.Ok(t, c2.DeleteBlock(ctx, block1))
+		testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c2.deletes))
+		testutil.Equals(t, 2, len(memcached.cache))
+	})
+
+	t.Run("should store the block keys index once per flush", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.TrackBlockKeys = true
+		config.BlockKeysIndex = true
+		config.BlockKeysIndexFlushInterval = 10 * time.Millisecond
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		// Neither the index already stored is fetched, nor the index stored, by the stores.
+		indexKey := c.key(ctx, cacheKey{block1, cacheKeyBlockKeys{}})
+		for i := 1; i <= 3; i++ {
+			c.StoreSeries(ctx, block1, storage.SeriesRef(i), []byte{byte(i)})
+		}
+		memcached.mtx.Lock()
+		_, stored := memcached.cache[indexKey]
+		testutil.Equals(t, 0, memcached.getMultiCalls)
+		memcached.mtx.Unlock()
+		testutil.Assert(t, !stored)
+
+		retryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		testutil.Ok(t, runutil.Retry(5*time.Millisecond, retryCtx.Done(), func() error {
+			memcached.mtx.Lock()
+			defer memcached.mtx.Unlock()
+			if _, ok := memcached.cache[indexKey]; !ok {
+				return errors.New("block keys index not stored yet")
+			}
+			return nil
+		}))
+		index, ok, err := c.fetchBlockKeysIndex(ctx, indexKey)
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok)
+		testutil.Equals(t, 3, len(index.keys))
+		// The index already stored is fetched once, by the flush.
+		memcached.mtx.Lock()
+		defer memcached.mtx.Unlock()
+		testutil.Equals(t, 2, memcached.getMultiCalls)
+	})
+}
+
+func TestBlockKeysIndex_EncodeDecode(t *testing.T) {
+	for _, index := range []blockKeysIndex{
+		{keys: map[string]int{}},
+		{keys: map[string]int{"V1:P:a": 0, "V1:S:b": 3}},
+		{keys: map[string]int{"V1:S:c": 1}, overflowed: true},
+	} {
+		decoded, err := decodeBlockKeysIndex(index.encode())
+		testutil.Ok(t, err)
+		testutil.Equals(t, index, decoded)
+	}
+
+	encoded := blockKeysIndex{keys: map[string]int{"V1:P:a": 0}}.encode()
+	for _, invalid := range [][]byte{nil, {2}, encoded[:len(encoded)-1]} {
+		_, err := decodeBlockKeysIndex(invalid)
+		testutil.NotOk(t, err)
+	}
+}
+
+func TestRemoteIndexCache_StoreMulti(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	postings := map[labels.Label][]byte{label1: {1}, label2: {2}}
+	series := map[storage.SeriesRef][]byte{1: {3}, 2: {4}, 3: {5}}
+
+	assertStored := func(t *testing.T, c *RemoteIndexCache) {
+		hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+		testutil.Equals(t, postings, hits)
+		testutil.Equals(t, 0, len(misses))
+
+		seriesHits, seriesMisses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2, 3})
+		testutil.Equals(t, series, seriesHits)
+		testutil.Equals(t, 0, len(seriesMisses))
+
+		testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.storedBytes.WithLabelValues(cacheTypePostings)))
+		testutil.Equals(t, 3.0, prom_testutil.ToFloat64(c.storedBytes.WithLabelValues(cacheTypeSeries)))
+	}
+
+	t.Run("should store the entries with a single operation if supported by the client", func(t *testing.T) {
+		memcached := &mockedSetMultiMemcachedClient{mockedMemcachedClient: newMockedMemcachedClient(nil)}
+		c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+		testutil.Ok(t, err)
+
+		c.StoreMultiPostings(ctx, block, postings)
+		c.StoreMultiSeries(ctx, block, series)
+		testutil.Equals(t, 2, memcached.setMultiCalls)
+		testutil.Equals(t, uint64(2), histogramSampleCount(t, c.operationDuration.WithLabelValues(opSetMultiAsync)))
+		testutil.Equals(t, uint64(0), histogramSampleCount(t, c.operationDuration.WithLabelValues(opSetAsync)))
+
+		assertStored(t, c)
+	})
+
+	t.Run("should store the entries one by one otherwise", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+		testutil.Ok(t, err)
+
+		c.StoreMultiPostings(ctx, block, postings)
+		c.StoreMultiSeries(ctx, block, series)
+		testutil.Equals(t, 5, len(memcached.cache))
+		testutil.Equals(t, uint64(2), histogramSampleCount(t, c.operationDuration.WithLabelValues(opSetMultiAsync)))
+
+		assertStored(t, c)
+	})
+
+	t.Run("should skip the entries exceeding the max item size", func(t *testing.T) {
+		memcached := &mockedSetMultiMemcachedClient{mockedMemcachedClient: newMockedMemcachedClient(nil)}
+		config := DefaultRemoteIndexCacheConfig
+		config.MaxItemSize = 1
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{1: {1}, 2: {1, 2}})
+		testutil.Equals(t, 1, len(memcached.cache))
+		testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.tooBigItems.WithLabelValues(cacheTypeSeries)))
+	})
+}
+
+func TestRemoteIndexCache_PartialTimeouts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	memcached := &mockedPartialMemcachedClient{mockedMemcachedClient: newMockedMemcachedClient(nil)}
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+	testutil.Ok(t, err)
+
+	c.StoreSeries(ctx, block, 1, []byte{1})
+	c.StoreSeries(ctx, block, 2, []byte{2})
+	memcached.timedOut = map[string]bool{c.key(ctx, cacheKey{block, cacheKeySeries(2)}): true}
+
+	// Timed out items are misses, but tracked apart from the actual ones.
+	hits, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2, 3})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1}}, hits)
+	testutil.Equals(t, []storage.SeriesRef{2, 3}, misses)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.partialTimeouts))
+}
+
+func TestRemoteIndexCache_AsyncQueueHighWatermark(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	lbl := labels.Label{Name: "instance", Value: "a"}
+
+	t.Run("should reject an invalid watermark", func(t *testing.T) {
+		config := DefaultRemoteIndexCacheConfig
+		config.AsyncQueueHighWatermark = 1.5
+		_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+		testutil.Equals(t, errRemoteIndexCacheAsyncQueueHighWatermark, err)
+	})
+
+	for _, testData := range []struct {
+		watermark       float64
+		queueFullRatio  float64
+		expectedDropped bool
+	}{
+		{watermark: 0, queueFullRatio: 1, expectedDropped: false},
+		{watermark: 0.8, queueFullRatio: 0.5, expectedDropped: false},
+		{watermark: 0.8, queueFullRatio: 0.8, expectedDropped: true},
+	} {
+		t.Run(fmt.Sprintf("watermark %v with queue full ratio %v", testData.watermark, testData.queueFullRatio), func(t *testing.T) {
+			memcached := &mockedAsyncQueueMemcachedClient{mockedMemcachedClient: newMockedMemcachedClient(nil), queueFullRatio: testData.queueFullRatio}
+			reg := prometheus.NewPedanticRegistry()
+			config := DefaultRemoteIndexCacheConfig
+			config.AsyncQueueHighWatermark = testData.watermark
+			c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, reg, config)
+			testutil.Ok(t, err)
+
+			c.StorePostings(ctx, block, lbl, []byte{1})
+			c.StoreExpandedPostings(ctx, block, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "b")}, []byte{2})
+			c.StoreSeries(ctx, block, 1, []byte{3})
+
+			expectedStored, expectedDroppedPerType := 3, 0.0
+			if testData.expectedDropped {
+				expectedStored, expectedDroppedPerType = 0, 1.0
+			}
+			testutil.Equals(t, expectedStored, len(memcached.cache))
+			for _, typ := range []string{cacheTypePostings, cacheTypeExpandedPostings, cacheTypeSeries} {
+				testutil.Equals(t, expectedDroppedPerType, prom_testutil.ToFloat64(c.droppedItems.WithLabelValues(typ)))
+			}
+			testutil.Ok(t, prom_testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
+				# HELP thanos_store_index_cache_async_queue_full_ratio Ratio, between 0 and 1, of the cache client async queue in use. With multiple clients, the most saturated queue is reported.
+				# TYPE thanos_store_index_cache_async_queue_full_ratio gauge
+				thanos_store_index_cache_async_queue_full_ratio %v
+			`, testData.queueFullRatio)), "thanos_store_index_cache_async_queue_full_ratio"))
+		})
+	}
+}
+
+func TestRemoteIndexCache_Stats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "instance", "a")}
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, IndexCacheStats{}, c.Stats())
+
+	c.StorePostings(ctx, block, label1, []byte{1})
+	c.StoreExpandedPostings(ctx, block, matchers, []byte{2})
+	c.StoreSeries(ctx, block, 1, []byte{3})
+
+	c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+	c.FetchExpandedPostings(ctx, block, matchers)
+	c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2, 3})
+
+	testutil.Equals(t, IndexCacheStats{
+		PostingsRequests:         2,
+		PostingsHits:             1,
+		ExpandedPostingsRequests: 1,
+		ExpandedPostingsHits:     1,
+		SeriesRequests:           3,
+		SeriesHits:               1,
+	}, c.Stats())
+}
+
+func TestRemoteIndexCache_KeyRouter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	nameLabel := labels.Label{Name: labels.MetricName, Value: "up"}
+	jobLabel := labels.Label{Name: "job", Value: "thanos"}
+	router := NewLabelNameKeyRouter(map[string]int{labels.MetricName: 1})
+
+	t.Run("should require a router with multiple clients", func(t *testing.T) {
+		clients := []cacheutil.RemoteCacheClient{newMockedMemcachedClient(nil), newMockedMemcachedClient(nil)}
+		_, err := NewRemoteIndexCacheWithRouter(log.NewNopLogger(), clients, nil, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Equals(t, errRemoteIndexCacheKeyRouterRequired, err)
+	})
+
+	t.Run("should store and fetch each key from the client it's routed to", func(t *testing.T) {
+		defaultClient, nameClient := newMockedMemcachedClient(nil), newMockedMemcachedClient(nil)
+		c, err := NewRemoteIndexCacheWithRouter(log.NewNopLogger(), []cacheutil.RemoteCacheClient{defaultClient, nameClient}, router, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Ok(t, err)
+
+		c.StorePostings(ctx, block, nameLabel, []byte{1})
+		c.StorePostings(ctx, block, jobLabel, []byte{2})
+		c.StoreSeries(ctx, block, 1, []byte{3})
+		testutil.Equals(t, 2, len(defaultClient.cache))
+		testutil.Equals(t, 1, len(nameClient.cache))
+		testutil.Equals(t, []byte{1}, nameClient.cache[c.key(ctx, cacheKey{block, cacheKeyPostings(nameLabel)})])
+
+		hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{nameLabel, jobLabel})
+		testutil.Equals(t, map[labels.Label][]byte{nameLabel: {1}, jobLabel: {2}}, hits)
+		testutil.Equals(t, 0, len(misses))
+		testutil.Equals(t, 1, defaultClient.getMultiCount())
+		testutil.Equals(t, 1, nameClient.getMultiCount())
+
+		// Fetching keys routed to a single client doesn't query the others.
+		hits, _ = c.FetchMultiPostings(ctx, block, []labels.Label{nameLabel})
+		testutil.Equals(t, map[labels.Label][]byte{nameLabel: {1}}, hits)
+		testutil.Equals(t, 1, defaultClient.getMultiCount())
+		testutil.Equals(t, 2, nameClient.getMultiCount())
+	})
+
+	t.Run("should return the hits of the other clients if one fails", func(t *testing.T) {
+		clientErr := errors.New("mocked error")
+		defaultClient, nameClient := newMockedMemcachedClient(nil), newMockedMemcachedClient(clientErr)
+		c, err := NewRemoteIndexCacheWithRouter(log.NewNopLogger(), []cacheutil.RemoteCacheClient{defaultClient, nameClient}, router, nil, DefaultRemoteIndexCacheConfig)
+		testutil.Ok(t, err)
+
+		c.StorePostings(ctx, block, nameLabel, []byte{1})
+		c.StorePostings(ctx, block, jobLabel, []byte{2})
+
+		hits, misses, err := c.FetchMultiPostingsE(ctx, block, []labels.Label{nameLabel, jobLabel})
+		testutil.Equals(t, clientErr, err)
+		testutil.Equals(t, map[labels.Label][]byte{jobLabel: {2}}, hits)
+		testutil.Equals(t, []labels.Label{nameLabel}, misses)
+	})
+}
+
+func TestRemoteIndexCache_KeyVersion(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	v1, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	config.KeyVersion = CacheKeyVersion + 1
+	v2, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	// Different versions should never produce the same key.
+	testutil.Assert(t, v1.key(ctx, cacheKey{block, cacheKeyPostings(label)}) != v2.key(ctx, cacheKey{block, cacheKeyPostings(label)}))
+	testutil.Assert(t, v1.key(ctx, cacheKey{block, cacheKeySeries(1)}) != v2.key(ctx, cacheKey{block, cacheKeySeries(1)}))
+
+	// An entry written with a version should not be visible to another version sharing the same backend.
+	v1.StorePostings(ctx, block, label, []byte{1})
+	v1.StoreSeries(ctx, block, 1, []byte{2})
+
+	_, misses := v2.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, []labels.Label{label}, misses)
+	_, seriesMisses := v2.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, []storage.SeriesRef{1}, seriesMisses)
+
+	hits, _ := v1.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, hits)
+
+	config.KeyVersion = 0
+	_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Equals(t, errRemoteIndexCacheKeyVersionNotPositive, err)
+}
+
+func TestRemoteIndexCache_CompactKeys(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	config.CompactKeys = true
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	str, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+
+	k := cacheKey{block, cacheKeyPostings(label)}
+	testutil.Equals(t, k.compactString(CacheKeyVersion, ""), c.key(ctx, k))
+	testutil.Equals(t, k.compactString(CacheKeyVersion, "team-a"), c.key(ContextWithTenant(ctx, "team-a"), k))
+
+	c.StorePostings(ctx, block, label, []byte{1})
+	c.StoreSeries(ctx, block, 1, []byte{2})
+
+	hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, hits)
+	testutil.Equals(t, 0, len(misses))
+	seriesHits, seriesMisses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {2}}, seriesHits)
+	testutil.Equals(t, 0, len(seriesMisses))
+
+	// Entries stored with compact keys should not be visible with string keys.
+	_, misses = str.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, []labels.Label{label}, misses)
+
+	config.KeyVersion = math.MaxUint8 + 1
+	_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Equals(t, errRemoteIndexCacheCompactKeysVersionTooLarge, err)
+}
+
+func TestRemoteIndexCache_VerifyKeys(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	config.VerifyKeys = true
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	unverified, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+
+	// Verified entries are namespaced away from unverified ones.
+	unverified.StoreSeries(ctx, block, 1, []byte{1})
+	_, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, []storage.SeriesRef{1}, misses)
+
+	c.StoreSeries(ctx, block, 1, []byte{1})
+	c.StoreSeries(ctx, block, 2, []byte{2})
+	hits, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1}, 2: {2}}, hits)
+	testutil.Equals(t, 0, len(misses))
+
+	// An entry found under the key of another item is reported as a collision and considered a miss.
+	memcached.cache[c.key(ctx, cacheKey{block, cacheKeySeries(2)})] = memcached.cache[c.key(ctx, cacheKey{block, cacheKeySeries(1)})]
+	hits, misses = c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1}}, hits)
+	testutil.Equals(t, []storage.SeriesRef{2}, misses)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.collisions.WithLabelValues(cacheTypeSeries)))
+
+	// Labels whose name and value only differ by where the separator is map to the same postings key.
+	label1 := labels.Label{Name: "a:b", Value: "c"}
+	label2 := labels.Label{Name: "a", Value: "b:c"}
+	testutil.Equals(t, c.key(ctx, cacheKey{block, cacheKeyPostings(label1)}), c.key(ctx, cacheKey{block, cacheKeyPostings(label2)}))
+
+	c.StorePostings(ctx, block, label1, []byte{3})
+	postingsHits, postingsMisses := c.FetchMultiPostings(ctx, block, []labels.Label{label1})
+	testutil.Equals(t, map[labels.Label][]byte{label1: {3}}, postingsHits)
+	testutil.Equals(t, 0, len(postingsMisses))
+
+	postingsHits, postingsMisses = c.FetchMultiPostings(ctx, block, []labels.Label{label2})
+	testutil.Equals(t, 0, len(postingsHits))
+	testutil.Equals(t, []labels.Label{label2}, postingsMisses)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.collisions.WithLabelValues(cacheTypePostings)))
+}
+
+func TestRemoteIndexCache_StoreTimestamps(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	now := time.Unix(1000, 0)
+	config := DefaultRemoteIndexCacheConfig
+	config.StoreTimestamps = true
+	config.VerifyKeys = true
+	config.Compression = CompressionSnappy
+	config.Clock = func() time.Time { return now }
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	untimestamped, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+
+	// Timestamped entries are namespaced away from untimestamped ones.
+	untimestamped.StorePostings(ctx, block, label1, []byte{1})
+	_, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1})
+	testutil.Equals(t, []labels.Label{label1}, misses)
+
+	c.StorePostings(ctx, block, label1, []byte{1})
+	now = now.Add(time.Minute)
+	c.StorePostings(ctx, block, label2, []byte{2})
+	now = now.Add(time.Minute)
+
+	hits, ages, misses, err := c.FetchMultiPostingsWithAge(ctx, block, []labels.Label{label1, label2, {Name: "instance", Value: "c"}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[labels.Label][]byte{label1: {1}, label2: {2}}, hits)
+	testutil.Equals(t, map[labels.Label]time.Duration{label1: 2 * time.Minute, label2: time.Minute}, ages)
+	testutil.Equals(t, []labels.Label{{Name: "instance", Value: "c"}}, misses)
+
+	hits, ages, _, err = c.FetchMultiPostingsWithAge(ctx, block, []labels.Label{label2})
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[labels.Label][]byte{label2: {2}}, hits)
+	testutil.Equals(t, map[labels.Label]time.Duration{label2: time.Minute}, ages)
+
+	// Entries stored by a process whose clock is ahead are reported with a zero age.
+	now = now.Add(-time.Hour)
+	_, ages, _, err = c.FetchMultiPostingsWithAge(ctx, block, []labels.Label{label1})
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[labels.Label]time.Duration{label1: 0}, ages)
+
+	// The other types of entries are timestamped too.
+	c.StoreSeries(ctx, block, 1, []byte{3})
+	seriesHits, _ := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {3}}, seriesHits)
+
+	// No ages are returned with the timestamps disabled.
+	hits, ages, _, err = untimestamped.FetchMultiPostingsWithAge(ctx, block, []labels.Label{label1})
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, hits)
+	testutil.Equals(t, 0, len(ages))
+}
+
+func TestRemoteIndexCache_Checksums(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	config.Checksums = true
+	config.StoreTimestamps = true
+	config.Compression = CompressionSnappy
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	unchecksummed, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+
+	// Checksummed entries are namespaced away from unchecksummed ones.
+	unchecksummed.StorePostings(ctx, block, label1, []byte{1})
+	_, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1})
+	testutil.Equals(t, []labels.Label{label1}, misses)
+
+	value := bytes.Repeat([]byte{0, 0, 0, 1}, 256)
+	c.StorePostings(ctx, block, label1, value)
+	c.StorePostings(ctx, block, label2, value)
+	c.StoreSeries(ctx, block, 1, value)
+	c.StoreSeries(ctx, block, 2, value)
+
+	// Truncate and corrupt some of the entries.
+	key := c.key(ctx, cacheKey{block, cacheKeyPostings(label2)})
+	memcached.cache[key] = memcached.cache[key][:len(memcached.cache[key])-1]
+	key = c.key(ctx, cacheKey{block, cacheKeySeries(2)})
+	memcached.cache[key][len(memcached.cache[key])-1]++
+
+	hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+	testutil.Equals(t, map[labels.Label][]byte{label1: value}, hits)
+	testutil.Equals(t, []labels.Label{label2}, misses)
+
+	seriesHits, seriesMisses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: value}, seriesHits)
+	testutil.Equals(t, []storage.SeriesRef{2}, seriesMisses)
+
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.corruptedEntries.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.corruptedEntries.WithLabelValues(cacheTypeSeries)))
+
+	// Entries too short to hold a checksum are corrupted too.
+	memcached.cache[c.key(ctx, cacheKey{block, cacheKeyPostings(label2)})] = []byte{1}
+	_, misses = c.FetchMultiPostings(ctx, block, []labels.Label{label2})
+	testutil.Equals(t, []labels.Label{label2}, misses)
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.corruptedEntries.WithLabelValues(cacheTypePostings)))
+}
+
+func TestRemoteIndexCache_Close(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	config.TrackBlockKeys = true
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	c.StorePostings(ctx, block, label, []byte{1})
+	c.Close()
+
+	// Fetches are misses, without calling the client.
+	hits, misses, err := c.FetchMultiPostingsE(ctx, block, []labels.Label{label})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(hits))
+	testutil.Equals(t, []labels.Label{label}, misses)
+	testutil.Equals(t, 0, memcached.getMultiCalls)
+
+	// Stores are no-ops.
+	c.StoreSeries(ctx, block, 1, []byte{2})
+	c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{2: {3}})
+	testutil.Equals(t, 1, len(memcached.cache))
+
+	// Deletes fail.
+	err = c.DeleteBlock(ctx, block)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, errRemoteIndexCacheClosed.Error(), err.Error())
+	testutil.Equals(t, 1, len(memcached.cache))
+}
+
+func TestRemoteIndexCache_HealthCheck(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	healthy, unhealthy := newMockedMemcachedClient(nil), newMockedMemcachedClient(errors.New("mocked error"))
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), healthy, nil)
+	testutil.Ok(t, err)
+	testutil.Ok(t, c.HealthCheck(ctx))
+
+	// The cache is unhealthy if any of its clients is, including from behind the wrapping caches.
+	c, err = NewRemoteIndexCacheWithRouter(log.NewNopLogger(), []cacheutil.RemoteCacheClient{healthy, unhealthy}, NewLabelNameKeyRouter(nil), nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+	testutil.NotOk(t, c.HealthCheck(ctx))
+
+	tiered, err := NewTieredIndexCache(NopIndexCache{}, c)
+	testutil.Ok(t, err)
+	testutil.NotOk(t, HealthCheck(ctx, NewTracingIndexCache(tiered)))
+	testutil.NotOk(t, HealthCheck(ctx, NewMigratingIndexCache(NopIndexCache{}, c, nil)))
+
+	// The caches not implementing the health check are considered healthy.
+	testutil.Ok(t, HealthCheck(ctx, NopIndexCache{}))
+
+	c.Close()
+	testutil.NotOk(t, c.HealthCheck(ctx))
+}
+
+func TestRemoteIndexCache_SynchronousStore(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+
+	config := DefaultRemoteIndexCacheConfig
+	config.SynchronousStore = true
+
+	// Clients unable to store synchronously are rejected.
+	_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, errRemoteIndexCacheSynchronousStoreUnsupported, err)
+
+	memcached := &mockedSyncMemcachedClient{mockedSetMultiMemcachedClient: &mockedSetMultiMemcachedClient{mockedMemcachedClient: newMockedMemcachedClient(nil)}}
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	// Entries are stored one by one with Set, even if the client supports SetMultiAsync,
+	// and can be fetched right away.
+	c.StorePostings(ctx, block, label, []byte{1})
+	c.StoreSeries(ctx, block, 1, []byte{2})
+	c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{2: {3}, 3: {4}})
+	testutil.Equals(t, 4, memcached.setCalls)
+	testutil.Equals(t, 0, memcached.setMultiCalls)
+
+	hits, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2, 3})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {2}, 2: {3}, 3: {4}}, hits)
+	testutil.Equals(t, 0, len(misses))
+
+	// Failures are returned.
+	memcached.setErr = errors.New("failed")
+	err = c.set(ctx, cacheTypeSeries, cacheKey{block, cacheKeySeries(4)}, []byte{5}, time.Hour)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, memcached.setErr, err)
+	err = c.setMulti(ctx, cacheTypeSeries, block, []cacheKey{{block, cacheKeySeries(5)}}, [][]byte{{6}}, time.Hour)
+	testutil.NotOk(t, err)
+}
+
+func TestRemoteIndexCache_ConfiguredTTL(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewPedanticRegistry()
+	config := DefaultRemoteIndexCacheConfig
+	config.PostingsTTL = time.Hour
+	config.SeriesTTL = 2 * time.Hour
+	_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), reg, config)
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, prom_testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP thanos_store_index_cache_configured_ttl_seconds TTL of the entries stored to the cache, as configured, before any per block override and jitter.
+		# TYPE thanos_store_index_cache_configured_ttl_seconds gauge
+		thanos_store_index_cache_configured_ttl_seconds{item_type="ExpandedPostings"} 3600
+		thanos_store_index_cache_configured_ttl_seconds{item_type="LabelNames"} 3600
+		thanos_store_index_cache_configured_ttl_seconds{item_type="LabelValues"} 3600
+		thanos_store_index_cache_configured_ttl_seconds{item_type="Postings"} 3600
+		thanos_store_index_cache_configured_ttl_seconds{item_type="Series"} 7200
+	`), "thanos_store_index_cache_configured_ttl_seconds"))
+}
+
+func TestRemoteIndexCache_OperationsLog(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1, label2 := labels.Label{Name: "instance", Value: "a"}, labels.Label{Name: "instance", Value: "b"}
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+
+	operationsLog := &bytes.Buffer{}
+	config := DefaultRemoteIndexCacheConfig
+	config.OperationsLog = operationsLog
+	config.Clock = func() time.Time { return now }
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+	testutil.Ok(t, err)
+
+	c.StorePostings(ctx, block, label1, []byte{1, 2})
+	c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+	c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{1: {1}, 2: {2}})
+	c.FetchLabelValues(ctx, block, "instance", nil)
+
+	var entries []OperationsLogEntry
+	testutil.Ok(t, ReadOperationsLog(operationsLog, func(e OperationsLogEntry) error {
+		testutil.Assert(t, now.Equal(e.Time), "unexpected time %v", e.Time)
+		e.Time = time.Time{}
+		entries = append(entries, e)
+		return nil
+	}))
+	testutil.Equals(t, []OperationsLogEntry{
+		{Op: OperationStore, ItemType: cacheTypePostings, Block: block.String(), Keys: 1, Bytes: 2},
+		{Op: OperationFetch, ItemType: cacheTypePostings, Block: block.String(), Keys: 2, Hits: 1, Bytes: 2},
+		{Op: OperationStore, ItemType: cacheTypeSeries, Block: block.String(), Keys: 2, Bytes: 2},
+		{Op: OperationFetch, ItemType: cacheTypeLabelValues, Block: block.String(), Keys: 1},
+	}, entries)
+
+	// The invalid entries fail the read.
+	for _, content := range []string{"{", `{"op":"delete"}`} {
+		testutil.NotOk(t, ReadOperationsLog(strings.NewReader(content), func(OperationsLogEntry) error { return nil }))
+	}
+}
+
+func TestRemoteIndexCache_StorePostingsSync(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+
+	// Clients unable to store synchronously fail the synchronous stores only.
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+	testutil.Equals(t, errRemoteIndexCacheSynchronousStoreUnsupported, c.StorePostingsSync(ctx, block, label, []byte{1}))
+
+	memcached := &mockedSyncMemcachedClient{mockedSetMultiMemcachedClient: &mockedSetMultiMemcachedClient{mockedMemcachedClient: newMockedMemcachedClient(nil)}}
+	c, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+
+	// The postings are stored with Set, even if the synchronous store isn't enabled.
+	testutil.Ok(t, StorePostingsSync(ctx, NewTracingIndexCache(c), block, label, []byte{1}))
+	testutil.Equals(t, 1, memcached.setCalls)
+	hits, _ := c.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, hits)
+
+	// Failures are returned.
+	memcached.setErr = errors.New("failed")
+	testutil.Equals(t, memcached.setErr, c.StorePostingsSync(ctx, block, label, []byte{2}))
+
+	// Caches storing synchronously anyway fall back to StorePostings.
+	inmemory, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, DefaultInMemoryIndexCacheConfig)
+	testutil.Ok(t, err)
+	testutil.Ok(t, StorePostingsSync(ctx, inmemory, block, label, []byte{1}))
+	hits, _ = inmemory.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, hits)
+}
+
+func TestRemoteIndexCache_StoreIfAbsent(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+
+	config := DefaultRemoteIndexCacheConfig
+	config.StoreIfAbsent = true
+
+	// Clients unable to add items are rejected.
+	_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, errRemoteIndexCacheStoreIfAbsentUnsupported, err)
+
+	memcached := &mockedAddMemcachedClient{mockedSetMultiMemcachedClient: &mockedSetMultiMemcachedClient{mockedMemcachedClient: newMockedMemcachedClient(nil)}}
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	// Only the first stored value of an entry is kept, the others being counted.
+	c.StorePostings(ctx, block, label, []byte{1})
+	c.StorePostings(ctx, block, label, []byte{2})
+	c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{1: {3}, 2: {4}})
+	c.StoreMultiSeries(ctx, block, map[storage.SeriesRef][]byte{2: {5}})
+	testutil.Equals(t, 5, memcached.addCalls)
+	testutil.Equals(t, 0, memcached.setMultiCalls)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.skippedExisting.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.skippedExisting.WithLabelValues(cacheTypeSeries)))
+
+	hits, _ := c.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, hits)
+	seriesHits, _ := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {3}, 2: {4}}, seriesHits)
+
+	// It can't be combined with the synchronous store.
+	config.SynchronousStore = true
+	_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, errRemoteIndexCacheStoreIfAbsentSynchronous, err)
+}
+
+func TestRemoteIndexCache_Prefetch(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	now := time.Unix(1000, 0)
+	config := DefaultRemoteIndexCacheConfig
+	config.PrefetchTTL = time.Minute
+	config.Clock = func() time.Time { return now }
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	// Prefetching is a no-op if disabled.
+	disabled, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, DefaultRemoteIndexCacheConfig)
+	testutil.Ok(t, err)
+	disabled.Prefetch(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, 0, memcached.getMultiCalls)
+
+	c.StoreSeries(ctx, block, 1, []byte{1})
+	c.StoreSeries(ctx, block, 2, []byte{2})
+	Prefetch(ctx, NewTracingIndexCache(c), block, []storage.SeriesRef{1, 2, 3})
+	waitPrefetches(t, c)
+	testutil.Equals(t, 1, memcached.getMultiCalls)
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.prefetches.WithLabelValues(prefetchIssued)))
+
+	// The prefetched series are served from memory, and no longer held afterwards.
+	delete(memcached.cache, c.key(ctx, cacheKey{block, cacheKeySeries(1)}))
+	hits, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1}, 2: {2}}, hits)
+	testutil.Equals(t, 0, len(misses))
+	testutil.Equals(t, 1, memcached.getMultiCalls)
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.prefetchHits))
+
+	hits, misses = c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{2: {2}}, hits)
+	testutil.Equals(t, []storage.SeriesRef{1}, misses)
+	testutil.Equals(t, 2, memcached.getMultiCalls)
+
+	// The series not prefetched are fetched from the cache, and the expired prefetched ones too.
+	c.StoreSeries(ctx, block, 3, []byte{3})
+	Prefetch(ctx, c, block, []storage.SeriesRef{2})
+	waitPrefetches(t, c)
+	now = now.Add(2 * time.Minute)
+	delete(memcached.cache, c.key(ctx, cacheKey{block, cacheKeySeries(2)}))
+	hits, misses = c.FetchMultiSeries(ctx, block, []storage.SeriesRef{2, 3})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{3: {3}}, hits)
+	testutil.Equals(t, []storage.SeriesRef{2}, misses)
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.prefetchHits))
+
+	// Prefetches are dropped when too many are running.
+	for i := 0; i < config.MaxPrefetchConcurrency; i++ {
+		c.prefetchGate <- struct{}{}
+	}
+	c.Prefetch(ctx, block, []storage.SeriesRef{3})
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.prefetches.WithLabelValues(prefetchDropped)))
+}
+
+func TestRemoteIndexCache_MaxInFlightGetMulti(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	config.MaxInFlightGetMulti = 1
+	config.MaxGetMultiQueueWait = 10 * time.Millisecond
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+	c.StorePostings(ctx, block, label, []byte{1})
+
+	// The operations are issued right away while below the limit.
+	hits, _ := c.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, hits)
+	testutil.Equals(t, 1, memcached.getMultiCount())
+
+	// Once reached, the operations queued for longer than the max queue wait miss.
+	c.getMultiGate <- struct{}{}
+	hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, 0, len(hits))
+	testutil.Equals(t, []labels.Label{label}, misses)
+	testutil.Equals(t, 1, memcached.getMultiCount())
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.getMultiQueueTimeouts))
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.getMultiQueueLength))
+
+	// Without a max queue wait, the operations are queued until a slot is released.
+	config.MaxGetMultiQueueWait = 0
+	c, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+	c.getMultiGate <- struct{}{}
+
+	done := make(chan map[labels.Label][]byte)
+	go func() {
+		hits, _ := c.FetchMultiPostings(ctx, block, []labels.Label{label})
+		done <- hits
+	}()
+	for deadline := time.Now().Add(10 * time.Second); prom_testutil.ToFloat64(c.getMultiQueueLength) != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the operation to be queued")
+		}
+	}
+	<-c.getMultiGate
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, <-done)
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(c.getMultiQueueLength))
+	testutil.Equals(t, 0, len(c.getMultiGate))
+}
+
+func TestRemoteIndexCache_PostingsLabelNames(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	pod := labels.Label{Name: "pod", Value: "a"}
+	job := labels.Label{Name: "job", Value: "a"}
+	env := labels.Label{Name: "env", Value: "a"}
+	ctx := context.Background()
+
+	config := DefaultRemoteIndexCacheConfig
+	config.PostingsLabelNamesAllowlist = []string{"pod"}
+	config.PostingsLabelNamesDenylist = []string{"pod"}
+	_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, errRemoteIndexCachePostingsLabelNameAllowDenied, errors.Cause(err))
+
+	for _, tc := range []struct {
+		name      string
+		allowlist []string
+		denylist  []string
+		cached    []labels.Label
+		uncached  []labels.Label
+		skipped   float64
+	}{
+		{name: "denylist", denylist: []string{"pod"}, cached: []labels.Label{job, env}, uncached: []labels.Label{pod}, skipped: 2},
+		{name: "allowlist", allowlist: []string{"job"}, cached: []labels.Label{job}, uncached: []labels.Label{pod, env}, skipped: 3},
+		{name: "both", allowlist: []string{"job", "env"}, denylist: []string{"pod"}, cached: []labels.Label{job, env}, uncached: []labels.Label{pod}, skipped: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			memcached := newMockedMemcachedClient(nil)
+			config := DefaultRemoteIndexCacheConfig
+			config.PostingsLabelNamesAllowlist = tc.allowlist
+			config.PostingsLabelNamesDenylist = tc.denylist
+			c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+			testutil.Ok(t, err)
+
+			// The postings of the labels which aren't cached are skipped, whatever the store.
+			c.StorePostings(ctx, block, pod, []byte{1})
+			c.StoreMultiPostings(ctx, block, map[labels.Label][]byte{job: {2}, env: {3}})
+			testutil.Ok(t, c.StorePostingsSync(ctx, block, pod, []byte{1}))
+			testutil.Equals(t, len(tc.cached), len(memcached.cache))
+			testutil.Equals(t, tc.skipped, prom_testutil.ToFloat64(c.skippedPostings))
+
+			// And they miss without being requested.
+			hits, misses := c.FetchMultiPostings(ctx, block, []labels.Label{pod, job, env})
+			testutil.Equals(t, len(tc.cached), len(hits))
+			testutil.Equals(t, len(tc.uncached), len(misses))
+			for _, lbl := range tc.uncached {
+				_, ok := hits[lbl]
+				testutil.Assert(t, !ok)
+			}
+			testutil.Equals(t, float64(len(tc.cached)), prom_testutil.ToFloat64(c.postingRequests))
+
+			_, misses = c.FetchMultiPostings(ctx, block, tc.uncached)
+			testutil.Equals(t, tc.uncached, misses)
+			testutil.Equals(t, 1, memcached.getMultiCount())
 		})
 	}
 }
 
+// waitPrefetches waits for the prefetches running on the cache to complete, by acquiring all the
+// slots of the gate bounding them.
+func waitPrefetches(t *testing.T, c *RemoteIndexCache) {
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < cap(c.prefetchGate); i++ {
+		select {
+		case c.prefetchGate <- struct{}{}:
+		case <-timeout:
+			t.Fatal("timed out waiting for the prefetches to complete")
+		}
+	}
+	for i := 0; i < cap(c.prefetchGate); i++ {
+		<-c.prefetchGate
+	}
+}
+
+func TestRemoteIndexCache_TrackHitsByBlockAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000000, 0)
+	freshBlock := ulid.MustNew(ulid.Timestamp(now.Add(-time.Minute)), nil)
+	recentBlock := ulid.MustNew(ulid.Timestamp(now.Add(-2*time.Hour)), nil)
+	oldBlock := ulid.MustNew(ulid.Timestamp(now.Add(-48*time.Hour)), nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	config.TrackHitsByBlockAge = true
+	config.Clock = func() time.Time { return now }
+	c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	c.StorePostings(ctx, freshBlock, label, []byte{1})
+	c.StoreSeries(ctx, oldBlock, 1, []byte{1})
+
+	c.FetchMultiPostings(ctx, freshBlock, []labels.Label{label})
+	c.FetchMultiPostings(ctx, recentBlock, []labels.Label{label})
+	c.FetchMultiSeries(ctx, oldBlock, []storage.SeriesRef{1, 2})
+
+	for _, tc := range []struct {
+		typ, age       string
+		requests, hits float64
+	}{
+		{typ: cacheTypePostings, age: blockAgeLessThanHour, requests: 1, hits: 1},
+		{typ: cacheTypePostings, age: blockAgeLessThanDay, requests: 1, hits: 0},
+		{typ: cacheTypePostings, age: blockAgeMoreThanDay, requests: 0, hits: 0},
+		{typ: cacheTypeSeries, age: blockAgeMoreThanDay, requests: 2, hits: 1},
+	} {
+		testutil.Equals(t, tc.requests, prom_testutil.ToFloat64(c.requestsByBlockAge.WithLabelValues(tc.typ, tc.age)), "%s %s", tc.typ, tc.age)
+		testutil.Equals(t, tc.hits, prom_testutil.ToFloat64(c.hitsByBlockAge.WithLabelValues(tc.typ, tc.age)), "%s %s", tc.typ, tc.age)
+	}
+}
+
+func TestRemoteIndexCache_Tenant(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+	ctx := context.Background()
+	memcached := newMockedMemcachedClient(nil)
+
+	config := DefaultRemoteIndexCacheConfig
+	untenanted, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	config.Tenant = "team-a"
+	tenanted, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+	testutil.Ok(t, err)
+
+	// Without a tenant, keys should be left untouched.
+	k := cacheKey{block, cacheKeyPostings(label)}
+	testutil.Equals(t, k.versionedString(CacheKeyVersion), untenanted.key(ctx, k))
+	testutil.Equals(t, k.versionedString(CacheKeyVersion), untenanted.key(ContextWithTenant(ctx, ""), k))
+
+	// The tenant carried by the context should take precedence over the configured one.
+	testutil.Equals(t, "V2:team-a/"+k.string(), tenanted.key(ctx, k))
+	testutil.Equals(t, "V2:team-b/"+k.string(), tenanted.key(ContextWithTenant(ctx, "team-b"), k))
+	testutil.Equals(t, k.versionedString(CacheKeyVersion), tenanted.key(ContextWithTenant(ctx, ""), k))
+
+	// Tenants unsafe to be used in a key should be hashed.
+	unsafeKey := untenanted.key(ContextWithTenant(ctx, "team a/"+strings.Repeat("x", 500)), k)
+	testutil.Assert(t, strings.HasPrefix(unsafeKey, "V2:#"), "unexpected key %s", unsafeKey)
+	testutil.Assert(t, len(unsafeKey) <= 250, "key %s exceeds the max length", unsafeKey)
+
+	// An entry written by a tenant should not be visible to another tenant sharing the same backend.
+	tenanted.StorePostings(ctx, block, label, []byte{1})
+	tenanted.StoreSeries(ContextWithTenant(ctx, "team-b"), block, 1, []byte{2})
+
+	_, misses := untenanted.FetchMultiPostings(ctx, block, []labels.Label{label})
+	testutil.Equals(t, []labels.Label{label}, misses)
+	_, seriesMisses := tenanted.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, []storage.SeriesRef{1}, seriesMisses)
+
+	hits, _ := untenanted.FetchMultiPostings(ContextWithTenant(ctx, "team-a"), block, []labels.Label{label})
+	testutil.Equals(t, map[labels.Label][]byte{label: {1}}, hits)
+	seriesHits, _ := tenanted.FetchMultiSeries(ContextWithTenant(ctx, "team-b"), block, []storage.SeriesRef{1})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {2}}, seriesHits)
+}
+
+func TestRemoteIndexCache_TTL(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label := labels.Label{Name: "instance", Value: "a"}
+
+	t.Run("should use the default TTL when created with the default config", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		c, err := NewRemoteIndexCache(log.NewNopLogger(), memcached, nil)
+		testutil.Ok(t, err)
+
+		ctx := context.Background()
+		c.StorePostings(ctx, block, label, []byte{1})
+		c.StoreSeries(ctx, block, 1, []byte{2})
+
+		testutil.Equals(t, memcachedDefaultTTL, memcached.ttls[c.key(ctx, cacheKey{block, cacheKeyPostings(label)})])
+		testutil.Equals(t, memcachedDefaultTTL, memcached.ttls[c.key(ctx, cacheKey{block, cacheKeySeries(1)})])
+	})
+
+	t.Run("should use the configured TTL for each item type", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.PostingsTTL = 72 * time.Hour
+		config.SeriesTTL = 6 * time.Hour
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		ctx := context.Background()
+		c.StorePostings(ctx, block, label, []byte{1})
+		c.StoreSeries(ctx, block, 1, []byte{2})
+
+		testutil.Equals(t, 72*time.Hour, memcached.ttls[c.key(ctx, cacheKey{block, cacheKeyPostings(label)})])
+		testutil.Equals(t, 6*time.Hour, memcached.ttls[c.key(ctx, cacheKey{block, cacheKeySeries(1)})])
+	})
+
+	t.Run("should use the TTL returned by the TTL func for the block", func(t *testing.T) {
+		now := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+		oldBlock := ulid.MustNew(ulid.Timestamp(now.Add(-8*24*time.Hour)), nil)
+		newBlock := ulid.MustNew(ulid.Timestamp(now.Add(-6*24*time.Hour)), nil)
+
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.Clock = func() time.Time { return now }
+		config.TTLFunc = func(blockID ulid.ULID, now time.Time) time.Duration {
+			if now.Sub(ulid.Time(blockID.Time())) > 7*24*time.Hour {
+				return 30 * 24 * time.Hour
+			}
+			return time.Hour
+		}
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		ctx := context.Background()
+		c.StorePostings(ctx, oldBlock, label, []byte{1})
+		c.StoreSeries(ctx, oldBlock, 1, []byte{2})
+		c.StorePostings(ctx, newBlock, label, []byte{1})
+		c.StoreSeries(ctx, newBlock, 1, []byte{2})
+
+		testutil.Equals(t, 30*24*time.Hour, memcached.ttls[c.key(ctx, cacheKey{oldBlock, cacheKeyPostings(label)})])
+		testutil.Equals(t, 30*24*time.Hour, memcached.ttls[c.key(ctx, cacheKey{oldBlock, cacheKeySeries(1)})])
+		testutil.Equals(t, time.Hour, memcached.ttls[c.key(ctx, cacheKey{newBlock, cacheKeyPostings(label)})])
+		testutil.Equals(t, time.Hour, memcached.ttls[c.key(ctx, cacheKey{newBlock, cacheKeySeries(1)})])
+	})
+
+	t.Run("should fall back to the configured TTL if the TTL func doesn't return a positive one", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.PostingsTTL = 72 * time.Hour
+		config.SeriesTTL = 6 * time.Hour
+		config.TTLFunc = func(blockID ulid.ULID, _ time.Time) time.Duration {
+			if blockID == block {
+				return 0
+			}
+			return -time.Hour
+		}
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		ctx := context.Background()
+		otherBlock := ulid.MustNew(2, nil)
+		c.StorePostings(ctx, block, label, []byte{1})
+		c.StoreSeries(ctx, otherBlock, 1, []byte{2})
+
+		testutil.Equals(t, 72*time.Hour, memcached.ttls[c.key(ctx, cacheKey{block, cacheKeyPostings(label)})])
+		testutil.Equals(t, 6*time.Hour, memcached.ttls[c.key(ctx, cacheKey{otherBlock, cacheKeySeries(1)})])
+	})
+
+	t.Run("should reject a zero TTL", func(t *testing.T) {
+		config := DefaultRemoteIndexCacheConfig
+		config.PostingsTTL = 0
+		_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+		testutil.Equals(t, errRemoteIndexCachePostingsTTLNotPositive, err)
+
+		config = DefaultRemoteIndexCacheConfig
+		config.SeriesTTL = 0
+		_, err = NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+		testutil.Equals(t, errRemoteIndexCacheSeriesTTLNotPositive, err)
+	})
+
+	t.Run("should jitter the TTL of each entry", func(t *testing.T) {
+		memcached := newMockedMemcachedClient(nil)
+		config := DefaultRemoteIndexCacheConfig
+		config.TTLJitter = 0.1
+		c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+		testutil.Ok(t, err)
+
+		ctx := context.Background()
+		for i := 0; i < 100; i++ {
+			c.StoreSeries(ctx, block, storage.SeriesRef(i), []byte{1})
+		}
+
+		distinct := map[time.Duration]struct{}{}
+		for _, ttl := range memcached.ttls {
+			testutil.Assert(t, ttl >= 21*time.Hour+36*time.Minute && ttl <= 26*time.Hour+24*time.Minute, "TTL %s out of the jitter range", ttl)
+			distinct[ttl] = struct{}{}
+		}
+		testutil.Assert(t, len(distinct) > 1, "expected the TTLs to be jittered")
+	})
+
+	t.Run("should jitter the TTLs deterministically with the same clock", func(t *testing.T) {
+		now := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+		config := DefaultRemoteIndexCacheConfig
+		config.TTLJitter = 0.1
+		config.Clock = func() time.Time { return now }
+
+		ctx := context.Background()
+		var ttls []map[string]time.Duration
+		for i := 0; i < 2; i++ {
+			memcached := newMockedMemcachedClient(nil)
+			c, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), memcached, nil, config)
+			testutil.Ok(t, err)
+
+			for id := 0; id < 10; id++ {
+				c.StoreSeries(ctx, block, storage.SeriesRef(id), []byte{1})
+			}
+			ttls = append(ttls, memcached.ttls)
+		}
+		testutil.Equals(t, ttls[0], ttls[1])
+	})
+
+	t.Run("should reject an invalid TTL jitter", func(t *testing.T) {
+		config := DefaultRemoteIndexCacheConfig
+		config.TTLJitter = 1
+		_, err := NewRemoteIndexCacheWithConfig(log.NewNopLogger(), newMockedMemcachedClient(nil), nil, config)
+		testutil.Equals(t, errRemoteIndexCacheTTLJitter, err)
+	})
+}
+
 type mockedPostings struct {
 	block ulid.ULID
 	label labels.Label
@@ -210,21 +2149,94 @@ type mockedSeries struct {
 	value []byte
 }
 
+// mockedGenericCache is a mocked generic cache, storing the items in memory.
+type mockedGenericCache struct {
+	mtx   sync.Mutex
+	items map[string]cacheutil.RemoteCacheItem
+	err   error
+}
+
+func newMockedGenericCache() *mockedGenericCache {
+	return &mockedGenericCache{items: map[string]cacheutil.RemoteCacheItem{}}
+}
+
+func (c *mockedGenericCache) Store(ctx context.Context, data map[string][]byte, ttl time.Duration) {
+	items := make([]cacheutil.RemoteCacheItem, 0, len(data))
+	for key, value := range data {
+		items = append(items, cacheutil.RemoteCacheItem{Key: key, Value: value, TTL: ttl})
+	}
+	_ = c.StoreMulti(ctx, items)
+}
+
+func (c *mockedGenericCache) StoreMulti(_ context.Context, items []cacheutil.RemoteCacheItem) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	for _, item := range items {
+		c.items[item.Key] = item
+	}
+	return nil
+}
+
+func (c *mockedGenericCache) Fetch(ctx context.Context, keys []string) map[string][]byte {
+	hits, _ := c.FetchMulti(ctx, keys)
+	return hits
+}
+
+func (c *mockedGenericCache) FetchMulti(_ context.Context, keys []string) (map[string][]byte, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	hits := map[string][]byte{}
+	for _, key := range keys {
+		if item, ok := c.items[key]; ok {
+			hits[key] = item.Value
+		}
+	}
+	return hits, nil
+}
+
+func (c *mockedGenericCache) Name() string { return "mocked" }
+
 type mockedMemcachedClient struct {
+	mtx               sync.Mutex
 	cache             map[string][]byte
+	ttls              map[string]time.Duration
+	getMultiCalls     int
 	mockedGetMultiErr error
 }
 
 func newMockedMemcachedClient(mockedGetMultiErr error) *mockedMemcachedClient {
 	return &mockedMemcachedClient{
 		cache:             map[string][]byte{},
+		ttls:              map[string]time.Duration{},
 		mockedGetMultiErr: mockedGetMultiErr,
 	}
 }
 
 func (c *mockedMemcachedClient) GetMulti(ctx context.Context, keys []string) map[string][]byte {
+	hits, _ := c.GetMultiWithError(ctx, keys)
+	return hits
+}
+
+// HealthCheck fails with the mocked GetMulti error, if any.
+func (c *mockedMemcachedClient) HealthCheck(context.Context) error {
+	return c.mockedGetMultiErr
+}
+
+func (c *mockedMemcachedClient) GetMultiWithError(_ context.Context, keys []string) (map[string][]byte, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.getMultiCalls++
 	if c.mockedGetMultiErr != nil {
-		return nil
+		return nil, c.mockedGetMultiErr
 	}
 
 	hits := map[string][]byte{}
@@ -235,11 +2247,32 @@ func (c *mockedMemcachedClient) GetMulti(ctx context.Context, keys []string) map
 		}
 	}
 
-	return hits
+	return hits, nil
+}
+
+func (c *mockedMemcachedClient) getMultiCount() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.getMultiCalls
 }
 
 func (c *mockedMemcachedClient) SetAsync(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
 	c.cache[key] = value
+	c.ttls[key] = ttl
+
+	return nil
+}
+
+func (c *mockedMemcachedClient) Delete(_ context.Context, key string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.cache, key)
+	delete(c.ttls, key)
 
 	return nil
 }
@@ -247,3 +2280,173 @@ func (c *mockedMemcachedClient) SetAsync(ctx context.Context, key string, value
 func (c *mockedMemcachedClient) Stop() {
 	// Nothing to do.
 }
+
+func BenchmarkRemoteIndexCache_FetchMultiPostings_SingleLabel(b *testing.B) {
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	lbls := []labels.Label{{Name: "instance", Value: "a"}}
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(b, err)
+	c.StorePostings(ctx, block, lbls[0], []byte{1, 2, 3})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.FetchMultiPostings(ctx, block, lbls)
+	}
+}
+
+// benchmarkKeysCounts are the numbers of keys fetched at once by the fetches benchmarks,
+// from a single label or series up to the keys of a high cardinality query.
+var benchmarkKeysCounts = []int{1, 100, 10000, 100000}
+
+func BenchmarkRemoteIndexCache_FetchMultiPostings(b *testing.B) {
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(b, err)
+
+	lbls := make([]labels.Label, benchmarkKeysCounts[len(benchmarkKeysCounts)-1])
+	for i := range lbls {
+		lbls[i] = labels.Label{Name: "instance", Value: strconv.Itoa(i)}
+		c.StorePostings(ctx, block, lbls[i], []byte{1, 2, 3})
+	}
+
+	for _, n := range benchmarkKeysCounts {
+		b.Run(fmt.Sprintf("keys=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.FetchMultiPostings(ctx, block, lbls[:n])
+			}
+		})
+	}
+}
+
+func BenchmarkRemoteIndexCache_FetchMultiSeries(b *testing.B) {
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+
+	c, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(b, err)
+
+	ids := make([]storage.SeriesRef, benchmarkKeysCounts[len(benchmarkKeysCounts)-1])
+	for i := range ids {
+		ids[i] = storage.SeriesRef(i)
+		c.StoreSeries(ctx, block, ids[i], []byte{1, 2, 3})
+	}
+
+	for _, n := range benchmarkKeysCounts {
+		b.Run(fmt.Sprintf("keys=%d/map", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				hits, _ := c.FetchMultiSeries(ctx, block, ids[:n])
+				for range hits {
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("keys=%d/iter", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = c.IterMultiSeries(ctx, block, ids[:n], func(storage.SeriesRef, []byte) {})
+			}
+		})
+	}
+}
+
+type mockedAsyncQueueMemcachedClient struct {
+	*mockedMemcachedClient
+
+	queueFullRatio float64
+}
+
+func (c *mockedAsyncQueueMemcachedClient) AsyncQueueFullRatio() float64 {
+	return c.queueFullRatio
+}
+
+// mockedPartialMemcachedClient is a client reporting the configured keys as timed out.
+type mockedPartialMemcachedClient struct {
+	*mockedMemcachedClient
+
+	timedOut map[string]bool
+}
+
+func (c *mockedPartialMemcachedClient) GetMultiPartial(ctx context.Context, keys []string) (map[string][]byte, []string, error) {
+	var fetched, timedOut []string
+	for _, key := range keys {
+		if c.timedOut[key] {
+			timedOut = append(timedOut, key)
+		} else {
+			fetched = append(fetched, key)
+		}
+	}
+
+	hits, err := c.GetMultiWithError(ctx, fetched)
+	if err == nil && len(timedOut) > 0 {
+		err = context.DeadlineExceeded
+	}
+	return hits, timedOut, err
+}
+
+type mockedSetMultiMemcachedClient struct {
+	*mockedMemcachedClient
+
+	setMultiCalls int
+}
+
+func (c *mockedSetMultiMemcachedClient) SetMultiAsync(ctx context.Context, items []cacheutil.RemoteCacheItem) error {
+	c.mtx.Lock()
+	c.setMultiCalls++
+	c.mtx.Unlock()
+
+	for _, item := range items {
+		if err := c.SetAsync(ctx, item.Key, item.Value, item.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mockedSyncMemcachedClient is a client storing items synchronously, failing with setErr if set.
+type mockedSyncMemcachedClient struct {
+	*mockedSetMultiMemcachedClient
+
+	setCalls int
+	setErr   error
+}
+
+func (c *mockedSyncMemcachedClient) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.setCalls++
+	if c.setErr != nil {
+		return c.setErr
+	}
+	c.cache[key] = value
+	c.ttls[key] = ttl
+	return nil
+}
+
+// mockedAddMemcachedClient is a client able to add items, synchronously.
+type mockedAddMemcachedClient struct {
+	*mockedSetMultiMemcachedClient
+
+	addCalls int
+}
+
+func (c *mockedAddMemcachedClient) AddAsync(_ context.Context, key string, value []byte, ttl time.Duration, exists func()) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.addCalls++
+	if _, ok := c.cache[key]; ok {
+		exists()
+		return nil
+	}
+	c.cache[key] = value
+	c.ttls[key] = ttl
+	return nil
+}
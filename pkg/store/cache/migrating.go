@@ -0,0 +1,164 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+const (
+	migrationBackendPrimary   = "primary"
+	migrationBackendSecondary = "secondary"
+)
+
+// MigratingIndexCache is an IndexCache used to migrate from a backend to another without
+// starting from a cold cache. Stores write to both the primary (new) and the secondary (old)
+// backends, while fetches check the primary backend first, then fall back to the secondary
+// one, backfilling the primary backend with its hits. Once the primary backend is warm, the
+// secondary one can be dropped.
+type MigratingIndexCache struct {
+	tiered *TieredIndexCache
+	hits   *prometheus.CounterVec
+}
+
+// NewMigratingIndexCache makes a new MigratingIndexCache migrating from the secondary backend to the primary one.
+func NewMigratingIndexCache(primary, secondary IndexCache, reg prometheus.Registerer) *MigratingIndexCache {
+	hits := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_migration_hits_total",
+		Help: "Total number of items requests to the migrating index cache that were a hit, by the backend they were fetched from.",
+	}, []string{"backend", "item_type"})
+
+	return &MigratingIndexCache{
+		tiered: &TieredIndexCache{tiers: []IndexCache{
+			newHitsCountingIndexCache(primary, hits, migrationBackendPrimary),
+			newHitsCountingIndexCache(secondary, hits, migrationBackendSecondary),
+		}},
+		hits: hits,
+	}
+}
+
+// StorePostings stores the postings into both backends.
+func (c *MigratingIndexCache) StorePostings(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) {
+	c.tiered.StorePostings(ctx, blockID, l, v)
+}
+
+// FetchMultiPostings fetches multiple postings - each identified by a label - from the primary
+// backend, then the ones it misses from the secondary backend, and returns a map containing
+// cache hits, along with a list of keys missing from both.
+func (c *MigratingIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, keys []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	return c.tiered.FetchMultiPostings(ctx, blockID, keys)
+}
+
+// StoreSeries stores the series into both backends.
+func (c *MigratingIndexCache) StoreSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef, v []byte) {
+	c.tiered.StoreSeries(ctx, blockID, id, v)
+}
+
+// FetchMultiSeries fetches multiple series - each identified by ID - from the primary backend,
+// then the ones it misses from the secondary backend, and returns a map containing cache hits,
+// along with a list of IDs missing from both.
+func (c *MigratingIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef) {
+	return c.tiered.FetchMultiSeries(ctx, blockID, ids)
+}
+
+// StoreLabelValues stores the label values into both backends.
+func (c *MigratingIndexCache) StoreLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte) {
+	c.tiered.StoreLabelValues(ctx, blockID, labelName, matchers, v)
+}
+
+// FetchLabelValues fetches the label values from the primary backend or, if missing, from the secondary one.
+func (c *MigratingIndexCache) FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool) {
+	return c.tiered.FetchLabelValues(ctx, blockID, labelName, matchers)
+}
+
+// StoreLabelNames stores the label names into both backends.
+func (c *MigratingIndexCache) StoreLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	c.tiered.StoreLabelNames(ctx, blockID, matchers, v)
+}
+
+// FetchLabelNames fetches the label names from the primary backend or, if missing, from the secondary one.
+func (c *MigratingIndexCache) FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	return c.tiered.FetchLabelNames(ctx, blockID, matchers)
+}
+
+// StorePostingsSync stores the postings into both backends, blocking until both acknowledge the write.
+func (c *MigratingIndexCache) StorePostingsSync(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) error {
+	return c.tiered.StorePostingsSync(ctx, blockID, l, v)
+}
+
+// HealthCheck checks the health of both backends.
+func (c *MigratingIndexCache) HealthCheck(ctx context.Context) error {
+	return c.tiered.HealthCheck(ctx)
+}
+
+// ForgetBlock forgets the block in both backends.
+func (c *MigratingIndexCache) ForgetBlock(blockID ulid.ULID) {
+	c.tiered.ForgetBlock(blockID)
+}
+
+// hitsCountingIndexCache is an IndexCache counting the hits of the wrapped one.
+type hitsCountingIndexCache struct {
+	IndexCache
+
+	postingHits     prometheus.Counter
+	seriesHits      prometheus.Counter
+	labelValuesHits prometheus.Counter
+	labelNamesHits  prometheus.Counter
+}
+
+func newHitsCountingIndexCache(cache IndexCache, hits *prometheus.CounterVec, backend string) *hitsCountingIndexCache {
+	return &hitsCountingIndexCache{
+		IndexCache:      cache,
+		postingHits:     hits.WithLabelValues(backend, cacheTypePostings),
+		seriesHits:      hits.WithLabelValues(backend, cacheTypeSeries),
+		labelValuesHits: hits.WithLabelValues(backend, cacheTypeLabelValues),
+		labelNamesHits:  hits.WithLabelValues(backend, cacheTypeLabelNames),
+	}
+}
+
+func (c *hitsCountingIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, keys []labels.Label) (map[labels.Label][]byte, []labels.Label) {
+	hits, misses := c.IndexCache.FetchMultiPostings(ctx, blockID, keys)
+	c.postingHits.Add(float64(len(hits)))
+	return hits, misses
+}
+
+func (c *hitsCountingIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (map[storage.SeriesRef][]byte, []storage.SeriesRef) {
+	hits, misses := c.IndexCache.FetchMultiSeries(ctx, blockID, ids)
+	c.seriesHits.Add(float64(len(hits)))
+	return hits, misses
+}
+
+func (c *hitsCountingIndexCache) FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool) {
+	v, ok := c.IndexCache.FetchLabelValues(ctx, blockID, labelName, matchers)
+	if ok {
+		c.labelValuesHits.Inc()
+	}
+	return v, ok
+}
+
+func (c *hitsCountingIndexCache) FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	v, ok := c.IndexCache.FetchLabelNames(ctx, blockID, matchers)
+	if ok {
+		c.labelNamesHits.Inc()
+	}
+	return v, ok
+}
+
+func (c *hitsCountingIndexCache) StorePostingsSync(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) error {
+	return StorePostingsSync(ctx, c.IndexCache, blockID, l, v)
+}
+
+func (c *hitsCountingIndexCache) HealthCheck(ctx context.Context) error {
+	return HealthCheck(ctx, c.IndexCache)
+}
+
+func (c *hitsCountingIndexCache) ForgetBlock(blockID ulid.ULID) {
+	ForgetBlock(c.IndexCache, blockID)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestMigratingIndexCache(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	ctx := context.Background()
+
+	primary, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+	secondary, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+	c := NewMigratingIndexCache(primary, secondary, nil)
+	hits := func(backend, itemType string) float64 {
+		return promtest.ToFloat64(c.hits.WithLabelValues(backend, itemType))
+	}
+
+	// Stores write to both backends.
+	c.StorePostings(ctx, block, label1, []byte{1})
+	pHits, _ := primary.FetchMultiPostings(ctx, block, []labels.Label{label1})
+	testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, pHits)
+	sHits, _ := secondary.FetchMultiPostings(ctx, block, []labels.Label{label1})
+	testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, sHits)
+
+	// Items only found in the secondary backend are backfilled to the primary one.
+	secondary.StorePostings(ctx, block, label2, []byte{2})
+	secondary.StoreSeries(ctx, block, 1, []byte{3})
+
+	postings, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+	testutil.Equals(t, map[labels.Label][]byte{label1: {1}, label2: {2}}, postings)
+	testutil.Equals(t, 0, len(misses))
+	testutil.Equals(t, 1.0, hits(migrationBackendPrimary, cacheTypePostings))
+	testutil.Equals(t, 1.0, hits(migrationBackendSecondary, cacheTypePostings))
+
+	series, seriesMisses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {3}}, series)
+	testutil.Equals(t, []storage.SeriesRef{2}, seriesMisses)
+	testutil.Equals(t, 0.0, hits(migrationBackendPrimary, cacheTypeSeries))
+	testutil.Equals(t, 1.0, hits(migrationBackendSecondary, cacheTypeSeries))
+
+	pHits, _ = primary.FetchMultiPostings(ctx, block, []labels.Label{label2})
+	testutil.Equals(t, map[labels.Label][]byte{label2: {2}}, pHits)
+	primarySeries, _ := primary.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, map[storage.SeriesRef][]byte{1: {3}}, primarySeries)
+
+	// Once backfilled, the items are fetched from the primary backend.
+	_, _ = c.FetchMultiPostings(ctx, block, []labels.Label{label2})
+	testutil.Equals(t, 2.0, hits(migrationBackendPrimary, cacheTypePostings))
+	testutil.Equals(t, 1.0, hits(migrationBackendSecondary, cacheTypePostings))
+}
+
+func TestNewIndexCache_Migrating(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	cache, err := NewIndexCache(log.NewNopLogger(), []byte(`
+type: MIGRATING
+config:
+  primary:
+    type: IN-MEMORY
+  secondary:
+    type: IN-MEMORY
+`), reg)
+	testutil.Ok(t, err)
+
+	migrating, ok := cache.(*MigratingIndexCache)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 2, len(migrating.tiered.tiers))
+
+	// The metrics of the backends are distinguished by their label.
+	testutil.Equals(t, 2, promtest.CollectAndCount(reg, "thanos_store_index_cache_max_size_bytes"))
+
+	_, err = NewIndexCache(log.NewNopLogger(), []byte(`
+type: MIGRATING
+config:
+  primary:
+    type: MIGRATING
+  secondary:
+    type: IN-MEMORY
+`), reg)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,206 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+const (
+	// missReasonUnseen is the reason of the misses of keys neither stored nor hit through this
+	// process, or not recently enough to still be tracked, e.g. the keys of a cold block.
+	missReasonUnseen = "unseen"
+	// missReasonGone is the reason of the misses of keys recently stored or hit through this
+	// process, which are gone since, e.g. evicted or expired.
+	missReasonGone = "gone"
+)
+
+// MissReasonIndexCache is an IndexCache categorizing the misses of the cache it wraps by whether
+// the missed keys have been seen, that is stored or hit, through it before. It tells the misses of
+// the keys which have never been cached, e.g. of the cold blocks, apart from the misses of the keys
+// which have been cached but have been evicted or have expired since.
+//
+// The classification is heuristic: only the most recently seen keys are tracked, so the misses of
+// the keys seen too long ago are counted as unseen, while the keys whose asynchronous store failed,
+// or is still in flight, are counted as gone. The keys stored by other processes sharing a remote
+// cache are unseen, until they're hit.
+type MissReasonIndexCache struct {
+	c IndexCache
+
+	mtx sync.Mutex
+	// seen holds the fingerprints of the most recently seen keys.
+	seen *lru.LRU
+
+	misses *prometheus.CounterVec
+}
+
+// NewMissReasonIndexCache makes a new MissReasonIndexCache tracking up to maxTrackedKeys seen keys
+// of the given cache, which bounds the memory used by the tracking to about 100 bytes per key.
+func NewMissReasonIndexCache(cache IndexCache, maxTrackedKeys int, reg prometheus.Registerer) (*MissReasonIndexCache, error) {
+	if maxTrackedKeys <= 0 {
+		return nil, errors.New("the max number of tracked keys must be positive")
+	}
+	seen, err := lru.NewLRU(maxTrackedKeys, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MissReasonIndexCache{
+		c:    cache,
+		seen: seen,
+		misses: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_store_index_cache_miss_reason_total",
+			Help: "Total number of items requests to the cache that were a miss, by whether the items had been seen, i.e. stored or hit, recently through this process (gone) or not (unseen).",
+		}, []string{"item_type", "reason"}),
+	}
+	for _, typ := range []string{cacheTypePostings, cacheTypeSeries, cacheTypeLabelValues, cacheTypeLabelNames} {
+		c.misses.WithLabelValues(typ, missReasonUnseen)
+		c.misses.WithLabelValues(typ, missReasonGone)
+	}
+	return c, nil
+}
+
+func (c *MissReasonIndexCache) StorePostings(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) {
+	c.c.StorePostings(ctx, blockID, l, v)
+	c.see(cacheKey{blockID, cacheKeyPostings(l)})
+}
+
+// StorePostingsSync stores the postings into the wrapped cache synchronously, tracking them as seen once stored.
+func (c *MissReasonIndexCache) StorePostingsSync(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) error {
+	if err := StorePostingsSync(ctx, c.c, blockID, l, v); err != nil {
+		return err
+	}
+	c.see(cacheKey{blockID, cacheKeyPostings(l)})
+	return nil
+}
+
+func (c *MissReasonIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, keys []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	hits, misses = c.c.FetchMultiPostings(ctx, blockID, keys)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for l := range hits {
+		c.seen.Add(cacheKey{blockID, cacheKeyPostings(l)}.fingerprint(), nil)
+	}
+	for _, l := range misses {
+		c.classifyMiss(cacheKey{blockID, cacheKeyPostings(l)})
+	}
+	return hits, misses
+}
+
+func (c *MissReasonIndexCache) StoreSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef, v []byte) {
+	c.c.StoreSeries(ctx, blockID, id, v)
+	c.see(cacheKey{blockID, cacheKeySeries(id)})
+}
+
+func (c *MissReasonIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef) {
+	hits, misses = c.c.FetchMultiSeries(ctx, blockID, ids)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for id := range hits {
+		c.seen.Add(cacheKey{blockID, cacheKeySeries(id)}.fingerprint(), nil)
+	}
+	for _, id := range misses {
+		c.classifyMiss(cacheKey{blockID, cacheKeySeries(id)})
+	}
+	return hits, misses
+}
+
+func (c *MissReasonIndexCache) StoreMultiPostings(ctx context.Context, blockID ulid.ULID, entries map[labels.Label][]byte) {
+	StoreMultiPostings(ctx, c.c, blockID, entries)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for l := range entries {
+		c.seen.Add(cacheKey{blockID, cacheKeyPostings(l)}.fingerprint(), nil)
+	}
+}
+
+func (c *MissReasonIndexCache) StoreMultiSeries(ctx context.Context, blockID ulid.ULID, entries map[storage.SeriesRef][]byte) {
+	StoreMultiSeries(ctx, c.c, blockID, entries)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for id := range entries {
+		c.seen.Add(cacheKey{blockID, cacheKeySeries(id)}.fingerprint(), nil)
+	}
+}
+
+// Prefetch forwards the hint to the wrapped cache. The prefetched series are classified once fetched.
+func (c *MissReasonIndexCache) Prefetch(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) {
+	Prefetch(ctx, c.c, blockID, ids)
+}
+
+// HealthCheck forwards the health check to the wrapped cache.
+func (c *MissReasonIndexCache) HealthCheck(ctx context.Context) error {
+	return HealthCheck(ctx, c.c)
+}
+
+// ForgetBlock forwards to the wrapped cache.
+func (c *MissReasonIndexCache) ForgetBlock(blockID ulid.ULID) {
+	ForgetBlock(c.c, blockID)
+}
+
+func (c *MissReasonIndexCache) StoreLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte) {
+	c.c.StoreLabelValues(ctx, blockID, labelName, matchers, v)
+	c.see(cacheKey{blockID, newCacheKeyLabelValues(labelName, matchers)})
+}
+
+func (c *MissReasonIndexCache) FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool) {
+	v, ok := c.c.FetchLabelValues(ctx, blockID, labelName, matchers)
+	c.observeFetch(cacheKey{blockID, newCacheKeyLabelValues(labelName, matchers)}, ok)
+	return v, ok
+}
+
+func (c *MissReasonIndexCache) StoreLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	c.c.StoreLabelNames(ctx, blockID, matchers, v)
+	c.see(cacheKey{blockID, newCacheKeyLabelNames(matchers)})
+}
+
+func (c *MissReasonIndexCache) FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	v, ok := c.c.FetchLabelNames(ctx, blockID, matchers)
+	c.observeFetch(cacheKey{blockID, newCacheKeyLabelNames(matchers)}, ok)
+	return v, ok
+}
+
+// see tracks the key as seen.
+func (c *MissReasonIndexCache) see(key cacheKey) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.seen.Add(key.fingerprint(), nil)
+}
+
+// observeFetch tracks the key as seen if it's a hit, or classifies the miss otherwise.
+func (c *MissReasonIndexCache) observeFetch(key cacheKey, hit bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if hit {
+		c.seen.Add(key.fingerprint(), nil)
+		return
+	}
+	c.classifyMiss(key)
+}
+
+// classifyMiss counts the miss of the key by whether it has been seen. A missed key isn't untracked, so
+// that the misses of a key which isn't stored again keep being counted as gone. It must be called with
+// the lock held.
+func (c *MissReasonIndexCache) classifyMiss(key cacheKey) {
+	reason := missReasonUnseen
+	if c.seen.Contains(key.fingerprint()) {
+		reason = missReasonGone
+	}
+	c.misses.WithLabelValues(key.keyType(), reason).Inc()
+}
@@ -0,0 +1,86 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestMissReasonIndexCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	label3 := labels.Label{Name: "instance", Value: "c"}
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")}
+
+	t.Run("should tell the misses of the keys never seen apart from the ones of the keys gone", func(t *testing.T) {
+		// The nop cache drops all the stores, like a cache evicting the entries right away.
+		c, err := NewMissReasonIndexCache(NopIndexCache{}, 10, nil)
+		testutil.Ok(t, err)
+
+		c.StorePostings(ctx, block, label1, []byte{1})
+		StoreMultiSeries(ctx, c, block, map[storage.SeriesRef][]byte{1: {1}})
+		c.StoreLabelValues(ctx, block, "instance", matchers, []byte{1})
+
+		_, misses := c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2})
+		testutil.Equals(t, []labels.Label{label1, label2}, misses)
+		c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2, 3})
+		c.FetchLabelValues(ctx, block, "instance", matchers)
+		c.FetchLabelValues(ctx, block, "job", matchers)
+		c.FetchLabelNames(ctx, block, matchers)
+
+		// The keys of another block are never seen.
+		c.FetchMultiPostings(ctx, ulid.MustNew(2, nil), []labels.Label{label1})
+
+		for _, tc := range []struct {
+			typ          string
+			unseen, gone float64
+		}{
+			{typ: cacheTypePostings, unseen: 2, gone: 1},
+			{typ: cacheTypeSeries, unseen: 2, gone: 1},
+			{typ: cacheTypeLabelValues, unseen: 1, gone: 1},
+			{typ: cacheTypeLabelNames, unseen: 1, gone: 0},
+		} {
+			testutil.Equals(t, tc.unseen, promtest.ToFloat64(c.misses.WithLabelValues(tc.typ, missReasonUnseen)), tc.typ)
+			testutil.Equals(t, tc.gone, promtest.ToFloat64(c.misses.WithLabelValues(tc.typ, missReasonGone)), tc.typ)
+		}
+	})
+
+	t.Run("should track the keys hit and only the most recently seen ones", func(t *testing.T) {
+		inmemory, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, DefaultInMemoryIndexCacheConfig)
+		testutil.Ok(t, err)
+		c, err := NewMissReasonIndexCache(inmemory, 2, nil)
+		testutil.Ok(t, err)
+
+		// The postings stored directly into the wrapped cache are seen once hit.
+		inmemory.StorePostings(ctx, block, label1, []byte{1})
+		hits, _ := c.FetchMultiPostings(ctx, block, []labels.Label{label1})
+		testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, hits)
+		c.StorePostings(ctx, block, label2, []byte{2})
+		c.StorePostings(ctx, block, label3, []byte{3})
+
+		// The first postings seen aren't tracked anymore, given only two keys are.
+		inmemory.reset()
+		c.FetchMultiPostings(ctx, block, []labels.Label{label1, label2, label3})
+		testutil.Equals(t, 1.0, promtest.ToFloat64(c.misses.WithLabelValues(cacheTypePostings, missReasonUnseen)))
+		testutil.Equals(t, 2.0, promtest.ToFloat64(c.misses.WithLabelValues(cacheTypePostings, missReasonGone)))
+	})
+
+	t.Run("should fail without tracked keys", func(t *testing.T) {
+		_, err := NewMissReasonIndexCache(NopIndexCache{}, 0, nil)
+		testutil.NotOk(t, err)
+	})
+}
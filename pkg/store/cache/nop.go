@@ -0,0 +1,50 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// NopIndexCache is an IndexCache which discards all the stores and misses all the fetches.
+// It allows to disable the index cache without changing the store wiring.
+type NopIndexCache struct{}
+
+// StorePostings discards the postings.
+func (NopIndexCache) StorePostings(context.Context, ulid.ULID, labels.Label, []byte) {}
+
+// FetchMultiPostings returns all the keys as misses.
+func (NopIndexCache) FetchMultiPostings(_ context.Context, _ ulid.ULID, keys []labels.Label) (map[labels.Label][]byte, []labels.Label) {
+	return map[labels.Label][]byte{}, keys
+}
+
+// StoreSeries discards the series.
+func (NopIndexCache) StoreSeries(context.Context, ulid.ULID, storage.SeriesRef, []byte) {}
+
+// FetchMultiSeries returns all the IDs as misses.
+func (NopIndexCache) FetchMultiSeries(_ context.Context, _ ulid.ULID, ids []storage.SeriesRef) (map[storage.SeriesRef][]byte, []storage.SeriesRef) {
+	return map[storage.SeriesRef][]byte{}, ids
+}
+
+// StoreLabelValues discards the label values.
+func (NopIndexCache) StoreLabelValues(context.Context, ulid.ULID, string, []*labels.Matcher, []byte) {
+}
+
+// FetchLabelValues always returns a miss.
+func (NopIndexCache) FetchLabelValues(context.Context, ulid.ULID, string, []*labels.Matcher) ([]byte, bool) {
+	return nil, false
+}
+
+// StoreLabelNames discards the label names.
+func (NopIndexCache) StoreLabelNames(context.Context, ulid.ULID, []*labels.Matcher, []byte) {
+}
+
+// FetchLabelNames always returns a miss.
+func (NopIndexCache) FetchLabelNames(context.Context, ulid.ULID, []*labels.Matcher) ([]byte, bool) {
+	return nil, false
+}
@@ -0,0 +1,39 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestNopIndexCache(t *testing.T) {
+	t.Parallel()
+
+	cache, err := NewIndexCache(log.NewNopLogger(), []byte("type: NONE"), nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, NopIndexCache{}, cache)
+
+	ctx := context.Background()
+	block := ulid.MustNew(1, nil)
+	lbls := []labels.Label{{Name: "foo", Value: "bar"}}
+
+	cache.StorePostings(ctx, block, lbls[0], []byte{1})
+	cache.StoreSeries(ctx, block, 1, []byte{2})
+
+	pHits, pMisses := cache.FetchMultiPostings(ctx, block, lbls)
+	testutil.Equals(t, 0, len(pHits))
+	testutil.Equals(t, lbls, pMisses)
+
+	sHits, sMisses := cache.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+	testutil.Equals(t, 0, len(sHits))
+	testutil.Equals(t, []storage.SeriesRef{1}, sMisses)
+}
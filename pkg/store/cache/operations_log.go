@@ -0,0 +1,105 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// OperationFetch is the operation of the entries of the operations log recording a fetch.
+	OperationFetch = "fetch"
+	// OperationStore is the operation of the entries of the operations log recording a store.
+	OperationStore = "store"
+)
+
+// OperationsLogEntry is an operation on the remote index cache, as recorded to the operations log,
+// one JSON object per line, so that the sequence of operations of a production cache can be replayed
+// offline, e.g. with the tools cache replay command. The keys themselves aren't recorded.
+type OperationsLogEntry struct {
+	// Time is when the operation completed.
+	Time time.Time `json:"time"`
+	// Op is either OperationFetch or OperationStore.
+	Op string `json:"op"`
+	// ItemType is the type of the items fetched or stored, e.g. Postings or Series.
+	ItemType string `json:"item_type"`
+	// Block is the ULID of the block the items belong to.
+	Block string `json:"block"`
+	// Keys is the number of items requested by a fetch, or stored by a store.
+	Keys int `json:"keys"`
+	// Hits is the number of items found by a fetch.
+	Hits int `json:"hits,omitempty"`
+	// Bytes is the size of the items found by a fetch, or stored by a store, as stored in the cache.
+	Bytes int `json:"bytes"`
+}
+
+// operationsLog appends the entries to a writer, serializing the concurrent operations.
+type operationsLog struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+
+	failures prometheus.Counter
+}
+
+func newOperationsLog(w io.Writer, failures prometheus.Counter) *operationsLog {
+	return &operationsLog{enc: json.NewEncoder(w), failures: failures}
+}
+
+// record appends the entry to the log. The failures are counted, rather than failing the operation.
+func (l *operationsLog) record(e OperationsLogEntry) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if err := l.enc.Encode(e); err != nil {
+		l.failures.Inc()
+	}
+}
+
+// recordFetch records the fetch of the given number of items of the block, of which hits were found.
+func (c *RemoteIndexCache) recordFetch(typ string, blockID ulid.ULID, keys, hits, bytes int) {
+	if c.operationsLog == nil {
+		return
+	}
+	c.operationsLog.record(OperationsLogEntry{Time: c.now(), Op: OperationFetch, ItemType: typ, Block: blockID.String(), Keys: keys, Hits: hits, Bytes: bytes})
+}
+
+// recordStore records the store of the given number of items of the block.
+func (c *RemoteIndexCache) recordStore(typ string, blockID ulid.ULID, keys, bytes int) {
+	if c.operationsLog == nil || keys == 0 {
+		return
+	}
+	c.operationsLog.record(OperationsLogEntry{Time: c.now(), Op: OperationStore, ItemType: typ, Block: blockID.String(), Keys: keys, Bytes: bytes})
+}
+
+// ReadOperationsLog reads the entries of an operations log, calling f for each of them in order.
+// It stops at the first error returned by f, or at the first entry which can't be decoded.
+func ReadOperationsLog(r io.Reader, f func(OperationsLogEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e OperationsLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return errors.Wrapf(err, "decode operations log entry at line %d", line)
+		}
+		if e.Op != OperationFetch && e.Op != OperationStore {
+			return errors.Errorf("unknown operation %q at line %d", e.Op, line)
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "read operations log")
+}
@@ -0,0 +1,90 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"sync"
+	"time"
+)
+
+// prefetchedEntries holds the entries fetched ahead of time from the remote cache, as they've
+// been fetched, until they're fetched for real or expire. It's bounded by a max number of
+// entries, above which the newly prefetched entries are discarded.
+type prefetchedEntries struct {
+	mtx        sync.Mutex
+	maxEntries int
+	entries    map[string]prefetchedEntry
+}
+
+type prefetchedEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func newPrefetchedEntries(maxEntries int) *prefetchedEntries {
+	return &prefetchedEntries{
+		maxEntries: maxEntries,
+		entries:    map[string]prefetchedEntry{},
+	}
+}
+
+// add holds the values by key until expiresAt, and returns the number of values held, which may
+// be lower than the given ones if the max number of entries is reached. The expired entries are
+// removed to make room if needed.
+func (p *prefetchedEntries) add(values map[string][]byte, now, expiresAt time.Time) int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if len(p.entries)+len(values) > p.maxEntries {
+		for key, entry := range p.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(p.entries, key)
+			}
+		}
+	}
+
+	added := 0
+	for key, value := range values {
+		if len(p.entries) >= p.maxEntries {
+			break
+		}
+		p.entries[key] = prefetchedEntry{value: value, expiresAt: expiresAt}
+		added++
+	}
+	return added
+}
+
+// take removes the entries of the keys which haven't expired yet from both the held entries and
+// the keys, and returns their values by key.
+func (p *prefetchedEntries) take(keys routedKeys, now time.Time) map[string][]byte {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if len(p.entries) == 0 {
+		return nil
+	}
+
+	var values map[string][]byte
+	for client, clientKeys := range keys {
+		remaining := clientKeys[:0]
+		for _, key := range clientKeys {
+			entry, ok := p.entries[key]
+			if !ok {
+				remaining = append(remaining, key)
+				continue
+			}
+			delete(p.entries, key)
+			if !now.Before(entry.expiresAt) {
+				remaining = append(remaining, key)
+				continue
+			}
+			if values == nil {
+				values = map[string][]byte{}
+			}
+			values[key] = entry.value
+		}
+		keys[client] = remaining
+	}
+	return values
+}
@@ -0,0 +1,37 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestPrefetchedEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+	p := newPrefetchedEntries(3)
+
+	testutil.Equals(t, 2, p.add(map[string][]byte{"a": {1}, "b": {2}}, now, now.Add(time.Minute)))
+	testutil.Equals(t, 1, p.add(map[string][]byte{"c": {3}, "d": {4}}, now, now.Add(2*time.Minute)))
+
+	// The expired entries are removed to make room.
+	testutil.Equals(t, 2, p.add(map[string][]byte{"e": {5}, "f": {6}}, now.Add(time.Minute), now.Add(2*time.Minute)))
+	testutil.Equals(t, 3, len(p.entries))
+
+	// Taken entries are removed from the keys too.
+	keys := routedKeys{{"a", "e", "x"}, {"f"}}
+	testutil.Equals(t, map[string][]byte{"e": {5}, "f": {6}}, p.take(keys, now.Add(time.Minute)))
+	testutil.Equals(t, routedKeys{{"a", "x"}, {}}, keys)
+	testutil.Equals(t, 1, len(p.entries))
+
+	// Expired entries aren't taken.
+	keys = routedKeys{{"c", "d"}}
+	testutil.Equals(t, 0, len(p.take(keys, now.Add(2*time.Minute))))
+	testutil.Equals(t, routedKeys{{"c", "d"}}, keys)
+	testutil.Equals(t, 0, len(p.entries))
+}
@@ -0,0 +1,67 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// seriesCodecKeyPrefix prefixes the keys of the series values encoded with a SeriesCodec, followed
+// by the codec ID, so that the values are only ever decoded with the codec they were encoded with,
+// and the raw values, stored without a codec, are never mistaken for encoded ones.
+const seriesCodecKeyPrefix = "X"
+
+var errSeriesCodecIncomplete = errors.New("series codec requires both an encode and a decode function")
+
+// SeriesCodec transforms the series values around their storage in the remote index cache, e.g. to
+// store them in another format. The keys of the encoded values are prefixed by the codec ID, so that
+// the entries encoded with another codec, or not encoded at all, are kept apart while rolling out a codec.
+type SeriesCodec struct {
+	// ID identifies the codec the values are encoded with.
+	ID byte
+	// Encode encodes the raw series value before it's stored.
+	Encode func(v []byte) ([]byte, error)
+	// Decode decodes back the value returned by Encode once fetched.
+	Decode func(v []byte) ([]byte, error)
+}
+
+func (c *SeriesCodec) validate() error {
+	if c.Encode == nil || c.Decode == nil {
+		return errSeriesCodecIncomplete
+	}
+	return nil
+}
+
+// keyPrefix returns the prefix of the keys of the series values encoded with the codec.
+func (c *SeriesCodec) keyPrefix() string {
+	return seriesCodecKeyPrefix + strconv.Itoa(int(c.ID)) + ":"
+}
+
+// encodeSeries encodes v with the codec. With no codec, i.e. the identity one, v is returned as is.
+func encodeSeries(codec *SeriesCodec, v []byte) ([]byte, error) {
+	if codec == nil {
+		return v, nil
+	}
+
+	encoded, err := codec.Encode(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encode series with codec %d", codec.ID)
+	}
+	return encoded, nil
+}
+
+// decodeSeries decodes v, encoded with the codec. With no codec, v is returned as is.
+func decodeSeries(codec *SeriesCodec, v []byte) ([]byte, error) {
+	if codec == nil {
+		return v, nil
+	}
+
+	decoded, err := codec.Decode(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode series with codec %d", codec.ID)
+	}
+	return decoded, nil
+}
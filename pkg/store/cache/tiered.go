@@ -0,0 +1,180 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/thanos-io/thanos/pkg/errutil"
+)
+
+// TieredIndexCache is an IndexCache composed of an ordered list of tiers, typically
+// a fast in-memory cache in front of a slower remote one. Fetches check the tiers in
+// order and backfill the earlier tiers on a hit from a later one, while stores write
+// through to all tiers.
+type TieredIndexCache struct {
+	tiers []IndexCache
+}
+
+// NewTieredIndexCache makes a new TieredIndexCache. Tiers are checked in the order they are provided.
+func NewTieredIndexCache(tiers ...IndexCache) (*TieredIndexCache, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("at least one index cache tier is required")
+	}
+
+	return &TieredIndexCache{tiers: tiers}, nil
+}
+
+// StorePostings stores the postings into all the tiers.
+func (c *TieredIndexCache) StorePostings(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) {
+	for _, tier := range c.tiers {
+		tier.StorePostings(ctx, blockID, l, v)
+	}
+}
+
+// StorePostingsSync stores the postings into all the tiers, blocking until all of them acknowledge
+// the write, and returns the errors of the tiers failing to store them.
+func (c *TieredIndexCache) StorePostingsSync(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) error {
+	errs := errutil.MultiError{}
+	for _, tier := range c.tiers {
+		if err := StorePostingsSync(ctx, tier, blockID, l, v); err != nil {
+			errs.Add(err)
+		}
+	}
+	return errs.Err()
+}
+
+// FetchMultiPostings fetches multiple postings - each identified by a label - from the tiers
+// and returns a map containing cache hits, along with a list of keys missing from all tiers.
+func (c *TieredIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, keys []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	hits = map[labels.Label][]byte{}
+	misses = keys
+
+	for i, tier := range c.tiers {
+		if len(misses) == 0 {
+			break
+		}
+
+		var tierHits map[labels.Label][]byte
+		tierHits, misses = tier.FetchMultiPostings(ctx, blockID, misses)
+
+		for lbl, v := range tierHits {
+			hits[lbl] = v
+
+			// Backfill the tiers which have been checked before this one.
+			for _, prev := range c.tiers[:i] {
+				prev.StorePostings(ctx, blockID, lbl, v)
+			}
+		}
+	}
+
+	return hits, misses
+}
+
+// StoreSeries stores the series into all the tiers.
+func (c *TieredIndexCache) StoreSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef, v []byte) {
+	for _, tier := range c.tiers {
+		tier.StoreSeries(ctx, blockID, id, v)
+	}
+}
+
+// FetchMultiSeries fetches multiple series - each identified by ID - from the tiers
+// and returns a map containing cache hits, along with a list of IDs missing from all tiers.
+func (c *TieredIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef) {
+	hits = map[storage.SeriesRef][]byte{}
+	misses = ids
+
+	for i, tier := range c.tiers {
+		if len(misses) == 0 {
+			break
+		}
+
+		var tierHits map[storage.SeriesRef][]byte
+		tierHits, misses = tier.FetchMultiSeries(ctx, blockID, misses)
+
+		for id, v := range tierHits {
+			hits[id] = v
+
+			// Backfill the tiers which have been checked before this one.
+			for _, prev := range c.tiers[:i] {
+				prev.StoreSeries(ctx, blockID, id, v)
+			}
+		}
+	}
+
+	return hits, misses
+}
+
+// StoreLabelValues stores the label values into all the tiers.
+func (c *TieredIndexCache) StoreLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte) {
+	for _, tier := range c.tiers {
+		tier.StoreLabelValues(ctx, blockID, labelName, matchers, v)
+	}
+}
+
+// FetchLabelValues fetches the label values from the first tier holding them, backfilling
+// the tiers which have been checked before it.
+func (c *TieredIndexCache) FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) ([]byte, bool) {
+	for i, tier := range c.tiers {
+		v, ok := tier.FetchLabelValues(ctx, blockID, labelName, matchers)
+		if !ok {
+			continue
+		}
+
+		for _, prev := range c.tiers[:i] {
+			prev.StoreLabelValues(ctx, blockID, labelName, matchers, v)
+		}
+		return v, true
+	}
+
+	return nil, false
+}
+
+// StoreLabelNames stores the label names into all the tiers.
+func (c *TieredIndexCache) StoreLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	for _, tier := range c.tiers {
+		tier.StoreLabelNames(ctx, blockID, matchers, v)
+	}
+}
+
+// FetchLabelNames fetches the label names from the first tier holding them, backfilling
+// the tiers which have been checked before it.
+func (c *TieredIndexCache) FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) ([]byte, bool) {
+	for i, tier := range c.tiers {
+		v, ok := tier.FetchLabelNames(ctx, blockID, matchers)
+		if !ok {
+			continue
+		}
+
+		for _, prev := range c.tiers[:i] {
+			prev.StoreLabelNames(ctx, blockID, matchers, v)
+		}
+		return v, true
+	}
+
+	return nil, false
+}
+
+// HealthCheck checks the health of all the tiers, returning an error if any is unhealthy.
+func (c *TieredIndexCache) HealthCheck(ctx context.Context) error {
+	errs := errutil.MultiError{}
+	for _, tier := range c.tiers {
+		if err := HealthCheck(ctx, tier); err != nil {
+			errs.Add(err)
+		}
+	}
+	return errs.Err()
+}
+
+// ForgetBlock forgets the block in all the tiers.
+func (c *TieredIndexCache) ForgetBlock(blockID ulid.ULID) {
+	for _, tier := range c.tiers {
+		ForgetBlock(tier, blockID)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestTieredIndexCache(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+	label3 := labels.Label{Name: "instance", Value: "c"}
+	ctx := context.Background()
+
+	newTiers := func(t *testing.T) (*InMemoryIndexCache, *RemoteIndexCache, *TieredIndexCache) {
+		inmemory, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, DefaultInMemoryIndexCacheConfig)
+		testutil.Ok(t, err)
+		remote, err := NewRemoteIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+		testutil.Ok(t, err)
+		tiered, err := NewTieredIndexCache(inmemory, remote)
+		testutil.Ok(t, err)
+
+		return inmemory, remote, tiered
+	}
+
+	t.Run("should write through to all tiers", func(t *testing.T) {
+		inmemory, remote, tiered := newTiers(t)
+		tiered.StorePostings(ctx, block, label1, []byte{1})
+		tiered.StoreSeries(ctx, block, 1, []byte{2})
+
+		for _, tier := range []IndexCache{inmemory, remote} {
+			hits, _ := tier.FetchMultiPostings(ctx, block, []labels.Label{label1})
+			testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, hits)
+			seriesHits, _ := tier.FetchMultiSeries(ctx, block, []storage.SeriesRef{1})
+			testutil.Equals(t, map[storage.SeriesRef][]byte{1: {2}}, seriesHits)
+		}
+	})
+
+	t.Run("should merge the hits of all tiers and backfill the earlier tiers", func(t *testing.T) {
+		inmemory, remote, tiered := newTiers(t)
+		inmemory.StorePostings(ctx, block, label1, []byte{1})
+		remote.StorePostings(ctx, block, label2, []byte{2})
+		remote.StoreSeries(ctx, block, 2, []byte{2})
+
+		hits, misses := tiered.FetchMultiPostings(ctx, block, []labels.Label{label1, label2, label3})
+		testutil.Equals(t, map[labels.Label][]byte{label1: {1}, label2: {2}}, hits)
+		testutil.Equals(t, []labels.Label{label3}, misses)
+
+		seriesHits, seriesMisses := tiered.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+		testutil.Equals(t, map[storage.SeriesRef][]byte{2: {2}}, seriesHits)
+		testutil.Equals(t, []storage.SeriesRef{1}, seriesMisses)
+
+		// The in-memory tier should have been backfilled.
+		hits, misses = inmemory.FetchMultiPostings(ctx, block, []labels.Label{label2})
+		testutil.Equals(t, map[labels.Label][]byte{label2: {2}}, hits)
+		testutil.Equals(t, 0, len(misses))
+		seriesHits, _ = inmemory.FetchMultiSeries(ctx, block, []storage.SeriesRef{2})
+		testutil.Equals(t, map[storage.SeriesRef][]byte{2: {2}}, seriesHits)
+	})
+
+	t.Run("should fail without tiers", func(t *testing.T) {
+		_, err := NewTieredIndexCache()
+		testutil.NotOk(t, err)
+	})
+}
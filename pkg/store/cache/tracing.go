@@ -0,0 +1,172 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+// TracingIndexCache is an IndexCache including its operations in the traces, as child
+// spans of the span found in the context, if any. Without a tracer in the context, the
+// spans are no-op.
+type TracingIndexCache struct {
+	c IndexCache
+}
+
+// NewTracingIndexCache makes a new TracingIndexCache, tracing the operations on the given cache.
+func NewTracingIndexCache(cache IndexCache) *TracingIndexCache {
+	return &TracingIndexCache{c: cache}
+}
+
+func (t *TracingIndexCache) StorePostings(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) {
+	t.traceStore(ctx, cacheTypePostings, blockID, len(v), func(ctx context.Context) {
+		t.c.StorePostings(ctx, blockID, l, v)
+	})
+}
+
+// StorePostingsSync traces the synchronous store of the postings, i.e. including its acknowledgement.
+func (t *TracingIndexCache) StorePostingsSync(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) (err error) {
+	t.traceStore(ctx, cacheTypePostings, blockID, len(v), func(ctx context.Context) {
+		err = StorePostingsSync(ctx, t.c, blockID, l, v)
+	})
+	return err
+}
+
+func (t *TracingIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, keys []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	t.traceFetch(ctx, cacheTypePostings, blockID, len(keys), func(ctx context.Context) (int, int) {
+		hits, misses = t.c.FetchMultiPostings(ctx, blockID, keys)
+		bytes := 0
+		for _, v := range hits {
+			bytes += len(v)
+		}
+		return len(hits), bytes
+	})
+	return hits, misses
+}
+
+func (t *TracingIndexCache) StoreSeries(ctx context.Context, blockID ulid.ULID, id storage.SeriesRef, v []byte) {
+	t.traceStore(ctx, cacheTypeSeries, blockID, len(v), func(ctx context.Context) {
+		t.c.StoreSeries(ctx, blockID, id, v)
+	})
+}
+
+func (t *TracingIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) (hits map[storage.SeriesRef][]byte, misses []storage.SeriesRef) {
+	t.traceFetch(ctx, cacheTypeSeries, blockID, len(ids), func(ctx context.Context) (int, int) {
+		hits, misses = t.c.FetchMultiSeries(ctx, blockID, ids)
+		bytes := 0
+		for _, v := range hits {
+			bytes += len(v)
+		}
+		return len(hits), bytes
+	})
+	return hits, misses
+}
+
+func (t *TracingIndexCache) StoreMultiPostings(ctx context.Context, blockID ulid.ULID, entries map[labels.Label][]byte) {
+	bytes := 0
+	for _, v := range entries {
+		bytes += len(v)
+	}
+	t.traceStoreMulti(ctx, cacheTypePostings, blockID, len(entries), bytes, func(ctx context.Context) {
+		StoreMultiPostings(ctx, t.c, blockID, entries)
+	})
+}
+
+func (t *TracingIndexCache) StoreMultiSeries(ctx context.Context, blockID ulid.ULID, entries map[storage.SeriesRef][]byte) {
+	bytes := 0
+	for _, v := range entries {
+		bytes += len(v)
+	}
+	t.traceStoreMulti(ctx, cacheTypeSeries, blockID, len(entries), bytes, func(ctx context.Context) {
+		StoreMultiSeries(ctx, t.c, blockID, entries)
+	})
+}
+
+// Prefetch forwards the hint to the traced cache, without tracing it, given it returns immediately.
+func (t *TracingIndexCache) Prefetch(ctx context.Context, blockID ulid.ULID, ids []storage.SeriesRef) {
+	Prefetch(ctx, t.c, blockID, ids)
+}
+
+// HealthCheck forwards the health check to the traced cache, without tracing it.
+func (t *TracingIndexCache) HealthCheck(ctx context.Context) error {
+	return HealthCheck(ctx, t.c)
+}
+
+// ForgetBlock forwards to the traced cache, without tracing it.
+func (t *TracingIndexCache) ForgetBlock(blockID ulid.ULID) {
+	ForgetBlock(t.c, blockID)
+}
+
+func (t *TracingIndexCache) StoreLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher, v []byte) {
+	t.traceStore(ctx, cacheTypeLabelValues, blockID, len(v), func(ctx context.Context) {
+		t.c.StoreLabelValues(ctx, blockID, labelName, matchers, v)
+	})
+}
+
+func (t *TracingIndexCache) FetchLabelValues(ctx context.Context, blockID ulid.ULID, labelName string, matchers []*labels.Matcher) (v []byte, ok bool) {
+	t.traceFetch(ctx, cacheTypeLabelValues, blockID, 1, func(ctx context.Context) (int, int) {
+		v, ok = t.c.FetchLabelValues(ctx, blockID, labelName, matchers)
+		if !ok {
+			return 0, 0
+		}
+		return 1, len(v)
+	})
+	return v, ok
+}
+
+func (t *TracingIndexCache) StoreLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher, v []byte) {
+	t.traceStore(ctx, cacheTypeLabelNames, blockID, len(v), func(ctx context.Context) {
+		t.c.StoreLabelNames(ctx, blockID, matchers, v)
+	})
+}
+
+func (t *TracingIndexCache) FetchLabelNames(ctx context.Context, blockID ulid.ULID, matchers []*labels.Matcher) (v []byte, ok bool) {
+	t.traceFetch(ctx, cacheTypeLabelNames, blockID, 1, func(ctx context.Context) (int, int) {
+		v, ok = t.c.FetchLabelNames(ctx, blockID, matchers)
+		if !ok {
+			return 0, 0
+		}
+		return 1, len(v)
+	})
+	return v, ok
+}
+
+// traceFetch runs the fetch in a span tagged with the item type and the number of requested keys,
+// then with the number of hits and their size in bytes, as returned by the fetch.
+func (t *TracingIndexCache) traceFetch(ctx context.Context, itemType string, blockID ulid.ULID, keys int, fetch func(context.Context) (int, int)) {
+	tracing.DoWithSpan(ctx, "index_cache_fetch", func(spanCtx context.Context, span tracing.Span) {
+		span.SetTag("item_type", itemType)
+		span.SetTag("block", blockID.String())
+		span.SetTag("keys", keys)
+
+		hits, bytes := fetch(spanCtx)
+
+		span.SetTag("hits", hits)
+		span.SetTag("bytes", bytes)
+	})
+}
+
+// traceStore runs the store of a single item in a span tagged with the item type and size in bytes.
+func (t *TracingIndexCache) traceStore(ctx context.Context, itemType string, blockID ulid.ULID, bytes int, store func(context.Context)) {
+	t.traceStoreMulti(ctx, itemType, blockID, 1, bytes, store)
+}
+
+// traceStoreMulti runs the store of multiple items in a span tagged with the item type, the number
+// of items and their size in bytes.
+func (t *TracingIndexCache) traceStoreMulti(ctx context.Context, itemType string, blockID ulid.ULID, keys, bytes int, store func(context.Context)) {
+	tracing.DoWithSpan(ctx, "index_cache_store", func(spanCtx context.Context, span tracing.Span) {
+		span.SetTag("item_type", itemType)
+		span.SetTag("block", blockID.String())
+		span.SetTag("keys", keys)
+		span.SetTag("bytes", bytes)
+
+		store(spanCtx)
+	})
+}
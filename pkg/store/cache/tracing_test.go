@@ -0,0 +1,75 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+func TestTracingIndexCache(t *testing.T) {
+	t.Parallel()
+
+	block := ulid.MustNew(1, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+
+	inmemory, err := NewInMemoryIndexCacheWithConfig(log.NewNopLogger(), nil, DefaultInMemoryIndexCacheConfig)
+	testutil.Ok(t, err)
+	c := NewTracingIndexCache(inmemory)
+
+	t.Run("should be a no-op without tracer", func(t *testing.T) {
+		c.StorePostings(context.Background(), block, label1, []byte{1, 2})
+		hits, misses := c.FetchMultiPostings(context.Background(), block, []labels.Label{label1, label2})
+		testutil.Equals(t, map[labels.Label][]byte{label1: {1, 2}}, hits)
+		testutil.Equals(t, []labels.Label{label2}, misses)
+	})
+
+	t.Run("should nest spans under the span in the context", func(t *testing.T) {
+		tracer := mocktracer.New()
+		parent := tracer.StartSpan("query")
+		ctx := opentracing.ContextWithSpan(tracing.ContextWithTracer(context.Background(), tracer), parent)
+
+		c.StoreSeries(ctx, block, 1, []byte{1, 2, 3})
+		hits, misses := c.FetchMultiSeries(ctx, block, []storage.SeriesRef{1, 2})
+		testutil.Equals(t, map[storage.SeriesRef][]byte{1: {1, 2, 3}}, hits)
+		testutil.Equals(t, []storage.SeriesRef{2}, misses)
+		parent.Finish()
+
+		spans := tracer.FinishedSpans()
+		testutil.Equals(t, 3, len(spans))
+
+		store, fetch := spans[0], spans[1]
+		parentID := parent.Context().(mocktracer.MockSpanContext).SpanID
+		testutil.Equals(t, parentID, store.ParentID)
+		testutil.Equals(t, parentID, fetch.ParentID)
+
+		testutil.Equals(t, "index_cache_store", store.OperationName)
+		testutil.Equals(t, map[string]interface{}{
+			"item_type": cacheTypeSeries,
+			"block":     block.String(),
+			"keys":      1,
+			"bytes":     3,
+		}, store.Tags())
+
+		testutil.Equals(t, "index_cache_fetch", fetch.OperationName)
+		testutil.Equals(t, map[string]interface{}{
+			"item_type": cacheTypeSeries,
+			"block":     block.String(),
+			"keys":      2,
+			"hits":      1,
+			"bytes":     3,
+		}, fetch.Tags())
+	})
+}
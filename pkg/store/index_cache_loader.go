@@ -0,0 +1,148 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/groupcache/singleflight"
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+)
+
+const (
+	// unheldIndexHeadersDirname is the directory, within the store one, the index-headers of the
+	// blocks not held by the store but whose index cache items it loads are written to.
+	unheldIndexHeadersDirname = "index-cache-loader"
+	// maxUnheldIndexHeaders is the max number of index-headers of the blocks not held by the
+	// store which are kept open.
+	maxUnheldIndexHeaders = 16
+)
+
+// unheldIndexHeaders holds the index-header readers of the blocks whose index cache items are loaded
+// by the store without holding them. It happens with the groupcache index cache when the stores are
+// sharded, given the owner of an item is picked by the hash of its key, regardless of the stores
+// holding its block. Only the most recently used readers are kept, the others being closed and their
+// files removed.
+type unheldIndexHeaders struct {
+	logger                      log.Logger
+	bkt                         objstore.BucketReader
+	dir                         string
+	postingOffsetsInMemSampling int
+
+	// builds deduplicates the concurrent builds of the index-header of a block.
+	builds singleflight.Group
+
+	mtx     sync.Mutex
+	readers *lru.LRU
+}
+
+// newUnheldIndexHeaders makes the index-headers of the blocks not held by the store, written under
+// dir or kept in memory if empty.
+func newUnheldIndexHeaders(logger log.Logger, bkt objstore.BucketReader, dir string, postingOffsetsInMemSampling int) *unheldIndexHeaders {
+	h := &unheldIndexHeaders{
+		logger:                      logger,
+		bkt:                         bkt,
+		dir:                         dir,
+		postingOffsetsInMemSampling: postingOffsetsInMemSampling,
+	}
+	// The size is positive, so that no error is returned.
+	h.readers, _ = lru.NewLRU(maxUnheldIndexHeaders, h.onEvict)
+	return h
+}
+
+// postingsOffset returns the offsets of the postings of the label in the index of the block,
+// building its index-header from the object storage if not open yet.
+func (h *unheldIndexHeaders) postingsOffset(ctx context.Context, id ulid.ULID, l labels.Label) (index.Range, error) {
+	h.mtx.Lock()
+	if r, ok := h.readers.Get(id); ok {
+		// The lock is held while the reader is used, so that it can't be closed by an eviction.
+		defer h.mtx.Unlock()
+		return r.(indexheader.Reader).PostingsOffset(l.Name, l.Value)
+	}
+	h.mtx.Unlock()
+
+	// The index-header is built without holding the lock, given it's read from the object storage.
+	_, err := h.builds.Do(id.String(), func() (interface{}, error) {
+		r, err := indexheader.NewBinaryReader(ctx, h.logger, h.bkt, h.dir, id, h.postingOffsetsInMemSampling)
+		if err != nil {
+			return nil, errors.Wrap(err, "create index header reader")
+		}
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		h.readers.Add(id, r)
+		return nil, nil
+	})
+	if err != nil {
+		return index.Range{}, err
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	r, ok := h.readers.Get(id)
+	if !ok {
+		return index.Range{}, errors.Errorf("index header of block %s evicted while loading", id)
+	}
+	return r.(indexheader.Reader).PostingsOffset(l.Name, l.Value)
+}
+
+// removeStale removes the files of the index-headers which aren't open, e.g. left over by a
+// previous run.
+func (h *unheldIndexHeaders) removeStale() error {
+	if h.dir == "" {
+		return nil
+	}
+	fis, err := os.ReadDir(h.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read dir")
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for _, fi := range fis {
+		if id, ok := block.IsBlockDir(fi.Name()); ok && h.readers.Contains(id) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(h.dir, fi.Name())); err != nil {
+			level.Warn(h.logger).Log("msg", "failed to remove stale index header of block not held", "name", fi.Name(), "err", err)
+		}
+	}
+	return nil
+}
+
+// close closes all the readers and removes their files.
+func (h *unheldIndexHeaders) close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.readers.Purge()
+}
+
+// onEvict closes the evicted reader and removes its file. It's called with the lock held.
+func (h *unheldIndexHeaders) onEvict(key, value interface{}) {
+	id := key.(ulid.ULID)
+	if err := value.(indexheader.Reader).Close(); err != nil {
+		level.Warn(h.logger).Log("msg", "failed to close index header of block not held", "block", id, "err", err)
+	}
+	if h.dir == "" {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(h.dir, id.String())); err != nil {
+		level.Warn(h.logger).Log("msg", "failed to remove index header of block not held", "block", id, "err", err)
+	}
+}
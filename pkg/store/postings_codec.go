@@ -4,7 +4,11 @@
 package store
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
 
 	"github.com/golang/snappy"
 	"github.com/pkg/errors"
@@ -21,11 +25,31 @@ import (
 // and Varint is very efficient at encoding small values (values < 128 are encoded as
 // single byte, values < 16384 are encoded as two bytes). Diff + varint reduces postings size
 // significantly (to about 20% of original), snappy then halves it to ~10% of the original.
+//
+// Large postings are compressed using the Snappy framing format instead, so that they can be
+// decompressed on demand while iterated rather than being fully decompressed in memory first.
+// Stores predating this format would read such postings as raw ones, so the remote index cache
+// keys version was bumped along with it, keeping them out of their reach.
 
 const (
-	codecHeaderSnappy = "dvs" // As in "diff+varint+snappy".
+	codecHeaderSnappy         = "dvs" // As in "diff+varint+snappy".
+	codecHeaderStreamedSnappy = "dss" // As in "diff+varint+streamed snappy".
+
+	// streamedPostingsMinLength is the minimum number of postings entries for postings to be
+	// stream compressed. Decompressing a stream requires buffers of about 140KiB, so below
+	// that the decompressed postings are not bigger than the buffers.
+	streamedPostingsMinLength = 1 << 17
 )
 
+// encodePostingsForCache encodes postings to be stored in the index cache, stream compressing
+// them if they are large. Length argument is expected number of postings.
+func encodePostingsForCache(p index.Postings, length int) ([]byte, error) {
+	if length >= streamedPostingsMinLength {
+		return diffVarintSnappyStreamedEncode(p, length)
+	}
+	return diffVarintSnappyEncode(p, length)
+}
+
 // isDiffVarintSnappyEncodedPostings returns true, if input looks like it has been encoded by diff+varint+snappy codec.
 func isDiffVarintSnappyEncodedPostings(input []byte) bool {
 	return bytes.HasPrefix(input, []byte(codecHeaderSnappy))
@@ -142,3 +166,132 @@ func (it *diffVarintPostings) Seek(x storage.SeriesRef) bool {
 func (it *diffVarintPostings) Err() error {
 	return it.buf.Err()
 }
+
+// isDiffVarintSnappyStreamedEncodedPostings returns true, if input looks like it has been encoded by
+// diff+varint+streamed snappy codec.
+func isDiffVarintSnappyStreamedEncodedPostings(input []byte) bool {
+	return bytes.HasPrefix(input, []byte(codecHeaderStreamedSnappy))
+}
+
+// diffVarintSnappyStreamedEncode encodes postings into diff+varint representation,
+// compressed using the Snappy framing format as it's encoded.
+// Returned byte slice starts with codecHeaderStreamedSnappy header.
+// Length argument is expected number of postings, used for preallocating buffer.
+func diffVarintSnappyStreamedEncode(p index.Postings, length int) ([]byte, error) {
+	// Compressed postings take about half a byte per posting.
+	buf := bytes.NewBuffer(make([]byte, 0, len(codecHeaderStreamedSnappy)+length/2))
+	buf.WriteString(codecHeaderStreamedSnappy)
+
+	w := snappy.NewBufferedWriter(buf)
+	varint := make([]byte, binary.MaxVarintLen64)
+
+	prev := storage.SeriesRef(0)
+	for p.Next() {
+		v := p.At()
+		if v < prev {
+			return nil, errors.Errorf("postings entries must be in increasing order, current: %d, previous: %d", v, prev)
+		}
+
+		n := binary.PutUvarint(varint, uint64(v-prev))
+		if _, err := w.Write(varint[:n]); err != nil {
+			return nil, errors.Wrap(err, "snappy encode")
+		}
+		prev = v
+	}
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "snappy encode")
+	}
+	return buf.Bytes(), nil
+}
+
+// diffVarintSnappyStreamedDecode returns the postings encoded by diffVarintSnappyStreamedEncode.
+// The postings are decompressed while iterated, so corrupted data is reported by the
+// postings Err() method. If observeDecompression is not nil, it's called with the time
+// spent decompressing each chunk of the postings while they're iterated.
+func diffVarintSnappyStreamedDecode(input []byte, observeDecompression func(time.Duration)) (index.Postings, error) {
+	if !isDiffVarintSnappyStreamedEncodedPostings(input) {
+		return nil, errors.New("header not found")
+	}
+
+	r := snappy.NewReader(bytes.NewReader(input[len(codecHeaderStreamedSnappy):]))
+	if observeDecompression == nil {
+		return &streamedDiffVarintPostings{r: r}, nil
+	}
+	return &streamedDiffVarintPostings{r: bufio.NewReader(&timedReader{r: r, observe: observeDecompression})}, nil
+}
+
+// timedReader is an io.Reader observing the time spent in each read of the reader it wraps.
+type timedReader struct {
+	r       io.Reader
+	observe func(time.Duration)
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.r.Read(p)
+	r.observe(time.Since(start))
+	return n, err
+}
+
+// streamedDiffVarintPostings is an implementation of index.Postings based on diff+varint encoded
+// data, read from a reader.
+type streamedDiffVarintPostings struct {
+	r   io.ByteReader
+	cur storage.SeriesRef
+	err error
+}
+
+func (it *streamedDiffVarintPostings) At() storage.SeriesRef {
+	return it.cur
+}
+
+func (it *streamedDiffVarintPostings) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	val, err := binary.ReadUvarint(it.r)
+	if err != nil {
+		// The end of the data is only expected between two values.
+		if err != io.EOF {
+			it.err = errors.Wrap(err, "read streamed postings")
+		}
+		it.r = eofByteReader{}
+		return false
+	}
+
+	it.cur = it.cur + storage.SeriesRef(val)
+	return true
+}
+
+func (it *streamedDiffVarintPostings) Seek(x storage.SeriesRef) bool {
+	if it.cur >= x {
+		return true
+	}
+
+	// We cannot do any search due to how values are stored,
+	// so we simply advance until we find the right value.
+	for it.Next() {
+		if it.At() >= x {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (it *streamedDiffVarintPostings) Err() error {
+	return it.err
+}
+
+// eofByteReader is an io.ByteReader at the end of its data, releasing the reader of
+// exhausted postings.
+type eofByteReader struct{}
+
+func (eofByteReader) ReadByte() (byte, error) {
+	return 0, io.EOF
+}
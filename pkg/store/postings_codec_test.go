@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
@@ -57,8 +58,9 @@ func TestDiffVarintCodec(t *testing.T) {
 		codingFunction   func(index.Postings, int) ([]byte, error)
 		decodingFunction func([]byte) (index.Postings, error)
 	}{
-		"raw":    {codingFunction: diffVarintEncodeNoHeader, decodingFunction: func(bytes []byte) (index.Postings, error) { return newDiffVarintPostings(bytes), nil }},
-		"snappy": {codingFunction: diffVarintSnappyEncode, decodingFunction: diffVarintSnappyDecode},
+		"raw":             {codingFunction: diffVarintEncodeNoHeader, decodingFunction: func(bytes []byte) (index.Postings, error) { return newDiffVarintPostings(bytes), nil }},
+		"snappy":          {codingFunction: diffVarintSnappyEncode, decodingFunction: diffVarintSnappyDecode},
+		"streamed snappy": {codingFunction: diffVarintSnappyStreamedEncode, decodingFunction: func(bytes []byte) (index.Postings, error) { return diffVarintSnappyStreamedDecode(bytes, nil) }},
 	}
 
 	for postingName, postings := range postingsMap {
@@ -89,6 +91,59 @@ func TestDiffVarintCodec(t *testing.T) {
 	}
 }
 
+func TestDiffVarintSnappyStreamedCodec(t *testing.T) {
+	refs := make([]storage.SeriesRef, 0, streamedPostingsMinLength)
+	for i := 0; i < streamedPostingsMinLength; i++ {
+		refs = append(refs, storage.SeriesRef(i*3))
+	}
+
+	t.Run("large postings are stream compressed", func(t *testing.T) {
+		data, err := encodePostingsForCache(index.NewListPostings(refs), len(refs))
+		testutil.Ok(t, err)
+		testutil.Assert(t, isDiffVarintSnappyStreamedEncodedPostings(data))
+
+		// The decompression time is observed while the postings are iterated.
+		var decompressions int
+		p, err := diffVarintSnappyStreamedDecode(data, func(time.Duration) { decompressions++ })
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, decompressions)
+		comparePostings(t, index.NewListPostings(refs), p)
+		testutil.Assert(t, decompressions > 0)
+	})
+
+	t.Run("small postings are compressed", func(t *testing.T) {
+		data, err := encodePostingsForCache(index.NewListPostings(refs[:10]), 10)
+		testutil.Ok(t, err)
+		testutil.Assert(t, isDiffVarintSnappyEncodedPostings(data))
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		data, err := diffVarintSnappyStreamedEncode(index.NewListPostings(refs), len(refs))
+		testutil.Ok(t, err)
+
+		p, err := diffVarintSnappyStreamedDecode(data, nil)
+		testutil.Ok(t, err)
+		testutil.Assert(t, p.Seek(10))
+		testutil.Equals(t, storage.SeriesRef(12), p.At())
+		testutil.Assert(t, p.Seek(12))
+		testutil.Equals(t, storage.SeriesRef(12), p.At())
+		testutil.Assert(t, !p.Seek(refs[len(refs)-1]+1))
+		testutil.Ok(t, p.Err())
+	})
+
+	t.Run("corrupted data is reported once iterated", func(t *testing.T) {
+		data, err := diffVarintSnappyStreamedEncode(index.NewListPostings(refs), len(refs))
+		testutil.Ok(t, err)
+
+		p, err := diffVarintSnappyStreamedDecode(data[:len(data)/2], func(time.Duration) {})
+		testutil.Ok(t, err)
+		for p.Next() {
+		}
+		testutil.NotOk(t, p.Err())
+		testutil.Assert(t, !p.Next())
+	})
+}
+
 func comparePostings(t *testing.T, p1, p2 index.Postings) {
 	for p1.Next() {
 		if !p2.Next() {
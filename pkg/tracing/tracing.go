@@ -8,6 +8,8 @@ import (
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+
+	"github.com/thanos-io/thanos/pkg/tracing/migration"
 )
 
 const (
@@ -47,6 +49,19 @@ func tracerFromContext(ctx context.Context) opentracing.Tracer {
 	return nil
 }
 
+// TraceIDFromContext returns the ID of the trace of the span carried by the context, if any, and if
+// it can be told by the tracer propagated in the context, or by the OpenTelemetry bridge otherwise.
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return "", false
+	}
+	if t, ok := tracerFromContext(ctx).(Tracer); ok {
+		return t.GetTraceIDFromSpanContext(span.Context())
+	}
+	return migration.GetTraceIDFromBridgeSpan(span)
+}
+
 // CopyTraceContext copies the necessary trace context from given source context to target context.
 func CopyTraceContext(trgt, src context.Context) context.Context {
 	ctx := ContextWithTracer(trgt, tracerFromContext(src))
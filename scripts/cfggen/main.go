@@ -65,9 +65,10 @@ var (
 		trclient.Lightstep:             lightstep.Config{},
 	}
 	indexCacheConfigs = map[storecache.IndexCacheProvider]interface{}{
-		storecache.INMEMORY:  storecache.InMemoryIndexCacheConfig{},
-		storecache.MEMCACHED: cacheutil.MemcachedClientConfig{},
-		storecache.REDIS:     cacheutil.DefaultRedisClientConfig,
+		storecache.INMEMORY:   storecache.InMemoryIndexCacheConfig{},
+		storecache.MEMCACHED:  cacheutil.MemcachedClientConfig{},
+		storecache.REDIS:      cacheutil.DefaultRedisClientConfig,
+		storecache.GROUPCACHE: storecache.DefaultGroupcacheIndexCacheConfig,
 	}
 
 	queryfrontendCacheConfigs = map[queryfrontend.ResponseCacheProvider]interface{}{